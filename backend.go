@@ -1,12 +1,19 @@
 package cva
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"net/http"
+	"net/url"
+	"os"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -18,28 +25,58 @@ import (
 	"github.com/pkg/errors"
 )
 
-const (
-	pluginVersion = "v0.0.1"
+// pluginVersion is the version of the plugin build, set at build time with
+// -ldflags "-X github.com/brongineer/cross-vault-auth-plugin.pluginVersion=<version>"
+var pluginVersion = "v0.0.1"
 
-	minTLSVersion = tls.VersionTLS12
+const (
+	defaultMinTLSVersion = tls.VersionTLS12
 
 	loginPath  = "login"
 	configPath = "config"
 	rolePath   = "role"
 
-	tlsUpdateTicker = time.Second * 30
-	requestTimeout  = time.Second * 30
+	tlsUpdateTicker         = time.Second * 30
+	requestTimeout          = time.Second * 30
+	roleEntityCheckInterval = time.Minute * 5
+
+	upstreamVersionCheckInterval = time.Minute * 5
+)
+
+// codes of field errors returned by config and role writes
+const (
+	fieldErrorTTLOrder        = "ttl_order"
+	fieldErrorMissingRequired = "missing_required"
+	// value is malformed or not one of the supported ones
+	fieldErrorInvalidValue = "invalid_value"
+	// numeric or duration value is negative or exceeds its limit
+	fieldErrorOutOfRange = "out_of_range"
+	// value contradicts another field of the same write
+	fieldErrorConflict = "conflict"
+	// value is restricted by mount's configuration, e.g. allowed_policies or allowed_namespaces
+	fieldErrorNotAllowed = "not_allowed"
+	// target Vault cluster can't be reached with the connection settings of the write
+	fieldErrorUnreachable = "unreachable"
 )
 
 var (
 	backendHelp = "The Cross-Vault Auth Backend allows authentication through another Vault cluster"
 
-	httpClientIsNotSet            = errors.New("HTTP client is not set")
-	tlsConfigIsNotSet             = errors.New("TLS config is not set")
-	typeAssertionFailed           = errors.New("type assertion failed")
-	unknownLoginMethod            = errors.New("unknown login method")
-	tokenNotFoundInWrappedData    = errors.New("token not found in wrapped data, expect data stored in key 'secret'")
-	accessorNotFoundInWrappedData = errors.New("accessor not found in wrapped data, expect data stored in key 'secret'")
+	httpClientIsNotSet              = errors.New("HTTP client is not set")
+	tlsConfigIsNotSet               = errors.New("TLS config is not set")
+	typeAssertionFailed             = errors.New("type assertion failed")
+	unknownLoginMethod              = errors.New("unknown login method")
+	roleValidationFailed            = errors.New("role validation failed")
+	tlsPeerCertificateMissing       = errors.New("upstream did not present TLS certificate")
+	tlsPinnedCertificateMismatch    = errors.New("upstream TLS certificate does not match pinned fingerprint")
+	invalidFingerprint              = errors.New("fingerprint must be hex encoded SHA-256 sum")
+	unknownCipherSuite              = errors.New("unknown cipher suite")
+	tls13CipherSuiteNotConfigurable = errors.New("TLS 1.3 cipher suites are not configurable")
+	insecureCipherSuitesOnly        = errors.New("at least one secure cipher suite must be allowed")
+	unknownTLSVersion               = errors.New("unknown TLS version")
+	invalidProxyURL                 = errors.New("proxy URL must be an http, https or socks5 URL with host")
+	tokenNotFoundInWrappedData      = errors.New("token not found in wrapped data, expect data stored in key 'secret'")
+	accessorNotFoundInWrappedData   = errors.New("accessor not found in wrapped data, expect data stored in key 'secret'")
 )
 
 type crossVaultAuthBackend struct {
@@ -51,25 +88,124 @@ type crossVaultAuthBackend struct {
 	// tlsConfig for vault.Client. Periodically updated to handle CA certificate changes
 	tlsConfig *tls.Config
 
+	// tlsTrustedCASHA256 stores fingerprints of CA certificates currently trusted by tlsConfig
+	tlsTrustedCASHA256 []string
+
+	// tlsPinnedSHA256 is the fingerprint of upstream's leaf certificate currently enforced by tlsConfig
+	tlsPinnedSHA256 string
+
+	// proxyURL is the proxy currently applied to httpClient, proxy settings of the environment are used if empty
+	proxyURL string
+
 	// tlsConfigUpdateRunning reflects the current state of the tlsConfig update process
 	tlsConfigUpdateRunning bool
 
 	// tlsConfigUpdateCancel should be called on backend's shutdown
 	tlsConfigUpdateCancel context.CancelFunc
 
+	// tlsConfigUpdateDone is closed when the running tlsConfig update process exits
+	tlsConfigUpdateDone chan struct{}
+
+	// tlsConfigUpdatePeriod is the refresh interval of the running tlsConfig update process
+	tlsConfigUpdatePeriod time.Duration
+
+	// tlsConfigLastUpdate stores the time of the last tlsConfig update attempt made by the update process
+	tlsConfigLastUpdate time.Time
+
+	// tlsConfigLastUpdateErr stores the error of the last tlsConfig update attempt, nil if it succeeded
+	tlsConfigLastUpdateErr error
+
 	// default mutex provides thread safety for regular operations
 	mu sync.RWMutex
 
 	// tlsMu provides thread safety for TLS configuration updates operations
 	tlsMu sync.RWMutex
 
-	// ctx is the context used for requests to upstream Vault cluster
-	ctx context.Context
-	// cancel function for ctx
-	cancel context.CancelFunc
+	// tlsRestartMu serializes restarts of tlsConfig update process, concurrent ones would leave
+	// the process which can't be stopped
+	tlsRestartMu sync.Mutex
+
+	// sharedClient is the vault client shared by logins, rebuilt when sharedClientKey changes
+	sharedClient *api.Client
+
+	// sharedClientKey identifies configuration and TLS settings sharedClient was built with
+	sharedClientKey upstreamClientKey
+
+	// sharedClientMu provides thread safety for sharedClient operations
+	sharedClientMu sync.Mutex
+
+	// tlsConfigGeneration is incremented every time tlsConfig or httpClient is replaced, protected by tlsMu
+	tlsConfigGeneration uint64
+
+	// roleStatuses stores runtime state of the roles, not persisted
+	roleStatuses map[string]*roleStatus
+
+	// roleEntitiesLastCheck stores the time of the last roles' entities verification
+	roleEntitiesLastCheck time.Time
+
+	// upstreamVersion is the version target Vault cluster reported during the last version check
+	upstreamVersion string
+
+	// upstreamVersionWarnings lists enabled features target Vault cluster doesn't provide
+	upstreamVersionWarnings []string
+
+	// upstreamVersionLastCheck stores the time of the last successful target Vault cluster version check
+	upstreamVersionLastCheck time.Time
+
+	// upstreamVersionErr stores the error of the last target Vault cluster version check, nil if it succeeded
+	upstreamVersionErr error
+
+	// statusMu provides thread safety for roleStatuses and upstream version operations
+	statusMu sync.RWMutex
+
+	// mountOptions stores options the backend was mounted with, config fields among them are
+	// persisted as initial configuration
+	mountOptions map[string]string
+
+	// statsMu provides thread safety for methodAttempts and serializes read-modify-write operations of
+	// persisted login statistics
+	statsMu sync.Mutex
+
+	// methodAttempts stores login attempts per method counted since the statistics were persisted last time
+	methodAttempts map[string]int64
+
+	// roleCache stores recently read roles by lowercased name, used only if roleCacheTTL is positive
+	roleCache map[string]*roleCacheEntry
 
-	// vc is the vault client instance
-	vc *api.Client
+	// roleCacheTTL is the time roles are cached for, applied from configuration
+	roleCacheTTL time.Duration
+
+	// roleCacheGeneration is incremented on every invalidation, so roles read before it are not cached
+	roleCacheGeneration uint64
+
+	// roleCacheMu provides thread safety for role cache operations
+	roleCacheMu sync.Mutex
+
+	// roleCacheStats stores role cache counters since plugin start, protected by roleCacheMu
+	roleCacheStats roleCacheStats
+
+	// lookupCache stores source token lookup results by lookupCacheKey, used for logins only if
+	// fail_open_cached_lookups is set and target Vault cluster fails the lookup with a transient error
+	lookupCache map[string]*lookupCacheEntry
+
+	// entityEnabledCache stores expiration of positive entity status checks by entityCacheKey
+	entityEnabledCache map[string]time.Time
+
+	// lookupCacheMu provides thread safety for lookupCache and entityEnabledCache operations
+	lookupCacheMu sync.Mutex
+
+	// loginCounters stores numbers of logins by role, method and outcome since plugin start
+	loginCounters map[loginCounterKey]int64
+
+	// metricsMu provides thread safety for loginCounters operations
+	metricsMu sync.Mutex
+
+	// metaPatterns stores compiled entity_meta patterns of roles in regex match mode, so logins
+	// don't compile them again
+	metaPatterns *metaPatternCache
+
+	// displayNamePatterns stores compiled required_source_display_name patterns of roles
+	displayNamePatterns *metaPatternCache
 }
 
 func defaultHTTPClient() *http.Client {
@@ -77,7 +213,20 @@ func defaultHTTPClient() *http.Client {
 }
 
 func defaultTLSConfig() *tls.Config {
-	return &tls.Config{MinVersion: minTLSVersion}
+	return &tls.Config{MinVersion: defaultMinTLSVersion}
+}
+
+// fieldErrorResponse returns error response carrying the invalid field, error code and message in its
+// data, so API consumers can react to the failure without parsing the message.
+func fieldErrorResponse(field, code, message string) *logical.Response {
+	resp := logical.ErrorResponse(message)
+	// error responses may carry only 'data' element in addition to the error
+	resp.Data["data"] = map[string]interface{}{
+		"field":   field,
+		"code":    code,
+		"message": message,
+	}
+	return resp
 }
 
 func validateHTTPClient(b *crossVaultAuthBackend) error {
@@ -96,13 +245,22 @@ func Factory(ctx context.Context, conf *logical.BackendConfig) (logical.Backend,
 	if err := b.Setup(ctx, conf); err != nil {
 		return nil, err
 	}
+	b.mountOptions = conf.Config
 	return b, nil
 }
 
 func backend() *crossVaultAuthBackend {
 	b := &crossVaultAuthBackend{
-		httpClient: defaultHTTPClient(),
-		tlsConfig:  defaultTLSConfig(),
+		httpClient:          defaultHTTPClient(),
+		tlsConfig:           defaultTLSConfig(),
+		roleStatuses:        make(map[string]*roleStatus),
+		roleCache:           make(map[string]*roleCacheEntry),
+		methodAttempts:      make(map[string]int64),
+		lookupCache:         make(map[string]*lookupCacheEntry),
+		entityEnabledCache:  make(map[string]time.Time),
+		loginCounters:       make(map[loginCounterKey]int64),
+		metaPatterns:        newMetaPatternCache(compileMetaPattern),
+		displayNamePatterns: newMetaPatternCache(compileDisplayNamePattern),
 	}
 
 	b.Backend = &framework.Backend{
@@ -110,9 +268,25 @@ func backend() *crossVaultAuthBackend {
 		Paths: framework.PathAppend(
 			[]*framework.Path{
 				b.pathConfig(),
+				b.pathConfigStatus(),
+				b.pathConfigEffective(),
+				b.pathConfigCARotate(),
+				b.pathConfigTLSRestart(),
+				b.pathConfigReload(),
+				b.pathRoleSchema(),
+				b.pathRoleRepair(),
+				b.pathRoleStatus(),
+				b.pathRoleTestMatch(),
+				b.pathRoleRename(),
 				b.pathRole(),
 				b.pathRoleList(),
+				b.pathRoleBulk(),
 				b.pathLogin(),
+				b.pathExport(),
+				b.pathImport(),
+				b.pathMethodStats(),
+				b.pathMetrics(),
+				b.pathInfoVersion(),
 			},
 		),
 		PathsSpecial: &logical.Paths{
@@ -124,6 +298,7 @@ func backend() *crossVaultAuthBackend {
 			},
 		},
 		InitializeFunc: b.initialize,
+		Invalidate:     b.invalidate,
 		Clean:          b.cleanup,
 		BackendType:    logical.TypeCredential,
 		RunningVersion: pluginVersion,
@@ -133,16 +308,81 @@ func backend() *crossVaultAuthBackend {
 }
 
 func (b *crossVaultAuthBackend) initialize(ctx context.Context, req *logical.InitializationRequest) error {
+	if err := b.applyMountOptionsConfig(ctx, req.Storage); err != nil {
+		return err
+	}
+
+	// apply stored TLS settings right away, so logins after reload don't wait for the first tick
+	b.refreshTLSConfig(ctx, req.Storage)
+
+	period := tlsUpdateTicker
+	config, err := b.config(ctx, req.Storage)
+	if err != nil {
+		b.Logger().Warn("failed to read configuration, default TLS refresh interval is used", "error", err)
+	} else if config != nil {
+		period = config.tlsRefreshInterval()
+	}
+
 	tlsUpdaterContext, tlsUpdaterCancel := context.WithCancel(ctx)
-	if err := b.runTLSConfigUpdater(tlsUpdaterContext, req.Storage, tlsUpdateTicker); err != nil {
+	if err = b.runTLSConfigUpdater(tlsUpdaterContext, req.Storage, period); err != nil {
 		tlsUpdaterCancel()
 		return err
 	}
+	b.tlsMu.Lock()
 	b.tlsConfigUpdateCancel = tlsUpdaterCancel
+	b.tlsMu.Unlock()
+	return nil
+}
+
+// applyMountOptionsConfig persists config fields passed as mount options, so the backend is usable
+// right after mount. Options are validated the same way as on config write. Stored configuration
+// is never overwritten.
+func (b *crossVaultAuthBackend) applyMountOptionsConfig(ctx context.Context, storage logical.Storage) error {
+	path := b.pathConfig()
+	raw := make(map[string]interface{})
+	for key, value := range b.mountOptions {
+		if _, ok := path.Fields[key]; ok {
+			raw[key] = value
+		}
+	}
+	if len(raw) == 0 {
+		return nil
+	}
+	// target cluster might be offline while the plugin starts, so it is checked on demand only
+	if _, ok := raw["verify_connection"]; !ok {
+		raw["verify_connection"] = false
+	}
+
+	config, err := b.config(ctx, storage)
+	if err != nil {
+		return err
+	}
+	if config != nil {
+		b.Logger().Debug("configuration is already set, config mount options ignored")
+		return nil
+	}
+
+	data := &framework.FieldData{Raw: raw, Schema: path.Fields}
+	if err = data.Validate(); err != nil {
+		return errors.Wrap(err, "invalid config mount options")
+	}
+	resp, err := b.pathConfigWrite(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      configPath,
+		Storage:   storage,
+	}, data)
+	if err != nil {
+		return err
+	}
+	if resp.IsError() {
+		return errors.Wrap(resp.Error(), "invalid config mount options")
+	}
 	return nil
 }
 
 func (b *crossVaultAuthBackend) cleanup(_ context.Context) {
+	b.tlsMu.Lock()
+	defer b.tlsMu.Unlock()
 	if b.tlsConfigUpdateCancel != nil {
 		b.tlsConfigUpdateCancel()
 		b.tlsConfigUpdateCancel = nil
@@ -193,26 +433,41 @@ func (b *crossVaultAuthBackend) runTLSConfigUpdater(
 	}
 
 	wg.Add(1)
+	b.tlsConfigUpdatePeriod = period
+	done := make(chan struct{})
+	b.tlsConfigUpdateDone = done
 	ticker := time.NewTicker(period)
 	go func(ctx context.Context, storage logical.Storage) {
 		defer func() {
 			b.tlsMu.Lock()
 			ticker.Stop()
-			b.tlsConfigUpdateRunning = false
+			// restart may have started the next process already, if this one didn't exit in time
+			if b.tlsConfigUpdateDone == done {
+				b.tlsConfigUpdateRunning = false
+			}
+			close(done)
 			b.Logger().Trace("TLS config updater shutdown complete")
 			b.tlsMu.Unlock()
 		}()
 
 		b.tlsConfigUpdateRunning = true
 		wg.Done()
+		b.refreshUpstreamVersion(ctx, storage)
 		for {
 			select {
 			case <-ctx.Done():
 				return
 			case <-ticker.C:
-				if err = updateTLSConfig(ctx, b, storage); err != nil {
-					b.Logger().Warn("TLS config update failed", "error", err)
+				b.refreshTLSConfig(ctx, storage)
+				if checkErr := b.verifyRoleEntities(ctx, storage); checkErr != nil {
+					b.Logger().Warn("roles' entities verification failed", "error", checkErr)
+				}
+				// storage is read-only on performance standbys, they keep counting in memory
+				statsErr := b.persistMethodStats(ctx, storage)
+				if statsErr != nil && !errors.Is(statsErr, logical.ErrReadOnly) {
+					b.Logger().Warn("failed to persist login method statistics", "error", statsErr)
 				}
+				b.refreshUpstreamVersion(ctx, storage)
 			}
 		}
 	}(ctx, storage)
@@ -220,40 +475,409 @@ func (b *crossVaultAuthBackend) runTLSConfigUpdater(
 	return nil
 }
 
-func (b *crossVaultAuthBackend) updateTLSConfig(config *crossVaultAuthBackendConfig) error {
-	var caCertBytes []byte
+// restartTLSConfigUpdater stops the running tlsConfig update process, if any, waits for it to exit
+// and starts a new one with provided refresh interval (the same one if zero), then refreshes tlsConfig
+// right away. The new process is bound to the backend's lifetime, not to the context of the restart request.
+// It is started even if the old one doesn't exit until the context is done: the old one is cancelled
+// already and exits once its current refresh completes, while without the new one tlsConfig would not
+// be refreshed until the next restart.
+func (b *crossVaultAuthBackend) restartTLSConfigUpdater(
+	ctx context.Context,
+	storage logical.Storage,
+	period time.Duration,
+) error {
+	// the process' tick takes b.mu, so callers must not hold it
+	b.tlsRestartMu.Lock()
+	defer b.tlsRestartMu.Unlock()
+
+	b.tlsMu.RLock()
+	cancel, done, current := b.tlsConfigUpdateCancel, b.tlsConfigUpdateDone, b.tlsConfigUpdatePeriod
+	b.tlsMu.RUnlock()
+
+	if cancel != nil {
+		cancel()
+	}
+	if done != nil {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			b.Logger().Warn("TLS config updater didn't stop in time, the new one is started anyway",
+				"error", ctx.Err())
+			b.tlsMu.Lock()
+			if b.tlsConfigUpdateDone == done {
+				b.tlsConfigUpdateRunning = false
+			}
+			b.tlsMu.Unlock()
+		}
+	}
+	if period == time.Duration(0) {
+		period = current
+	}
+	if period == time.Duration(0) {
+		period = tlsUpdateTicker
+	}
+
+	tlsUpdaterContext, tlsUpdaterCancel := context.WithCancel(context.Background())
+	if err := b.runTLSConfigUpdater(tlsUpdaterContext, storage, period); err != nil {
+		tlsUpdaterCancel()
+		return err
+	}
+	b.tlsMu.Lock()
+	b.tlsConfigUpdateCancel = tlsUpdaterCancel
+	b.tlsMu.Unlock()
+
+	if ctx.Err() == nil {
+		b.refreshTLSConfig(ctx, storage)
+	}
+	return nil
+}
+
+// applyTLSRefreshInterval restarts the running tlsConfig update process if its refresh interval differs
+// from provided one. Nothing is started if the process is not running.
+func (b *crossVaultAuthBackend) applyTLSRefreshInterval(
+	ctx context.Context,
+	storage logical.Storage,
+	period time.Duration,
+) error {
+	b.tlsMu.RLock()
+	running, current := b.tlsConfigUpdateRunning, b.tlsConfigUpdatePeriod
+	b.tlsMu.RUnlock()
+	if !running || current == period {
+		return nil
+	}
+	if err := b.restartTLSConfigUpdater(ctx, storage, period); err != nil {
+		return err
+	}
+	b.Logger().Info("TLS config updater restarted with new refresh interval", "interval", period.String())
+	return nil
+}
 
+// refreshTLSConfig applies stored configuration to tlsConfig and records the outcome
+// reported by config/status endpoint. Failures are logged only.
+func (b *crossVaultAuthBackend) refreshTLSConfig(ctx context.Context, storage logical.Storage) {
+	updateErr := updateTLSConfig(ctx, b, storage)
+	if updateErr != nil {
+		b.Logger().Warn("TLS config update failed", "error", updateErr)
+	}
+	b.tlsMu.Lock()
+	b.tlsConfigLastUpdate = time.Now()
+	b.tlsConfigLastUpdateErr = updateErr
+	b.tlsMu.Unlock()
+}
+
+func (b *crossVaultAuthBackend) updateTLSConfig(config *crossVaultAuthBackendConfig) error {
 	b.tlsMu.Lock()
 	defer b.tlsMu.Unlock()
+	return b.updateTLSConfigLocked(config)
+}
 
+// updateTLSConfigLocked applies provided configuration to tlsConfig and HTTP client. The ones in use by
+// requests in flight are never modified: if any setting changes, new ones replace them and idle
+// connections of the previous transport are closed, so they don't outlive the previous settings.
+// Caller must hold tlsMu.
+func (b *crossVaultAuthBackend) updateTLSConfigLocked(config *crossVaultAuthBackendConfig) error {
 	if err := validateHTTPClient(b); err != nil {
 		return err
 	}
+	transport, ok := b.httpClient.Transport.(*http.Transport)
+	if !ok {
+		return typeAssertionFailed
+	}
+
+	tlsConfig, trustedCASHA256, err := b.newTLSConfigLocked(config)
+	if err != nil {
+		return err
+	}
+	proxy, err := proxyFunc(config.ProxyURL)
+	if err != nil {
+		return err
+	}
 
-	if config.CACert != "" {
-		caCertBytes = []byte(config.CACert)
+	tlsChanged := !sameTLSConfig(b.tlsConfig, tlsConfig) || b.tlsPinnedSHA256 != config.TLSPinnedSHA256
+	if !tlsChanged && b.proxyURL == config.ProxyURL && b.httpClient.Timeout == config.HTTPClientTimeout &&
+		transport.DisableKeepAlives == config.DisableKeepAlives &&
+		transport.IdleConnTimeout == config.idleConnTimeout() {
+		return nil
+	}
+	if tlsChanged {
+		b.tlsConfig = tlsConfig
+		b.tlsTrustedCASHA256 = trustedCASHA256
+		b.tlsPinnedSHA256 = config.TLSPinnedSHA256
 	}
+	b.httpClient = newHTTPClient(b.tlsConfig, proxy, config)
+	b.proxyURL = config.ProxyURL
+	transport.CloseIdleConnections()
+	b.tlsConfigGeneration++
+	return nil
+}
 
-	certPool := x509.NewCertPool()
-	if len(caCertBytes) > 0 {
+// newTLSConfigLocked returns tlsConfig built from provided configuration along with fingerprints of
+// trusted CA certificates. Caller must hold tlsMu.
+func (b *crossVaultAuthBackend) newTLSConfigLocked(config *crossVaultAuthBackendConfig) (*tls.Config, []string, error) {
+	caCertBytes, caErr := caCertificate(config)
+	certPool, err := rootCertPool(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	trustedCASHA256 := certificateFingerprints(caCertBytes)
+	switch {
+	case caErr != nil:
+		// the file might be missing for a moment while it is rewritten, so trusted CAs are not cleared
+		b.Logger().Warn("CA certificate file can't be read, previously trusted CA certificates are kept",
+			"error", caErr)
+		certPool = b.tlsConfig.RootCAs
+		trustedCASHA256 = b.tlsTrustedCASHA256
+	case len(caCertBytes) > 0:
 		if ok := certPool.AppendCertsFromPEM(caCertBytes); !ok {
 			b.Logger().Warn("Provided CA certificate data does not contain valid certificates")
 		}
-	} else {
+	case !config.AppendCACert:
 		b.Logger().Warn("No CA certificates provided")
 	}
 
-	if !b.tlsConfig.RootCAs.Equal(certPool) {
-		transport, ok := b.httpClient.Transport.(*http.Transport)
+	cipherSuites, err := cipherSuiteIDs(config.TLSCipherSuites)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certificates, err := clientCertificates(config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	minVersion, err := tlsVersionID(config.MinTLSVersion)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &tls.Config{
+		RootCAs:            certPool,
+		InsecureSkipVerify: config.InsecureSkipVerify,
+		VerifyConnection:   pinnedCertificateVerifier(config.TLSPinnedSHA256),
+		CipherSuites:       cipherSuites,
+		Certificates:       certificates,
+		MinVersion:         minVersion,
+		ServerName:         config.TLSServerName,
+	}, trustedCASHA256, nil
+}
+
+// sameTLSConfig reports whether both tlsConfigs hold the same settings, except the pinned certificate
+// verifier which can't be compared.
+func sameTLSConfig(a, b *tls.Config) bool {
+	return a.RootCAs.Equal(b.RootCAs) && a.InsecureSkipVerify == b.InsecureSkipVerify &&
+		slices.Equal(a.CipherSuites, b.CipherSuites) && sameCertificates(a.Certificates, b.Certificates) &&
+		a.MinVersion == b.MinVersion && a.ServerName == b.ServerName
+}
+
+// newHTTPClient returns HTTP client with its own transport using provided tlsConfig and proxy.
+func newHTTPClient(
+	tlsConfig *tls.Config,
+	proxy func(*http.Request) (*url.URL, error),
+	config *crossVaultAuthBackendConfig,
+) *http.Client {
+	transport := cleanhttp.DefaultPooledTransport()
+	transport.TLSClientConfig = tlsConfig
+	transport.Proxy = proxy
+	transport.DisableKeepAlives = config.DisableKeepAlives
+	transport.IdleConnTimeout = config.idleConnTimeout()
+	return &http.Client{
+		Transport: transport,
+		Timeout:   config.HTTPClientTimeout,
+	}
+}
+
+// resetTLSConfig restores default TLS settings, so CA certificates and pinned fingerprint of deleted
+// configuration are no longer trusted. Idle connections established with previous settings are closed.
+func (b *crossVaultAuthBackend) resetTLSConfig() error {
+	b.tlsMu.Lock()
+	defer b.tlsMu.Unlock()
+
+	if err := validateHTTPClient(b); err != nil {
+		return err
+	}
+	transport, ok := b.httpClient.Transport.(*http.Transport)
+	if !ok {
+		return typeAssertionFailed
+	}
+	b.tlsConfig = defaultTLSConfig()
+	b.tlsTrustedCASHA256 = nil
+	b.tlsPinnedSHA256 = ""
+	b.httpClient = newHTTPClient(b.tlsConfig, http.ProxyFromEnvironment, &crossVaultAuthBackendConfig{})
+	b.proxyURL = ""
+	transport.CloseIdleConnections()
+	b.tlsConfigGeneration++
+	return nil
+}
+
+// caCertificate returns PEM encoded CA certificate to trust, read from ca_cert_file if it is configured.
+func caCertificate(config *crossVaultAuthBackendConfig) ([]byte, error) {
+	if config.CACertFile != "" {
+		return os.ReadFile(config.CACertFile)
+	}
+	if config.CACert == "" {
+		return nil, nil
+	}
+	return []byte(config.CACert), nil
+}
+
+// rootCertPool returns pool CA certificates are added to, a copy of the system one if they are appended to it.
+func rootCertPool(config *crossVaultAuthBackendConfig) (*x509.CertPool, error) {
+	if !config.AppendCACert {
+		return x509.NewCertPool(), nil
+	}
+	return x509.SystemCertPool()
+}
+
+// clientCertificates returns client certificate presented to target Vault cluster, none if it is not configured.
+func clientCertificates(config *crossVaultAuthBackendConfig) ([]tls.Certificate, error) {
+	if config.ClientCert == "" && config.ClientKey == "" {
+		return nil, nil
+	}
+	certificate, err := tls.X509KeyPair([]byte(config.ClientCert), []byte(config.ClientKey))
+	if err != nil {
+		return nil, err
+	}
+	return []tls.Certificate{certificate}, nil
+}
+
+// sameCertificates reports whether both lists hold the same certificate chains. Private keys are not
+// compared, as the key of the certificate can't change without the certificate.
+func sameCertificates(a, b []tls.Certificate) bool {
+	return slices.EqualFunc(a, b, func(x, y tls.Certificate) bool {
+		return slices.EqualFunc(x.Certificate, y.Certificate, bytes.Equal)
+	})
+}
+
+// proxyFunc returns http.Transport Proxy callback using provided proxy URL, proxy settings of
+// the environment if it is empty.
+func proxyFunc(proxyURL string) (func(*http.Request) (*url.URL, error), error) {
+	if proxyURL == "" {
+		return http.ProxyFromEnvironment, nil
+	}
+	parsed, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Host == "" || (parsed.Scheme != "http" && parsed.Scheme != "https" && parsed.Scheme != "socks5") {
+		return nil, invalidProxyURL
+	}
+	return http.ProxyURL(parsed), nil
+}
+
+// pinnedCertificateVerifier returns tls.Config VerifyConnection callback rejecting connections
+// whose leaf certificate SHA-256 fingerprint differs from provided one. Returns nil if
+// fingerprint is empty.
+func pinnedCertificateVerifier(fingerprint string) func(tls.ConnectionState) error {
+	if fingerprint == "" {
+		return nil
+	}
+	return func(state tls.ConnectionState) error {
+		if len(state.PeerCertificates) == 0 {
+			return tlsPeerCertificateMissing
+		}
+		sum := sha256.Sum256(state.PeerCertificates[0].Raw)
+		if hex.EncodeToString(sum[:]) != fingerprint {
+			return tlsPinnedCertificateMismatch
+		}
+		return nil
+	}
+}
+
+// parseCertificates parses PEM encoded certificates in the same way they are added to x509.CertPool,
+// invalid blocks are skipped.
+func parseCertificates(pemCerts []byte) []*x509.Certificate {
+	var certs []*x509.Certificate
+	for len(pemCerts) > 0 {
+		var block *pem.Block
+		block, pemCerts = pem.Decode(pemCerts)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" || len(block.Headers) != 0 {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			continue
+		}
+		certs = append(certs, cert)
+	}
+	return certs
+}
+
+// certificateFingerprints returns hex encoded SHA-256 fingerprints of PEM encoded certificates
+// in the same way they are added to x509.CertPool, invalid blocks are skipped.
+func certificateFingerprints(pemCerts []byte) []string {
+	fingerprints := []string{}
+	for _, cert := range parseCertificates(pemCerts) {
+		fingerprints = append(fingerprints, certificateFingerprint(cert))
+	}
+	return fingerprints
+}
+
+// certificateFingerprint returns hex encoded SHA-256 fingerprint of the certificate.
+func certificateFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// cipherSuiteIDs returns IDs of named TLS 1.0-1.2 cipher suites, nil if no names provided. Unknown and
+// TLS 1.3 only suites are rejected, as well as lists consisting of insecure suites only.
+func cipherSuiteIDs(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	known := make(map[string]*tls.CipherSuite)
+	for _, suite := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		known[suite.Name] = suite
+	}
+
+	ids := make([]uint16, 0, len(names))
+	secure := false
+	for _, name := range names {
+		suite, ok := known[name]
 		if !ok {
-			return typeAssertionFailed
+			return nil, fmt.Errorf("%w: %q", unknownCipherSuite, name)
 		}
-		b.tlsConfig.RootCAs = certPool
-		b.tlsConfig.InsecureSkipVerify = config.InsecureSkipVerify
-		transport.TLSClientConfig = b.tlsConfig
+		if !slices.ContainsFunc(suite.SupportedVersions, func(version uint16) bool { return version < tls.VersionTLS13 }) {
+			return nil, fmt.Errorf("%w: %q", tls13CipherSuiteNotConfigurable, name)
+		}
+		secure = secure || !suite.Insecure
+		ids = append(ids, suite.ID)
+	}
+	if !secure {
+		return nil, insecureCipherSuitesOnly
 	}
+	return ids, nil
+}
 
-	return nil
+// tlsVersions maps accepted min_tls_version values to TLS version IDs.
+var tlsVersions = map[string]uint16{
+	"tls10": tls.VersionTLS10,
+	"tls11": tls.VersionTLS11,
+	"tls12": tls.VersionTLS12,
+	"tls13": tls.VersionTLS13,
+}
+
+// tlsVersionID returns ID of named TLS version, defaultMinTLSVersion if no name provided.
+func tlsVersionID(name string) (uint16, error) {
+	if name == "" {
+		return defaultMinTLSVersion, nil
+	}
+	id, ok := tlsVersions[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("%w: %q, expected one of tls10, tls11, tls12, tls13", unknownTLSVersion, name)
+	}
+	return id, nil
+}
+
+// normalizeFingerprint converts SHA-256 fingerprint to lowercase hex string without separators.
+func normalizeFingerprint(fingerprint string) (string, error) {
+	normalized := strings.ToLower(strings.ReplaceAll(fingerprint, ":", ""))
+	if decoded, err := hex.DecodeString(normalized); err != nil || len(decoded) != sha256.Size {
+		return "", invalidFingerprint
+	}
+	return normalized, nil
 }
 
 func updateTLSConfig(ctx context.Context, b *crossVaultAuthBackend, storage logical.Storage) error {
@@ -270,6 +894,8 @@ func updateTLSConfig(ctx context.Context, b *crossVaultAuthBackend, storage logi
 	if err = b.updateTLSConfig(config); err != nil {
 		return err
 	}
+	// role cache TTL is refreshed together with TLS config, so changes made on other nodes are applied
+	b.setRoleCacheTTL(config.RoleCacheTTL)
 	return nil
 }
 
@@ -283,18 +909,27 @@ func (b *crossVaultAuthBackend) role(
 		err error
 	)
 
-	raw, err = storage.Get(ctx, fmt.Sprintf("%s/%s", rolePath, strings.ToLower(name)))
-	if err != nil {
-		return nil, err
-	}
-	if raw == nil {
-		return nil, nil
+	value, cached := b.cachedRole(name)
+	cacheEnabled, generation := b.roleCacheState()
+	if !cached {
+		raw, err = storage.Get(ctx, fmt.Sprintf("%s/%s", rolePath, strings.ToLower(name)))
+		if err != nil {
+			return nil, err
+		}
+		if raw == nil {
+			return nil, nil
+		}
+		value = raw.Value
 	}
 
 	role := &crossVaultAuthRoleEntry{}
-	if err = json.Unmarshal(raw.Value, role); err != nil {
-		return nil, err
+	if err = json.Unmarshal(value, role); err != nil {
+		return nil, fmt.Errorf("%w: role %q: %v, use role/%s/repair to remove it", roleStorageEntryCorrupted,
+			name, err, name)
 	}
 
+	if !cached && cacheEnabled {
+		b.cacheRole(name, value, generation)
+	}
 	return role, nil
 }
@@ -0,0 +1,200 @@
+package cva
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	log "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/sdk/helper/logging"
+	"github.com/hashicorp/vault/sdk/logical"
+	"gotest.tools/v3/assert"
+)
+
+func TestBackend_InitializeAppliesTLSConfig(t *testing.T) {
+	t.Parallel()
+
+	upstream := newTestTLSUpstream(t, upstreamHandlers(map[string]interface{}{"entity_id": testEntityID}))
+	b, storage := getBackend(t)
+	ctx := context.Background()
+
+	// configuration stored before reload, not applied by the new backend instance yet
+	entry, err := logical.StorageEntryJSON(configPath, &crossVaultAuthBackendConfig{
+		Cluster:   upstream.URL,
+		Namespace: rootNamespace,
+		CACert:    certificatePEM(upstream),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = storage.Put(ctx, entry); err != nil {
+		t.Fatal(err)
+	}
+	writeRole(t, b, storage, "sample", map[string]interface{}{"entity_id": testEntityID})
+
+	if err = b.Initialize(ctx, &logical.InitializationRequest{Storage: storage}); err != nil {
+		t.Fatal(err)
+	}
+	defer b.Cleanup(ctx)
+
+	cvab := b.(*crossVaultAuthBackend)
+	cvab.tlsMu.RLock()
+	assert.Assert(t, cvab.tlsConfig.RootCAs != nil)
+	cvab.tlsMu.RUnlock()
+
+	resp, err := doLogin(t, b, storage, map[string]interface{}{"role": "sample", "secret": testWrappedToken})
+	if err != nil || resp.IsError() {
+		t.Fatalf("unexpected error: %v, %v", err, resp)
+	}
+}
+
+func TestBackend_InitializeMountOptions(t *testing.T) {
+	t.Parallel()
+
+	upstream := newTestTLSUpstream(t, upstreamHandlers(map[string]interface{}{"entity_id": testEntityID}))
+
+	tests := map[string]struct {
+		options         map[string]string
+		storedCluster   string
+		expectErr       bool
+		expectedCluster string
+	}{
+		"no-options": {
+			options: map[string]string{"plugin_name": "cross-vault-auth"},
+		},
+		"options": {
+			options: map[string]string{
+				"plugin_name": "cross-vault-auth",
+				"cluster":     upstream.URL,
+				"ca_cert":     certificatePEM(upstream),
+			},
+			expectedCluster: upstream.URL,
+		},
+		"invalid-options": {
+			options:   map[string]string{"cluster": upstream.URL, "unwrap_retries": "-1"},
+			expectErr: true,
+		},
+		"stored-config-kept": {
+			options:         map[string]string{"cluster": upstream.URL},
+			storedCluster:   "https://127.0.0.1:8200",
+			expectedCluster: "https://127.0.0.1:8200",
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			storage := &logical.InmemStorage{}
+			b, err := Factory(ctx, &logical.BackendConfig{
+				Logger:      logging.NewVaultLogger(log.Trace),
+				System:      &logical.StaticSystemView{MaxLeaseTTLVal: time.Hour},
+				StorageView: storage,
+				Config:      tCase.options,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tCase.storedCluster != "" {
+				writeConfig(t, b, storage, map[string]interface{}{"cluster": tCase.storedCluster})
+			}
+
+			err = b.Initialize(ctx, &logical.InitializationRequest{Storage: storage})
+			defer b.Cleanup(ctx)
+			if tCase.expectErr {
+				assert.Assert(t, err != nil)
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			config, err := b.(*crossVaultAuthBackend).config(ctx, storage)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tCase.expectedCluster == "" {
+				assert.Assert(t, config == nil)
+				return
+			}
+			assert.Equal(t, config.Cluster, tCase.expectedCluster)
+		})
+	}
+}
+
+func TestBackend_MountOptionsLogin(t *testing.T) {
+	t.Parallel()
+
+	upstream := newTestTLSUpstream(t, upstreamHandlers(map[string]interface{}{"entity_id": testEntityID}))
+	ctx := context.Background()
+	storage := &logical.InmemStorage{}
+	b, err := Factory(ctx, &logical.BackendConfig{
+		Logger:      logging.NewVaultLogger(log.Trace),
+		System:      &logical.StaticSystemView{MaxLeaseTTLVal: time.Hour},
+		StorageView: storage,
+		Config:      map[string]string{"cluster": upstream.URL, "ca_cert": certificatePEM(upstream)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = b.Initialize(ctx, &logical.InitializationRequest{Storage: storage}); err != nil {
+		t.Fatal(err)
+	}
+	defer b.Cleanup(ctx)
+	writeRole(t, b, storage, "sample", map[string]interface{}{"entity_id": testEntityID})
+
+	resp, err := doLogin(t, b, storage, map[string]interface{}{"role": "sample", "secret": testWrappedToken})
+	if err != nil || resp.IsError() {
+		t.Fatalf("unexpected error: %v, %v", err, resp)
+	}
+}
+
+func TestBackend_HTTPClientReplacedOnConfigChange(t *testing.T) {
+	t.Parallel()
+
+	upstream := newTestUpstream(t, upstreamHandlers(map[string]interface{}{"entity_id": testEntityID}))
+	b, storage := getBackend(t)
+	writeConfig(t, b, storage, map[string]interface{}{"cluster": upstream.URL})
+	writeRole(t, b, storage, "sample", map[string]interface{}{"entity_id": testEntityID})
+
+	cvab := b.(*crossVaultAuthBackend)
+	previous := cvab.httpClient
+
+	// logins keep using the client while settings change, the race detector reports modifications in place
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				resp, err := doLogin(t, b, storage, map[string]interface{}{"role": "sample", "secret": testWrappedToken})
+				if err != nil || resp.IsError() {
+					t.Errorf("unexpected error: %v, %v", err, resp)
+				}
+			}
+		}()
+	}
+	for i := 0; i < 5; i++ {
+		writeConfig(t, b, storage, map[string]interface{}{
+			"cluster":             upstream.URL,
+			"disable_keep_alives": i%2 == 0,
+			"idle_conn_timeout":   30 + i,
+			"http_client_timeout": 60 + i,
+			"proxy_url":           []string{"", upstream.URL}[i%2],
+		})
+		writeConfig(t, b, storage, map[string]interface{}{"cluster": upstream.URL})
+	}
+	wg.Wait()
+
+	cvab.tlsMu.RLock()
+	defer cvab.tlsMu.RUnlock()
+	assert.Assert(t, cvab.httpClient != previous)
+	transport, ok := previous.Transport.(*http.Transport)
+	assert.Assert(t, ok)
+	// the replaced client keeps the settings it was used with
+	assert.Equal(t, previous.Timeout, time.Duration(0))
+	assert.Equal(t, transport.DisableKeepAlives, false)
+}
@@ -2,6 +2,10 @@ package cva
 
 import (
 	"context"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
@@ -10,7 +14,19 @@ import (
 	"github.com/hashicorp/vault/sdk/logical"
 )
 
+const (
+	testEntityID     = "11112222-3333-4444-5555-666677778888"
+	testWrappedToken = "hvs.wrapped"
+	testSourceToken  = "hvs.source"
+)
+
 func getBackend(t *testing.T) (logical.Backend, logical.Storage) {
+	t.Helper()
+	return getBackendWithEvents(t, nil)
+}
+
+// getBackendWithEvents returns backend configured with provided event sender.
+func getBackendWithEvents(t *testing.T, events logical.EventSender) (logical.Backend, logical.Storage) {
 	t.Helper()
 	defaultLeaseTTL := time.Hour * 24
 	maxLeaseTTL := time.Hour * 24
@@ -26,7 +42,8 @@ func getBackend(t *testing.T) (logical.Backend, logical.Storage) {
 			DefaultLeaseTTLVal: defaultLeaseTTL,
 			MaxLeaseTTLVal:     maxLeaseTTL,
 		},
-		StorageView: &logical.InmemStorage{},
+		StorageView:  &logical.InmemStorage{},
+		EventsSender: events,
 	}
 	if err := b.Setup(context.Background(), config); err != nil {
 		t.Fatalf("failed to setup backend: %v", err)
@@ -34,3 +51,117 @@ func getBackend(t *testing.T) (logical.Backend, logical.Storage) {
 
 	return b, config.StorageView
 }
+
+// newTestUpstream starts HTTP server imitating upstream Vault cluster. Handlers are
+// registered by API path, e.g. "/v1/sys/wrapping/unwrap".
+func newTestUpstream(t *testing.T, handlers map[string]http.HandlerFunc) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(upstreamMux(handlers))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// newTestTLSUpstream is the same as newTestUpstream, but server uses TLS.
+func newTestTLSUpstream(t *testing.T, handlers map[string]http.HandlerFunc) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewTLSServer(upstreamMux(handlers))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func upstreamMux(handlers map[string]http.HandlerFunc) *http.ServeMux {
+	mux := http.NewServeMux()
+	for path, handler := range handlers {
+		mux.HandleFunc(path, handler)
+	}
+	return mux
+}
+
+// certificatePEM returns PEM encoded certificate of TLS test server.
+func certificatePEM(srv *httptest.Server) string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}))
+}
+
+// jsonHandler responds with provided status code and body encoded as JSON.
+func jsonHandler(status int, body interface{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(body)
+	}
+}
+
+// unwrapHandler responds with wrapped data suitable for every login method.
+func unwrapHandler(secret string) http.HandlerFunc {
+	return jsonHandler(http.StatusOK, map[string]interface{}{
+		"auth": map[string]interface{}{"client_token": secret},
+		"data": map[string]interface{}{"secret": secret},
+	})
+}
+
+// lookupHandler responds with provided token lookup data.
+func lookupHandler(data map[string]interface{}) http.HandlerFunc {
+	return jsonHandler(http.StatusOK, map[string]interface{}{"data": data})
+}
+
+// upstreamHandlers returns handlers for unwrap and both lookup endpoints responding with provided lookup data.
+func upstreamHandlers(lookup map[string]interface{}) map[string]http.HandlerFunc {
+	return map[string]http.HandlerFunc{
+		"/v1/sys/wrapping/unwrap":        unwrapHandler(testSourceToken),
+		"/v1/auth/token/lookup":          lookupHandler(lookup),
+		"/v1/auth/token/lookup-accessor": lookupHandler(lookup),
+	}
+}
+
+// unverifiedConfig disables connection check of config write unless data sets it, test upstreams don't
+// serve health requests.
+func unverifiedConfig(data map[string]interface{}) map[string]interface{} {
+	if data == nil {
+		data = make(map[string]interface{})
+	}
+	if _, ok := data["verify_connection"]; !ok {
+		data["verify_connection"] = false
+	}
+	return data
+}
+
+func writeConfig(t *testing.T, b logical.Backend, storage logical.Storage, data map[string]interface{}) {
+	t.Helper()
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      configPath,
+		Data:      unverifiedConfig(data),
+		Storage:   storage,
+	})
+	if err != nil || resp.IsError() {
+		t.Fatalf("failed to write config: %v, %v", err, resp)
+	}
+}
+
+func writeRole(t *testing.T, b logical.Backend, storage logical.Storage, name string, data map[string]interface{}) {
+	t.Helper()
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      rolePath + "/" + name,
+		Data:      data,
+		Storage:   storage,
+	})
+	if err != nil || resp.IsError() {
+		t.Fatalf("failed to write role: %v, %v", err, resp)
+	}
+}
+
+func doLogin(
+	t *testing.T,
+	b logical.Backend,
+	storage logical.Storage,
+	data map[string]interface{},
+) (*logical.Response, error) {
+	t.Helper()
+	return b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      loginPath,
+		Data:      data,
+		Storage:   storage,
+	})
+}
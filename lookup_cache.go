@@ -0,0 +1,115 @@
+package cva
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/armon/go-metrics"
+)
+
+const (
+	// failOpenCacheTTL is the time positive results of upstream checks are usable for fail-open logins
+	failOpenCacheTTL = 5 * time.Minute
+	// maxLookupCacheEntries limits the number of cached results, the cache is reset once it is reached
+	maxLookupCacheEntries = 10000
+)
+
+// lookupCacheEntry is the source token looked up by a successful request to target Vault cluster,
+// cached for fail-open logins until expiration.
+type lookupCacheEntry struct {
+	source *sourceToken
+
+	expiresAt time.Time
+}
+
+// lookupCacheKey returns the cache key of source token lookup result. The secret is hashed, so source
+// tokens and accessors are never kept in memory by the cache.
+func lookupCacheKey(namespace, method, secret string) string {
+	sum := sha256.Sum256([]byte(normalizeNamespace(namespace) + "\x00" + method + "\x00" + secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// entityCacheKey returns the cache key of positive entity status check.
+func entityCacheKey(namespace, entityID string) string {
+	return normalizeNamespace(namespace) + "\x00" + strings.ToLower(entityID)
+}
+
+// clone returns the copy of source token which doesn't share metadata and policies with the original.
+func (s *sourceToken) clone() *sourceToken {
+	c := *s
+	c.Meta = maps.Clone(s.Meta)
+	c.Policies = slices.Clone(s.Policies)
+	c.IdentityPolicies = slices.Clone(s.IdentityPolicies)
+	c.Warnings = nil
+	c.Degraded = false
+	return &c
+}
+
+// cacheLookup caches source token lookup result for fail-open logins.
+func (b *crossVaultAuthBackend) cacheLookup(key string, source *sourceToken) {
+	b.lookupCacheMu.Lock()
+	defer b.lookupCacheMu.Unlock()
+
+	if len(b.lookupCache) >= maxLookupCacheEntries {
+		b.lookupCache = make(map[string]*lookupCacheEntry)
+	}
+	b.lookupCache[key] = &lookupCacheEntry{
+		source:    source.clone(),
+		expiresAt: time.Now().Add(failOpenCacheTTL),
+	}
+}
+
+// cachedLookup returns the copy of cached source token lookup result if it is not expired.
+func (b *crossVaultAuthBackend) cachedLookup(key string) (*sourceToken, bool) {
+	b.lookupCacheMu.Lock()
+	defer b.lookupCacheMu.Unlock()
+
+	entry, ok := b.lookupCache[key]
+	if ok && time.Now().After(entry.expiresAt) {
+		delete(b.lookupCache, key)
+		ok = false
+	}
+	if !ok {
+		metrics.IncrCounter([]string{metricsPrefix, "lookup_cache", "miss"}, 1)
+		return nil, false
+	}
+	metrics.IncrCounter([]string{metricsPrefix, "lookup_cache", "hit"}, 1)
+	return entry.source.clone(), true
+}
+
+// cacheEntityEnabled caches positive entity status check for fail-open logins.
+func (b *crossVaultAuthBackend) cacheEntityEnabled(key string) {
+	b.lookupCacheMu.Lock()
+	defer b.lookupCacheMu.Unlock()
+
+	if len(b.entityEnabledCache) >= maxLookupCacheEntries {
+		b.entityEnabledCache = make(map[string]time.Time)
+	}
+	b.entityEnabledCache[key] = time.Now().Add(failOpenCacheTTL)
+}
+
+// cachedEntityEnabled reports whether the entity was found enabled recently.
+func (b *crossVaultAuthBackend) cachedEntityEnabled(key string) bool {
+	b.lookupCacheMu.Lock()
+	defer b.lookupCacheMu.Unlock()
+
+	expiresAt, ok := b.entityEnabledCache[key]
+	if ok && time.Now().After(expiresAt) {
+		delete(b.entityEnabledCache, key)
+		ok = false
+	}
+	return ok
+}
+
+// resetLookupCache removes all cached results. Must be called on every configuration change.
+func (b *crossVaultAuthBackend) resetLookupCache() {
+	b.lookupCacheMu.Lock()
+	defer b.lookupCacheMu.Unlock()
+
+	b.lookupCache = make(map[string]*lookupCacheEntry)
+	b.entityEnabledCache = make(map[string]time.Time)
+}
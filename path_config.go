@@ -2,7 +2,15 @@ package cva
 
 import (
 	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/hashicorp/go-secure-stdlib/strutil"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
 )
@@ -10,14 +18,50 @@ import (
 const (
 	rootNamespace = "root"
 
+	methodPrecedenceRequest = "request"
+	methodPrecedenceRole    = "role"
+
+	disallowedPoliciesReject = "reject"
+	disallowedPoliciesFilter = "filter"
+
+	strictEmptyMetaEmpty = "empty"
+	strictEmptyMetaAny   = "any"
+
+	defaultMaxEntityMetaLength = 1024
+	defaultMaxTokenPolicies    = 64
+
+	defaultTokenAuthMount = "token"
+
+	// defaultIdleConnTimeout matches idle timeout of the pooled HTTP client transport
+	defaultIdleConnTimeout = time.Second * 90
+
+	// maxRoleCacheTTL bounds role cache TTL, so roles changed on other nodes are not served stale for long
+	maxRoleCacheTTL = time.Minute * 5
+
 	configHelpSynopsis    = "Configures target Vault cluster API information"
 	configHelpDescription = `
 The Cross Vault Auth Backend validates token, issued by the target 
 Vault cluster using token lookup capability. It ensures, that the 
 token is valid and matches provided role configuration: entity ID 
 and it's metadata.`
+
+	configStatusHelpSynopsis    = "Reports the state of the background TLS config updater"
+	configStatusHelpDescription = `
+The TLS config updater periodically re-applies the stored CA certificate 
+to the HTTP client used to reach the target Vault cluster. The endpoint 
+reports whether the updater is running, its refresh interval, the 
+outcome of its last refresh and SHA-256 fingerprints of CA certificates 
+currently trusted, as well as the outcome of the last target Vault 
+cluster version check.`
 )
 
+// defaultCredentialHeaders are names of request headers login fields are read from, if allowed
+var defaultCredentialHeaders = map[string]string{
+	"role":   "X-Cross-Vault-Role",
+	"secret": "X-Cross-Vault-Secret",
+	"method": "X-Cross-Vault-Method",
+}
+
 type crossVaultAuthBackendConfig struct {
 	// Cluster stores the address of the target Vault cluster
 	Cluster string `json:"cluster"`
@@ -28,8 +72,144 @@ type crossVaultAuthBackendConfig struct {
 	// CACert stores CA certificate to validate target Vault cluster's cert
 	CACert string `json:"ca_cert"`
 
+	// CACertFile stores path of PEM encoded CA certificate file read on every TLS config refresh,
+	// takes precedence over CACert
+	CACertFile string `json:"ca_cert_file"`
+
+	// AppendCACert defines whether CA certificates are trusted in addition to system ones instead of replacing them
+	AppendCACert bool `json:"append_ca_cert"`
+
+	// ClientCert stores PEM encoded certificate presented to target Vault cluster requiring client certificates
+	ClientCert string `json:"client_cert"`
+
+	// ClientKey stores PEM encoded private key of ClientCert, it is never returned by the API
+	ClientKey string `json:"client_key"`
+
 	// InsecureSkipVerify defines whether to skip TLS verification
 	InsecureSkipVerify bool `json:"insecure_skip_verify"`
+
+	// MethodPrecedence defines which login method wins when the one requested
+	// conflicts with the single method allowed by the role
+	MethodPrecedence string `json:"method_precedence"`
+
+	// EmitEvents defines whether to send login events via Vault event system
+	EmitEvents bool `json:"emit_events"`
+
+	// MetaKeyStripPrefix is removed from upstream metadata keys before comparison with role's metadata
+	MetaKeyStripPrefix string `json:"meta_key_strip_prefix"`
+
+	// UnwrapRetries defines how many times unwrap is retried on transient upstream failures
+	UnwrapRetries int `json:"unwrap_retries"`
+
+	// TLSPinnedSHA256 is the expected SHA-256 fingerprint of target Vault cluster's leaf certificate
+	TLSPinnedSHA256 string `json:"tls_pinned_sha256"`
+
+	// TLSCipherSuites restricts cipher suites of TLS 1.0-1.2 connections to target Vault cluster, Go defaults if empty
+	TLSCipherSuites []string `json:"tls_cipher_suites"`
+
+	// TLSServerName overrides the server name sent with SNI and verified against target Vault cluster's
+	// certificate, the host of Cluster is used if empty
+	TLSServerName string `json:"tls_server_name"`
+
+	// MinTLSVersion is the minimal TLS version of connections to target Vault cluster, TLS 1.2 if empty
+	MinTLSVersion string `json:"min_tls_version"`
+
+	// TLSRefreshInterval is the period of background TLS config refresh, tlsUpdateTicker if zero
+	TLSRefreshInterval time.Duration `json:"tls_refresh_interval"`
+
+	// MaxTokenTTL is the ceiling for token_ttl and token_max_ttl of all roles, no ceiling if zero
+	MaxTokenTTL time.Duration `json:"max_token_ttl"`
+
+	// VerifyRoleEntities defines whether to periodically check that roles' entities exist upstream
+	VerifyRoleEntities bool `json:"verify_role_entities"`
+
+	// AllowedPolicies limits policies roles may grant, no limit if empty
+	AllowedPolicies []string `json:"allowed_policies"`
+
+	// DisallowedPoliciesAction defines whether login with a role granting policies outside
+	// AllowedPolicies is rejected or issues token with such policies filtered out
+	DisallowedPoliciesAction string `json:"disallowed_policies_action"`
+
+	// MethodAutodetect defines whether login method is detected from the secret's shape if not requested
+	MethodAutodetect bool `json:"method_autodetect"`
+
+	// StrictEmptyMeta defines how strict roles without metadata constraints are verified: upstream
+	// entity must have no metadata or may have any metadata
+	StrictEmptyMeta string `json:"strict_empty_meta"`
+
+	// HTTPClientTimeout is the hard cap of HTTP requests to target Vault cluster, no cap if zero
+	HTTPClientTimeout time.Duration `json:"http_client_timeout"`
+
+	// AllowDuplicateMetaKeys defines whether duplicate keys in roles' metadata input are accepted,
+	// the last value wins then
+	AllowDuplicateMetaKeys bool `json:"allow_duplicate_meta_keys"`
+
+	// AllowedNamespaces limits namespaces roles may override the configured one with, no limit if empty
+	AllowedNamespaces []string `json:"allowed_namespaces"`
+
+	// StrictValidation defines whether inconsistent settings are rejected on write instead of being warned about
+	StrictValidation bool `json:"strict_validation"`
+
+	// DebugLogin defines whether login responses carry debugging details. Not intended for production
+	DebugLogin bool `json:"debug_login"`
+
+	// MaxEntityMetaLength limits length of roles' metadata keys and values, no limit if zero
+	MaxEntityMetaLength int `json:"max_entity_meta_length"`
+
+	// MaxTokenPolicies limits number of roles' token_policies, no limit if zero
+	MaxTokenPolicies int `json:"max_token_policies"`
+
+	// AllowHeaderCredentials defines whether login fields absent in the request body are read from request headers
+	AllowHeaderCredentials bool `json:"allow_header_credentials"`
+
+	// CredentialHeaders maps login fields to the names of request headers they are read from
+	CredentialHeaders map[string]string `json:"credential_headers"`
+
+	// ProxyURL is the proxy requests to target Vault cluster are sent through, proxy settings of
+	// the environment are used if empty
+	ProxyURL string `json:"proxy_url"`
+
+	// DisableKeepAlives defines whether connections to target Vault cluster are closed after each request
+	DisableKeepAlives bool `json:"disable_keep_alives"`
+
+	// IdleConnTimeout is the time idle connections to target Vault cluster are kept, default one if zero
+	IdleConnTimeout time.Duration `json:"idle_conn_timeout"`
+
+	// TokenAuthMount is the path of token auth method source tokens are looked up at, relative to the namespace
+	TokenAuthMount string `json:"token_auth_mount"`
+
+	// ValidateOnWrite defines whether target Vault cluster must be reachable for configuration to be written
+	ValidateOnWrite bool `json:"validate_on_write"`
+
+	// ForwardUpstreamWarnings defines whether warnings of source token lookup are added to login responses
+	ForwardUpstreamWarnings bool `json:"forward_upstream_warnings"`
+
+	// FailOpenCachedLookups defines whether cached source token lookup results are used while target
+	// Vault cluster is unreachable
+	FailOpenCachedLookups bool `json:"fail_open_cached_lookups"`
+
+	// HashAuditEntityIDs defines whether entity IDs in issued tokens' metadata and display name are hashed
+	HashAuditEntityIDs bool `json:"hash_audit_entity_ids"`
+
+	// DeniedEntityIDs lists entities of target Vault cluster logins are rejected for, regardless of roles
+	DeniedEntityIDs []string `json:"denied_entity_ids"`
+
+	// TrustOnFirstUse defines whether certificate presented by target Vault cluster is pinned on config
+	// write if no pin is set
+	TrustOnFirstUse bool `json:"trust_on_first_use"`
+
+	// RoleCacheTTL is the time roles read from storage are cached in memory for, cache is disabled if zero
+	RoleCacheTTL time.Duration `json:"role_cache_ttl"`
+
+	// AllowRoleSelection defines whether login without role selects the most specific role the source token matches
+	AllowRoleSelection bool `json:"allow_role_selection"`
+
+	// RequiredMetaKeyPrefix is the prefix every metadata key of roles must start with, not enforced if empty
+	RequiredMetaKeyPrefix string `json:"required_meta_key_prefix"`
+
+	// CheckUpstreamVersion defines whether version of target Vault cluster is periodically checked
+	// against the versions required by enabled features
+	CheckUpstreamVersion bool `json:"check_upstream_version"`
 }
 
 func (b *crossVaultAuthBackend) pathConfig() *framework.Path {
@@ -50,11 +230,264 @@ func (b *crossVaultAuthBackend) pathConfig() *framework.Path {
 				Type:        framework.TypeString,
 				Description: "PEM encoded CA cert to be used by HTTP client",
 			},
+			"ca_cert_file": {
+				Type: framework.TypeString,
+				Description: `Absolute path of PEM encoded CA cert file, e.g. maintained by cert-manager. The file 
+is read on every TLS config refresh instead of using ca_cert, so its rotations are picked up automatically`,
+			},
+			"append_ca_cert": {
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `Flag defines whether ca_cert (or ca_cert_file) is trusted in addition to system CA 
+certificates instead of replacing them`,
+			},
+			"client_cert": {
+				Type: framework.TypeString,
+				Description: `PEM encoded client certificate presented to target Vault cluster, which requires 
+client certificates. Must be provided together with client_key`,
+			},
+			"client_key": {
+				Type:        framework.TypeString,
+				Description: "PEM encoded private key of client_cert. Never returned on read",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Sensitive: true,
+				},
+			},
 			"insecure_skip_verify": {
 				Type:        framework.TypeBool,
 				Default:     false,
 				Description: "Flag defines whether to skip TLS verification",
 			},
+			"method_precedence": {
+				Type:    framework.TypeString,
+				Default: methodPrecedenceRequest,
+				Description: `Defines which login method wins when the requested one conflicts with the 
+single method allowed by the role. 'request' rejects the login, 'role' uses the role's method.`,
+				AllowedValues: []interface{}{methodPrecedenceRequest, methodPrecedenceRole},
+			},
+			"emit_events": {
+				Type:        framework.TypeBool,
+				Default:     false,
+				Description: "Flag defines whether to send login events via Vault event system",
+			},
+			"meta_key_strip_prefix": {
+				Type:        framework.TypeString,
+				Description: "Prefix to be removed from upstream metadata keys before comparison with role's metadata",
+			},
+			"unwrap_retries": {
+				Type:    framework.TypeInt,
+				Default: 0,
+				Description: `Number of unwrap retries on transient upstream failures. Rejected wrapping 
+tokens are never retried. Retries are bound to the request timeout`,
+			},
+			"tls_pinned_sha256": {
+				Type: framework.TypeString,
+				Description: `Hex encoded SHA-256 fingerprint of target Vault cluster's leaf certificate. 
+If set, connections presenting another certificate are rejected`,
+			},
+			"tls_cipher_suites": {
+				Type: framework.TypeCommaStringSlice,
+				Description: `Names of cipher suites allowed for TLS 1.0-1.2 connections to target Vault cluster, 
+e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. At least one suite must be secure. TLS 1.3 suites are not 
+configurable. Go defaults are used if not set`,
+			},
+			"tls_server_name": {
+				Type: framework.TypeString,
+				Description: `Server name sent with SNI and verified against target Vault cluster's certificate, 
+e.g. when cluster is an IP address or load balancer hostname not present in the certificate. The host of 
+cluster is used if not set`,
+			},
+			"min_tls_version": {
+				Type:          framework.TypeString,
+				Default:       "tls12",
+				AllowedValues: []interface{}{"tls10", "tls11", "tls12", "tls13"},
+				Description: `Minimal TLS version of connections to target Vault cluster. Versions below 
+TLS 1.2 are meant for legacy clusters only`,
+			},
+			"tls_refresh_interval": {
+				Type: framework.TypeDurationSecond,
+				Description: `Period of background refresh of TLS config (CA certificate, client certificate, etc.) 
+from stored configuration. Running refresh process is restarted on change. Defaults to 30s if not set`,
+			},
+			"max_token_ttl": {
+				Type: framework.TypeDurationSecond,
+				Description: `Ceiling for token_ttl and token_max_ttl of all roles. Roles exceeding it are 
+rejected on write. No ceiling if not set`,
+			},
+			"verify_role_entities": {
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `Flag defines whether to periodically check that entities bound to roles still 
+exist in target Vault cluster. Requires read access to identity/entity/id/*`,
+			},
+			"allowed_policies": {
+				Type: framework.TypeCommaStringSlice,
+				Description: `Policies roles are allowed to grant. Roles with other token_policies are rejected 
+on write and handled according to disallowed_policies_action on login. No limit if not set`,
+			},
+			"disallowed_policies_action": {
+				Type:    framework.TypeString,
+				Default: disallowedPoliciesReject,
+				Description: `Defines login behavior when the role grants policies not listed in allowed_policies. 
+'reject' denies the login, 'filter' issues token without disallowed policies.`,
+				AllowedValues: []interface{}{disallowedPoliciesReject, disallowedPoliciesFilter},
+			},
+			"method_autodetect": {
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `Flag defines whether to detect login method from the secret if it is not requested. 
+Secrets looking like a wrapping token use 'token-full', others require the method to be specified`,
+			},
+			"strict_empty_meta": {
+				Type:    framework.TypeString,
+				Default: strictEmptyMetaEmpty,
+				Description: `Defines how roles with strict_meta_verify and no entity_meta are verified. 'empty' requires 
+upstream entity to have no metadata, 'any' accepts any metadata, effectively disabling strictness.`,
+				AllowedValues: []interface{}{strictEmptyMetaEmpty, strictEmptyMetaAny},
+			},
+			"http_client_timeout": {
+				Type: framework.TypeDurationSecond,
+				Description: `Hard cap of HTTP requests to target Vault cluster applied to the HTTP client in addition 
+to the request context. Must not be less than the request timeout (30s). No cap if not set`,
+			},
+			"allow_duplicate_meta_keys": {
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `Flag defines whether roles' entity_meta and entity_meta_any may contain duplicate keys, 
+the last value wins then. Otherwise role write with duplicate keys is rejected`,
+			},
+			"allowed_namespaces": {
+				Type: framework.TypeCommaStringSlice,
+				Description: `Namespaces roles are allowed to send login requests to instead of the configured one. 
+Roles with other namespace are rejected on write. No limit if not set`,
+			},
+			"strict_validation": {
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `Flag defines whether inconsistent settings, e.g. ca_cert together with insecure_skip_verify, 
+are rejected. Otherwise the write succeeds with warnings`,
+			},
+			"debug_login": {
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `Flag defines whether login responses carry debugging details, e.g. role's metadata 
+keys which were matched. Values and secrets are never included. Not intended for production`,
+			},
+			"max_entity_meta_length": {
+				Type:    framework.TypeInt,
+				Default: defaultMaxEntityMetaLength,
+				Description: `Maximum length of each key and value of roles' entity_meta and entity_meta_any. 
+Roles exceeding it are rejected on write. No limit if 0`,
+			},
+			"max_token_policies": {
+				Type:    framework.TypeInt,
+				Default: defaultMaxTokenPolicies,
+				Description: `Maximum number of roles' token_policies. Roles exceeding it are rejected on write. 
+No limit if 0`,
+			},
+			"allow_header_credentials": {
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `Flag defines whether login fields role, secret and method absent in the request body are 
+read from request headers. Headers must be allowed by mount's passthrough_request_headers`,
+			},
+			"credential_headers": {
+				Type: framework.TypeKVPairs,
+				Description: fmt.Sprintf(`Names of request headers login fields are read from, keys are field names 
+(role, secret, method). Not specified fields use default headers: %s`, formatCredentialHeaders(defaultCredentialHeaders)),
+			},
+			"proxy_url": {
+				Type: framework.TypeString,
+				Description: `URL of HTTP(S) or SOCKS5 proxy requests to target Vault cluster are sent through, 
+e.g. http://proxy.example.com:3128. Proxy settings of the environment (HTTPS_PROXY, etc.) are used if not set`,
+			},
+			"disable_keep_alives": {
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `Flag defines whether connections to target Vault cluster are closed after each request. 
+Every login then pays for new TCP and TLS handshakes, use it only if idle connections are dropped by network 
+equipment or the plugin process is short-lived`,
+			},
+			"idle_conn_timeout": {
+				Type: framework.TypeDurationSecond,
+				Description: `Time idle connections to target Vault cluster are kept for reuse. Shorter timeout 
+reduces open connections at the cost of more handshakes. 90s if not set`,
+			},
+			"token_auth_mount": {
+				Type:    framework.TypeString,
+				Default: defaultTokenAuthMount,
+				Description: `Path of token auth method in target Vault cluster source tokens are looked up at, e.g. 
+'token' for auth/token/lookup. The path is relative to the namespace requests are sent to`,
+			},
+			"validate_on_write": {
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `Flag defines whether configuration is written only if target Vault cluster responds to 
+health request with the new connection settings. Unlike verify_connection, it is stored and applies to every write`,
+			},
+			"verify_connection": {
+				Type:    framework.TypeBool,
+				Default: true,
+				Description: `Flag defines whether this write checks that target Vault cluster responds to health
+request made with the new connection settings, the write fails otherwise. The flag is not stored`,
+			},
+			"forward_upstream_warnings": {
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `Flag defines whether warnings returned by target Vault cluster on source token lookup, 
+e.g. about deprecated paths, are added to login response. They are logged at debug level regardless of it`,
+			},
+			"fail_open_cached_lookups": {
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `DANGEROUS. Flag defines whether login succeeds with the source token lookup result cached 
+by a previous successful login if target Vault cluster fails the lookup with a transient error. Wrapped 
+secret is always unwrapped, so credentials are never accepted without target Vault cluster`,
+			},
+			"denied_entity_ids": {
+				Type: framework.TypeCommaStringSlice,
+				Description: `IDs of entities in target Vault cluster logins are rejected for, regardless of the role 
+bound to them. Intended for blocking compromised entities across all roles at once`,
+			},
+			"trust_on_first_use": {
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `Experimental. Flag defines whether certificate presented by target Vault cluster is 
+fetched without verification on config write and its fingerprint is stored as tls_pinned_sha256, unless the pin 
+is provided. The pin is kept on subsequent writes while cluster is not changed`,
+			},
+			"role_cache_ttl": {
+				Type: framework.TypeDurationSecond,
+				Description: fmt.Sprintf(`Time roles read from storage are cached in memory for, up to %s. Local 
+role changes invalidate the cache, changes made on other nodes may be served stale until expiration. 
+Disabled if not set`, maxRoleCacheTTL),
+			},
+			"allow_role_selection": {
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `Flag defines whether login request may omit the role. The most specific role the source 
+token matches is selected then: the role verifying more metadata keys, more keys with exact values, strict one. 
+Several roles of the same specificity are rejected as ambiguous. Roles requiring dual secret or overriding namespace 
+are never selected`,
+			},
+			"required_meta_key_prefix": {
+				Type: framework.TypeString,
+				Description: `Prefix every key of roles' entity_meta and entity_meta_any must start with (e.g. teamA/). 
+Roles having other keys are rejected on write. Not enforced if empty`,
+			},
+			"check_upstream_version": {
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `Flag defines whether version of target Vault cluster is detected on plugin start and 
+periodically. Warnings are logged and reported by config/status endpoint if the cluster doesn't provide 
+features enabled by configuration`,
+			},
+			"hash_audit_entity_ids": {
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `Flag defines whether entity IDs in issued tokens' metadata and display name, which appear 
+in audit logs, are replaced with their SHA-256 hashes. Alias metadata is not affected`,
+			},
 		},
 		Operations: map[logical.Operation]framework.OperationHandler{
 			logical.ReadOperation: &framework.PathOperation{
@@ -71,12 +504,76 @@ func (b *crossVaultAuthBackend) pathConfig() *framework.Path {
 				},
 				Description: "writes configuration",
 			},
+			logical.DeleteOperation: &framework.PathOperation{
+				Callback: b.pathConfigDelete,
+				DisplayAttrs: &framework.DisplayAttributes{
+					OperationVerb: "delete",
+				},
+				Description: "deletes configuration",
+			},
 		},
 		HelpSynopsis:    configHelpSynopsis,
 		HelpDescription: configHelpDescription,
 	}
 }
 
+func (b *crossVaultAuthBackend) pathConfigStatus() *framework.Path {
+	return &framework.Path{
+		Pattern: "config/status$",
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathConfigStatusRead,
+				DisplayAttrs: &framework.DisplayAttributes{
+					OperationVerb: "read",
+				},
+				Description: "returns TLS config updater status",
+			},
+		},
+		HelpSynopsis:    configStatusHelpSynopsis,
+		HelpDescription: configStatusHelpDescription,
+	}
+}
+
+func (b *crossVaultAuthBackend) pathConfigStatusRead(
+	_ context.Context,
+	_ *logical.Request,
+	_ *framework.FieldData,
+) (*logical.Response, error) {
+	b.tlsMu.RLock()
+	defer b.tlsMu.RUnlock()
+
+	var lastRefreshTime, lastRefreshError string
+	if !b.tlsConfigLastUpdate.IsZero() {
+		lastRefreshTime = b.tlsConfigLastUpdate.Format(time.RFC3339)
+	}
+	if b.tlsConfigLastUpdateErr != nil {
+		lastRefreshError = b.tlsConfigLastUpdateErr.Error()
+	}
+
+	trustedCASHA256 := append([]string{}, b.tlsTrustedCASHA256...)
+
+	b.statusMu.RLock()
+	defer b.statusMu.RUnlock()
+
+	var upstreamVersionError string
+	if b.upstreamVersionErr != nil {
+		upstreamVersionError = b.upstreamVersionErr.Error()
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"tls_trusted_ca_sha256":     trustedCASHA256,
+			"tls_updater_running":       b.tlsConfigUpdateRunning,
+			"tls_refresh_interval":      int64(b.tlsConfigUpdatePeriod.Seconds()),
+			"tls_last_refresh_time":     lastRefreshTime,
+			"tls_last_refresh_error":    lastRefreshError,
+			"upstream_version":          b.upstreamVersion,
+			"upstream_version_warnings": append([]string{}, b.upstreamVersionWarnings...),
+			"upstream_version_error":    upstreamVersionError,
+		},
+	}, nil
+}
+
 func (b *crossVaultAuthBackend) pathConfigRead(
 	ctx context.Context,
 	req *logical.Request,
@@ -89,14 +586,134 @@ func (b *crossVaultAuthBackend) pathConfigRead(
 	if config == nil {
 		return nil, nil
 	}
-	return &logical.Response{
-		Data: map[string]interface{}{
-			"cluster":              config.Cluster,
-			"namespace":            config.Namespace,
-			"ca_cert":              config.CACert,
-			"insecure_skip_verify": config.InsecureSkipVerify,
-		},
-	}, nil
+	resp := &logical.Response{
+		Data: config.responseData(),
+	}
+	if config.CACert != "" && resp.Data["ca_cert"] == "" {
+		resp.AddWarning("ca_cert does not contain valid certificates, its summary can't be reported")
+	}
+	return resp, nil
+}
+
+// redactedProxyURL returns proxy URL with password replaced by "xxxxx", so proxy credentials are not returned.
+func redactedProxyURL(proxyURL string) string {
+	parsed, err := url.Parse(proxyURL)
+	if err != nil {
+		return ""
+	}
+	return parsed.Redacted()
+}
+
+// caCertSummary describes PEM encoded CA certificates by their subject, SHA-256 fingerprint and
+// expiration time, so the certificates are not returned as is. Returns empty string if none of them
+// can be parsed.
+func caCertSummary(caCert string) interface{} {
+	certs := parseCertificates([]byte(caCert))
+	if len(certs) == 0 {
+		return ""
+	}
+	summary := make([]map[string]interface{}, 0, len(certs))
+	for _, cert := range certs {
+		summary = append(summary, map[string]interface{}{
+			"subject":   cert.Subject.String(),
+			"sha256":    certificateFingerprint(cert),
+			"not_after": cert.NotAfter.UTC().Format(time.RFC3339),
+		})
+	}
+	return summary
+}
+
+// responseData returns config fields as they are reported by config read. CA certificate is reported
+// by its summary only.
+func (c *crossVaultAuthBackendConfig) responseData() map[string]interface{} {
+	return map[string]interface{}{
+		"cluster":                    c.Cluster,
+		"namespace":                  c.Namespace,
+		"ca_cert":                    caCertSummary(c.CACert),
+		"ca_cert_file":               c.CACertFile,
+		"append_ca_cert":             c.AppendCACert,
+		"client_cert":                c.ClientCert,
+		"insecure_skip_verify":       c.InsecureSkipVerify,
+		"method_precedence":          c.MethodPrecedence,
+		"emit_events":                c.EmitEvents,
+		"meta_key_strip_prefix":      c.MetaKeyStripPrefix,
+		"unwrap_retries":             c.UnwrapRetries,
+		"tls_pinned_sha256":          c.TLSPinnedSHA256,
+		"tls_cipher_suites":          c.TLSCipherSuites,
+		"tls_server_name":            c.TLSServerName,
+		"min_tls_version":            c.MinTLSVersion,
+		"tls_refresh_interval":       int64(c.TLSRefreshInterval.Seconds()),
+		"max_token_ttl":              int64(c.MaxTokenTTL.Seconds()),
+		"verify_role_entities":       c.VerifyRoleEntities,
+		"allowed_policies":           c.AllowedPolicies,
+		"disallowed_policies_action": c.DisallowedPoliciesAction,
+		"method_autodetect":          c.MethodAutodetect,
+		"strict_empty_meta":          c.StrictEmptyMeta,
+		"http_client_timeout":        int64(c.HTTPClientTimeout.Seconds()),
+		"allow_duplicate_meta_keys":  c.AllowDuplicateMetaKeys,
+		"allowed_namespaces":         c.AllowedNamespaces,
+		"strict_validation":          c.StrictValidation,
+		"debug_login":                c.DebugLogin,
+		"max_entity_meta_length":     c.MaxEntityMetaLength,
+		"max_token_policies":         c.MaxTokenPolicies,
+		"allow_header_credentials":   c.AllowHeaderCredentials,
+		"credential_headers":         c.CredentialHeaders,
+		"token_auth_mount":           c.tokenAuthMount(),
+		"proxy_url":                  redactedProxyURL(c.ProxyURL),
+		"disable_keep_alives":        c.DisableKeepAlives,
+		"idle_conn_timeout":          int64(c.IdleConnTimeout.Seconds()),
+		"validate_on_write":          c.ValidateOnWrite,
+		"forward_upstream_warnings":  c.ForwardUpstreamWarnings,
+		"fail_open_cached_lookups":   c.FailOpenCachedLookups,
+		"hash_audit_entity_ids":      c.HashAuditEntityIDs,
+		"denied_entity_ids":          c.DeniedEntityIDs,
+		"trust_on_first_use":         c.TrustOnFirstUse,
+		"role_cache_ttl":             int64(c.RoleCacheTTL.Seconds()),
+		"allow_role_selection":       c.AllowRoleSelection,
+		"required_meta_key_prefix":   c.RequiredMetaKeyPrefix,
+		"check_upstream_version":     c.CheckUpstreamVersion,
+	}
+}
+
+// pathConfigDelete deletes stored configuration and resets runtime state derived from it, so logins
+// fail until the configuration is written again instead of reaching the cluster with stale settings.
+func (b *crossVaultAuthBackend) pathConfigDelete(
+	ctx context.Context,
+	req *logical.Request,
+	_ *framework.FieldData,
+) (*logical.Response, error) {
+	if err := b.deleteConfig(ctx, req.Storage); err != nil {
+		return nil, err
+	}
+	// TLS config updater's tick takes b.mu to verify roles' entities, so the updater is restarted
+	// after the lock is released
+	if err := b.applyTLSRefreshInterval(ctx, req.Storage, tlsUpdateTicker); err != nil {
+		return nil, err
+	}
+
+	b.Logger().Info("configuration deleted")
+	return nil, nil
+}
+
+func (b *crossVaultAuthBackend) deleteConfig(ctx context.Context, storage logical.Storage) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := storage.Delete(ctx, configPath); err != nil {
+		return err
+	}
+	if err := b.resetTLSConfig(); err != nil {
+		return err
+	}
+	b.sharedClientMu.Lock()
+	b.sharedClient = nil
+	b.sharedClientMu.Unlock()
+	b.setRoleCacheTTL(0)
+	b.resetLookupCache()
+	b.statusMu.Lock()
+	b.upstreamVersionLastCheck = time.Time{}
+	b.statusMu.Unlock()
+	return nil
 }
 
 func (b *crossVaultAuthBackend) pathConfigWrite(
@@ -104,41 +721,442 @@ func (b *crossVaultAuthBackend) pathConfigWrite(
 	req *logical.Request,
 	data *framework.FieldData,
 ) (*logical.Response, error) {
-	var (
-		entry *logical.StorageEntry
-		err   error
-	)
+	resp, err := b.storeConfig(ctx, req, data)
+	if err != nil || resp.IsError() {
+		return resp, err
+	}
+	// TLS config updater's tick takes b.mu to verify roles' entities, so the updater is restarted
+	// after the lock is released
+	config, err := b.config(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config != nil {
+		if err = b.applyTLSRefreshInterval(ctx, req.Storage, config.tlsRefreshInterval()); err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
 
+// storeConfig validates and stores the configuration, applying it to TLS settings and caches.
+func (b *crossVaultAuthBackend) storeConfig(
+	ctx context.Context,
+	req *logical.Request,
+	data *framework.FieldData,
+) (*logical.Response, error) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	cluster, _ := data.Get("cluster").(string)
-	if cluster == "" {
-		return logical.ErrorResponse("cluster must be provided"), nil
+	config, resp, err := b.parseConfig(ctx, req, data)
+	if err != nil || resp.IsError() {
+		return resp, err
+	}
+	if errResp, err := b.applyConfig(ctx, req.Storage, config); err != nil || errResp != nil {
+		return errResp, err
+	}
+	return resp, nil
+}
+
+// parseConfig validates the configuration write request and returns the configuration to store along
+// with the response holding its warnings, if any. Nothing is stored. Caller must hold b.mu.
+func (b *crossVaultAuthBackend) parseConfig(
+	ctx context.Context,
+	req *logical.Request,
+	data *framework.FieldData,
+) (*crossVaultAuthBackendConfig, *logical.Response, error) {
+	config := &crossVaultAuthBackendConfig{}
+	for _, parse := range []func(*framework.FieldData, *crossVaultAuthBackendConfig) *logical.Response{
+		parseConfigConnection,
+		parseConfigTLS,
+		parseConfigPolicies,
+		parseConfigLogin,
+	} {
+		if errResp := parse(data, config); errResp != nil {
+			return nil, errResp, nil
+		}
+	}
+
+	warnings := config.consistencyWarnings()
+	if config.StrictValidation && len(warnings) > 0 {
+		return nil, fieldErrorResponse("strict_validation", fieldErrorConflict, strings.Join(warnings, "; ")), nil
+	}
+
+	verifyConnection, _ := data.Get("verify_connection").(bool)
+	if errResp, err := b.checkConfigConnection(ctx, req.Storage, config, verifyConnection); errResp != nil || err != nil {
+		return nil, errResp, err
+	}
+
+	if len(warnings) == 0 {
+		return config, nil, nil
+	}
+	resp := &logical.Response{}
+	for _, warning := range warnings {
+		resp.AddWarning(warning)
+	}
+	return config, resp, nil
+}
+
+// parseConfigConnection parses the fields defining how target Vault cluster is reached.
+func parseConfigConnection(data *framework.FieldData, config *crossVaultAuthBackendConfig) *logical.Response {
+	config.Cluster, _ = data.Get("cluster").(string)
+	if config.Cluster == "" {
+		return fieldErrorResponse("cluster", fieldErrorMissingRequired, "cluster must be provided")
+	}
+	config.Namespace, _ = data.Get("namespace").(string)
+
+	config.ProxyURL, _ = data.Get("proxy_url").(string)
+	if _, err := proxyFunc(config.ProxyURL); err != nil {
+		return fieldErrorResponse("proxy_url", fieldErrorInvalidValue, "proxy_url: "+err.Error())
+	}
+	config.DisableKeepAlives, _ = data.Get("disable_keep_alives").(bool)
+	config.ValidateOnWrite, _ = data.Get("validate_on_write").(bool)
+
+	idleConnTimeout, _ := data.Get("idle_conn_timeout").(int)
+	if idleConnTimeout < 0 {
+		return fieldErrorResponse("idle_conn_timeout", fieldErrorOutOfRange, "idle_conn_timeout must not be negative")
+	}
+	config.IdleConnTimeout = time.Duration(idleConnTimeout) * time.Second
+
+	httpClientTimeout, _ := data.Get("http_client_timeout").(int)
+	config.HTTPClientTimeout = time.Duration(httpClientTimeout) * time.Second
+	if config.HTTPClientTimeout != 0 && config.HTTPClientTimeout < requestTimeout {
+		return fieldErrorResponse("http_client_timeout", fieldErrorOutOfRange,
+			fmt.Sprintf("http_client_timeout must not be less than request timeout (%s)", requestTimeout))
+	}
+
+	tokenAuthMount, _ := data.Get("token_auth_mount").(string)
+	config.TokenAuthMount = strings.Trim(tokenAuthMount, "/")
+	if config.TokenAuthMount == "" || strutil.StrListContains(strings.Split(config.TokenAuthMount, "/"), "..") {
+		return fieldErrorResponse("token_auth_mount", fieldErrorInvalidValue,
+			"token_auth_mount must be a non-empty path without '..' segments")
+	}
+
+	config.UnwrapRetries, _ = data.Get("unwrap_retries").(int)
+	if config.UnwrapRetries < 0 {
+		return fieldErrorResponse("unwrap_retries", fieldErrorOutOfRange, "unwrap_retries must not be negative")
+	}
+	return nil
+}
+
+// parseConfigTLS parses the fields of TLS connection to target Vault cluster.
+func parseConfigTLS(data *framework.FieldData, config *crossVaultAuthBackendConfig) *logical.Response {
+	var err error
+
+	config.CACert, _ = data.Get("ca_cert").(string)
+	config.CACertFile, _ = data.Get("ca_cert_file").(string)
+	if config.CACertFile != "" {
+		if !filepath.IsAbs(config.CACertFile) {
+			return fieldErrorResponse("ca_cert_file", fieldErrorInvalidValue, "ca_cert_file must be an absolute path")
+		}
+		if _, err = os.ReadFile(config.CACertFile); err != nil {
+			return fieldErrorResponse("ca_cert_file", fieldErrorInvalidValue, fmt.Sprintf("ca_cert_file: %s", err))
+		}
+	}
+	config.AppendCACert, _ = data.Get("append_ca_cert").(bool)
+	config.InsecureSkipVerify, _ = data.Get("insecure_skip_verify").(bool)
+
+	config.ClientCert, _ = data.Get("client_cert").(string)
+	config.ClientKey, _ = data.Get("client_key").(string)
+	if (config.ClientCert == "") != (config.ClientKey == "") {
+		return fieldErrorResponse("client_key", fieldErrorConflict, "client_cert and client_key must be provided together")
+	}
+	if _, err = clientCertificates(config); err != nil {
+		return fieldErrorResponse("client_cert", fieldErrorInvalidValue, "client_cert and client_key: "+err.Error())
 	}
-	namespace, _ := data.Get("namespace").(string)
-	caCert, _ := data.Get("ca_cert").(string)
-	insecureSkipVerify, _ := data.Get("insecure_skip_verify").(bool)
 
-	config := &crossVaultAuthBackendConfig{
-		Cluster:            cluster,
-		Namespace:          namespace,
-		CACert:             caCert,
-		InsecureSkipVerify: insecureSkipVerify,
+	config.TLSPinnedSHA256, _ = data.Get("tls_pinned_sha256").(string)
+	if config.TLSPinnedSHA256 != "" {
+		if config.TLSPinnedSHA256, err = normalizeFingerprint(config.TLSPinnedSHA256); err != nil {
+			return fieldErrorResponse("tls_pinned_sha256", fieldErrorInvalidValue, "tls_pinned_sha256: "+err.Error())
+		}
 	}
 
-	if err = b.updateTLSConfig(config); err != nil {
+	config.TLSCipherSuites, _ = data.Get("tls_cipher_suites").([]string)
+	if _, err = cipherSuiteIDs(config.TLSCipherSuites); err != nil {
+		return fieldErrorResponse("tls_cipher_suites", fieldErrorInvalidValue, "tls_cipher_suites: "+err.Error())
+	}
+
+	config.TLSServerName, _ = data.Get("tls_server_name").(string)
+	if strings.ContainsAny(config.TLSServerName, "/ ") {
+		return fieldErrorResponse("tls_server_name", fieldErrorInvalidValue, "tls_server_name must be a hostname")
+	}
+
+	tlsRefreshInterval, _ := data.Get("tls_refresh_interval").(int)
+	if tlsRefreshInterval < 0 {
+		return fieldErrorResponse("tls_refresh_interval", fieldErrorOutOfRange,
+			"tls_refresh_interval must not be negative")
+	}
+	config.TLSRefreshInterval = time.Duration(tlsRefreshInterval) * time.Second
+
+	minTLSVersion, _ := data.Get("min_tls_version").(string)
+	config.MinTLSVersion = strings.ToLower(minTLSVersion)
+	if _, err = tlsVersionID(config.MinTLSVersion); err != nil {
+		return fieldErrorResponse("min_tls_version", fieldErrorInvalidValue, "min_tls_version: "+err.Error())
+	}
+	return nil
+}
+
+// parseConfigPolicies parses the mount's limits of roles' tokens.
+func parseConfigPolicies(data *framework.FieldData, config *crossVaultAuthBackendConfig) *logical.Response {
+	maxTokenTTL, _ := data.Get("max_token_ttl").(int)
+	if maxTokenTTL < 0 {
+		return fieldErrorResponse("max_token_ttl", fieldErrorOutOfRange, "max_token_ttl must not be negative")
+	}
+	config.MaxTokenTTL = time.Duration(maxTokenTTL) * time.Second
+
+	config.AllowedPolicies, _ = data.Get("allowed_policies").([]string)
+	config.DisallowedPoliciesAction, _ = data.Get("disallowed_policies_action").(string)
+	if config.DisallowedPoliciesAction != disallowedPoliciesReject &&
+		config.DisallowedPoliciesAction != disallowedPoliciesFilter {
+		return fieldErrorResponse("disallowed_policies_action", fieldErrorInvalidValue,
+			"disallowed_policies_action must be one of: reject, filter")
+	}
+
+	config.MaxTokenPolicies, _ = data.Get("max_token_policies").(int)
+	if config.MaxTokenPolicies < 0 {
+		return fieldErrorResponse("max_token_policies", fieldErrorOutOfRange,
+			"max_token_policies must not be negative")
+	}
+	return nil
+}
+
+// parseConfigLogin parses the fields defining login behavior.
+func parseConfigLogin(data *framework.FieldData, config *crossVaultAuthBackendConfig) *logical.Response {
+	var err error
+
+	config.MethodPrecedence, _ = data.Get("method_precedence").(string)
+	if config.MethodPrecedence != methodPrecedenceRequest && config.MethodPrecedence != methodPrecedenceRole {
+		return fieldErrorResponse("method_precedence", fieldErrorInvalidValue,
+			"method_precedence must be one of: request, role")
+	}
+	config.StrictEmptyMeta, _ = data.Get("strict_empty_meta").(string)
+	if config.StrictEmptyMeta != strictEmptyMetaEmpty && config.StrictEmptyMeta != strictEmptyMetaAny {
+		return fieldErrorResponse("strict_empty_meta", fieldErrorInvalidValue,
+			"strict_empty_meta must be one of: empty, any")
+	}
+	config.MaxEntityMetaLength, _ = data.Get("max_entity_meta_length").(int)
+	if config.MaxEntityMetaLength < 0 {
+		return fieldErrorResponse("max_entity_meta_length", fieldErrorOutOfRange,
+			"max_entity_meta_length must not be negative")
+	}
+	credentialHeaders, _ := data.Get("credential_headers").(map[string]string)
+	if config.CredentialHeaders, err = mergeCredentialHeaders(credentialHeaders); err != nil {
+		return fieldErrorResponse("credential_headers", fieldErrorInvalidValue, err.Error())
+	}
+	roleCacheTTL, _ := data.Get("role_cache_ttl").(int)
+	config.RoleCacheTTL = time.Duration(roleCacheTTL) * time.Second
+	if config.RoleCacheTTL < 0 || config.RoleCacheTTL > maxRoleCacheTTL {
+		return fieldErrorResponse("role_cache_ttl", fieldErrorOutOfRange,
+			fmt.Sprintf("role_cache_ttl must not be negative and must not exceed %s", maxRoleCacheTTL))
+	}
+
+	config.DeniedEntityIDs, _ = data.Get("denied_entity_ids").([]string)
+	for i, entityID := range config.DeniedEntityIDs {
+		// entity IDs are lowercase UUIDs, while operators may provide them in any case
+		config.DeniedEntityIDs[i] = strings.ToLower(entityID)
+	}
+
+	config.EmitEvents, _ = data.Get("emit_events").(bool)
+	config.MetaKeyStripPrefix, _ = data.Get("meta_key_strip_prefix").(string)
+	config.VerifyRoleEntities, _ = data.Get("verify_role_entities").(bool)
+	config.MethodAutodetect, _ = data.Get("method_autodetect").(bool)
+	config.AllowDuplicateMetaKeys, _ = data.Get("allow_duplicate_meta_keys").(bool)
+	config.AllowedNamespaces, _ = data.Get("allowed_namespaces").([]string)
+	config.StrictValidation, _ = data.Get("strict_validation").(bool)
+	config.DebugLogin, _ = data.Get("debug_login").(bool)
+	config.AllowHeaderCredentials, _ = data.Get("allow_header_credentials").(bool)
+	config.ForwardUpstreamWarnings, _ = data.Get("forward_upstream_warnings").(bool)
+	config.FailOpenCachedLookups, _ = data.Get("fail_open_cached_lookups").(bool)
+	config.HashAuditEntityIDs, _ = data.Get("hash_audit_entity_ids").(bool)
+	config.TrustOnFirstUse, _ = data.Get("trust_on_first_use").(bool)
+	config.AllowRoleSelection, _ = data.Get("allow_role_selection").(bool)
+	config.RequiredMetaKeyPrefix, _ = data.Get("required_meta_key_prefix").(string)
+	config.CheckUpstreamVersion, _ = data.Get("check_upstream_version").(bool)
+	return nil
+}
+
+// checkConfigConnection pins certificate of target Vault cluster trusted on first use and checks
+// that the cluster is reachable if requested.
+func (b *crossVaultAuthBackend) checkConfigConnection(
+	ctx context.Context,
+	storage logical.Storage,
+	config *crossVaultAuthBackendConfig,
+	verifyConnection bool,
+) (*logical.Response, error) {
+	if config.TrustOnFirstUse && config.TLSPinnedSHA256 == "" {
+		previous, err := b.config(ctx, storage)
+		if err != nil {
+			return nil, err
+		}
+		// certificate is trusted on the first use only, so the pin is kept while the cluster is the same
+		if previous != nil && previous.Cluster == config.Cluster && previous.TLSPinnedSHA256 != "" {
+			config.TLSPinnedSHA256 = previous.TLSPinnedSHA256
+		} else {
+			if config.TLSPinnedSHA256, err = fetchCertificateFingerprint(ctx, config); err != nil {
+				return fieldErrorResponse("trust_on_first_use", fieldErrorUnreachable,
+					fmt.Sprintf("trust_on_first_use: %s", err)), nil
+			}
+			b.Logger().Info("target Vault cluster certificate trusted on first use",
+				"tls_pinned_sha256", config.TLSPinnedSHA256)
+		}
+	}
+
+	return verifyClusterConnection(ctx, config, verifyConnection), nil
+}
+
+// verifyClusterConnection checks that target Vault cluster responds to health request made with the new
+// connection settings if requested by verify_connection of the write or stored validate_on_write.
+func verifyClusterConnection(
+	ctx context.Context,
+	config *crossVaultAuthBackendConfig,
+	verifyConnection bool,
+) *logical.Response {
+	if !config.ValidateOnWrite && !verifyConnection {
+		return nil
+	}
+	if err := checkClusterReachable(ctx, config); err != nil {
+		return fieldErrorResponse("cluster", fieldErrorUnreachable,
+			fmt.Sprintf("target Vault cluster is not reachable: %s", err))
+	}
+	return nil
+}
+
+// applyConfig applies the configuration to TLS settings and caches and stores it. Caller must hold b.mu.
+func (b *crossVaultAuthBackend) applyConfig(
+	ctx context.Context,
+	storage logical.Storage,
+	config *crossVaultAuthBackendConfig,
+) (*logical.Response, error) {
+	if err := b.updateTLSConfig(config); err != nil {
 		return logical.ErrorResponse(err.Error()), nil
 	}
 
-	entry, err = logical.StorageEntryJSON(configPath, config)
+	entry, err := logical.StorageEntryJSON(configPath, config)
 	if err != nil {
 		return nil, err
 	}
 
-	if err = req.Storage.Put(ctx, entry); err != nil {
+	if err = storage.Put(ctx, entry); err != nil {
 		return nil, err
 	}
+	b.setRoleCacheTTL(config.RoleCacheTTL)
+	// cached results might belong to another cluster or the flag might be unset
+	b.resetLookupCache()
+	// cluster or enabled features might have changed, so version is checked again on the next refresh
+	b.statusMu.Lock()
+	b.upstreamVersionLastCheck = time.Time{}
+	b.statusMu.Unlock()
 
 	return nil, nil
 }
+
+// idleConnTimeout returns the time idle connections to target Vault cluster are kept for, configurations
+// written before it became configurable use the default one.
+func (c *crossVaultAuthBackendConfig) idleConnTimeout() time.Duration {
+	if c.IdleConnTimeout == 0 {
+		return defaultIdleConnTimeout
+	}
+	return c.IdleConnTimeout
+}
+
+// tlsRefreshInterval returns period of background TLS config refresh, configurations written before
+// it became configurable use the default one.
+func (c *crossVaultAuthBackendConfig) tlsRefreshInterval() time.Duration {
+	if c.TLSRefreshInterval == 0 {
+		return tlsUpdateTicker
+	}
+	return c.TLSRefreshInterval
+}
+
+// tokenAuthMount returns path of token auth method, configurations written before it became
+// configurable use the default one.
+func (c *crossVaultAuthBackendConfig) tokenAuthMount() string {
+	if c.TokenAuthMount == "" {
+		return defaultTokenAuthMount
+	}
+	return c.TokenAuthMount
+}
+
+// consistencyWarnings returns descriptions of settings which contradict each other.
+func (c *crossVaultAuthBackendConfig) consistencyWarnings() []string {
+	var warnings []string
+	if c.CACert != "" && c.CACertFile != "" {
+		warnings = append(warnings, "ca_cert is ignored since ca_cert_file is set")
+	}
+	if c.CACert != "" && c.InsecureSkipVerify {
+		warnings = append(warnings, "ca_cert is ignored since insecure_skip_verify is set, TLS verification is disabled")
+	}
+	if c.MinTLSVersion == "tls13" && len(c.TLSCipherSuites) > 0 {
+		warnings = append(warnings, "tls_cipher_suites is ignored since min_tls_version is tls13, "+
+			"TLS 1.3 cipher suites are not configurable")
+	}
+	clusterURL, err := url.Parse(c.Cluster)
+	if err != nil {
+		return warnings
+	}
+	if clusterURL.Scheme == "http" && c.ClientCert != "" {
+		warnings = append(warnings, "client_cert is ignored since cluster is not an https URL, TLS is not used")
+	}
+	if clusterURL.Scheme == "http" && c.TLSServerName != "" {
+		warnings = append(warnings, "tls_server_name is ignored since cluster is not an https URL, TLS is not used")
+	}
+	switch {
+	case clusterURL.Scheme == "http" && c.CACert != "":
+		warnings = append(warnings, "ca_cert is ignored since cluster is not an https URL, TLS is not used")
+	case clusterURL.Scheme == "https" && c.CACert == "" && c.CACertFile == "" && !c.AppendCACert &&
+		!c.InsecureSkipVerify:
+		// trusted CAs are limited to ca_cert, so the cluster's certificate can't be verified
+		warnings = append(warnings, "cluster is an https URL, but none of ca_cert, append_ca_cert and "+
+			"insecure_skip_verify is set; system CA certificates are not trusted, so TLS verification will fail")
+	}
+	return warnings
+}
+
+// entityDenied reports whether the entity is on config's deny-list. Empty entity ID is never denied.
+func (c *crossVaultAuthBackendConfig) entityDenied(entityID string) bool {
+	return entityID != "" && strutil.StrListContains(c.DeniedEntityIDs, strings.ToLower(entityID))
+}
+
+// disallowedPolicies returns policies not present in config's allow-list.
+// Returns nil if config does not limit policies.
+func (c *crossVaultAuthBackendConfig) disallowedPolicies(policies []string) []string {
+	if len(c.AllowedPolicies) == 0 {
+		return nil
+	}
+	var disallowed []string
+	for _, policy := range policies {
+		if !strutil.StrListContains(c.AllowedPolicies, policy) {
+			disallowed = append(disallowed, policy)
+		}
+	}
+	return disallowed
+}
+
+// mergeCredentialHeaders returns default credential headers overridden by the provided ones.
+func mergeCredentialHeaders(headers map[string]string) (map[string]string, error) {
+	merged := make(map[string]string, len(defaultCredentialHeaders))
+	for field, header := range defaultCredentialHeaders {
+		merged[field] = header
+	}
+	for field, header := range headers {
+		if _, ok := defaultCredentialHeaders[field]; !ok {
+			return nil, fmt.Errorf("credential_headers: unknown login field %q", field)
+		}
+		if header == "" {
+			return nil, fmt.Errorf("credential_headers: header name of login field %q must not be empty", field)
+		}
+		merged[field] = header
+	}
+	return merged, nil
+}
+
+// formatCredentialHeaders formats credential headers as sorted field=header pairs.
+func formatCredentialHeaders(headers map[string]string) string {
+	pairs := make([]string, 0, len(headers))
+	for field, header := range headers {
+		pairs = append(pairs, field+"="+header)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ", ")
+}
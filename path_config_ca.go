@@ -0,0 +1,233 @@
+package cva
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/url"
+
+	"github.com/hashicorp/go-cleanhttp"
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	caRotatePath = "config/ca/rotate"
+
+	caRotateHelpSynopsis    = "Replaces CA certificate after verifying it against target Vault cluster"
+	caRotateHelpDescription = `
+Builds temporary TLS config trusting the new CA certificate only and sends
+health request to target Vault cluster with it. The certificate is stored
+and applied only if TLS handshake succeeds, otherwise the current one is
+kept and the handshake error is returned.`
+)
+
+func (b *crossVaultAuthBackend) pathConfigCARotate() *framework.Path {
+	return &framework.Path{
+		Pattern: caRotatePath + "$",
+		Fields: map[string]*framework.FieldSchema{
+			"ca_cert": {
+				Type:        framework.TypeString,
+				Description: "PEM encoded CA certificate to trust instead of the current one. The field is mandatory.",
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathConfigCARotateWrite,
+				DisplayAttrs: &framework.DisplayAttributes{
+					OperationVerb: "rotate",
+				},
+				Description: "verifies and replaces CA certificate",
+			},
+		},
+		HelpSynopsis:    caRotateHelpSynopsis,
+		HelpDescription: caRotateHelpDescription,
+	}
+}
+
+func (b *crossVaultAuthBackend) pathConfigCARotateWrite(
+	ctx context.Context,
+	req *logical.Request,
+	data *framework.FieldData,
+) (*logical.Response, error) {
+	caCert, _ := data.Get("ca_cert").(string)
+	if caCert == "" {
+		return logical.ErrorResponse("'ca_cert' field is mandatory"), nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	config, err := b.config(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return logical.ErrorResponse("configuration is not set"), nil
+	}
+	if config.CACertFile != "" {
+		return logical.ErrorResponse("ca_cert_file is set, CA certificate is rotated by replacing the file"), nil
+	}
+
+	if err = b.verifyCACert(ctx, config, caCert); err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("new CA certificate verification failed: %s", err)), nil
+	}
+
+	config.CACert = caCert
+	if err = b.updateTLSConfig(config); err != nil {
+		return nil, err
+	}
+	entry, err := logical.StorageEntryJSON(configPath, config)
+	if err != nil {
+		return nil, err
+	}
+	if err = req.Storage.Put(ctx, entry); err != nil {
+		return nil, err
+	}
+
+	b.Logger().Info("CA certificate rotated", "trusted_ca_sha256", certificateFingerprints([]byte(caCert)))
+	return nil, nil
+}
+
+// verifyCACert sends health request to target Vault cluster over connection trusting provided CA
+// certificate only. Certificate verification is never skipped, regardless of configuration.
+func (b *crossVaultAuthBackend) verifyCACert(
+	ctx context.Context,
+	config *crossVaultAuthBackendConfig,
+	caCert string,
+) error {
+	clusterURL, err := url.Parse(config.Cluster)
+	if err != nil || clusterURL.Scheme != "https" {
+		return fmt.Errorf("cluster %q is not an https URL", config.Cluster)
+	}
+	if !x509.NewCertPool().AppendCertsFromPEM([]byte(caCert)) {
+		return fmt.Errorf("ca_cert does not contain valid certificates")
+	}
+
+	verified := *config
+	verified.CACert = caCert
+	verified.CACertFile = ""
+	verified.AppendCACert = false
+	verified.InsecureSkipVerify = false
+	return checkClusterReachable(ctx, &verified)
+}
+
+// checkClusterReachable sends health request to target Vault cluster using temporary client built
+// from provided configuration, so backend's client is not affected.
+func checkClusterReachable(ctx context.Context, config *crossVaultAuthBackendConfig) error {
+	tlsConfig, err := clusterTLSConfig(config)
+	if err != nil {
+		return err
+	}
+	return clusterHealth(ctx, config, tlsConfig)
+}
+
+// fetchCertificateFingerprint performs TLS handshake with target Vault cluster without certificate
+// verification and returns SHA-256 fingerprint of the certificate it presented, so the certificate
+// can be pinned when trusted on first use.
+func fetchCertificateFingerprint(ctx context.Context, config *crossVaultAuthBackendConfig) (string, error) {
+	clusterURL, err := url.Parse(config.Cluster)
+	if err != nil || clusterURL.Scheme != "https" {
+		return "", fmt.Errorf("cluster %q is not an https URL", config.Cluster)
+	}
+	tlsConfig, err := clusterTLSConfig(config)
+	if err != nil {
+		return "", err
+	}
+	tlsConfig.InsecureSkipVerify = true
+	tlsConfig.VerifyConnection = nil
+
+	address := clusterURL.Host
+	if clusterURL.Port() == "" {
+		address = net.JoinHostPort(clusterURL.Hostname(), "443")
+	}
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	dialer := &tls.Dialer{Config: tlsConfig}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return "", typeAssertionFailed
+	}
+
+	peerCertificates := tlsConn.ConnectionState().PeerCertificates
+	if len(peerCertificates) == 0 {
+		return "", tlsPeerCertificateMissing
+	}
+	sum := sha256.Sum256(peerCertificates[0].Raw)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// clusterTLSConfig builds TLS config of connections to target Vault cluster from provided configuration
+// the same way backend's TLS config is updated.
+func clusterTLSConfig(config *crossVaultAuthBackendConfig) (*tls.Config, error) {
+	caCertBytes, err := caCertificate(config)
+	if err != nil {
+		return nil, err
+	}
+	certPool, err := rootCertPool(config)
+	if err != nil {
+		return nil, err
+	}
+	certPool.AppendCertsFromPEM(caCertBytes)
+	cipherSuites, err := cipherSuiteIDs(config.TLSCipherSuites)
+	if err != nil {
+		return nil, err
+	}
+	certificates, err := clientCertificates(config)
+	if err != nil {
+		return nil, err
+	}
+	minVersion, err := tlsVersionID(config.MinTLSVersion)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		MinVersion:         minVersion,
+		RootCAs:            certPool,
+		InsecureSkipVerify: config.InsecureSkipVerify,
+		CipherSuites:       cipherSuites,
+		VerifyConnection:   pinnedCertificateVerifier(config.TLSPinnedSHA256),
+		Certificates:       certificates,
+		ServerName:         config.TLSServerName,
+	}, nil
+}
+
+// clusterHealth sends health request to the configured cluster using temporary client with provided
+// TLS config. Requests are sent through the configured proxy to the configured namespace, the same way
+// login requests are.
+func clusterHealth(ctx context.Context, config *crossVaultAuthBackendConfig, tlsConfig *tls.Config) error {
+	proxy, err := proxyFunc(config.ProxyURL)
+	if err != nil {
+		return err
+	}
+	transport := cleanhttp.DefaultTransport()
+	transport.TLSClientConfig = tlsConfig
+	transport.Proxy = proxy
+	httpClient := cleanhttp.DefaultClient()
+	httpClient.Transport = transport
+
+	vaultClientConfig := api.DefaultConfig()
+	vaultClientConfig.HttpClient = httpClient
+	vaultClientConfig.Address = config.Cluster
+	vaultClientConfig.MaxRetries = 0
+	client, err := api.NewClient(vaultClientConfig)
+	if err != nil {
+		return err
+	}
+	setUpstreamNamespace(client, config.Namespace)
+
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	_, err = client.Sys().HealthWithContext(ctx)
+	return err
+}
@@ -0,0 +1,305 @@
+package cva
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"gotest.tools/v3/assert"
+)
+
+func TestConfig_CARotate(t *testing.T) {
+	t.Parallel()
+
+	health := map[string]http.HandlerFunc{
+		"/v1/sys/health": jsonHandler(http.StatusOK, map[string]interface{}{"initialized": true, "sealed": false}),
+	}
+	upstream := newTestTLSUpstream(t, health)
+
+	tests := map[string]struct {
+		cluster   string
+		caCert    string
+		expectErr bool
+	}{
+		"valid": {
+			cluster: upstream.URL,
+			caCert:  certificatePEM(upstream),
+		},
+		"other-ca": {
+			cluster:   upstream.URL,
+			caCert:    selfSignedCertificatePEM(t),
+			expectErr: true,
+		},
+		"invalid-pem": {
+			cluster:   upstream.URL,
+			caCert:    "-----BEGIN CERTIFICATE-----\ninvalid\n-----END CERTIFICATE-----\n",
+			expectErr: true,
+		},
+		"plain-http": {
+			cluster:   "http://127.0.0.1:8200",
+			caCert:    certificatePEM(upstream),
+			expectErr: true,
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			const currentCA = "DATA OMITTED"
+			b, storage := getBackend(t)
+			writeConfig(t, b, storage, map[string]interface{}{
+				"cluster": tCase.cluster,
+				"ca_cert": currentCA,
+			})
+
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      caRotatePath,
+				Data:      map[string]interface{}{"ca_cert": tCase.caCert},
+				Storage:   storage,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, resp.IsError(), tCase.expectErr)
+
+			config, err := b.(*crossVaultAuthBackend).config(context.Background(), storage)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tCase.expectErr {
+				assert.Equal(t, config.CACert, currentCA)
+				assert.DeepEqual(t, b.(*crossVaultAuthBackend).tlsTrustedCASHA256, []string{})
+				return
+			}
+			assert.Equal(t, config.CACert, tCase.caCert)
+			assert.DeepEqual(t, b.(*crossVaultAuthBackend).tlsTrustedCASHA256,
+				certificateFingerprints([]byte(tCase.caCert)))
+		})
+	}
+}
+
+func TestConfig_CACertFile(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	b, storage := getBackend(t)
+	cvab := b.(*crossVaultAuthBackend)
+	caCertFile := filepath.Join(t.TempDir(), "ca.pem")
+	initial := selfSignedCertificatePEM(t)
+	if err := os.WriteFile(caCertFile, []byte(initial), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	writeConfig(t, b, storage, map[string]interface{}{
+		"cluster":      "https://127.0.0.1:8200",
+		"ca_cert":      selfSignedCertificatePEM(t),
+		"ca_cert_file": caCertFile,
+	})
+	assert.DeepEqual(t, cvab.tlsTrustedCASHA256, certificateFingerprints([]byte(initial)))
+
+	// rotated file is picked up on the next refresh
+	rotated := selfSignedCertificatePEM(t)
+	if err := os.WriteFile(caCertFile, []byte(rotated), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cvab.refreshTLSConfig(ctx, storage)
+	assert.DeepEqual(t, cvab.tlsTrustedCASHA256, certificateFingerprints([]byte(rotated)))
+	rootCAs := cvab.tlsConfig.RootCAs
+
+	// missing file doesn't clear trusted CAs
+	if err := os.Remove(caCertFile); err != nil {
+		t.Fatal(err)
+	}
+	cvab.refreshTLSConfig(ctx, storage)
+	assert.DeepEqual(t, cvab.tlsTrustedCASHA256, certificateFingerprints([]byte(rotated)))
+	assert.Assert(t, cvab.tlsConfig.RootCAs.Equal(rootCAs))
+}
+
+func TestConfig_CACertFileErrors(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		caCertFile func(t *testing.T) string
+	}{
+		"relative-path": {
+			caCertFile: func(_ *testing.T) string { return "ca.pem" },
+		},
+		"missing": {
+			caCertFile: func(t *testing.T) string { return filepath.Join(t.TempDir(), "ca.pem") },
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			b, storage := getBackend(t)
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      configPath,
+				Data: unverifiedConfig(map[string]interface{}{
+					"cluster":      "https://127.0.0.1:8200",
+					"ca_cert_file": tCase.caCertFile(t),
+				}),
+				Storage: storage,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Assert(t, resp.IsError())
+		})
+	}
+}
+
+func TestConfig_CARotateWithCACertFile(t *testing.T) {
+	t.Parallel()
+
+	b, storage := getBackend(t)
+	caCertFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caCertFile, []byte(selfSignedCertificatePEM(t)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	writeConfig(t, b, storage, map[string]interface{}{
+		"cluster":      "https://127.0.0.1:8200",
+		"ca_cert_file": caCertFile,
+	})
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      caRotatePath,
+		Data:      map[string]interface{}{"ca_cert": selfSignedCertificatePEM(t)},
+		Storage:   storage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Assert(t, resp.IsError())
+}
+
+func TestConfig_CABundle(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		appendCACert bool
+		previousCA   bool
+	}{
+		"two-cert-bundle": {
+			previousCA: true,
+		},
+		"append-to-system": {
+			appendCACert: true,
+		},
+		"bundle-append-to-system": {
+			appendCACert: true,
+			previousCA:   true,
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			upstream := newTestTLSUpstream(t, map[string]http.HandlerFunc{
+				"/v1/sys/health": jsonHandler(http.StatusOK, map[string]interface{}{"initialized": true}),
+			})
+			caCert := certificatePEM(upstream)
+			if tCase.previousCA {
+				// CA being rotated out is trusted together with the new one
+				caCert = selfSignedCertificatePEM(t) + caCert
+			}
+			b, storage := getBackend(t)
+			writeConfig(t, b, storage, map[string]interface{}{
+				"cluster":        upstream.URL,
+				"ca_cert":        caCert,
+				"append_ca_cert": tCase.appendCACert,
+			})
+
+			cvab := b.(*crossVaultAuthBackend)
+			expected := x509.NewCertPool()
+			if tCase.appendCACert {
+				var err error
+				if expected, err = x509.SystemCertPool(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			expected.AppendCertsFromPEM([]byte(caCert))
+			assert.Assert(t, cvab.tlsConfig.RootCAs.Equal(expected))
+			assert.DeepEqual(t, cvab.tlsTrustedCASHA256, certificateFingerprints([]byte(caCert)))
+
+			// refresh with the same bundle is not treated as change
+			generation := cvab.tlsConfigGeneration
+			cvab.refreshTLSConfig(ctx, storage)
+			assert.Equal(t, cvab.tlsConfigGeneration, generation)
+
+			resp, err := cvab.httpClient.Get(upstream.URL + "/v1/sys/health")
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = resp.Body.Close()
+			assert.Equal(t, resp.StatusCode, http.StatusOK)
+		})
+	}
+}
+
+// clientCertificatePEM returns PEM encoded self-signed client certificate and its private key.
+func clientCertificatePEM(t *testing.T) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "cross-vault-auth"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+}
+
+// selfSignedCertificatePEM returns PEM encoded self-signed CA certificate unrelated to test servers' one.
+func selfSignedCertificatePEM(t *testing.T) string {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "other-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
@@ -0,0 +1,105 @@
+package cva
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	configEffectivePath = "config/effective"
+
+	configEffectiveHelpSynopsis    = "Reports configuration with defaults applied"
+	configEffectiveHelpDescription = `
+Returns every config field with the value the plugin actually uses and
+whether it is the default one, together with built-in settings which are
+not configurable (request timeout, unwrap retry interval, etc.). Stored configuration doesn't record which fields were set
+explicitly, so a field set to its default value is reported as default.`
+)
+
+// effectiveConfigValues returns values used by the plugin for config fields, whose stored zero
+// value means the default is applied at the time of use.
+func effectiveConfigValues(config *crossVaultAuthBackendConfig) map[string]interface{} {
+	values := config.responseData()
+	if config.IdleConnTimeout == 0 {
+		values["idle_conn_timeout"] = int64(defaultIdleConnTimeout.Seconds())
+	}
+	if config.TLSRefreshInterval == 0 {
+		values["tls_refresh_interval"] = int64(tlsUpdateTicker.Seconds())
+	}
+	if config.MinTLSVersion == "" {
+		values["min_tls_version"] = "tls12"
+	}
+	return values
+}
+
+// effectiveConfigDefaults returns default values of config fields, which differ from the schema
+// defaults as they are applied on write or at the time of use.
+func effectiveConfigDefaults() map[string]interface{} {
+	return map[string]interface{}{
+		"credential_headers":   defaultCredentialHeaders,
+		"idle_conn_timeout":    int64(defaultIdleConnTimeout.Seconds()),
+		"tls_refresh_interval": int64(tlsUpdateTicker.Seconds()),
+	}
+}
+
+func (b *crossVaultAuthBackend) pathConfigEffective() *framework.Path {
+	return &framework.Path{
+		Pattern: configEffectivePath + "$",
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathConfigEffectiveRead,
+				DisplayAttrs: &framework.DisplayAttributes{
+					OperationVerb: "read",
+				},
+				Description: "returns configuration with defaults applied",
+			},
+		},
+		HelpSynopsis:    configEffectiveHelpSynopsis,
+		HelpDescription: configEffectiveHelpDescription,
+	}
+}
+
+func (b *crossVaultAuthBackend) pathConfigEffectiveRead(
+	ctx context.Context,
+	req *logical.Request,
+	_ *framework.FieldData,
+) (*logical.Response, error) {
+	config, err := b.config(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return nil, nil
+	}
+
+	schema := b.pathConfig().Fields
+	defaults := effectiveConfigDefaults()
+	fields := make(map[string]interface{})
+	for name, value := range effectiveConfigValues(config) {
+		defaultValue, ok := defaults[name]
+		if !ok {
+			defaultValue = schema[name].DefaultOrZero()
+		}
+		fields[name] = map[string]interface{}{
+			"value": value,
+			// values are compared by their representation, as read and schema types differ, e.g. int64 and int
+			"default": fmt.Sprint(value) == fmt.Sprint(defaultValue),
+		}
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"fields": fields,
+			"builtin": map[string]interface{}{
+				"request_timeout":            int64(requestTimeout.Seconds()),
+				"unwrap_retry_interval_ms":   unwrapRetryInterval.Milliseconds(),
+				"role_entity_check_interval": int64(roleEntityCheckInterval.Seconds()),
+				"max_role_request_timeout":   int64(maxRoleRequestTimeout.Seconds()),
+				"max_role_cache_ttl":         int64(maxRoleCacheTTL.Seconds()),
+			},
+		},
+	}, nil
+}
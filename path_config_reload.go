@@ -0,0 +1,79 @@
+package cva
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	configReloadPath = "config/reload"
+
+	configReloadHelpSynopsis    = "Applies stored TLS settings right away"
+	configReloadHelpDescription = `
+Applies stored configuration to TLS config of connections to target Vault
+cluster synchronously, the same way the background updater does on every
+tick, and reports whether the trusted CA certificates changed. Lets
+automation apply a new CA certificate deterministically instead of waiting
+for the next refresh.`
+)
+
+func (b *crossVaultAuthBackend) pathConfigReload() *framework.Path {
+	return &framework.Path{
+		Pattern: configReloadPath + "$",
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathConfigReloadWrite,
+				DisplayAttrs: &framework.DisplayAttributes{
+					OperationVerb: "reload",
+				},
+				Description: "applies stored TLS settings right away",
+			},
+		},
+		HelpSynopsis:    configReloadHelpSynopsis,
+		HelpDescription: configReloadHelpDescription,
+	}
+}
+
+func (b *crossVaultAuthBackend) pathConfigReloadWrite(
+	ctx context.Context,
+	req *logical.Request,
+	_ *framework.FieldData,
+) (*logical.Response, error) {
+	config, err := b.config(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return logical.ErrorResponse("configuration is not set"), nil
+	}
+
+	// the comparison and the update are made under the same lock, so updater's tick can't apply
+	// the change in between
+	b.tlsMu.Lock()
+	if err = validateHTTPClient(b); err != nil {
+		b.tlsMu.Unlock()
+		return nil, err
+	}
+	previousRootCAs := b.tlsConfig.RootCAs
+	updateErr := b.updateTLSConfigLocked(config)
+	rootCAChanged := !previousRootCAs.Equal(b.tlsConfig.RootCAs)
+	b.tlsConfigLastUpdate = time.Now()
+	b.tlsConfigLastUpdateErr = updateErr
+	trustedCASHA256 := append([]string{}, b.tlsTrustedCASHA256...)
+	b.tlsMu.Unlock()
+	if updateErr != nil {
+		return logical.ErrorResponse(updateErr.Error()), nil
+	}
+	b.setRoleCacheTTL(config.RoleCacheTTL)
+
+	b.Logger().Info("TLS config reloaded", "root_ca_changed", rootCAChanged)
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"root_ca_changed":       rootCAChanged,
+			"tls_trusted_ca_sha256": trustedCASHA256,
+		},
+	}, nil
+}
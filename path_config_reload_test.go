@@ -0,0 +1,90 @@
+package cva
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"gotest.tools/v3/assert"
+)
+
+func TestConfig_Reload(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		// storedCACert replaces CA certificate in storage after config write, as if written by another node
+		storedCACert  bool
+		expectChanged bool
+	}{
+		"ca-changed": {
+			storedCACert:  true,
+			expectChanged: true,
+		},
+		"unchanged": {},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			b, storage := getBackend(t)
+			writeConfig(t, b, storage, map[string]interface{}{
+				"cluster": "https://127.0.0.1:8200",
+				"ca_cert": selfSignedCertificatePEM(t),
+			})
+			if tCase.storedCACert {
+				config, err := b.(*crossVaultAuthBackend).config(ctx, storage)
+				if err != nil {
+					t.Fatal(err)
+				}
+				config.CACert = selfSignedCertificatePEM(t)
+				entry, err := logical.StorageEntryJSON(configPath, config)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err = storage.Put(ctx, entry); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			resp, err := b.HandleRequest(ctx, &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      configReloadPath,
+				Storage:   storage,
+			})
+			if err != nil || resp.IsError() {
+				t.Fatalf("unexpected error: %v, %v", err, resp)
+			}
+			assert.Equal(t, resp.Data["root_ca_changed"], tCase.expectChanged)
+			fingerprints, _ := resp.Data["tls_trusted_ca_sha256"].([]string)
+			assert.Equal(t, len(fingerprints), 1)
+
+			// the change is applied once only
+			resp, err = b.HandleRequest(ctx, &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      configReloadPath,
+				Storage:   storage,
+			})
+			if err != nil || resp.IsError() {
+				t.Fatalf("unexpected error: %v, %v", err, resp)
+			}
+			assert.Equal(t, resp.Data["root_ca_changed"], false)
+		})
+	}
+}
+
+func TestConfig_ReloadNotConfigured(t *testing.T) {
+	t.Parallel()
+
+	b, storage := getBackend(t)
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      configReloadPath,
+		Storage:   storage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Assert(t, resp.IsError())
+}
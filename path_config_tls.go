@@ -0,0 +1,48 @@
+package cva
+
+import (
+	"context"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	tlsRestartPath = "config/tls/restart"
+
+	tlsRestartHelpSynopsis    = "Restarts background TLS config updater"
+	tlsRestartHelpDescription = `
+Stops the running TLS config updater, starts a new one with the same refresh
+interval and applies stored configuration right away, so a stuck updater can
+be recovered without reloading the plugin. Returns the same data as
+config/status endpoint.`
+)
+
+func (b *crossVaultAuthBackend) pathConfigTLSRestart() *framework.Path {
+	return &framework.Path{
+		Pattern: tlsRestartPath + "$",
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathConfigTLSRestartWrite,
+				DisplayAttrs: &framework.DisplayAttributes{
+					OperationVerb: "restart",
+				},
+				Description: "restarts TLS config updater",
+			},
+		},
+		HelpSynopsis:    tlsRestartHelpSynopsis,
+		HelpDescription: tlsRestartHelpDescription,
+	}
+}
+
+func (b *crossVaultAuthBackend) pathConfigTLSRestartWrite(
+	ctx context.Context,
+	req *logical.Request,
+	data *framework.FieldData,
+) (*logical.Response, error) {
+	if err := b.restartTLSConfigUpdater(ctx, req.Storage, 0); err != nil {
+		return nil, err
+	}
+	b.Logger().Info("TLS config updater restarted")
+	return b.pathConfigStatusRead(ctx, req, data)
+}
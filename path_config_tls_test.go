@@ -0,0 +1,248 @@
+package cva
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"gotest.tools/v3/assert"
+)
+
+func TestConfig_TLSRestart(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		initialize bool
+	}{
+		"running": {
+			initialize: true,
+		},
+		"not-running": {},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			b, storage := getBackend(t)
+			if tCase.initialize {
+				if err := b.Initialize(ctx, &logical.InitializationRequest{Storage: storage}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			defer b.Cleanup(ctx)
+
+			cvab := b.(*crossVaultAuthBackend)
+			cvab.tlsMu.RLock()
+			previousDone := cvab.tlsConfigUpdateDone
+			cvab.tlsMu.RUnlock()
+
+			resp, err := b.HandleRequest(ctx, &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      tlsRestartPath,
+				Storage:   storage,
+			})
+			if err != nil || resp.IsError() {
+				t.Fatalf("unexpected error: %v, %v", err, resp)
+			}
+			assert.Equal(t, resp.Data["tls_updater_running"], true)
+			assert.Equal(t, resp.Data["tls_refresh_interval"], int64(tlsUpdateTicker.Seconds()))
+			lastRefreshTime, _ := resp.Data["tls_last_refresh_time"].(string)
+			assert.Assert(t, lastRefreshTime != "")
+
+			if previousDone != nil {
+				select {
+				case <-previousDone:
+				default:
+					t.Fatal("previous updater is still running")
+				}
+			}
+			cvab.tlsMu.RLock()
+			defer cvab.tlsMu.RUnlock()
+			assert.Assert(t, cvab.tlsConfigUpdateRunning)
+			assert.Assert(t, cvab.tlsConfigUpdateDone != previousDone)
+		})
+	}
+}
+
+func TestConfig_TLSRefreshInterval(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		initialize      bool
+		interval        string
+		expectRestart   bool
+		expectedRunning bool
+		expectedPeriod  time.Duration
+	}{
+		"changed": {
+			initialize:      true,
+			interval:        "10s",
+			expectRestart:   true,
+			expectedRunning: true,
+			expectedPeriod:  10 * time.Second,
+		},
+		"unchanged": {
+			initialize:      true,
+			interval:        "30s",
+			expectedRunning: true,
+			expectedPeriod:  tlsUpdateTicker,
+		},
+		"not-set": {
+			initialize:      true,
+			expectedRunning: true,
+			expectedPeriod:  tlsUpdateTicker,
+		},
+		"not-running": {
+			interval: "10s",
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			b, storage := getBackend(t)
+			if tCase.initialize {
+				if err := b.Initialize(ctx, &logical.InitializationRequest{Storage: storage}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			defer b.Cleanup(ctx)
+
+			cvab := b.(*crossVaultAuthBackend)
+			cvab.tlsMu.RLock()
+			previousDone := cvab.tlsConfigUpdateDone
+			cvab.tlsMu.RUnlock()
+
+			data := map[string]interface{}{"cluster": "http://127.0.0.1:8200"}
+			if tCase.interval != "" {
+				data["tls_refresh_interval"] = tCase.interval
+			}
+			writeConfig(t, b, storage, data)
+
+			if previousDone != nil {
+				select {
+				case <-previousDone:
+					assert.Assert(t, tCase.expectRestart, "updater is restarted")
+				default:
+					assert.Assert(t, !tCase.expectRestart, "previous updater is still running")
+				}
+			}
+			cvab.tlsMu.RLock()
+			defer cvab.tlsMu.RUnlock()
+			assert.Equal(t, cvab.tlsConfigUpdateRunning, tCase.expectedRunning)
+			assert.Equal(t, cvab.tlsConfigUpdatePeriod, tCase.expectedPeriod)
+		})
+	}
+}
+
+func TestConfig_TLSRefreshIntervalInitialize(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	b, storage := getBackend(t)
+	writeConfig(t, b, storage, map[string]interface{}{
+		"cluster":              "http://127.0.0.1:8200",
+		"tls_refresh_interval": "1m",
+	})
+	if err := b.Initialize(ctx, &logical.InitializationRequest{Storage: storage}); err != nil {
+		t.Fatal(err)
+	}
+	defer b.Cleanup(ctx)
+
+	cvab := b.(*crossVaultAuthBackend)
+	cvab.tlsMu.RLock()
+	defer cvab.tlsMu.RUnlock()
+	assert.Equal(t, cvab.tlsConfigUpdatePeriod, time.Minute)
+}
+
+func TestConfig_TLSRefreshIntervalBlockedTick(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		// stuck tick doesn't complete until the test releases it, otherwise the tick completes once
+		// it gets the backend lock, like roles' entities verification does
+		stuck bool
+	}{
+		"tick-waiting-for-lock": {},
+		"stuck-tick":            {stuck: true},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			b, storage := getBackend(t)
+			cvab := b.(*crossVaultAuthBackend)
+
+			// updater whose tick is in progress when the updater is cancelled
+			release := make(chan struct{})
+			releaseOnce := sync.OnceFunc(func() { close(release) })
+			defer releaseOnce()
+			updaterCtx, updaterCancel := context.WithCancel(ctx)
+			blockedDone := make(chan struct{})
+			cvab.tlsMu.Lock()
+			cvab.tlsConfigUpdateRunning = true
+			cvab.tlsConfigUpdatePeriod = tlsUpdateTicker
+			cvab.tlsConfigUpdateCancel = updaterCancel
+			cvab.tlsConfigUpdateDone = blockedDone
+			cvab.tlsMu.Unlock()
+			go func() {
+				<-updaterCtx.Done()
+				if tCase.stuck {
+					<-release
+				}
+				cvab.mu.RLock()
+				cvab.mu.RUnlock()
+				cvab.tlsMu.Lock()
+				if cvab.tlsConfigUpdateDone == blockedDone {
+					cvab.tlsConfigUpdateRunning = false
+				}
+				close(blockedDone)
+				cvab.tlsMu.Unlock()
+			}()
+			defer b.Cleanup(ctx)
+
+			writeCtx, cancel := context.WithTimeout(ctx, 500*time.Millisecond)
+			defer cancel()
+			resp, err := b.HandleRequest(writeCtx, &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      configPath,
+				Data: unverifiedConfig(map[string]interface{}{
+					"cluster":              "http://127.0.0.1:8200",
+					"tls_refresh_interval": 10,
+				}),
+				Storage: storage,
+			})
+			if err != nil || resp.IsError() {
+				t.Fatalf("unexpected error: %v, %v", err, resp)
+			}
+			if !tCase.stuck {
+				select {
+				case <-blockedDone:
+				default:
+					t.Fatal("config write returned before the cancelled updater exited")
+				}
+			}
+
+			cvab.tlsMu.RLock()
+			assert.Assert(t, cvab.tlsConfigUpdateRunning)
+			assert.Equal(t, cvab.tlsConfigUpdatePeriod, 10*time.Second)
+			assert.Assert(t, cvab.tlsConfigUpdateDone != blockedDone)
+			cvab.tlsMu.RUnlock()
+
+			// the cancelled updater exiting late doesn't affect the new one
+			releaseOnce()
+			<-blockedDone
+			cvab.tlsMu.RLock()
+			defer cvab.tlsMu.RUnlock()
+			assert.Assert(t, cvab.tlsConfigUpdateRunning)
+		})
+	}
+}
@@ -0,0 +1,419 @@
+package cva
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	exportHelpSynopsis    = "Export configuration and roles of the mount"
+	exportHelpDescription = `
+Returns stored configuration and all role definitions in one payload, 
+which can be written to the import endpoint of another mount. CA 
+certificate can be redacted from the exported configuration.`
+
+	importHelpSynopsis    = "Import configuration and roles exported from another mount"
+	importHelpDescription = `
+Recreates configuration and roles from the payload returned by the export 
+endpoint. Configuration and roles are validated the same way as on config 
+and role writes, nothing is stored unless all of them are valid. Existing 
+roles with the same names are overwritten.`
+)
+
+func (b *crossVaultAuthBackend) pathExport() *framework.Path {
+	return &framework.Path{
+		Pattern: "export/all$",
+		Fields: map[string]*framework.FieldSchema{
+			"redact_ca_cert": {
+				Type:        framework.TypeBool,
+				Default:     false,
+				Description: "Flag defines whether to omit CA certificate from exported configuration",
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathExportRead,
+				DisplayAttrs: &framework.DisplayAttributes{
+					OperationVerb: "export",
+				},
+				Description: "returns configuration and roles",
+			},
+		},
+		HelpSynopsis:    exportHelpSynopsis,
+		HelpDescription: exportHelpDescription,
+	}
+}
+
+func (b *crossVaultAuthBackend) pathImport() *framework.Path {
+	return &framework.Path{
+		Pattern: "import/all$",
+		Fields: map[string]*framework.FieldSchema{
+			"config": {
+				Type:        framework.TypeMap,
+				Description: "Configuration as returned by the export endpoint",
+			},
+			"roles": {
+				Type:        framework.TypeMap,
+				Description: "Roles as returned by the export endpoint",
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathImportWrite,
+				DisplayAttrs: &framework.DisplayAttributes{
+					OperationVerb: "import",
+				},
+				Description: "recreates configuration and roles",
+			},
+		},
+		HelpSynopsis:    importHelpSynopsis,
+		HelpDescription: importHelpDescription,
+	}
+}
+
+func (b *crossVaultAuthBackend) pathExportRead(
+	ctx context.Context,
+	req *logical.Request,
+	data *framework.FieldData,
+) (*logical.Response, error) {
+	redactCACert, _ := data.Get("redact_ca_cert").(bool)
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	var exportedConfig map[string]interface{}
+	config, err := b.config(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config != nil {
+		if redactCACert {
+			config.CACert = ""
+		}
+		// private key is never returned by the API, so the client certificate is omitted as well,
+		// otherwise the exported configuration would fail validation on import
+		config.ClientCert, config.ClientKey = "", ""
+		if exportedConfig, err = toMap(config); err != nil {
+			return nil, err
+		}
+	}
+
+	names, err := req.Storage.List(ctx, rolePath+"/")
+	if err != nil {
+		return nil, err
+	}
+	exportedRoles := make(map[string]interface{}, len(names))
+	for _, name := range names {
+		role, err := b.role(ctx, req.Storage, name)
+		if err != nil {
+			return nil, err
+		}
+		if role == nil {
+			continue
+		}
+		exportedRoles[name] = b.exportedRole(role)
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"config": exportedConfig,
+			"roles":  exportedRoles,
+		},
+	}, nil
+}
+
+func (b *crossVaultAuthBackend) pathImportWrite(
+	ctx context.Context,
+	req *logical.Request,
+	data *framework.FieldData,
+) (*logical.Response, error) {
+	importedConfig, _ := data.Get("config").(map[string]interface{})
+	importedRoles, _ := data.Get("roles").(map[string]interface{})
+
+	resp, err := b.importAll(ctx, req, importedConfig, importedRoles)
+	if err != nil || resp.IsError() {
+		return resp, err
+	}
+	if importedConfig != nil {
+		// TLS config updater's tick takes b.mu, so the updater is restarted after the lock is released
+		config, err := b.config(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+		if err = b.applyTLSRefreshInterval(ctx, req.Storage, config.tlsRefreshInterval()); err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+// importAll validates imported configuration and roles the same way as config and role writes do,
+// then stores them. Nothing is stored unless all of them are valid.
+func (b *crossVaultAuthBackend) importAll(
+	ctx context.Context,
+	req *logical.Request,
+	importedConfig map[string]interface{},
+	importedRoles map[string]interface{},
+) (*logical.Response, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// roles are written to the staged storage first, so they are validated against the imported
+	// configuration and base roles without touching the mount's storage
+	staged := newStagedStorage(req.Storage)
+	stagedReq := *req
+	stagedReq.Storage = staged
+	defer func() {
+		// roles read while validating might be cached from the staged storage
+		for name := range importedRoles {
+			b.invalidateRole(name)
+		}
+	}()
+
+	resp := &logical.Response{}
+	var config *crossVaultAuthBackendConfig
+	if importedConfig != nil {
+		configData := &framework.FieldData{Raw: importedConfig, Schema: b.pathConfig().Fields}
+		if err := configData.Validate(); err != nil {
+			return logical.ErrorResponse("config: " + err.Error()), nil
+		}
+		var (
+			configResp *logical.Response
+			err        error
+		)
+		config, configResp, err = b.parseConfig(ctx, &stagedReq, configData)
+		if err != nil || configResp.IsError() {
+			return configResp, err
+		}
+		if configResp != nil {
+			resp.Warnings = append(resp.Warnings, configResp.Warnings...)
+		}
+		entry, err := logical.StorageEntryJSON(configPath, config)
+		if err != nil {
+			return nil, err
+		}
+		if err = staged.Put(ctx, entry); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, name := range importOrder(importedRoles) {
+		roleResp, err := b.importRole(ctx, &stagedReq, name, importedRoles[name])
+		if err != nil {
+			return nil, err
+		}
+		if roleResp.IsError() {
+			return logical.ErrorResponse(fmt.Sprintf("role %q: %s", name, roleResp.Error())), nil
+		}
+		if roleResp != nil {
+			for _, warning := range roleResp.Warnings {
+				resp.AddWarning(fmt.Sprintf("role %q: %s", name, warning))
+			}
+		}
+	}
+
+	if config != nil {
+		if errResp, err := b.applyConfig(ctx, req.Storage, config); err != nil || errResp != nil {
+			return errResp, err
+		}
+	}
+	if err := staged.commit(ctx, rolePath+"/"); err != nil {
+		return nil, err
+	}
+
+	if len(resp.Warnings) == 0 {
+		return nil, nil
+	}
+	return resp, nil
+}
+
+// importRole validates and writes single imported role the same way as roles/bulk does, the existing
+// role is replaced. Caller must hold b.mu.
+func (b *crossVaultAuthBackend) importRole(
+	ctx context.Context,
+	req *logical.Request,
+	roleName string,
+	raw interface{},
+) (*logical.Response, error) {
+	roleData, ok := raw.(map[string]interface{})
+	if !ok {
+		return logical.ErrorResponse("role definition must be an object"), nil
+	}
+	if !roleNameRegex.MatchString(roleName) {
+		return logical.ErrorResponse("invalid role name"), nil
+	}
+
+	schema := b.pathRole().Fields
+	fields := make(map[string]interface{}, len(roleData))
+	for key, value := range roleData {
+		if _, ok := schema[key]; (!ok || key == "name") && key != "role_id" {
+			return logical.ErrorResponse(fmt.Sprintf("unknown field %q", key)), nil
+		}
+		fields[key] = value
+	}
+	roleID, _ := fields["role_id"].(string)
+	delete(fields, "role_id")
+
+	data := &framework.FieldData{Raw: fields, Schema: schema}
+	if err := data.Validate(); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	entryReq := *req
+	entryReq.Operation = logical.CreateOperation
+	roleUpdCtx := context.WithValue(ctx, roleNameCtxKey, roleName)
+	return b.roleEntryUpdate(roleUpdCtx, &entryReq, data, &crossVaultAuthRoleEntry{RoleID: roleID})
+}
+
+// importOrder returns names of imported roles ordered so base roles precede the roles derived from them.
+func importOrder(roles map[string]interface{}) []string {
+	names := make([]string, 0, len(roles))
+	byName := make(map[string]string, len(roles))
+	for name := range roles {
+		names = append(names, name)
+		byName[strings.ToLower(name)] = name
+	}
+	sort.Strings(names)
+
+	ordered := make([]string, 0, len(names))
+	visited := make(map[string]struct{}, len(names))
+	var visit func(name string)
+	visit = func(name string) {
+		if _, ok := visited[name]; ok {
+			return
+		}
+		visited[name] = struct{}{}
+		roleData, _ := roles[name].(map[string]interface{})
+		if baseRole, ok := roleData["base_role"].(string); ok {
+			if baseName, ok := byName[strings.ToLower(baseRole)]; ok {
+				visit(baseName)
+			}
+		}
+		ordered = append(ordered, name)
+	}
+	for _, name := range names {
+		visit(name)
+	}
+	return ordered
+}
+
+// exportedRole returns fields set by the role in the format of role write parameters along with role
+// identifier. Fields the role doesn't set are omitted, so they are still inherited after import.
+func (b *crossVaultAuthBackend) exportedRole(role *crossVaultAuthRoleEntry) map[string]interface{} {
+	schema := b.pathRole().Fields
+	set := setRoleFields(role)
+	exported := map[string]interface{}{"role_id": role.RoleID}
+	for key, value := range roleResponseData(role) {
+		if _, ok := schema[key]; !ok {
+			continue
+		}
+		if _, ok := set[key]; ok {
+			exported[key] = value
+		}
+	}
+	return exported
+}
+
+// stagedStorage keeps writes in memory on top of the underlying storage until they are committed.
+type stagedStorage struct {
+	storage logical.Storage
+	// entries are staged writes by key, nil entry stands for deleted one
+	entries map[string]*logical.StorageEntry
+}
+
+func newStagedStorage(storage logical.Storage) *stagedStorage {
+	return &stagedStorage{
+		storage: storage,
+		entries: make(map[string]*logical.StorageEntry),
+	}
+}
+
+func (s *stagedStorage) List(ctx context.Context, prefix string) ([]string, error) {
+	keys, err := s.storage.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]struct{}, len(keys))
+	result := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if entry, ok := s.entries[prefix+key]; ok && entry == nil {
+			continue
+		}
+		seen[key] = struct{}{}
+		result = append(result, key)
+	}
+	for key, entry := range s.entries {
+		if entry == nil || !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		key = strings.TrimPrefix(key, prefix)
+		if i := strings.Index(key, "/"); i >= 0 {
+			key = key[:i+1]
+		}
+		if _, ok := seen[key]; !ok {
+			seen[key] = struct{}{}
+			result = append(result, key)
+		}
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+func (s *stagedStorage) Get(ctx context.Context, key string) (*logical.StorageEntry, error) {
+	if entry, ok := s.entries[key]; ok {
+		return entry, nil
+	}
+	return s.storage.Get(ctx, key)
+}
+
+func (s *stagedStorage) Put(_ context.Context, entry *logical.StorageEntry) error {
+	s.entries[entry.Key] = entry
+	return nil
+}
+
+func (s *stagedStorage) Delete(_ context.Context, key string) error {
+	s.entries[key] = nil
+	return nil
+}
+
+// commit writes staged entries having the prefix to the underlying storage.
+func (s *stagedStorage) commit(ctx context.Context, prefix string) error {
+	keys := make([]string, 0, len(s.entries))
+	for key := range s.entries {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		var err error
+		if entry := s.entries[key]; entry != nil {
+			err = s.storage.Put(ctx, entry)
+		} else {
+			err = s.storage.Delete(ctx, key)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// toMap converts stored entry to its JSON representation.
+func toMap(entry interface{}) (map[string]interface{}, error) {
+	raw, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]interface{})
+	if err = json.Unmarshal(raw, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
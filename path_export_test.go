@@ -0,0 +1,176 @@
+package cva
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"gotest.tools/v3/assert"
+)
+
+func TestExport_RoundTrip(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		redactCACert   bool
+		expectedCACert string
+	}{
+		"full": {
+			expectedCACert: "DATA OMITTED",
+		},
+		"redacted-ca-cert": {
+			redactCACert: true,
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			src, srcStorage := getBackend(t)
+			writeConfig(t, src, srcStorage, map[string]interface{}{
+				"cluster":        "https://127.0.0.1:8200",
+				"namespace":      "custom",
+				"ca_cert":        "DATA OMITTED",
+				"unwrap_retries": 2,
+			})
+			writeRole(t, src, srcStorage, "first", map[string]interface{}{
+				"entity_id":          testEntityID,
+				"entity_meta":        "env=prod",
+				"strict_meta_verify": true,
+				"request_timeout":    "15s",
+				"token_ttl":          "10m",
+				"token_policies":     "test,sample",
+			})
+			writeRole(t, src, srcStorage, "second", map[string]interface{}{
+				"entity_id":       testEntityID,
+				"allowed_methods": WrappedAccessorOnly,
+			})
+			// derived role sorts before its base, so the base must be imported first
+			writeRole(t, src, srcStorage, "derived", map[string]interface{}{
+				"base_role":          "first",
+				"strict_meta_verify": false,
+			})
+
+			resp, err := src.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.ReadOperation,
+				Path:      "export/all",
+				Data:      map[string]interface{}{"redact_ca_cert": tCase.redactCACert},
+				Storage:   srcStorage,
+			})
+			if err != nil || resp.IsError() {
+				t.Fatalf("unexpected error: %v, %v", err, resp)
+			}
+
+			exportedConfig, _ := resp.Data["config"].(map[string]interface{})
+			unverifiedConfig(exportedConfig)
+			dst, dstStorage := getBackend(t)
+			resp, err = dst.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      "import/all",
+				Data:      resp.Data,
+				Storage:   dstStorage,
+			})
+			if err != nil || resp.IsError() {
+				t.Fatalf("unexpected error: %v, %v", err, resp)
+			}
+
+			srcConfig, _ := src.(*crossVaultAuthBackend).config(context.Background(), srcStorage)
+			dstConfig, _ := dst.(*crossVaultAuthBackend).config(context.Background(), dstStorage)
+			srcConfig.CACert = tCase.expectedCACert
+			assert.DeepEqual(t, dstConfig, srcConfig)
+
+			for _, roleName := range []string{"first", "second", "derived"} {
+				srcRole, _ := src.(*crossVaultAuthBackend).role(context.Background(), srcStorage, roleName)
+				dstRole, _ := dst.(*crossVaultAuthBackend).role(context.Background(), dstStorage, roleName)
+				assert.DeepEqual(t, dstRole, srcRole)
+			}
+			derived, err := dst.(*crossVaultAuthBackend).resolvedRole(context.Background(), dstStorage, "derived")
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, derived.TokenTTL, 10*time.Minute)
+			assert.Equal(t, derived.StrictMetaVerify, false)
+		})
+	}
+}
+
+func TestImport_Invalid(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]map[string]interface{}{
+		"missing-cluster": {
+			"config": map[string]interface{}{"namespace": "custom"},
+		},
+		"missing-entity-id": {
+			"roles": map[string]interface{}{
+				"sample": map[string]interface{}{"token_ttl": 600},
+			},
+		},
+		"unknown-role-field": {
+			"roles": map[string]interface{}{
+				"sample": map[string]interface{}{"entity_id": testEntityID, "unknown": true},
+			},
+		},
+		"invalid-role-name": {
+			"roles": map[string]interface{}{
+				"sample/name": map[string]interface{}{"entity_id": testEntityID},
+			},
+		},
+		"invalid-role-field-value": {
+			"config": map[string]interface{}{"cluster": "https://127.0.0.1:8200"},
+			"roles": map[string]interface{}{
+				"first":  map[string]interface{}{"entity_id": testEntityID},
+				"second": map[string]interface{}{"entity_id": testEntityID, "meta_match_mode": "glob"},
+			},
+		},
+		"role-ttl-order": {
+			"roles": map[string]interface{}{
+				"sample": map[string]interface{}{"entity_id": testEntityID, "token_ttl": 600, "token_max_ttl": 60},
+			},
+		},
+		"role-namespace-not-allowed-by-config": {
+			"config": map[string]interface{}{
+				"cluster":            "https://127.0.0.1:8200",
+				"allowed_namespaces": "team-a",
+			},
+			"roles": map[string]interface{}{
+				"sample": map[string]interface{}{"entity_id": testEntityID, "namespace": "team-b"},
+			},
+		},
+		"missing-base-role": {
+			"roles": map[string]interface{}{
+				"sample": map[string]interface{}{"base_role": "missing"},
+			},
+		},
+	}
+
+	for n, tc := range tests {
+		name, data := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			b, storage := getBackend(t)
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      "import/all",
+				Data:      data,
+				Storage:   storage,
+			})
+			if err == nil && !resp.IsError() {
+				t.Fatalf("expected error, but no error occurred")
+			}
+			roles, err := storage.List(context.Background(), rolePath+"/")
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, len(roles), 0)
+			// configuration is not stored unless roles are valid as well
+			config, err := b.(*crossVaultAuthBackend).config(context.Background(), storage)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Assert(t, config == nil)
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package cva
+
+import (
+	"context"
+	"runtime/debug"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	vaultSDKModule = "github.com/hashicorp/vault/sdk"
+
+	infoVersionHelpSynopsis    = "Reports version and build info of the plugin"
+	infoVersionHelpDescription = `
+Returns version of the running plugin build, version of Vault SDK it 
+was built with, supported login methods and optional features. Helps 
+to confirm which plugin build is active on the mount.`
+)
+
+// supportedFeatures lists optional features of the plugin build.
+var supportedFeatures = []string{
+	"bulk_roles",
+	"config_status",
+	"export_import",
+	"login_events",
+	"method_autodetect",
+	"method_stats",
+	"role_inheritance",
+	"role_schema",
+	"tls_pinning",
+}
+
+func (b *crossVaultAuthBackend) pathInfoVersion() *framework.Path {
+	return &framework.Path{
+		Pattern: "info/version$",
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathInfoVersionRead,
+				DisplayAttrs: &framework.DisplayAttributes{
+					OperationVerb: "read",
+				},
+				Description: "returns plugin version and build info",
+			},
+		},
+		HelpSynopsis:    infoVersionHelpSynopsis,
+		HelpDescription: infoVersionHelpDescription,
+	}
+}
+
+func (b *crossVaultAuthBackend) pathInfoVersionRead(
+	_ context.Context,
+	_ *logical.Request,
+	_ *framework.FieldData,
+) (*logical.Response, error) {
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"version":     pluginVersion,
+			"sdk_version": moduleVersion(vaultSDKModule),
+			"methods":     []string{WrappedTokenFull, WrappedTokenOnly, WrappedAccessorOnly},
+			"features":    append([]string{}, supportedFeatures...),
+		},
+	}, nil
+}
+
+// moduleVersion returns version of the dependency module the binary was built with
+// or empty string if build info is not available.
+func moduleVersion(path string) string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == path {
+			if dep.Replace != nil {
+				return dep.Replace.Version
+			}
+			return dep.Version
+		}
+	}
+	return ""
+}
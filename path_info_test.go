@@ -0,0 +1,29 @@
+package cva
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"gotest.tools/v3/assert"
+)
+
+func TestInfo_Version(t *testing.T) {
+	t.Parallel()
+
+	b, storage := getBackend(t)
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "info/version",
+		Storage:   storage,
+	})
+	if err != nil || resp.IsError() {
+		t.Fatalf("unexpected error: %v, %v", err, resp)
+	}
+
+	assert.Equal(t, resp.Data["version"], pluginVersion)
+	assert.DeepEqual(t, resp.Data["methods"], []string{WrappedTokenFull, WrappedTokenOnly, WrappedAccessorOnly})
+	assert.DeepEqual(t, resp.Data["features"], supportedFeatures)
+	_, ok := resp.Data["sdk_version"].(string)
+	assert.Assert(t, ok)
+}
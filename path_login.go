@@ -2,13 +2,28 @@ package cva
 
 import (
 	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"reflect"
+	"maps"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/hashicorp/go-secure-stdlib/strutil"
+	"github.com/hashicorp/go-sockaddr"
 	"github.com/hashicorp/vault/api"
 	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/cidrutil"
 	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/pkg/errors"
 )
 
 const (
@@ -18,12 +33,34 @@ During Cross Vault authentication process, backend will validate provided token
 accessor at the peered Vault cluster and issue new token in case validation will be passed.
 `
 
-	tokenLookupPath    = "auth/token/lookup"
+	sourceTokenTypeService = "service"
+	sourceTokenTypeBatch   = "batch"
+
+	tokenLookupPath    = "auth/%s/lookup"
 	tokenPayloadKey    = "token"
-	accessorLookupPath = "auth/token/lookup-accessor"
+	accessorLookupPath = "auth/%s/lookup-accessor"
 	accessorPayloadKey = "accessor"
 )
 
+const (
+	loginEventType = "cross-vault-auth/login"
+
+	loginOutcomeSuccess = "success"
+	loginOutcomeFailure = "failure"
+)
+
+// wrappingTokenPrefixes are prefixes of Vault service tokens, which wrapping tokens are
+var wrappingTokenPrefixes = []string{"hvs.", "s."}
+
+const (
+	unwrapRetryInterval = time.Millisecond * 500
+)
+
+const maxCorrelationIDLength = 128
+
+// correlationIDRegex defines characters allowed in caller-supplied correlation ID
+var correlationIDRegex = regexp.MustCompile(`^[A-Za-z0-9._:/-]+$`)
+
 const (
 	WrappedTokenFull    = "token-full"
 	WrappedTokenOnly    = "token-only"
@@ -36,13 +73,18 @@ func (b *crossVaultAuthBackend) pathLogin() *framework.Path {
 		Fields: map[string]*framework.FieldSchema{
 			"role": {
 				Type:        framework.TypeString,
-				Description: "Name of the role to login. The field is mandatory.",
+				Description: "Name of the role to login. The field is mandatory unless mount allows role selection.",
 			},
 			"secret": {
 				Type: framework.TypeString,
 				Description: "Token issued by the peered Vault cluster or token accessor if " +
 					"corresponding flag set to true. The field is mandatory.",
 			},
+			"secret2": {
+				Type: framework.TypeString,
+				Description: "Second independent secret of the same entity wrapped the same way as 'secret'. " +
+					"Mandatory if the role requires dual secret.",
+			},
 			// instead of field "accessor" add field "method" with possible values:
 			// - token-full: "secret" field should contain wrapping toking with full token data obtained by '-wrap-ttl=N write auth/.../login'
 			// - token-only: "secret" field should contain wrapping token with target token itself wrapped using cubbyhole secret engine
@@ -52,10 +94,33 @@ func (b *crossVaultAuthBackend) pathLogin() *framework.Path {
 				Default:     WrappedTokenFull,
 				Description: "Field defines how to operate with provided secret",
 			},
+			"bind_caller_ip": {
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: "Flag defines whether the issued token is bound to the caller's IP address. " +
+					"The address must be within role's token_bound_cidrs if those are set.",
+			},
+			"correlation_id": {
+				Type: framework.TypeString,
+				Description: fmt.Sprintf("Caller's request or correlation ID copied to the issued token's metadata. "+
+					"Up to %d alphanumeric characters and '.', '_', ':', '/', '-'.", maxCorrelationIDLength),
+			},
+			"ttl": {
+				Type: framework.TypeDurationSecond,
+				Description: "Requested TTL of the issued token. Must not exceed role's token_max_ttl and " +
+					"system max TTL. Role's token_ttl is used if not set. Shortened to the TTL of role's matching " +
+					"ttl_by_meta rule.",
+			},
+			"validate_only": {
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: "Flag defines whether login only validates the secret and returns the result " +
+					"without issuing a token. The secret is consumed the same way as by regular login.",
+			},
 		},
 		Operations: map[logical.Operation]framework.OperationHandler{
 			logical.UpdateOperation: &framework.PathOperation{
-				Callback: b.login,
+				Callback: b.handleLogin,
 			},
 			logical.AliasLookaheadOperation: &framework.PathOperation{
 				Callback: b.loginAliasLookahead,
@@ -71,12 +136,22 @@ func (b *crossVaultAuthBackend) loginAliasLookahead(
 	req *logical.Request,
 	data *framework.FieldData,
 ) (*logical.Response, error) {
+	if err := b.applyHeaderCredentials(ctx, req, data); err != nil {
+		return nil, err
+	}
 	roleName, _ := data.Get("role").(string)
 	if roleName == "" {
 		return nil, fmt.Errorf("'role' field is mandatory")
 	}
 
-	role, err := b.role(ctx, req.Storage, roleName)
+	role, err := b.resolvedRole(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return nil, fmt.Errorf("role with provided name not found")
+	}
+	aliasName, err := role.aliasName(nil)
 	if err != nil {
 		return nil, err
 	}
@@ -84,97 +159,741 @@ func (b *crossVaultAuthBackend) loginAliasLookahead(
 	return &logical.Response{
 		Auth: &logical.Auth{
 			Alias: &logical.Alias{
-				Name: role.RoleID,
+				Name: aliasName,
 			},
 		},
 	}, nil
 }
 
-func (b *crossVaultAuthBackend) login(
+// handleLogin performs login and reports its outcome.
+func (b *crossVaultAuthBackend) handleLogin(
 	ctx context.Context,
 	req *logical.Request,
 	data *framework.FieldData,
 ) (*logical.Response, error) {
-	var (
-		validated bool
-		err       error
+	if err := b.applyHeaderCredentials(ctx, req, data); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	resp, err := b.login(ctx, req, data)
+
+	outcome := loginOutcomeSuccess
+	roleName := loginRoleName(data, resp)
+	if err != nil || resp.IsError() {
+		outcome = loginOutcomeFailure
+		if !b.roleExists(ctx, req.Storage, roleName) {
+			roleName = ""
+		}
+		b.recordLoginError(roleName, resp, err)
+	}
+	method := loginMethod(data, resp)
+	b.countLogin(roleName, method, outcome)
+	b.emitLoginEvent(ctx, req, roleName, method, outcome)
+
+	return resp, err
+}
+
+// applyHeaderCredentials fills login fields absent in the request body with values of the configured
+// request headers, if allowed by configuration. Body fields always take precedence.
+func (b *crossVaultAuthBackend) applyHeaderCredentials(
+	ctx context.Context,
+	req *logical.Request,
+	data *framework.FieldData,
+) error {
+	config, err := b.config(ctx, req.Storage)
+	if err != nil || config == nil || !config.AllowHeaderCredentials {
+		return err
+	}
+	for field, header := range config.CredentialHeaders {
+		if value, ok := data.Raw[field].(string); ok && value != "" {
+			continue
+		}
+		values := headerValues(req.Headers, header)
+		if len(values) == 0 {
+			continue
+		}
+		if len(values) > 1 {
+			return fmt.Errorf("header %q of login field %q must have single value", header, field)
+		}
+		if data.Raw == nil {
+			data.Raw = make(map[string]interface{})
+		}
+		data.Raw[field] = values[0]
+	}
+	return nil
+}
+
+// headerValues returns values of the header matching name case-insensitively, as passed through headers
+// keep the case they were configured with.
+func headerValues(headers map[string][]string, name string) []string {
+	for key, values := range headers {
+		if strings.EqualFold(key, name) {
+			return values
+		}
+	}
+	return nil
+}
+
+// loginRoleName returns name of the role the login was performed with: the requested one, or the
+// selected one if the login omitted the role.
+func loginRoleName(data *framework.FieldData, resp *logical.Response) string {
+	if roleName, _ := data.Get("role").(string); roleName != "" {
+		return roleName
+	}
+	switch {
+	case resp == nil:
+		return ""
+	case resp.Auth != nil:
+		roleName, _ := resp.Auth.InternalData["role"].(string)
+		return roleName
+	default:
+		roleName, _ := resp.Data["role"].(string)
+		return roleName
+	}
+}
+
+// loginMethod returns the method the login was performed with: the resolved one if the login succeeded,
+// the requested one otherwise.
+func loginMethod(data *framework.FieldData, resp *logical.Response) string {
+	if resp != nil && resp.Auth != nil {
+		if method, ok := resp.Auth.Metadata["method"]; ok {
+			return method
+		}
+	}
+	method, _ := data.Get("method").(string)
+	return method
+}
+
+// emitLoginEvent sends login event via Vault event system if enabled by configuration.
+// Secrets are never included in the event.
+func (b *crossVaultAuthBackend) emitLoginEvent(
+	ctx context.Context,
+	req *logical.Request,
+	roleName, method, outcome string,
+) {
+	config, err := b.config(ctx, req.Storage)
+	if err != nil || config == nil || !config.EmitEvents {
+		return
+	}
+
+	err = logical.SendEvent(ctx, b.Backend, loginEventType,
+		"role", roleName,
+		"method", method,
+		"outcome", outcome,
+		"mount", req.MountPoint,
+		"timestamp", time.Now().UTC().Format(time.RFC3339),
 	)
+	switch {
+	case errors.Is(err, framework.ErrNoEvents):
+		b.Logger().Debug("events are not supported by Vault, login event skipped")
+	case err != nil:
+		b.Logger().Warn("failed to send login event", "error", err)
+	}
+}
+
+func (b *crossVaultAuthBackend) login(
+	ctx context.Context,
+	req *logical.Request,
+	data *framework.FieldData,
+) (*logical.Response, error) {
+	var err error
 
 	roleName, _ := data.Get("role").(string)
 	if roleName == "" {
-		return logical.ErrorResponse("'role' field is mandatory"), nil
+		return b.loginSelectingRole(ctx, req, data)
 	}
 	secret, _ := data.Get("secret").(string)
 	if secret == "" {
 		return logical.ErrorResponse("'secret' field is mandatory"), nil
 	}
-	method, _ := data.Get("method").(string)
 
-	role, err := b.role(ctx, req.Storage, roleName)
+	role, err := b.resolvedRole(ctx, req.Storage, roleName)
+	if errors.Is(err, baseRoleNotFound) || errors.Is(err, roleInheritanceCycle) {
+		return logical.ErrorResponse(err.Error()), nil
+	}
 	if err != nil {
 		return nil, err
 	}
 	if role == nil {
 		return logical.ErrorResponse("role with provided name not found"), nil
 	}
+	// rejected before the single-use wrapping token is consumed
+	if role.Disabled {
+		return logical.ErrorResponse("role is disabled"), nil
+	}
 
 	config, err := b.config(ctx, req.Storage)
 	if err != nil {
 		return nil, err
 	}
+	if config == nil {
+		return logical.ErrorResponse("configuration is not set"), nil
+	}
 
-	// here I assume that there is VAULT_TOKEN env variable is already set.
-	// this assumption comes from the very concrete use case - when current
-	// vault cluster uses transit unseal option, so it is already authenticated
-	// in the target vault cluster via vault agent.
-	b.vc, err = api.NewClient(b.newConfig(config))
+	method, err := resolveLoginMethod(config, role, data)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	// requested TTL is checked before the single-use wrapping token is consumed
+	requestedTTL, err := b.requestedTTL(role, data)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	secret2, _ := data.Get("secret2").(string)
+	if role.RequireDualSecret && (secret2 == "" || secret2 == secret) {
+		return logical.ErrorResponse("role requires 'secret2' field with second independent secret"), nil
+	}
+	validateOnly, _ := data.Get("validate_only").(bool)
+	correlationID, _ := data.Get("correlation_id").(string)
+	if err = validateCorrelationID(correlationID); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	var callerCIDR *sockaddr.SockAddrMarshaler
+	if bindCallerIP, _ := data.Get("bind_caller_ip").(bool); bindCallerIP {
+		if callerCIDR, err = callerBoundCIDR(req, role); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+	}
+
+	b.countMethodAttempt(method)
+
+	client, err := b.sharedUpstreamClient(config)
 	if err != nil {
 		return nil, err
 	}
-	b.vc.SetNamespace(config.Namespace)
+	// wrapping tokens are namespace-scoped, so the namespace must be set before unwrap,
+	// both unwrap and lookup requests are sent to it. Shared client is copied, so the
+	// namespace doesn't affect other logins
+	if role.Namespace != "" {
+		client = client.WithNamespace(normalizeNamespace(role.Namespace))
+	}
 
-	b.ctx, b.cancel = context.WithTimeout(ctx, requestTimeout)
-	defer b.cancel()
+	// client and context are login's own, concurrent logins share the HTTP client only
+	reqCtx, cancel := context.WithTimeout(ctx, role.requestTimeout())
+	defer cancel()
 
-	secret, err = b.unwrapSecret(method, secret)
+	trace := newValidationTrace(config.DebugLogin)
+	timings := newLoginTimings(config.DebugLogin)
+	unwrapStart := time.Now()
+	secret, err = b.unwrapSecret(reqCtx, client, config, method, secret)
 	if err != nil {
 		return nil, err
 	}
-	validated, err = b.validateSecret(role, method, secret)
+	timings.since("unwrap", unwrapStart)
+	trace.set("unwrap", "ok")
+	source, err := b.validateSecret(reqCtx, client, config, role, method, secret, trace, timings)
 	if err != nil {
+		if errors.Is(err, roleValidationFailed) {
+			return trace.errorResponse(err.Error()), nil
+		}
 		return nil, err
 	}
-	if !validated {
-		return logical.ErrorResponse("role validation failed"), nil
+	if role.RequireDualSecret {
+		err = b.validateSecondSecret(reqCtx, client, config, role, method, secret, secret2, source)
+		if trace.check("secret2", err == nil); err != nil {
+			if errors.Is(err, roleValidationFailed) {
+				return trace.errorResponse(err.Error()), nil
+			}
+			return nil, err
+		}
 	}
 
-	metadata := map[string]string{"role": roleName, "mapped_entity_id": role.EntityID}
+	return b.issueAuth(&loginState{
+		config:        config,
+		role:          role,
+		roleName:      roleName,
+		method:        method,
+		source:        source,
+		trace:         trace,
+		timings:       timings,
+		requestedTTL:  requestedTTL,
+		callerCIDR:    callerCIDR,
+		correlationID: correlationID,
+		validateOnly:  validateOnly,
+	})
+}
+
+// loginState holds the outcome of source token validation and login options token issuance depends on.
+type loginState struct {
+	config        *crossVaultAuthBackendConfig
+	role          *crossVaultAuthRoleEntry
+	roleName      string
+	method        string
+	source        *sourceToken
+	trace         validationTrace
+	timings       loginTimings
+	requestedTTL  time.Duration
+	callerCIDR    *sockaddr.SockAddrMarshaler
+	correlationID string
+	validateOnly  bool
+}
+
+// issueAuth applies mount-wide checks to the validated login and returns response issuing the token.
+func (b *crossVaultAuthBackend) issueAuth(state *loginState) (*logical.Response, error) {
+	config, role, roleName, source, trace := state.config, state.role, state.roleName, state.source, state.trace
 
+	// deny-list applies regardless of the role, so compromised entity is blocked across all roles at once
+	if len(config.DeniedEntityIDs) > 0 && !trace.check("entity_not_denied", !config.entityDenied(source.EntityID)) {
+		b.Logger().Warn("login of entity denied by mount's denied_entity_ids rejected",
+			"role", roleName, "entity_id", source.EntityID)
+		return trace.errorResponse(fmt.Errorf("%w: entity is denied by mount's denied_entity_ids",
+			roleValidationFailed).Error()), nil
+	}
+
+	aliasName, err := role.aliasName(source)
+	if err != nil {
+		return trace.errorResponse(err.Error()), nil
+	}
+
+	mappedEntityID := role.loginEntityID(source)
 	auth := &logical.Auth{
 		InternalData: map[string]interface{}{"role": roleName},
-		DisplayName:  fmt.Sprintf("%s-%s", roleName, role.EntityID),
-		Metadata:     metadata,
+		DisplayName:  fmt.Sprintf("%s-%s", roleName, auditEntityID(config, mappedEntityID)),
+		Metadata:     tokenMetadata(config, role, roleName, state.method, source, state.correlationID),
 		Alias: &logical.Alias{
-			Name:     role.RoleID,
-			Metadata: metadata,
+			Name:     aliasName,
+			Metadata: map[string]string{"role": roleName, "mapped_entity_id": mappedEntityID},
 		},
 		Orphan: true,
 	}
+	if role.MirrorSourceOrphan {
+		auth.Orphan = source.Orphan
+	}
+	for _, groupAlias := range role.GroupAliases {
+		auth.GroupAliases = append(auth.GroupAliases, &logical.Alias{Name: groupAlias})
+	}
 	role.PopulateTokenAuth(auth)
+	if len(role.TokenPoliciesTemplate) > 0 {
+		var templatedPolicies []string
+		templatedPolicies, err = renderPolicyTemplates(config, role, source.Meta)
+		if !trace.check("policies_template", err == nil) {
+			return trace.errorResponse(err.Error()), nil
+		}
+		auth.Policies = strutil.RemoveDuplicatesStable(append(auth.Policies, templatedPolicies...), false)
+	}
 	auth.Renewable = false
+	if state.requestedTTL > time.Duration(0) {
+		auth.TTL = state.requestedTTL
+	}
+	// TTL mapped from metadata bounds the requested one, so the source can't get a longer-lived token
+	if ttl, ok := metaTTLFor(config, role, source.Meta); ok {
+		ttl = b.boundedTTL(role, ttl)
+		if state.requestedTTL == time.Duration(0) || ttl < auth.TTL {
+			auth.TTL = ttl
+		}
+	}
+	if role.TokenTTLJitter > 0 {
+		auth.TTL = b.jitteredTTL(auth.TTL, role.TokenTTLJitter)
+	}
+	if state.callerCIDR != nil {
+		auth.BoundCIDRs = []*sockaddr.SockAddrMarshaler{state.callerCIDR}
+	}
+
+	// role might have been written before allowed_policies was set or changed
+	if disallowed := config.disallowedPolicies(auth.Policies); len(disallowed) > 0 {
+		if !trace.check("policies_allowed", config.DisallowedPoliciesAction == disallowedPoliciesFilter) {
+			return trace.errorResponse(fmt.Sprintf("role grants policies not allowed by mount's allowed_policies: %s",
+				strings.Join(disallowed, ", "))), nil
+		}
+		b.Logger().Warn("policies not allowed by mount's allowed_policies filtered out",
+			"role", roleName, "policies", disallowed)
+		allowed := make([]string, 0, len(auth.Policies))
+		for _, policy := range auth.Policies {
+			if !strutil.StrListContains(disallowed, policy) {
+				allowed = append(allowed, policy)
+			}
+		}
+		auth.Policies = allowed
+	}
+
+	if role.SkipMetaVerify {
+		b.Logger().Warn("login succeeded with metadata verification skipped by role", "role", roleName)
+	}
+
+	resp := &logical.Response{Auth: auth}
+	if source.Degraded {
+		resp.AddWarning("target Vault cluster is unreachable, login was validated with cached results")
+	}
+	if config.ForwardUpstreamWarnings {
+		for _, warning := range source.Warnings {
+			resp.AddWarning("target Vault cluster: " + warning)
+		}
+	}
+	if config.DebugLogin {
+		resp.Data = map[string]interface{}{
+			"debug": map[string]interface{}{
+				"matched_meta_keys": matchedMetaKeys(role),
+				"trace":             map[string]interface{}(trace),
+				"timings_ms":        map[string]float64(state.timings),
+			},
+		}
+	}
+	if state.validateOnly {
+		// the caller needs the validation result only, so no token is issued
+		resp.Auth = nil
+		if resp.Data == nil {
+			resp.Data = make(map[string]interface{})
+		}
+		resp.Data["valid"] = true
+		resp.Data["role"] = roleName
+	}
+	return resp, nil
+}
+
+// tokenMetadata returns metadata of the token issued for the login, so audit log entries of requests
+// made with the token are self-explanatory. Login-specific keys are not added to alias metadata, as
+// it is shared by all logins of the role. Role's static metadata never overrides the keys set by login.
+func tokenMetadata(
+	config *crossVaultAuthBackendConfig,
+	role *crossVaultAuthRoleEntry,
+	roleName, method string,
+	source *sourceToken,
+	correlationID string,
+) map[string]string {
+	metadata := maps.Clone(role.TokenMetadata)
+	if metadata == nil {
+		metadata = make(map[string]string, len(reservedTokenMetadataKeys))
+	}
+	metadata["role"] = roleName
+	metadata["mapped_entity_id"] = auditEntityID(config, role.loginEntityID(source))
+	metadata["source_entity_id"] = auditEntityID(config, source.EntityID)
+	metadata["method"] = method
+	if clusterURL, err := url.Parse(config.Cluster); err == nil {
+		metadata["source_cluster"] = clusterURL.Host
+	}
+	if correlationID != "" {
+		metadata["correlation_id"] = correlationID
+	}
+	return metadata
+}
+
+// auditEntityID returns entity ID as it appears in issued token's metadata and display name: as is,
+// or SHA-256 hash of it if configured so. Empty ID is returned as is.
+func auditEntityID(config *crossVaultAuthBackendConfig, entityID string) string {
+	if !config.HashAuditEntityIDs || entityID == "" {
+		return entityID
+	}
+	sum := sha256.Sum256([]byte(entityID))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// validateCorrelationID checks length and charset of caller-supplied correlation ID, empty one is valid.
+func validateCorrelationID(correlationID string) error {
+	if correlationID == "" {
+		return nil
+	}
+	if len(correlationID) > maxCorrelationIDLength {
+		return fmt.Errorf("'correlation_id' must not be longer than %d characters", maxCorrelationIDLength)
+	}
+	if !correlationIDRegex.MatchString(correlationID) {
+		return fmt.Errorf("'correlation_id' contains characters other than alphanumeric and '.', '_', ':', '/', '-'")
+	}
+	return nil
+}
+
+func isKnownLoginMethod(method string) bool {
+	switch method {
+	case WrappedTokenFull, WrappedTokenOnly, WrappedAccessorOnly:
+		return true
+	default:
+		return false
+	}
+}
+
+// resolveLoginMethod returns the login method to be used, considering the method requested,
+// methods allowed by the role and configured method precedence.
+func resolveLoginMethod(
+	config *crossVaultAuthBackendConfig,
+	role *crossVaultAuthRoleEntry,
+	data *framework.FieldData,
+) (string, error) {
+	raw, requested := data.GetOk("method")
+	method, _ := raw.(string)
+
+	switch {
+	case len(role.AllowedMethods) == 1 && !requested:
+		method = role.AllowedMethods[0]
+	case len(role.AllowedMethods) == 1 && method != role.AllowedMethods[0]:
+		if config.MethodPrecedence != methodPrecedenceRole {
+			return "", fmt.Errorf("method %q is not allowed by the role, expected %q", method, role.AllowedMethods[0])
+		}
+		method = role.AllowedMethods[0]
+	case !requested && config.MethodAutodetect:
+		secret, _ := data.Get("secret").(string)
+		if method = detectLoginMethod(secret); method == "" {
+			return "", fmt.Errorf("unable to detect login method from the secret, 'method' field must be specified")
+		}
+	case !requested:
+		method, _ = data.GetDefaultOrZero("method").(string)
+	}
+
+	if !isKnownLoginMethod(method) {
+		return "", unknownLoginMethod
+	}
+	if len(role.AllowedMethods) > 1 && !strutil.StrListContains(role.AllowedMethods, method) {
+		return "", fmt.Errorf("method %q is not allowed by the role", method)
+	}
+	return method, nil
+}
+
+// requestedTTL returns TTL requested for the issued token or zero if not requested. Requested
+// TTL must not exceed role's token_max_ttl and system max TTL.
+func (b *crossVaultAuthBackend) requestedTTL(role *crossVaultAuthRoleEntry, data *framework.FieldData) (time.Duration, error) {
+	raw, ok := data.GetOk("ttl")
+	if !ok {
+		return 0, nil
+	}
+	ttl, _ := raw.(int)
+	requestedTTL := time.Duration(ttl) * time.Second
+
+	maxTTL := b.System().MaxLeaseTTL()
+	if role.TokenMaxTTL > time.Duration(0) && role.TokenMaxTTL < maxTTL {
+		maxTTL = role.TokenMaxTTL
+	}
+	if requestedTTL <= time.Duration(0) || requestedTTL > maxTTL {
+		return 0, fmt.Errorf("ttl must be positive and must not exceed %s", maxTTL)
+	}
+	return requestedTTL, nil
+}
+
+// boundedTTL limits ttl by role's token_max_ttl and system max TTL.
+func (b *crossVaultAuthBackend) boundedTTL(role *crossVaultAuthRoleEntry, ttl time.Duration) time.Duration {
+	maxTTL := b.System().MaxLeaseTTL()
+	if role.TokenMaxTTL > time.Duration(0) && role.TokenMaxTTL < maxTTL {
+		maxTTL = role.TokenMaxTTL
+	}
+	if ttl > maxTTL {
+		return maxTTL
+	}
+	return ttl
+}
+
+// metaTTLFor returns TTL of the first role's ttl_by_meta rule matching upstream metadata. Metadata is
+// compared the same way it is validated, i.e. with configured key prefix stripped, whitespace trimmed
+// and keys lowercased if the role requires so.
+func metaTTLFor(
+	config *crossVaultAuthBackendConfig,
+	role *crossVaultAuthRoleEntry,
+	metadata map[string]string,
+) (time.Duration, bool) {
+	if len(role.TTLByMeta) == 0 {
+		return 0, false
+	}
+	if config.MetaKeyStripPrefix != "" {
+		metadata = stripMetaKeyPrefix(metadata, config.MetaKeyStripPrefix)
+	}
+	if role.MetaKeysCaseInsensitive {
+		// TTL rules don't fail login, so ambiguous upstream keys just don't match any rule
+		lowercased, _, err := lowercaseMetaKeys(metadata, role)
+		if err != nil {
+			return 0, false
+		}
+		metadata = lowercased
+	}
+	for _, rule := range role.TTLByMeta {
+		key := rule.Key
+		if role.MetaKeysCaseInsensitive {
+			key = strings.ToLower(key)
+		}
+		actual, ok := metadata[key]
+		expected := rule.Value
+		if role.MetaTrimWhitespace {
+			actual, expected = strings.TrimSpace(actual), strings.TrimSpace(expected)
+		}
+		if ok && actual == expected {
+			return rule.TTL, true
+		}
+	}
+	return 0, false
+}
+
+// callerBoundCIDR returns single host CIDR of the caller's address. The address must be within
+// role's token_bound_cidrs if those are set, otherwise binding would widen the role's restriction.
+func callerBoundCIDR(req *logical.Request, role *crossVaultAuthRoleEntry) (*sockaddr.SockAddrMarshaler, error) {
+	if req.Connection == nil || req.Connection.RemoteAddr == "" {
+		return nil, fmt.Errorf("caller's address is unknown, token can't be bound to it")
+	}
+	addr, err := sockaddr.NewIPAddr(req.Connection.RemoteAddr)
+	if err != nil {
+		return nil, fmt.Errorf("caller's address %q is invalid: %w", req.Connection.RemoteAddr, err)
+	}
+	if len(role.TokenBoundCIDRs) > 0 && !cidrutil.RemoteAddrIsOk(req.Connection.RemoteAddr, role.TokenBoundCIDRs) {
+		return nil, fmt.Errorf("caller's address %q is not within role's token_bound_cidrs", req.Connection.RemoteAddr)
+	}
+	return &sockaddr.SockAddrMarshaler{SockAddr: addr}, nil
+}
+
+// jitteredTTL randomly reduces TTL by up to the percentage, so the result never exceeds the
+// bounds TTL was already checked against. Mount's default TTL is used if ttl is not set.
+func (b *crossVaultAuthBackend) jitteredTTL(ttl time.Duration, percentage int) time.Duration {
+	if ttl <= time.Duration(0) {
+		ttl = b.System().DefaultLeaseTTL()
+	}
+	maxJitter := int64(ttl) * int64(percentage) / 100
+	if maxJitter <= 0 {
+		return ttl
+	}
+	return ttl - time.Duration(rand.Int64N(maxJitter+1))
+}
+
+// detectLoginMethod returns login method matching the secret's shape or empty string if
+// the shape is ambiguous. Only wrapping tokens can be recognized, and since wrapping of
+// login response is the most common case, they are treated as token-full.
+func detectLoginMethod(secret string) string {
+	for _, prefix := range wrappingTokenPrefixes {
+		if strings.HasPrefix(secret, prefix) {
+			return WrappedTokenFull
+		}
+	}
+	return ""
+}
+
+// upstreamClient returns new client for the target Vault cluster.
+func (b *crossVaultAuthBackend) upstreamClient(config *crossVaultAuthBackendConfig) (*api.Client, error) {
+	// here I assume that there is VAULT_TOKEN env variable is already set.
+	// this assumption comes from the very concrete use case - when current
+	// vault cluster uses transit unseal option, so it is already authenticated
+	// in the target vault cluster via vault agent.
+	client, err := api.NewClient(b.newConfig(config))
+	if err != nil {
+		return nil, err
+	}
+	setUpstreamNamespace(client, config.Namespace)
+	return client, nil
+}
+
+// upstreamClientKey identifies settings the vault client depends on.
+type upstreamClientKey struct {
+	cluster       string
+	namespace     string
+	tlsGeneration uint64
+}
 
-	return &logical.Response{Auth: auth}, nil
+// sharedUpstreamClient returns vault client shared by logins, so they reuse the same client instead of
+// building it per request. The client is rebuilt if cluster or namespace is changed by configuration or
+// TLS settings are changed since it was built. Callers must not modify the returned client.
+func (b *crossVaultAuthBackend) sharedUpstreamClient(config *crossVaultAuthBackendConfig) (*api.Client, error) {
+	b.tlsMu.RLock()
+	key := upstreamClientKey{
+		cluster:       config.Cluster,
+		namespace:     normalizeNamespace(config.Namespace),
+		tlsGeneration: b.tlsConfigGeneration,
+	}
+	b.tlsMu.RUnlock()
+
+	b.sharedClientMu.Lock()
+	defer b.sharedClientMu.Unlock()
+
+	if b.sharedClient != nil && b.sharedClientKey == key {
+		return b.sharedClient, nil
+	}
+	client, err := b.upstreamClient(config)
+	if err != nil {
+		return nil, err
+	}
+	b.sharedClient, b.sharedClientKey = client, key
+	return client, nil
+}
+
+// setUpstreamNamespace sets namespace header of the client. Root namespace is addressed without
+// the header, as its literal name would be treated as a child namespace path.
+func setUpstreamNamespace(client *api.Client, namespace string) {
+	namespace = normalizeNamespace(namespace)
+	if namespace == "" {
+		client.ClearNamespace()
+		return
+	}
+	client.SetNamespace(namespace)
 }
 
 func (b *crossVaultAuthBackend) newConfig(config *crossVaultAuthBackendConfig) *api.Config {
 	vaultClientConfig := api.DefaultConfig()
+	// HTTP client is replaced when its settings change, so it is read under the lock
+	b.tlsMu.RLock()
 	vaultClientConfig.HttpClient = b.httpClient
+	b.tlsMu.RUnlock()
 	vaultClientConfig.Address = config.Cluster
 	return vaultClientConfig
 }
 
-func (b *crossVaultAuthBackend) unwrapSecret(method, secret string) (string, error) {
-	resp, err := b.vc.Logical().UnwrapWithContext(b.ctx, secret)
+// unwrapWithRetry unwraps secret retrying transient failures up to configured number of times.
+// Client's generic retries are disabled for the unwrap call, so the single-use wrapping token is
+// never resubmitted after the upstream rejected it.
+func (b *crossVaultAuthBackend) unwrapWithRetry(
+	ctx context.Context,
+	vc *api.Client,
+	config *crossVaultAuthBackendConfig,
+	secret string,
+) (*api.Secret, error) {
+	// the client may be shared by concurrent logins, so unwrap is sent by its clone sharing the same
+	// HTTP client. Unwrap authenticates with the wrapping token if client has no token set, keeping it
+	// as client's token afterward, which must not affect subsequent requests as well
+	client, err := vc.CloneWithHeaders()
+	if err != nil {
+		return nil, err
+	}
+	client.SetToken(vc.Token())
+	client.SetMaxRetries(0)
+
+	for attempt := 0; ; attempt++ {
+		resp, err := client.Logical().UnwrapWithContext(ctx, secret)
+		if err == nil || attempt >= config.UnwrapRetries || !isTransientUpstreamError(err) {
+			return resp, err
+		}
+		b.Logger().Debug("transient unwrap failure, retrying", "attempt", attempt+1, "error", err)
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(unwrapRetryInterval):
+		}
+	}
+}
+
+// isTransientUpstreamError reports whether the request failed before the upstream could process
+// it (connectivity problems, overload). Rejections of the token itself (not found, already used,
+// permission denied) and TLS verification failures are permanent.
+func isTransientUpstreamError(err error) bool {
+	var respErr *api.ResponseError
+	if errors.As(err, &respErr) {
+		switch respErr.StatusCode {
+		case http.StatusTooManyRequests,
+			http.StatusInternalServerError,
+			http.StatusBadGateway,
+			http.StatusServiceUnavailable,
+			http.StatusGatewayTimeout:
+			return true
+		default:
+			return false
+		}
+	}
+	var urlErr *url.Error
+	return errors.As(err, &urlErr) && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) &&
+		!isTLSVerificationError(err)
+}
+
+// isTLSVerificationError reports whether the connection was rejected because a certificate was not trusted,
+// by this side or by the upstream, so repeating the request can't succeed.
+func isTLSVerificationError(err error) bool {
+	var (
+		unknownAuthorityErr x509.UnknownAuthorityError
+		invalidCertErr      x509.CertificateInvalidError
+		hostnameErr         x509.HostnameError
+		verificationErr     *tls.CertificateVerificationError
+		alertErr            tls.AlertError
+		recordHeaderErr     tls.RecordHeaderError
+	)
+	return errors.As(err, &unknownAuthorityErr) || errors.As(err, &invalidCertErr) || errors.As(err, &hostnameErr) ||
+		errors.As(err, &verificationErr) || errors.As(err, &alertErr) || errors.As(err, &recordHeaderErr) ||
+		errors.Is(err, tlsPinnedCertificateMismatch) || errors.Is(err, tlsPeerCertificateMissing)
+}
+
+func (b *crossVaultAuthBackend) unwrapSecret(
+	ctx context.Context,
+	client *api.Client,
+	config *crossVaultAuthBackendConfig,
+	method, secret string,
+) (string, error) {
+	resp, err := b.unwrapWithRetry(ctx, client, config, secret)
 	if err != nil {
 		return "", err
 	}
@@ -200,47 +919,585 @@ func (b *crossVaultAuthBackend) unwrapSecret(method, secret string) (string, err
 	}
 }
 
-func (b *crossVaultAuthBackend) validateSecret(
-	role *crossVaultAuthRoleEntry,
+// sourceToken holds the data of the source token looked up at the upstream Vault cluster.
+type sourceToken struct {
+	EntityID      string            `json:"entity_id"`
+	Meta          map[string]string `json:"meta"`
+	Type          string            `json:"type"`
+	Accessor      string            `json:"accessor"`
+	Orphan        bool              `json:"orphan"`
+	Renewable     bool              `json:"renewable"`
+	NamespacePath string            `json:"namespace_path"`
+	DisplayName   string            `json:"display_name"`
+	// Policies are explicit policies of the token, IdentityPolicies are derived from its entity and groups
+	Policies         []string `json:"policies"`
+	IdentityPolicies []string `json:"identity_policies"`
+	// Warnings are the warnings of lookup response, not the part of token data
+	Warnings []string `json:"-"`
+	// Degraded is set if the login relies on cached results because target Vault cluster is unreachable
+	Degraded bool `json:"-"`
+}
+
+func (b *crossVaultAuthBackend) lookupSecret(
+	ctx context.Context,
+	client *api.Client,
+	config *crossVaultAuthBackendConfig,
 	method, secret string,
-) (bool, error) {
-	lookupPath := tokenLookupPath
+) (*sourceToken, error) {
+	lookupPath := fmt.Sprintf(tokenLookupPath, config.tokenAuthMount())
 	lookupPayloadKey := tokenPayloadKey
 	if method == WrappedAccessorOnly {
-		lookupPath = accessorLookupPath
+		lookupPath = fmt.Sprintf(accessorLookupPath, config.tokenAuthMount())
 		lookupPayloadKey = accessorPayloadKey
 	}
-	resp, err := b.vc.Logical().WriteWithContext(b.ctx, lookupPath, map[string]interface{}{lookupPayloadKey: secret})
+	// lookup path is relative to the namespace sent in the header, so it must not be prefixed with it
+	b.Logger().Trace("looking up source token", "path", lookupPath, "namespace", client.Namespace())
+	resp, err := client.Logical().WriteWithContext(ctx, lookupPath, map[string]interface{}{lookupPayloadKey: secret})
 	if err != nil {
-		return false, err
+		return nil, err
+	}
+
+	source, err := parseSourceToken(resp.Data)
+	if err != nil {
+		return nil, err
+	}
+	source.Warnings = resp.Warnings
+	for _, warning := range resp.Warnings {
+		b.Logger().Debug("target Vault cluster returned warning on source token lookup", "path", lookupPath,
+			"warning", warning)
+	}
+	return source, nil
+}
+
+// parseSourceToken decodes data of token lookup response.
+func parseSourceToken(data map[string]interface{}) (*sourceToken, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	source := &sourceToken{}
+	if err = json.Unmarshal(raw, source); err != nil {
+		return nil, err
 	}
+	if source.Meta == nil {
+		source.Meta = make(map[string]string)
+	}
+	return source, nil
+}
 
-	entityID := resp.Data["entity_id"]
-	if entityID != role.EntityID {
-		return false, nil
+// validateSecondSecret unwraps and validates the second secret of dual secret login. It must
+// resolve to another credential of the same entity as the first one.
+func (b *crossVaultAuthBackend) validateSecondSecret(
+	ctx context.Context,
+	client *api.Client,
+	config *crossVaultAuthBackendConfig,
+	role *crossVaultAuthRoleEntry,
+	method, firstSecret, wrappedSecret string,
+	firstSource *sourceToken,
+) error {
+	secret, err := b.unwrapSecret(ctx, client, config, method, wrappedSecret)
+	if err != nil {
+		return err
+	}
+	if secret == firstSecret {
+		return fmt.Errorf("%w: both secrets wrap the same credential", roleValidationFailed)
 	}
+	source, err := b.validateSecret(ctx, client, config, role, method, secret, nil, nil)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(source.EntityID, firstSource.EntityID) {
+		return fmt.Errorf("%w: secrets belong to different entities", roleValidationFailed)
+	}
+	if source.Degraded {
+		firstSource.Degraded = true
+	}
+	return nil
+}
 
-	raw, err := json.Marshal(resp.Data["meta"])
+// verifyEntityEnabled reads the entity from target Vault cluster and returns roleValidationFailed
+// if it is disabled or missing. Missing read permission is reported as validation failure as well,
+// so the login is never granted without the entity status checked.
+func (b *crossVaultAuthBackend) verifyEntityEnabled(ctx context.Context, client *api.Client, entityID string) error {
+	entity, err := client.Logical().ReadWithContext(ctx, fmt.Sprintf(entityReadPath, entityID))
+	var respErr *api.ResponseError
+	if errors.As(err, &respErr) && respErr.StatusCode == http.StatusForbidden {
+		return fmt.Errorf("%w: entity status can't be verified, read access to %s is required",
+			roleValidationFailed, fmt.Sprintf(entityReadPath, "*"))
+	}
 	if err != nil {
-		return false, err
+		return err
+	}
+	if entity == nil {
+		return fmt.Errorf("%w: entity not found", roleValidationFailed)
 	}
-	metadata := make(map[string]string)
-	err = json.Unmarshal(raw, &metadata)
+	if disabled, _ := entity.Data["disabled"].(bool); disabled {
+		return fmt.Errorf("%w: entity is disabled", roleValidationFailed)
+	}
+	return nil
+}
+
+func (b *crossVaultAuthBackend) validateSecret(
+	ctx context.Context,
+	client *api.Client,
+	config *crossVaultAuthBackendConfig,
+	role *crossVaultAuthRoleEntry,
+	method, secret string,
+	trace validationTrace,
+	timings loginTimings,
+) (*sourceToken, error) {
+	lookupStart := time.Now()
+	source, err := b.lookupSource(ctx, client, config, method, secret)
 	if err != nil {
-		return false, err
+		return nil, err
+	}
+	timings.since("lookup", lookupStart)
+	trace.set("lookup", lookupOutcome(source))
+	if err = b.validateSource(ctx, client, config, role, source, trace, timings); err != nil {
+		return nil, err
 	}
+	return source, nil
+}
 
-	if role.StrictMetaVerify {
-		if !reflect.DeepEqual(metadata, role.EntityMeta) {
-			return false, nil
+// validateSource checks the looked up source token against role's constraints, including the ones
+// which have to be verified in target Vault cluster.
+func (b *crossVaultAuthBackend) validateSource(
+	ctx context.Context,
+	client *api.Client,
+	config *crossVaultAuthBackendConfig,
+	role *crossVaultAuthRoleEntry,
+	source *sourceToken,
+	trace validationTrace,
+	timings loginTimings,
+) error {
+	if err := matchSource(config, role, b.metaPatterns, b.displayNamePatterns, source, trace, timings); err != nil {
+		return err
+	}
+
+	if role.RejectDisabledEntity && source.EntityID != "" {
+		cacheKey := entityCacheKey(client.Namespace(), source.EntityID)
+		err := b.verifyEntityEnabled(ctx, client, source.EntityID)
+		switch {
+		case err == nil:
+			if config.FailOpenCachedLookups {
+				b.cacheEntityEnabled(cacheKey)
+			}
+			trace.set("entity_enabled", true)
+		case config.FailOpenCachedLookups && isTransientUpstreamError(err) && b.cachedEntityEnabled(cacheKey):
+			b.Logger().Warn("target Vault cluster is unreachable, cached entity status is used",
+				"entity_id", source.EntityID, "error", err)
+			source.Degraded = true
+			trace.set("entity_enabled", "cached")
+		default:
+			trace.set("entity_enabled", false)
+			return err
 		}
 	}
+	return nil
+}
+
+// lookupSource looks up the source token at target Vault cluster. If fail_open_cached_lookups is set,
+// successful lookups are cached and the cached result is returned when the lookup fails with a
+// transient error, the result is marked as degraded then.
+func (b *crossVaultAuthBackend) lookupSource(
+	ctx context.Context,
+	client *api.Client,
+	config *crossVaultAuthBackendConfig,
+	method, secret string,
+) (*sourceToken, error) {
+	source, err := b.lookupSecret(ctx, client, config, method, secret)
+	if !config.FailOpenCachedLookups {
+		return source, err
+	}
+	cacheKey := lookupCacheKey(client.Namespace(), method, secret)
+	if err == nil {
+		b.cacheLookup(cacheKey, source)
+		return source, nil
+	}
+	if !isTransientUpstreamError(err) {
+		return nil, err
+	}
+	cached, ok := b.cachedLookup(cacheKey)
+	if !ok {
+		return nil, err
+	}
+	b.Logger().Warn("target Vault cluster is unreachable, cached source token lookup result is used",
+		"method", method, "error", err)
+	cached.Degraded = true
+	return cached, nil
+}
+
+// lookupOutcome returns validation trace outcome of source token lookup.
+func lookupOutcome(source *sourceToken) string {
+	if source.Degraded {
+		return "cached"
+	}
+	return "ok"
+}
+
+// matchSource checks the looked up source token against role's constraints which don't require
+// requests to target Vault cluster.
+func matchSource(
+	config *crossVaultAuthBackendConfig,
+	role *crossVaultAuthRoleEntry,
+	patterns *metaPatternCache,
+	displayNames *metaPatternCache,
+	source *sourceToken,
+	trace validationTrace,
+	timings loginTimings,
+) error {
+	if err := matchSourceEntity(role, source, trace); err != nil {
+		return err
+	}
+	if err := matchSourceProperties(role, source, trace); err != nil {
+		return err
+	}
+	if err := matchSourcePolicies(role, source, trace); err != nil {
+		return err
+	}
+	if err := matchSourceDisplayName(role, displayNames, source, trace); err != nil {
+		return err
+	}
+	return matchSourceMetadata(config, role, patterns, source, trace, timings)
+}
+
+// matchSourceEntity checks the entity of the source token against the ones accepted by the role.
+func matchSourceEntity(role *crossVaultAuthRoleEntry, source *sourceToken, trace validationTrace) error {
+	entityless := source.EntityID == ""
+	trace.set("entity_present", !entityless)
+	if !entityless {
+		if !trace.check("entity_match", role.acceptsEntity(source.EntityID)) {
+			return roleValidationFailed
+		}
+		return nil
+	}
+	if !role.AllowEntitylessSource {
+		return fmt.Errorf("%w: source token has no associated entity", roleValidationFailed)
+	}
+	if len(role.EntityMeta) == 0 && len(role.EntityMetaAny) == 0 {
+		return fmt.Errorf("%w: source token has no associated entity and role has no metadata constraints",
+			roleValidationFailed)
+	}
+	if role.SkipMetaVerify {
+		return fmt.Errorf("%w: source token has no associated entity and role skips metadata verification",
+			roleValidationFailed)
+	}
+	return nil
+}
+
+// matchSourceProperties checks type, renewable flag and namespace of the source token.
+func matchSourceProperties(role *crossVaultAuthRoleEntry, source *sourceToken, trace validationTrace) error {
+	if len(role.AllowedSourceTokenTypes) > 0 {
+		if !trace.check("source_token_type", strutil.StrListContains(role.AllowedSourceTokenTypes, source.Type)) {
+			return fmt.Errorf("%w: source token type %q is not allowed by the role", roleValidationFailed, source.Type)
+		}
+	}
+
+	if role.RequireRenewableSource || role.RequireNonRenewableSource {
+		allowed := source.Renewable && !role.RequireNonRenewableSource || !source.Renewable && !role.RequireRenewableSource
+		if !trace.check("source_renewable", allowed) {
+			return fmt.Errorf("%w: source token renewable flag %t is not allowed by the role", roleValidationFailed,
+				source.Renewable)
+		}
+	}
+
+	if role.SourceNamespace != "" &&
+		!trace.check("source_namespace", sameNamespace(role.SourceNamespace, source.NamespacePath)) {
+		return fmt.Errorf("%w: source token namespace %q is not allowed by the role", roleValidationFailed,
+			source.NamespacePath)
+	}
+	return nil
+}
+
+// matchSourcePolicies checks that the source token has policies required by the role.
+func matchSourcePolicies(role *crossVaultAuthRoleEntry, source *sourceToken, trace validationTrace) error {
+	if len(role.RequiredSourcePolicies) == 0 {
+		return nil
+	}
+	if missing := missingSourcePolicies(role, source); !trace.check("source_policies", len(missing) == 0) {
+		return fmt.Errorf("%w: source token lacks required policies: %s", roleValidationFailed,
+			strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// matchSourceDisplayName checks the display name of the source token against role's pattern.
+func matchSourceDisplayName(
+	role *crossVaultAuthRoleEntry,
+	displayNames *metaPatternCache,
+	source *sourceToken,
+	trace validationTrace,
+) error {
+	if role.RequiredSourceDisplayName == "" {
+		return nil
+	}
+	pattern, err := displayNames.compile(role.RequiredSourceDisplayName)
+	if err != nil {
+		return err
+	}
+	if !trace.check("display_name", pattern.MatchString(source.DisplayName)) {
+		return fmt.Errorf("%w: source token display name %q does not match the role", roleValidationFailed,
+			source.DisplayName)
+	}
+	return nil
+}
+
+// matchSourceMetadata checks the metadata of the source token entity against role's constraints.
+func matchSourceMetadata(
+	config *crossVaultAuthBackendConfig,
+	role *crossVaultAuthRoleEntry,
+	patterns *metaPatternCache,
+	source *sourceToken,
+	trace validationTrace,
+	timings loginTimings,
+) error {
+	if role.SkipMetaVerify {
+		trace.set("metadata_match", "skipped")
+		return nil
+	}
+
+	defer timings.since("metadata_comparison", time.Now())
+	metadata := source.Meta
+	if config.MetaKeyStripPrefix != "" {
+		metadata = stripMetaKeyPrefix(metadata, config.MetaKeyStripPrefix)
+	}
+	if role.MetaTrimWhitespace {
+		metadata, role = trimMetaWhitespace(metadata, role)
+	}
+	if role.MetaKeysCaseInsensitive {
+		var err error
+		if metadata, role, err = lowercaseMetaKeys(metadata, role); err != nil {
+			trace.set("metadata_match", false)
+			return err
+		}
+	}
+
+	// strict role without metadata constraints accepts any metadata if configured so
+	matchAny := role.StrictMetaVerify && len(role.EntityMeta) == 0 && len(role.EntityMetaAny) == 0 &&
+		config.StrictEmptyMeta == strictEmptyMetaAny
+	if !trace.check("metadata_match", matchAny || metadataMatches(role, patterns, metadata)) {
+		return roleValidationFailed
+	}
+
+	return nil
+}
+
+// missingSourcePolicies returns role's required source policies the source token doesn't have. Policies
+// considered are defined by role's policy_source.
+func missingSourcePolicies(role *crossVaultAuthRoleEntry, source *sourceToken) []string {
+	if len(role.RequiredSourcePolicies) == 0 {
+		return nil
+	}
+	var policies []string
+	switch role.policySource() {
+	case policySourceToken:
+		policies = source.Policies
+	case policySourceIdentity:
+		policies = source.IdentityPolicies
+	default:
+		policies = append(append(policies, source.Policies...), source.IdentityPolicies...)
+	}
+
+	var missing []string
+	for _, policy := range role.RequiredSourcePolicies {
+		if !strutil.StrListContains(policies, policy) {
+			missing = append(missing, policy)
+		}
+	}
+	return missing
+}
+
+// metadataMatches reports whether upstream metadata satisfies role's metadata constraints.
+// In strict mode upstream metadata must contain every key defined by the role and, unless role
+// tolerates extra keys, must not contain other keys.
+func metadataMatches(role *crossVaultAuthRoleEntry, patterns *metaPatternCache, metadata map[string]string) bool {
+	if role.StrictMetaVerify && !role.StrictIgnoreExtra &&
+		len(metadata) != len(role.EntityMeta)+len(role.EntityMetaAny) {
+		return false
+	}
+	regexMode := role.metaMatchMode() == metaMatchModeRegex
 	for key, value := range role.EntityMeta {
-		v := metadata[key]
-		if value != v {
-			return false, nil
+		actual, ok := metadata[key]
+		if role.StrictMetaVerify && !ok {
+			return false
+		}
+		if !regexMode {
+			if actual != value {
+				return false
+			}
+			continue
+		}
+		// missing key is not matched against the pattern as an empty value
+		if !ok {
+			return false
+		}
+		// patterns are validated on role write, but base role may change later, invalid ones never match
+		pattern, err := patterns.compile(value)
+		if err != nil || !pattern.MatchString(actual) {
+			return false
 		}
 	}
+	for key, options := range role.EntityMetaAny {
+		actual, ok := metadata[key]
+		if !ok || !strutil.StrListContains(options, actual) {
+			return false
+		}
+	}
+	return true
+}
+
+// sameNamespace reports whether namespace paths point to the same namespace.
+func sameNamespace(expected, actual string) bool {
+	return normalizeNamespace(expected) == normalizeNamespace(actual)
+}
+
+// normalizeNamespace trims surrounding slashes of namespace path and maps root namespace,
+// represented either by empty path or by its name, to empty path.
+func normalizeNamespace(path string) string {
+	path = strings.Trim(path, "/")
+	if path == rootNamespace {
+		return ""
+	}
+	return path
+}
+
+// matchedMetaKeys returns sorted role's metadata keys verified during successful login.
+func matchedMetaKeys(role *crossVaultAuthRoleEntry) []string {
+	keys := make([]string, 0, len(role.EntityMeta)+len(role.EntityMetaAny))
+	for key := range role.EntityMeta {
+		keys = append(keys, key)
+	}
+	for key := range role.EntityMetaAny {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// trimMetaWhitespace returns copies of upstream metadata and the role with surrounding whitespace
+// removed from the metadata values.
+func trimMetaWhitespace(
+	metadata map[string]string,
+	role *crossVaultAuthRoleEntry,
+) (map[string]string, *crossVaultAuthRoleEntry) {
+	trim := func(values map[string]string) map[string]string {
+		result := make(map[string]string, len(values))
+		for key, value := range values {
+			result[key] = strings.TrimSpace(value)
+		}
+		return result
+	}
+
+	trimmed := *role
+	trimmed.EntityMeta = trim(role.EntityMeta)
+	trimmed.EntityMetaAny = make(map[string][]string, len(role.EntityMetaAny))
+	for key, options := range role.EntityMetaAny {
+		trimmedOptions := make([]string, 0, len(options))
+		for _, option := range options {
+			trimmedOptions = append(trimmedOptions, strings.TrimSpace(option))
+		}
+		trimmed.EntityMetaAny[key] = trimmedOptions
+	}
+	return trim(metadata), &trimmed
+}
+
+// lowercaseMetaKeys returns copies of upstream metadata and the role with metadata keys lowercased.
+// Upstream keys differing only in case can't be told apart, so they fail the validation.
+func lowercaseMetaKeys(
+	metadata map[string]string,
+	role *crossVaultAuthRoleEntry,
+) (map[string]string, *crossVaultAuthRoleEntry, error) {
+	keys := make([]string, 0, len(metadata))
+	for key := range metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	lowercased := make(map[string]string, len(metadata))
+	original := make(map[string]string, len(metadata))
+	for _, key := range keys {
+		lowercaseKey := strings.ToLower(key)
+		if other, ok := original[lowercaseKey]; ok {
+			return nil, nil, fmt.Errorf("%w: upstream metadata keys %q and %q differ only in case",
+				roleValidationFailed, other, key)
+		}
+		original[lowercaseKey] = key
+		lowercased[lowercaseKey] = metadata[key]
+	}
 
-	return true, nil
+	// role's keys differing only in case are rejected on write
+	lowercasedRole := *role
+	lowercasedRole.EntityMeta = make(map[string]string, len(role.EntityMeta))
+	for key, value := range role.EntityMeta {
+		lowercasedRole.EntityMeta[strings.ToLower(key)] = value
+	}
+	lowercasedRole.EntityMetaAny = make(map[string][]string, len(role.EntityMetaAny))
+	for key, options := range role.EntityMetaAny {
+		lowercasedRole.EntityMetaAny[strings.ToLower(key)] = options
+	}
+	return lowercased, &lowercasedRole, nil
+}
+
+// stripMetaKeyPrefix returns copy of metadata with prefix removed from the keys.
+// Keys without prefix are kept as is.
+func stripMetaKeyPrefix(metadata map[string]string, prefix string) map[string]string {
+	result := make(map[string]string, len(metadata))
+	for key, value := range metadata {
+		result[strings.TrimPrefix(key, prefix)] = value
+	}
+	return result
+}
+
+// validationTrace records outcomes of login validation stages, so clients can find out which stage
+// failed without parsing error messages. Only outcomes are recorded, never secrets or values.
+// Nil trace records nothing.
+type validationTrace map[string]interface{}
+
+// newValidationTrace returns empty trace if enabled, nil otherwise.
+func newValidationTrace(enabled bool) validationTrace {
+	if !enabled {
+		return nil
+	}
+	return validationTrace{}
+}
+
+// set records outcome of the stage.
+func (t validationTrace) set(stage string, outcome interface{}) {
+	if t != nil {
+		t[stage] = outcome
+	}
+}
+
+// check records whether the stage passed and returns it.
+func (t validationTrace) check(stage string, passed bool) bool {
+	t.set(stage, passed)
+	return passed
+}
+
+// errorResponse returns error response carrying the trace in its data, if it is recorded.
+func (t validationTrace) errorResponse(text string) *logical.Response {
+	resp := logical.ErrorResponse(text)
+	if t != nil {
+		// error responses may carry only 'data' element in addition to the error
+		resp.Data["data"] = map[string]interface{}{"trace": map[string]interface{}(t)}
+	}
+	return resp
+}
+
+// loginTimings records time spent in login steps in milliseconds, so clients can find out whether login
+// latency comes from upstream requests or local processing. Nil timings record nothing.
+type loginTimings map[string]float64
+
+// newLoginTimings returns empty timings if enabled, nil otherwise.
+func newLoginTimings(enabled bool) loginTimings {
+	if !enabled {
+		return nil
+	}
+	return loginTimings{}
+}
+
+// since records time elapsed since start of the step.
+func (t loginTimings) since(step string, start time.Time) {
+	if t != nil {
+		t[step] = float64(time.Since(start)) / float64(time.Millisecond)
+	}
 }
@@ -0,0 +1,3459 @@
+package cva
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-secure-stdlib/parseutil"
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/sdk/logical"
+	"gotest.tools/v3/assert"
+)
+
+func TestLogin_MethodPrecedence(t *testing.T) {
+	t.Parallel()
+
+	lookup := map[string]interface{}{"entity_id": testEntityID}
+
+	tests := map[string]struct {
+		precedence string
+		method     string
+		expectErr  bool
+	}{
+		"matching": {
+			precedence: methodPrecedenceRequest,
+			method:     WrappedAccessorOnly,
+		},
+		"conflicting-request-precedence": {
+			precedence: methodPrecedenceRequest,
+			method:     WrappedTokenFull,
+			expectErr:  true,
+		},
+		"conflicting-role-precedence": {
+			precedence: methodPrecedenceRole,
+			method:     WrappedTokenFull,
+		},
+		"role-default": {
+			precedence: methodPrecedenceRequest,
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			// only accessor lookup is served, so login succeeds only if role's method is used
+			upstream := newTestUpstream(t, map[string]http.HandlerFunc{
+				"/v1/sys/wrapping/unwrap":        unwrapHandler(testSourceToken),
+				"/v1/auth/token/lookup-accessor": lookupHandler(lookup),
+			})
+			b, storage := getBackend(t)
+			writeConfig(t, b, storage, map[string]interface{}{
+				"cluster":           upstream.URL,
+				"method_precedence": tCase.precedence,
+			})
+			writeRole(t, b, storage, "sample", map[string]interface{}{
+				"entity_id":       testEntityID,
+				"allowed_methods": WrappedAccessorOnly,
+			})
+
+			data := map[string]interface{}{"role": "sample", "secret": testWrappedToken}
+			if tCase.method != "" {
+				data["method"] = tCase.method
+			}
+			resp, err := doLogin(t, b, storage, data)
+			if tCase.expectErr {
+				if err == nil && !resp.IsError() {
+					t.Fatalf("expected error, but no error occurred")
+				}
+				return
+			}
+			if err != nil || resp.IsError() {
+				t.Fatalf("unexpected error: %v, %v", err, resp)
+			}
+			assert.Equal(t, resp.Auth.Metadata["role"], "sample")
+		})
+	}
+}
+
+func TestLogin_Events(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		emitEvents     bool
+		secret         string
+		roleSelection  bool
+		allowedMethods string
+		expectedEvents int
+		expectedMethod string
+		outcome        string
+	}{
+		"success": {
+			emitEvents:     true,
+			secret:         testWrappedToken,
+			expectedEvents: 1,
+			outcome:        loginOutcomeSuccess,
+		},
+		"failure": {
+			emitEvents:     true,
+			expectedEvents: 1,
+			outcome:        loginOutcomeFailure,
+		},
+		"disabled": {
+			secret: testWrappedToken,
+		},
+		"role-selection": {
+			emitEvents:     true,
+			secret:         testWrappedToken,
+			roleSelection:  true,
+			expectedEvents: 1,
+			outcome:        loginOutcomeSuccess,
+		},
+		"resolved-method": {
+			emitEvents:     true,
+			secret:         testWrappedToken,
+			allowedMethods: WrappedTokenOnly,
+			expectedEvents: 1,
+			expectedMethod: WrappedTokenOnly,
+			outcome:        loginOutcomeSuccess,
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			upstream := newTestUpstream(t, upstreamHandlers(map[string]interface{}{"entity_id": testEntityID}))
+			events := logical.NewMockEventSender()
+			b, storage := getBackendWithEvents(t, events)
+			writeConfig(t, b, storage, map[string]interface{}{
+				"cluster":              upstream.URL,
+				"emit_events":          tCase.emitEvents,
+				"allow_role_selection": tCase.roleSelection,
+			})
+			role := map[string]interface{}{"entity_id": testEntityID}
+			if tCase.allowedMethods != "" {
+				role["allowed_methods"] = tCase.allowedMethods
+			}
+			writeRole(t, b, storage, "sample", role)
+
+			login := map[string]interface{}{"role": "sample", "secret": tCase.secret}
+			if tCase.roleSelection {
+				delete(login, "role")
+			}
+			_, _ = doLogin(t, b, storage, login)
+
+			assert.Equal(t, len(events.Events), tCase.expectedEvents)
+			if tCase.expectedEvents == 0 {
+				return
+			}
+			event := events.Events[0]
+			assert.Equal(t, string(event.Type), loginEventType)
+			fields := event.Event.Metadata.AsMap()
+			assert.Equal(t, fields["role"], "sample")
+			expectedMethod := tCase.expectedMethod
+			if expectedMethod == "" {
+				expectedMethod = WrappedTokenFull
+			}
+			assert.Equal(t, fields["method"], expectedMethod)
+			assert.Equal(t, fields["outcome"], tCase.outcome)
+			for _, value := range fields {
+				assert.Assert(t, value != testWrappedToken && value != testSourceToken)
+			}
+		})
+	}
+}
+
+func TestLogin_EventsNotSupported(t *testing.T) {
+	t.Parallel()
+
+	upstream := newTestUpstream(t, upstreamHandlers(map[string]interface{}{"entity_id": testEntityID}))
+	b, storage := getBackend(t)
+	writeConfig(t, b, storage, map[string]interface{}{
+		"cluster":     upstream.URL,
+		"emit_events": true,
+	})
+	writeRole(t, b, storage, "sample", map[string]interface{}{"entity_id": testEntityID})
+
+	resp, err := doLogin(t, b, storage, map[string]interface{}{"role": "sample", "secret": testWrappedToken})
+	if err != nil || resp.IsError() {
+		t.Fatalf("unexpected error: %v, %v", err, resp)
+	}
+}
+
+func TestLogin_SourceTokenType(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		allowedTypes string
+		tokenType    string
+		expectErr    bool
+	}{
+		"any-service":          {tokenType: sourceTokenTypeService},
+		"any-batch":            {tokenType: sourceTokenTypeBatch},
+		"service-service":      {allowedTypes: "service", tokenType: sourceTokenTypeService},
+		"service-batch":        {allowedTypes: "service", tokenType: sourceTokenTypeBatch, expectErr: true},
+		"batch-batch":          {allowedTypes: "batch", tokenType: sourceTokenTypeBatch},
+		"batch-service":        {allowedTypes: "batch", tokenType: sourceTokenTypeService, expectErr: true},
+		"both-batch":           {allowedTypes: "service,batch", tokenType: sourceTokenTypeBatch},
+		"service-missing-type": {allowedTypes: "service", expectErr: true},
+		"both-service":         {allowedTypes: "service,batch", tokenType: sourceTokenTypeService},
+		"batch-missing-type":   {allowedTypes: "batch", expectErr: true},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			upstream := newTestUpstream(t, upstreamHandlers(map[string]interface{}{
+				"entity_id": testEntityID,
+				"type":      tCase.tokenType,
+			}))
+			b, storage := getBackend(t)
+			writeConfig(t, b, storage, map[string]interface{}{"cluster": upstream.URL})
+			roleData := map[string]interface{}{"entity_id": testEntityID}
+			if tCase.allowedTypes != "" {
+				roleData["allowed_source_token_types"] = tCase.allowedTypes
+			}
+			writeRole(t, b, storage, "sample", roleData)
+
+			resp, err := doLogin(t, b, storage, map[string]interface{}{"role": "sample", "secret": testWrappedToken})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tCase.expectErr {
+				if !resp.IsError() {
+					t.Fatalf("expected error, but no error occurred")
+				}
+				assert.ErrorContains(t, resp.Error(), "source token type")
+				return
+			}
+			if resp.IsError() {
+				t.Fatalf("unexpected error: %v", resp.Error())
+			}
+		})
+	}
+}
+
+func TestLogin_MetaKeyStripPrefix(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		prefix    string
+		meta      map[string]interface{}
+		expectErr bool
+	}{
+		"prefix-present": {
+			prefix: "tags/",
+			meta:   map[string]interface{}{"tags/env": "prod", "tags/team": "core"},
+		},
+		"prefix-absent": {
+			prefix: "tags/",
+			meta:   map[string]interface{}{"env": "prod"},
+		},
+		"prefix-not-configured": {
+			meta:      map[string]interface{}{"tags/env": "prod"},
+			expectErr: true,
+		},
+		"value-mismatch": {
+			prefix:    "tags/",
+			meta:      map[string]interface{}{"tags/env": "dev"},
+			expectErr: true,
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			upstream := newTestUpstream(t, upstreamHandlers(map[string]interface{}{
+				"entity_id": testEntityID,
+				"meta":      tCase.meta,
+			}))
+			b, storage := getBackend(t)
+			writeConfig(t, b, storage, map[string]interface{}{
+				"cluster":               upstream.URL,
+				"meta_key_strip_prefix": tCase.prefix,
+			})
+			writeRole(t, b, storage, "sample", map[string]interface{}{
+				"entity_id":   testEntityID,
+				"entity_meta": "env=prod",
+			})
+
+			resp, err := doLogin(t, b, storage, map[string]interface{}{"role": "sample", "secret": testWrappedToken})
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, resp.IsError(), tCase.expectErr)
+		})
+	}
+}
+
+func TestLogin_UnwrapRetry(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		retries       int
+		failures      int32
+		failureStatus int
+		expectErr     bool
+		expectedCalls int32
+	}{
+		"transient-recovered": {
+			retries:       2,
+			failures:      1,
+			failureStatus: http.StatusServiceUnavailable,
+			expectedCalls: 2,
+		},
+		"transient-exhausted": {
+			retries:       1,
+			failures:      3,
+			failureStatus: http.StatusBadGateway,
+			expectErr:     true,
+			expectedCalls: 2,
+		},
+		"permanent": {
+			retries:       2,
+			failures:      1,
+			failureStatus: http.StatusBadRequest,
+			expectErr:     true,
+			expectedCalls: 1,
+		},
+		"retries-disabled": {
+			failures:      1,
+			failureStatus: http.StatusServiceUnavailable,
+			expectErr:     true,
+			expectedCalls: 1,
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			var calls int32
+			handlers := upstreamHandlers(map[string]interface{}{"entity_id": testEntityID})
+			handlers["/v1/sys/wrapping/unwrap"] = func(w http.ResponseWriter, r *http.Request) {
+				if atomic.AddInt32(&calls, 1) <= tCase.failures {
+					jsonHandler(tCase.failureStatus, map[string]interface{}{
+						"errors": []string{"wrapping token is not valid or does not exist"},
+					})(w, r)
+					return
+				}
+				unwrapHandler(testSourceToken)(w, r)
+			}
+			upstream := newTestUpstream(t, handlers)
+			b, storage := getBackend(t)
+			writeConfig(t, b, storage, map[string]interface{}{
+				"cluster":        upstream.URL,
+				"unwrap_retries": tCase.retries,
+			})
+			writeRole(t, b, storage, "sample", map[string]interface{}{"entity_id": testEntityID})
+
+			resp, err := doLogin(t, b, storage, map[string]interface{}{"role": "sample", "secret": testWrappedToken})
+			assert.Equal(t, err != nil || resp.IsError(), tCase.expectErr)
+			assert.Equal(t, atomic.LoadInt32(&calls), tCase.expectedCalls)
+		})
+	}
+}
+
+func TestLogin_TLSPinnedSHA256(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		pin       func(fingerprint string) string
+		expectErr bool
+	}{
+		"not-pinned": {
+			pin: func(string) string { return "" },
+		},
+		"matching": {
+			pin: func(fingerprint string) string { return fingerprint },
+		},
+		"matching-colon-separated": {
+			pin: func(fingerprint string) string {
+				pairs := make([]string, 0, len(fingerprint)/2)
+				for i := 0; i < len(fingerprint); i += 2 {
+					pairs = append(pairs, strings.ToUpper(fingerprint[i:i+2]))
+				}
+				return strings.Join(pairs, ":")
+			},
+		},
+		"mismatching": {
+			pin:       func(string) string { return strings.Repeat("ab", sha256.Size) },
+			expectErr: true,
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			upstream := newTestTLSUpstream(t, upstreamHandlers(map[string]interface{}{"entity_id": testEntityID}))
+			sum := sha256.Sum256(upstream.Certificate().Raw)
+
+			b, storage := getBackend(t)
+			writeConfig(t, b, storage, map[string]interface{}{
+				"cluster":           upstream.URL,
+				"ca_cert":           certificatePEM(upstream),
+				"tls_pinned_sha256": tCase.pin(hex.EncodeToString(sum[:])),
+			})
+			writeRole(t, b, storage, "sample", map[string]interface{}{"entity_id": testEntityID})
+
+			resp, err := doLogin(t, b, storage, map[string]interface{}{"role": "sample", "secret": testWrappedToken})
+			if tCase.expectErr {
+				assert.ErrorContains(t, err, tlsPinnedCertificateMismatch.Error())
+				return
+			}
+			if err != nil || resp.IsError() {
+				t.Fatalf("unexpected error: %v, %v", err, resp)
+			}
+		})
+	}
+}
+
+func TestLogin_MirrorSourceOrphan(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		mirror         bool
+		sourceOrphan   bool
+		expectedOrphan bool
+	}{
+		"not-mirrored-orphan":     {sourceOrphan: true, expectedOrphan: true},
+		"not-mirrored-non-orphan": {sourceOrphan: false, expectedOrphan: true},
+		"mirrored-orphan":         {mirror: true, sourceOrphan: true, expectedOrphan: true},
+		"mirrored-non-orphan":     {mirror: true, sourceOrphan: false, expectedOrphan: false},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			upstream := newTestUpstream(t, upstreamHandlers(map[string]interface{}{
+				"entity_id": testEntityID,
+				"orphan":    tCase.sourceOrphan,
+			}))
+			b, storage := getBackend(t)
+			writeConfig(t, b, storage, map[string]interface{}{"cluster": upstream.URL})
+			writeRole(t, b, storage, "sample", map[string]interface{}{
+				"entity_id":            testEntityID,
+				"mirror_source_orphan": tCase.mirror,
+			})
+
+			resp, err := doLogin(t, b, storage, map[string]interface{}{"role": "sample", "secret": testWrappedToken})
+			if err != nil || resp.IsError() {
+				t.Fatalf("unexpected error: %v, %v", err, resp)
+			}
+			assert.Equal(t, resp.Auth.Orphan, tCase.expectedOrphan)
+		})
+	}
+}
+
+func TestLogin_SourceRenewable(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		renewable    bool
+		role         map[string]interface{}
+		expectReject bool
+	}{
+		"no-requirement-renewable":     {renewable: true},
+		"no-requirement-non-renewable": {renewable: false},
+		"renewable-required": {
+			renewable: true,
+			role:      map[string]interface{}{"require_renewable_source": true},
+		},
+		"renewable-required-non-renewable": {
+			renewable:    false,
+			role:         map[string]interface{}{"require_renewable_source": true},
+			expectReject: true,
+		},
+		"non-renewable-required": {
+			renewable: false,
+			role:      map[string]interface{}{"require_non_renewable_source": true},
+		},
+		"non-renewable-required-renewable": {
+			renewable:    true,
+			role:         map[string]interface{}{"require_non_renewable_source": true},
+			expectReject: true,
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			upstream := newTestUpstream(t, upstreamHandlers(map[string]interface{}{
+				"entity_id": testEntityID,
+				"renewable": tCase.renewable,
+			}))
+			b, storage := getBackend(t)
+			writeConfig(t, b, storage, map[string]interface{}{"cluster": upstream.URL})
+			role := map[string]interface{}{"entity_id": testEntityID}
+			maps.Copy(role, tCase.role)
+			writeRole(t, b, storage, "sample", role)
+
+			resp, err := doLogin(t, b, storage, map[string]interface{}{"role": "sample", "secret": testWrappedToken})
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, resp.IsError(), tCase.expectReject)
+		})
+	}
+}
+
+func TestLogin_EntityMetaAny(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		meta      map[string]interface{}
+		strict    bool
+		expectErr bool
+	}{
+		"first-option": {
+			meta: map[string]interface{}{"env": "prod", "team": "core"},
+		},
+		"second-option": {
+			meta: map[string]interface{}{"env": "staging", "team": "core"},
+		},
+		"no-option-matches": {
+			meta:      map[string]interface{}{"env": "dev", "team": "core"},
+			expectErr: true,
+		},
+		"key-missing": {
+			meta:      map[string]interface{}{"team": "core"},
+			expectErr: true,
+		},
+		"strict-exact-keys": {
+			meta:   map[string]interface{}{"env": "staging", "team": "core"},
+			strict: true,
+		},
+		"strict-extra-key": {
+			meta:      map[string]interface{}{"env": "staging", "team": "core", "region": "eu"},
+			strict:    true,
+			expectErr: true,
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			upstream := newTestUpstream(t, upstreamHandlers(map[string]interface{}{
+				"entity_id": testEntityID,
+				"meta":      tCase.meta,
+			}))
+			b, storage := getBackend(t)
+			writeConfig(t, b, storage, map[string]interface{}{"cluster": upstream.URL})
+			writeRole(t, b, storage, "sample", map[string]interface{}{
+				"entity_id":          testEntityID,
+				"entity_meta":        "team=core",
+				"entity_meta_any":    "env=prod|staging",
+				"strict_meta_verify": tCase.strict,
+			})
+
+			resp, err := doLogin(t, b, storage, map[string]interface{}{"role": "sample", "secret": testWrappedToken})
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, resp.IsError(), tCase.expectErr)
+		})
+	}
+}
+
+func TestLogin_AllowedPolicies(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		action           string
+		expectErr        bool
+		expectedPolicies []string
+	}{
+		"reject": {
+			action:    "reject",
+			expectErr: true,
+		},
+		"filter": {
+			action:           "filter",
+			expectedPolicies: []string{"reader"},
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			upstream := newTestUpstream(t, upstreamHandlers(map[string]interface{}{"entity_id": testEntityID}))
+			b, storage := getBackend(t)
+			writeConfig(t, b, storage, map[string]interface{}{"cluster": upstream.URL})
+			writeRole(t, b, storage, "sample", map[string]interface{}{
+				"entity_id":      testEntityID,
+				"token_policies": "reader,admin",
+			})
+			// allow-list is set after the role was created
+			writeConfig(t, b, storage, map[string]interface{}{
+				"cluster":                    upstream.URL,
+				"allowed_policies":           "reader",
+				"disallowed_policies_action": tCase.action,
+			})
+
+			resp, err := doLogin(t, b, storage, map[string]interface{}{"role": "sample", "secret": testWrappedToken})
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, resp.IsError(), tCase.expectErr)
+			if !tCase.expectErr {
+				assert.DeepEqual(t, resp.Auth.Policies, tCase.expectedPolicies)
+			}
+		})
+	}
+}
+
+func TestLogin_MethodAutodetect(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		autodetect bool
+		secret     string
+		method     string
+		expectErr  bool
+	}{
+		"hvs-prefix": {
+			autodetect: true,
+			secret:     "hvs.wrapped",
+		},
+		"legacy-prefix": {
+			autodetect: true,
+			secret:     "s.wrapped",
+		},
+		"ambiguous": {
+			autodetect: true,
+			secret:     "wrapped",
+			expectErr:  true,
+		},
+		"ambiguous-method-requested": {
+			autodetect: true,
+			secret:     "wrapped",
+			method:     WrappedTokenFull,
+		},
+		"disabled-ambiguous": {
+			secret: "wrapped",
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			upstream := newTestUpstream(t, upstreamHandlers(map[string]interface{}{"entity_id": testEntityID}))
+			b, storage := getBackend(t)
+			writeConfig(t, b, storage, map[string]interface{}{
+				"cluster":           upstream.URL,
+				"method_autodetect": tCase.autodetect,
+			})
+			writeRole(t, b, storage, "sample", map[string]interface{}{"entity_id": testEntityID})
+
+			data := map[string]interface{}{"role": "sample", "secret": tCase.secret}
+			if tCase.method != "" {
+				data["method"] = tCase.method
+			}
+			resp, err := doLogin(t, b, storage, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, resp.IsError(), tCase.expectErr)
+		})
+	}
+}
+
+func TestLogin_StrictEmptyMeta(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		mode      string
+		meta      map[string]interface{}
+		expectErr bool
+	}{
+		"empty-upstream-with-meta": {
+			mode:      strictEmptyMetaEmpty,
+			meta:      map[string]interface{}{"env": "prod"},
+			expectErr: true,
+		},
+		"empty-upstream-without-meta": {
+			mode: strictEmptyMetaEmpty,
+			meta: map[string]interface{}{},
+		},
+		"any-upstream-with-meta": {
+			mode: strictEmptyMetaAny,
+			meta: map[string]interface{}{"env": "prod"},
+		},
+		"any-upstream-without-meta": {
+			mode: strictEmptyMetaAny,
+			meta: map[string]interface{}{},
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			upstream := newTestUpstream(t, upstreamHandlers(map[string]interface{}{
+				"entity_id": testEntityID,
+				"meta":      tCase.meta,
+			}))
+			b, storage := getBackend(t)
+			writeConfig(t, b, storage, map[string]interface{}{
+				"cluster":           upstream.URL,
+				"strict_empty_meta": tCase.mode,
+			})
+			writeRole(t, b, storage, "sample", map[string]interface{}{
+				"entity_id":          testEntityID,
+				"strict_meta_verify": true,
+			})
+
+			resp, err := doLogin(t, b, storage, map[string]interface{}{"role": "sample", "secret": testWrappedToken})
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, resp.IsError(), tCase.expectErr)
+		})
+	}
+}
+
+func TestLogin_StrictIgnoreExtra(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		strict      bool
+		ignoreExtra bool
+		meta        map[string]interface{}
+		expectErr   bool
+	}{
+		"strict-exact": {
+			strict: true,
+			meta:   map[string]interface{}{"env": "prod"},
+		},
+		"strict-extra": {
+			strict:    true,
+			meta:      map[string]interface{}{"env": "prod", "team": "core"},
+			expectErr: true,
+		},
+		"strict-ignore-extra-exact": {
+			strict:      true,
+			ignoreExtra: true,
+			meta:        map[string]interface{}{"env": "prod"},
+		},
+		"strict-ignore-extra-extra": {
+			strict:      true,
+			ignoreExtra: true,
+			meta:        map[string]interface{}{"env": "prod", "team": "core"},
+		},
+		"strict-ignore-extra-mismatch": {
+			strict:      true,
+			ignoreExtra: true,
+			meta:        map[string]interface{}{"env": "dev", "team": "core"},
+			expectErr:   true,
+		},
+		"strict-ignore-extra-missing": {
+			strict:      true,
+			ignoreExtra: true,
+			meta:        map[string]interface{}{"team": "core"},
+			expectErr:   true,
+		},
+		"non-strict-extra": {
+			meta: map[string]interface{}{"env": "prod", "team": "core"},
+		},
+		"non-strict-ignore-extra-extra": {
+			ignoreExtra: true,
+			meta:        map[string]interface{}{"env": "prod", "team": "core"},
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			upstream := newTestUpstream(t, upstreamHandlers(map[string]interface{}{
+				"entity_id": testEntityID,
+				"meta":      tCase.meta,
+			}))
+			b, storage := getBackend(t)
+			writeConfig(t, b, storage, map[string]interface{}{"cluster": upstream.URL})
+			writeRole(t, b, storage, "sample", map[string]interface{}{
+				"entity_id":           testEntityID,
+				"entity_meta":         "env=prod",
+				"strict_meta_verify":  tCase.strict,
+				"strict_ignore_extra": tCase.ignoreExtra,
+			})
+
+			resp, err := doLogin(t, b, storage, map[string]interface{}{"role": "sample", "secret": testWrappedToken})
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, resp.IsError(), tCase.expectErr)
+		})
+	}
+}
+
+func TestLogin_SourceNamespace(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		roleNamespace string
+		namespacePath string
+		expectErr     bool
+	}{
+		"not-restricted": {
+			namespacePath: "team-a/",
+		},
+		"matching": {
+			roleNamespace: "team-a",
+			namespacePath: "team-a/",
+		},
+		"nested-matching": {
+			roleNamespace: "/org/team-a/",
+			namespacePath: "org/team-a/",
+		},
+		"other-namespace": {
+			roleNamespace: "team-a",
+			namespacePath: "team-b/",
+			expectErr:     true,
+		},
+		"root-matching": {
+			roleNamespace: "root",
+			namespacePath: "",
+		},
+		"root-expected-child-presented": {
+			roleNamespace: "root",
+			namespacePath: "team-a/",
+			expectErr:     true,
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			upstream := newTestUpstream(t, upstreamHandlers(map[string]interface{}{
+				"entity_id":      testEntityID,
+				"namespace_path": tCase.namespacePath,
+			}))
+			b, storage := getBackend(t)
+			writeConfig(t, b, storage, map[string]interface{}{"cluster": upstream.URL})
+			writeRole(t, b, storage, "sample", map[string]interface{}{
+				"entity_id":        testEntityID,
+				"source_namespace": tCase.roleNamespace,
+			})
+
+			resp, err := doLogin(t, b, storage, map[string]interface{}{"role": "sample", "secret": testWrappedToken})
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, resp.IsError(), tCase.expectErr)
+		})
+	}
+}
+
+func TestLogin_RequestedTTL(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		roleMaxTTL  string
+		ttl         string
+		expectErr   bool
+		expectedTTL time.Duration
+	}{
+		"not-requested": {
+			roleMaxTTL:  "2h",
+			expectedTTL: time.Hour,
+		},
+		"within-role-max": {
+			roleMaxTTL:  "2h",
+			ttl:         "10m",
+			expectedTTL: time.Minute * 10,
+		},
+		"equal-to-role-max": {
+			roleMaxTTL:  "2h",
+			ttl:         "2h",
+			expectedTTL: time.Hour * 2,
+		},
+		"exceeds-role-max": {
+			roleMaxTTL: "2h",
+			ttl:        "3h",
+			expectErr:  true,
+		},
+		"exceeds-system-max": {
+			ttl:       "25h",
+			expectErr: true,
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			upstream := newTestUpstream(t, upstreamHandlers(map[string]interface{}{"entity_id": testEntityID}))
+			b, storage := getBackend(t)
+			writeConfig(t, b, storage, map[string]interface{}{"cluster": upstream.URL})
+			role := map[string]interface{}{"entity_id": testEntityID, "token_ttl": "1h"}
+			if tCase.roleMaxTTL != "" {
+				role["token_max_ttl"] = tCase.roleMaxTTL
+			}
+			writeRole(t, b, storage, "sample", role)
+
+			data := map[string]interface{}{"role": "sample", "secret": testWrappedToken}
+			if tCase.ttl != "" {
+				data["ttl"] = tCase.ttl
+			}
+			resp, err := doLogin(t, b, storage, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, resp.IsError(), tCase.expectErr)
+			if !tCase.expectErr {
+				assert.Equal(t, resp.Auth.TTL, tCase.expectedTTL)
+			}
+		})
+	}
+}
+
+func TestLogin_RejectDisabledEntity(t *testing.T) {
+	t.Parallel()
+
+	entityPath := "/v1/identity/entity/id/" + testEntityID
+	tests := map[string]struct {
+		reject    bool
+		entity    http.HandlerFunc
+		expectErr bool
+	}{
+		"enabled": {
+			reject: true,
+			entity: lookupHandler(map[string]interface{}{"id": testEntityID, "disabled": false}),
+		},
+		"disabled": {
+			reject:    true,
+			entity:    lookupHandler(map[string]interface{}{"id": testEntityID, "disabled": true}),
+			expectErr: true,
+		},
+		"disabled-not-checked": {
+			entity: lookupHandler(map[string]interface{}{"id": testEntityID, "disabled": true}),
+		},
+		"not-found": {
+			reject:    true,
+			entity:    jsonHandler(http.StatusNotFound, map[string]interface{}{"errors": []string{}}),
+			expectErr: true,
+		},
+		"permission-denied": {
+			reject:    true,
+			entity:    jsonHandler(http.StatusForbidden, map[string]interface{}{"errors": []string{"permission denied"}}),
+			expectErr: true,
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			handlers := upstreamHandlers(map[string]interface{}{"entity_id": testEntityID})
+			handlers[entityPath] = tCase.entity
+			upstream := newTestUpstream(t, handlers)
+			b, storage := getBackend(t)
+			writeConfig(t, b, storage, map[string]interface{}{"cluster": upstream.URL})
+			writeRole(t, b, storage, "sample", map[string]interface{}{
+				"entity_id":              testEntityID,
+				"reject_disabled_entity": tCase.reject,
+			})
+
+			resp, err := doLogin(t, b, storage, map[string]interface{}{"role": "sample", "secret": testWrappedToken})
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, resp.IsError(), tCase.expectErr)
+		})
+	}
+}
+
+func TestLogin_DebugMatchedMetaKeys(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		debug bool
+	}{
+		"enabled":  {debug: true},
+		"disabled": {debug: false},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			upstream := newTestUpstream(t, upstreamHandlers(map[string]interface{}{
+				"entity_id": testEntityID,
+				"meta":      map[string]interface{}{"team": "core", "env": "prod", "region": "eu"},
+			}))
+			b, storage := getBackend(t)
+			writeConfig(t, b, storage, map[string]interface{}{
+				"cluster":     upstream.URL,
+				"debug_login": tCase.debug,
+			})
+			writeRole(t, b, storage, "sample", map[string]interface{}{
+				"entity_id":       testEntityID,
+				"entity_meta":     "team=core",
+				"entity_meta_any": "env=prod|staging",
+			})
+
+			resp, err := doLogin(t, b, storage, map[string]interface{}{"role": "sample", "secret": testWrappedToken})
+			if err != nil || resp.IsError() {
+				t.Fatalf("unexpected error: %v, %v", err, resp)
+			}
+			if !tCase.debug {
+				assert.Assert(t, resp.Data == nil)
+				return
+			}
+			// timings vary between runs, they are verified by TestLogin_DebugTimings
+			debug, _ := resp.Data["debug"].(map[string]interface{})
+			delete(debug, "timings_ms")
+			assert.DeepEqual(t, resp.Data, map[string]interface{}{
+				"debug": map[string]interface{}{
+					"matched_meta_keys": []string{"env", "team"},
+					"trace": map[string]interface{}{
+						"unwrap":         "ok",
+						"lookup":         "ok",
+						"entity_present": true,
+						"entity_match":   true,
+						"metadata_match": true,
+					},
+				},
+			})
+		})
+	}
+}
+
+func TestLogin_DebugTimings(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		debug         bool
+		config        map[string]interface{}
+		login         map[string]interface{}
+		expectedSteps []string
+	}{
+		"enabled": {
+			debug:         true,
+			login:         map[string]interface{}{"role": "sample", "secret": testWrappedToken},
+			expectedSteps: []string{"lookup", "metadata_comparison", "unwrap"},
+		},
+		"role-selection": {
+			debug:         true,
+			config:        map[string]interface{}{"allow_role_selection": true},
+			login:         map[string]interface{}{"secret": testWrappedToken},
+			expectedSteps: []string{"lookup", "metadata_comparison", "unwrap"},
+		},
+		"disabled": {
+			login: map[string]interface{}{"role": "sample", "secret": testWrappedToken},
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			upstream := newTestUpstream(t, upstreamHandlers(map[string]interface{}{
+				"entity_id": testEntityID,
+				"meta":      map[string]interface{}{"team": "core"},
+			}))
+			b, storage := getBackend(t)
+			config := map[string]interface{}{"cluster": upstream.URL, "debug_login": tCase.debug}
+			maps.Copy(config, tCase.config)
+			writeConfig(t, b, storage, config)
+			writeRole(t, b, storage, "sample", map[string]interface{}{"entity_id": testEntityID, "entity_meta": "team=core"})
+
+			resp, err := doLogin(t, b, storage, tCase.login)
+			if err != nil || resp.IsError() {
+				t.Fatalf("unexpected error: %v, %v", err, resp)
+			}
+			if !tCase.debug {
+				assert.Assert(t, resp.Data == nil)
+				return
+			}
+			debug, _ := resp.Data["debug"].(map[string]interface{})
+			timings, ok := debug["timings_ms"].(map[string]float64)
+			assert.Assert(t, ok)
+			steps := make([]string, 0, len(timings))
+			for step, elapsed := range timings {
+				assert.Assert(t, elapsed >= 0)
+				steps = append(steps, step)
+			}
+			sort.Strings(steps)
+			assert.DeepEqual(t, steps, tCase.expectedSteps)
+		})
+	}
+}
+
+func TestLogin_Namespace(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		configNamespace   string
+		roleNamespace     string
+		expectedNamespace string
+	}{
+		"config-namespace": {
+			configNamespace:   "team-a",
+			expectedNamespace: "team-a",
+		},
+		"role-namespace": {
+			configNamespace:   "team-a",
+			roleNamespace:     "team-b",
+			expectedNamespace: "team-b",
+		},
+		"config-root": {
+			configNamespace: "root",
+		},
+		"config-empty": {
+			configNamespace: "",
+		},
+		"config-nested": {
+			configNamespace:   "/team-a/nested/",
+			expectedNamespace: "team-a/nested",
+		},
+		"role-root": {
+			configNamespace: "team-a",
+			roleNamespace:   "root",
+		},
+		"role-nested": {
+			configNamespace:   "root",
+			roleNamespace:     "team-a/nested",
+			expectedNamespace: "team-a/nested",
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			// wrapping token and source token live in the namespace, requests to other ones are rejected,
+			// root namespace is addressed without the header
+			inNamespace := func(next http.HandlerFunc) http.HandlerFunc {
+				return func(w http.ResponseWriter, r *http.Request) {
+					values := r.Header.Values("X-Vault-Namespace")
+					if (tCase.expectedNamespace == "" && len(values) > 0) ||
+						(tCase.expectedNamespace != "" && (len(values) != 1 || values[0] != tCase.expectedNamespace)) {
+						jsonHandler(http.StatusBadRequest, map[string]interface{}{
+							"errors": []string{"wrapping token is not valid or does not exist"},
+						})(w, r)
+						return
+					}
+					next(w, r)
+				}
+			}
+			lookup := lookupHandler(map[string]interface{}{"entity_id": testEntityID})
+			upstream := newTestUpstream(t, map[string]http.HandlerFunc{
+				"/v1/sys/wrapping/unwrap": inNamespace(unwrapHandler(testSourceToken)),
+				"/v1/auth/token/lookup":   inNamespace(lookup),
+			})
+			b, storage := getBackend(t)
+			writeConfig(t, b, storage, map[string]interface{}{
+				"cluster":   upstream.URL,
+				"namespace": tCase.configNamespace,
+			})
+			writeRole(t, b, storage, "sample", map[string]interface{}{
+				"entity_id": testEntityID,
+				"namespace": tCase.roleNamespace,
+			})
+
+			resp, err := doLogin(t, b, storage, map[string]interface{}{"role": "sample", "secret": testWrappedToken})
+			if err != nil || resp.IsError() {
+				t.Fatalf("unexpected error: %v, %v", err, resp)
+			}
+		})
+	}
+}
+
+func TestLogin_MetaTrimWhitespace(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		trim      bool
+		roleMeta  map[string]interface{}
+		meta      map[string]interface{}
+		expectErr bool
+	}{
+		"upstream-trailing-space": {
+			trim:     true,
+			roleMeta: map[string]interface{}{"env": "prod"},
+			meta:     map[string]interface{}{"env": "prod "},
+		},
+		"role-surrounding-space": {
+			trim:     true,
+			roleMeta: map[string]interface{}{"env": " prod\t"},
+			meta:     map[string]interface{}{"env": "prod"},
+		},
+		"upstream-trailing-space-not-trimmed": {
+			roleMeta:  map[string]interface{}{"env": "prod"},
+			meta:      map[string]interface{}{"env": "prod "},
+			expectErr: true,
+		},
+		"different-values-trimmed": {
+			trim:      true,
+			roleMeta:  map[string]interface{}{"env": "prod"},
+			meta:      map[string]interface{}{"env": " dev "},
+			expectErr: true,
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			upstream := newTestUpstream(t, upstreamHandlers(map[string]interface{}{
+				"entity_id": testEntityID,
+				"meta":      tCase.meta,
+			}))
+			b, storage := getBackend(t)
+			writeConfig(t, b, storage, map[string]interface{}{"cluster": upstream.URL})
+			writeRole(t, b, storage, "sample", map[string]interface{}{
+				"entity_id":            testEntityID,
+				"entity_meta":          tCase.roleMeta,
+				"meta_trim_whitespace": tCase.trim,
+			})
+
+			resp, err := doLogin(t, b, storage, map[string]interface{}{"role": "sample", "secret": testWrappedToken})
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, resp.IsError(), tCase.expectErr)
+		})
+	}
+}
+
+func TestLogin_MetaKeysCaseInsensitive(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		insensitive bool
+		strict      bool
+		roleMeta    map[string]interface{}
+		roleMetaAny string
+		meta        map[string]interface{}
+		expectErr   bool
+	}{
+		"same-case": {
+			roleMeta: map[string]interface{}{"env": "prod"},
+			meta:     map[string]interface{}{"env": "prod"},
+		},
+		"differ-in-case-sensitive": {
+			roleMeta:  map[string]interface{}{"env": "prod"},
+			meta:      map[string]interface{}{"Env": "prod"},
+			expectErr: true,
+		},
+		"differ-in-case-insensitive": {
+			insensitive: true,
+			roleMeta:    map[string]interface{}{"env": "prod"},
+			meta:        map[string]interface{}{"Env": "prod"},
+		},
+		"any-differ-in-case-insensitive": {
+			insensitive: true,
+			roleMetaAny: "TEAM=core|platform",
+			meta:        map[string]interface{}{"team": "core"},
+		},
+		"strict-differ-in-case-insensitive": {
+			insensitive: true,
+			strict:      true,
+			roleMeta:    map[string]interface{}{"Env": "prod"},
+			meta:        map[string]interface{}{"ENV": "prod"},
+		},
+		"values-stay-case-sensitive": {
+			insensitive: true,
+			roleMeta:    map[string]interface{}{"env": "prod"},
+			meta:        map[string]interface{}{"Env": "Prod"},
+			expectErr:   true,
+		},
+		"ambiguous-upstream-keys": {
+			insensitive: true,
+			roleMeta:    map[string]interface{}{"env": "prod"},
+			meta:        map[string]interface{}{"env": "prod", "ENV": "dev"},
+			expectErr:   true,
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			upstream := newTestUpstream(t, upstreamHandlers(map[string]interface{}{
+				"entity_id": testEntityID,
+				"meta":      tCase.meta,
+			}))
+			b, storage := getBackend(t)
+			writeConfig(t, b, storage, map[string]interface{}{"cluster": upstream.URL})
+			role := map[string]interface{}{
+				"entity_id":                  testEntityID,
+				"strict_meta_verify":         tCase.strict,
+				"meta_keys_case_insensitive": tCase.insensitive,
+			}
+			if tCase.roleMeta != nil {
+				role["entity_meta"] = tCase.roleMeta
+			}
+			if tCase.roleMetaAny != "" {
+				role["entity_meta_any"] = tCase.roleMetaAny
+			}
+			writeRole(t, b, storage, "sample", role)
+
+			resp, err := doLogin(t, b, storage, map[string]interface{}{"role": "sample", "secret": testWrappedToken})
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, resp.IsError(), tCase.expectErr)
+		})
+	}
+}
+
+func TestLogin_MetaMatchMode(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		mode      string
+		roleMeta  map[string]interface{}
+		meta      map[string]interface{}
+		expectErr bool
+	}{
+		"regex-matches": {
+			mode:     metaMatchModeRegex,
+			roleMeta: map[string]interface{}{"app": `payments-v1\.\d+\.\d+`, "env": "prod|stage"},
+			meta:     map[string]interface{}{"app": "payments-v1.4.2", "env": "stage"},
+		},
+		"regex-doesnt-match": {
+			mode:      metaMatchModeRegex,
+			roleMeta:  map[string]interface{}{"app": `payments-v1\.\d+\.\d+`},
+			meta:      map[string]interface{}{"app": "payments-v2.0.1"},
+			expectErr: true,
+		},
+		"regex-matches-whole-value": {
+			mode:      metaMatchModeRegex,
+			roleMeta:  map[string]interface{}{"env": "prod"},
+			meta:      map[string]interface{}{"env": "nonprod"},
+			expectErr: true,
+		},
+		"regex-missing-key": {
+			mode:      metaMatchModeRegex,
+			roleMeta:  map[string]interface{}{"env": ".*"},
+			meta:      map[string]interface{}{"app": "payments"},
+			expectErr: true,
+		},
+		"exact-pattern-is-literal": {
+			roleMeta:  map[string]interface{}{"app": `payments-v1\.\d+\.\d+`},
+			meta:      map[string]interface{}{"app": "payments-v1.4.2"},
+			expectErr: true,
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			upstream := newTestUpstream(t, upstreamHandlers(map[string]interface{}{
+				"entity_id": testEntityID,
+				"meta":      tCase.meta,
+			}))
+			b, storage := getBackend(t)
+			writeConfig(t, b, storage, map[string]interface{}{"cluster": upstream.URL})
+			role := map[string]interface{}{
+				"entity_id":   testEntityID,
+				"entity_meta": tCase.roleMeta,
+			}
+			if tCase.mode != "" {
+				role["meta_match_mode"] = tCase.mode
+			}
+			writeRole(t, b, storage, "sample", role)
+			if tCase.mode == metaMatchModeRegex {
+				// patterns are compiled on role write, logins use the cached ones
+				patterns := b.(*crossVaultAuthBackend).metaPatterns.patterns
+				for _, value := range tCase.roleMeta {
+					pattern, _ := value.(string)
+					_, ok := patterns[pattern]
+					assert.Assert(t, ok)
+				}
+			}
+
+			resp, err := doLogin(t, b, storage, map[string]interface{}{"role": "sample", "secret": testWrappedToken})
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, resp.IsError(), tCase.expectErr)
+		})
+	}
+}
+
+func TestLogin_DisabledRole(t *testing.T) {
+	t.Parallel()
+
+	var requests atomic.Int32
+	handlers := upstreamHandlers(map[string]interface{}{"entity_id": testEntityID})
+	for path, handler := range handlers {
+		next := handler
+		handlers[path] = func(w http.ResponseWriter, r *http.Request) {
+			requests.Add(1)
+			next(w, r)
+		}
+	}
+	upstream := newTestUpstream(t, handlers)
+	b, storage := getBackend(t)
+	writeConfig(t, b, storage, map[string]interface{}{"cluster": upstream.URL})
+	writeRole(t, b, storage, "sample", map[string]interface{}{"entity_id": testEntityID, "disabled": true})
+
+	resp, err := doLogin(t, b, storage, map[string]interface{}{"role": "sample", "secret": testWrappedToken})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Assert(t, resp.IsError())
+	assert.ErrorContains(t, resp.Error(), "role is disabled")
+	// the wrapping token is not consumed, so it can be used once the role is enabled
+	assert.Equal(t, requests.Load(), int32(0))
+
+	writeRole(t, b, storage, "sample", map[string]interface{}{"entity_id": testEntityID, "disabled": false})
+	resp, err = doLogin(t, b, storage, map[string]interface{}{"role": "sample", "secret": testWrappedToken})
+	if err != nil || resp.IsError() {
+		t.Fatalf("unexpected error: %v, %v", err, resp)
+	}
+	assert.Assert(t, requests.Load() > 0)
+}
+
+func TestLogin_PoliciesTemplate(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		config       map[string]interface{}
+		insensitive  bool
+		templates    []string
+		meta         map[string]interface{}
+		wantPolicies []string
+		expectErr    bool
+	}{
+		"rendered": {
+			templates:    []string{"app-{{identity.entity.metadata.env}}"},
+			meta:         map[string]interface{}{"env": "prod"},
+			wantPolicies: []string{"default", "app-prod"},
+		},
+		"whitespace-in-placeholder": {
+			templates:    []string{"{{ identity.entity.metadata.team }}-ro"},
+			meta:         map[string]interface{}{"team": "core"},
+			wantPolicies: []string{"default", "core-ro"},
+		},
+		"duplicate-policy": {
+			templates:    []string{"{{identity.entity.metadata.policy}}"},
+			meta:         map[string]interface{}{"policy": "default"},
+			wantPolicies: []string{"default"},
+		},
+		"strip-prefix": {
+			config:       map[string]interface{}{"meta_key_strip_prefix": "upstream_"},
+			templates:    []string{"app-{{identity.entity.metadata.env}}"},
+			meta:         map[string]interface{}{"upstream_env": "prod"},
+			wantPolicies: []string{"default", "app-prod"},
+		},
+		"case-insensitive": {
+			insensitive:  true,
+			templates:    []string{"app-{{identity.entity.metadata.env}}"},
+			meta:         map[string]interface{}{"ENV": "prod"},
+			wantPolicies: []string{"default", "app-prod"},
+		},
+		"missing-key": {
+			templates: []string{"app-{{identity.entity.metadata.env}}"},
+			meta:      map[string]interface{}{"team": "core"},
+			expectErr: true,
+		},
+		"empty-value": {
+			templates: []string{"app-{{identity.entity.metadata.env}}"},
+			meta:      map[string]interface{}{"env": ""},
+			expectErr: true,
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			upstream := newTestUpstream(t, upstreamHandlers(map[string]interface{}{
+				"entity_id": testEntityID,
+				"meta":      tCase.meta,
+			}))
+			b, storage := getBackend(t)
+			config := map[string]interface{}{"cluster": upstream.URL}
+			for k, v := range tCase.config {
+				config[k] = v
+			}
+			writeConfig(t, b, storage, config)
+			writeRole(t, b, storage, "sample", map[string]interface{}{
+				"entity_id":                  testEntityID,
+				"token_policies":             "default",
+				"token_policies_template":    tCase.templates,
+				"meta_keys_case_insensitive": tCase.insensitive,
+			})
+
+			resp, err := doLogin(t, b, storage, map[string]interface{}{"role": "sample", "secret": testWrappedToken})
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, resp.IsError(), tCase.expectErr)
+			if tCase.expectErr {
+				assert.ErrorContains(t, resp.Error(), "token_policies_template")
+				return
+			}
+			assert.DeepEqual(t, resp.Auth.Policies, tCase.wantPolicies)
+		})
+	}
+}
+
+func TestLogin_ConcurrentLogins(t *testing.T) {
+	t.Parallel()
+
+	// each namespace has its own entity, so a login using the client or namespace of
+	// a concurrent login is rejected
+	entities := map[string]string{
+		"":       testEntityID,
+		"team-a": "aaaabbbb-cccc-dddd-eeee-ffff00001111",
+		"team-b": "aaaabbbb-cccc-dddd-eeee-ffff00002222",
+	}
+	upstream := newTestUpstream(t, map[string]http.HandlerFunc{
+		"/v1/sys/wrapping/unwrap": unwrapHandler(testSourceToken),
+		"/v1/auth/token/lookup": func(w http.ResponseWriter, r *http.Request) {
+			lookupHandler(map[string]interface{}{
+				"entity_id": entities[r.Header.Get("X-Vault-Namespace")],
+			})(w, r)
+		},
+	})
+	b, storage := getBackend(t)
+	writeConfig(t, b, storage, map[string]interface{}{"cluster": upstream.URL})
+	roles := map[string]string{"root": "", "team-a": "team-a", "team-b": "team-b"}
+	for name, namespace := range roles {
+		writeRole(t, b, storage, name, map[string]interface{}{
+			"entity_id": entities[namespace],
+			"namespace": namespace,
+		})
+	}
+
+	const loginsPerRole = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, loginsPerRole*len(roles))
+	for name := range roles {
+		for i := 0; i < loginsPerRole; i++ {
+			wg.Add(1)
+			go func(name string) {
+				defer wg.Done()
+				resp, err := doLogin(t, b, storage, map[string]interface{}{"role": name, "secret": testWrappedToken})
+				if err == nil && resp.IsError() {
+					err = resp.Error()
+				}
+				if err != nil {
+					errs <- fmt.Errorf("role %s: %w", name, err)
+				}
+			}(name)
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestLogin_SharedUpstreamClient(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		roleNamespace string
+		config        map[string]interface{}
+		otherCluster  bool
+		expectRebuilt bool
+	}{
+		"unchanged-config": {},
+		"role-namespace": {
+			roleNamespace: "team-b",
+		},
+		"other-cluster": {
+			otherCluster:  true,
+			expectRebuilt: true,
+		},
+		"other-namespace": {
+			config:        map[string]interface{}{"namespace": "team-a"},
+			expectRebuilt: true,
+		},
+		"tls-changed": {
+			config:        map[string]interface{}{"ca_cert": selfSignedCertificatePEM(t)},
+			expectRebuilt: true,
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			handlers := upstreamHandlers(map[string]interface{}{"entity_id": testEntityID})
+			upstream := newTestUpstream(t, handlers)
+			b, storage := getBackend(t)
+			writeConfig(t, b, storage, map[string]interface{}{"cluster": upstream.URL})
+			writeRole(t, b, storage, "sample", map[string]interface{}{
+				"entity_id": testEntityID,
+				"namespace": tCase.roleNamespace,
+			})
+			login := func() *api.Client {
+				resp, err := doLogin(t, b, storage, map[string]interface{}{"role": "sample", "secret": testWrappedToken})
+				if err != nil || resp.IsError() {
+					t.Fatalf("unexpected error: %v, %v", err, resp)
+				}
+				cvab := b.(*crossVaultAuthBackend)
+				cvab.sharedClientMu.Lock()
+				defer cvab.sharedClientMu.Unlock()
+				// role's namespace is applied to the copy used by the login only
+				assert.Equal(t, cvab.sharedClient.Namespace(), cvab.sharedClientKey.namespace)
+				return cvab.sharedClient
+			}
+
+			first := login()
+			config := map[string]interface{}{"cluster": upstream.URL}
+			if tCase.otherCluster {
+				config["cluster"] = newTestUpstream(t, handlers).URL
+			}
+			maps.Copy(config, tCase.config)
+			writeConfig(t, b, storage, config)
+			second := login()
+
+			assert.Equal(t, first != second, tCase.expectRebuilt)
+		})
+	}
+}
+
+func TestLogin_BindCallerIP(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		bind          bool
+		remoteAddr    string
+		roleCIDRs     string
+		expectErr     bool
+		expectedCIDRs []string
+	}{
+		"not-bound": {
+			remoteAddr: "10.1.2.3",
+		},
+		"bound-ipv4": {
+			bind:          true,
+			remoteAddr:    "10.1.2.3",
+			expectedCIDRs: []string{"10.1.2.3"},
+		},
+		"bound-ipv6": {
+			bind:          true,
+			remoteAddr:    "2001:db8::1",
+			expectedCIDRs: []string{"2001:db8::1"},
+		},
+		"bound-within-role-cidrs": {
+			bind:          true,
+			remoteAddr:    "10.1.2.3",
+			roleCIDRs:     "10.1.0.0/16",
+			expectedCIDRs: []string{"10.1.2.3"},
+		},
+		"outside-role-cidrs": {
+			bind:       true,
+			remoteAddr: "10.2.2.3",
+			roleCIDRs:  "10.1.0.0/16",
+			expectErr:  true,
+		},
+		"unknown-address": {
+			bind:      true,
+			expectErr: true,
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			upstream := newTestUpstream(t, upstreamHandlers(map[string]interface{}{"entity_id": testEntityID}))
+			b, storage := getBackend(t)
+			writeConfig(t, b, storage, map[string]interface{}{"cluster": upstream.URL})
+			writeRole(t, b, storage, "sample", map[string]interface{}{"entity_id": testEntityID})
+			if tCase.roleCIDRs != "" {
+				// token_bound_cidrs is not exposed by role path, so it is set in storage directly
+				role, err := b.(*crossVaultAuthBackend).role(context.Background(), storage, "sample")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if role.TokenBoundCIDRs, err = parseutil.ParseAddrs(tCase.roleCIDRs); err != nil {
+					t.Fatal(err)
+				}
+				entry, err := logical.StorageEntryJSON(rolePath+"/sample", role)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err = storage.Put(context.Background(), entry); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			req := &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      loginPath,
+				Data: map[string]interface{}{
+					"role":           "sample",
+					"secret":         testWrappedToken,
+					"bind_caller_ip": tCase.bind,
+				},
+				Storage: storage,
+			}
+			if tCase.remoteAddr != "" {
+				req.Connection = &logical.Connection{RemoteAddr: tCase.remoteAddr}
+			}
+			resp, err := b.HandleRequest(context.Background(), req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, resp.IsError(), tCase.expectErr)
+			if tCase.expectErr || !tCase.bind {
+				return
+			}
+			cidrs := make([]string, 0, len(resp.Auth.BoundCIDRs))
+			for _, cidr := range resp.Auth.BoundCIDRs {
+				cidrs = append(cidrs, cidr.String())
+			}
+			assert.DeepEqual(t, cidrs, tCase.expectedCIDRs)
+		})
+	}
+}
+
+func TestLogin_UpstreamUnreachable(t *testing.T) {
+	t.Parallel()
+
+	upstream := newTestUpstream(t, upstreamHandlers(map[string]interface{}{"entity_id": testEntityID}))
+	b, storage := getBackend(t)
+	writeConfig(t, b, storage, map[string]interface{}{"cluster": upstream.URL})
+	writeRole(t, b, storage, "sample", map[string]interface{}{"entity_id": testEntityID})
+
+	resp, err := doLogin(t, b, storage, map[string]interface{}{"role": "sample", "secret": testWrappedToken})
+	if err != nil || resp.IsError() {
+		t.Fatalf("unexpected error: %v, %v", err, resp)
+	}
+
+	// previous successful login must not let the same or another secret in
+	upstream.Close()
+	for _, secret := range []string{testWrappedToken, "hvs.another"} {
+		resp, err = doLogin(t, b, storage, map[string]interface{}{"role": "sample", "secret": secret})
+		assert.Assert(t, err != nil || resp.IsError())
+	}
+}
+
+func TestLogin_EntityIDCase(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		roleEntityID     string
+		upstreamEntityID string
+		storeDirectly    bool
+		expectErr        bool
+	}{
+		"upper-case-role": {
+			roleEntityID:     strings.ToUpper(testEntityID),
+			upstreamEntityID: testEntityID,
+		},
+		"different-entity": {
+			roleEntityID:     strings.ToUpper(testEntityID),
+			upstreamEntityID: "99998888-7777-6666-5555-44443333AAAA",
+			expectErr:        true,
+		},
+		"upper-case-upstream": {
+			roleEntityID:     testEntityID,
+			upstreamEntityID: strings.ToUpper(testEntityID),
+		},
+		"stored-before-normalization": {
+			roleEntityID:     strings.ToUpper(testEntityID),
+			upstreamEntityID: testEntityID,
+			storeDirectly:    true,
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			upstream := newTestUpstream(t, upstreamHandlers(map[string]interface{}{"entity_id": tCase.upstreamEntityID}))
+			b, storage := getBackend(t)
+			writeConfig(t, b, storage, map[string]interface{}{"cluster": upstream.URL})
+			writeRole(t, b, storage, "sample", map[string]interface{}{"entity_id": tCase.roleEntityID})
+
+			role, err := b.(*crossVaultAuthBackend).role(context.Background(), storage, "sample")
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, role.EntityID, strings.ToLower(tCase.roleEntityID))
+			if tCase.storeDirectly {
+				role.EntityID = tCase.roleEntityID
+				entry, err := logical.StorageEntryJSON(rolePath+"/sample", role)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err = storage.Put(context.Background(), entry); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			resp, err := doLogin(t, b, storage, map[string]interface{}{"role": "sample", "secret": testWrappedToken})
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, resp.IsError(), tCase.expectErr)
+		})
+	}
+}
+
+func TestLogin_DualSecret(t *testing.T) {
+	t.Parallel()
+
+	const otherEntityID = "99998888-7777-6666-5555-444433332222"
+	// wrapping token -> wrapped source token, source token -> entity ID
+	wrapped := map[string]string{
+		"hvs.wrapped-a":       "hvs.source-a",
+		"hvs.wrapped-b":       "hvs.source-b",
+		"hvs.wrapped-a-again": "hvs.source-a",
+		"hvs.wrapped-other":   "hvs.source-other",
+	}
+	entities := map[string]string{
+		"hvs.source-a":     testEntityID,
+		"hvs.source-b":     testEntityID,
+		"hvs.source-other": otherEntityID,
+	}
+
+	tests := map[string]struct {
+		require   bool
+		secret2   string
+		expectErr bool
+	}{
+		"success": {
+			require: true,
+			secret2: "hvs.wrapped-b",
+		},
+		"not-required": {},
+		"second-missing": {
+			require:   true,
+			expectErr: true,
+		},
+		"second-same-wrapping-token": {
+			require:   true,
+			secret2:   "hvs.wrapped-a",
+			expectErr: true,
+		},
+		"second-same-credential": {
+			require:   true,
+			secret2:   "hvs.wrapped-a-again",
+			expectErr: true,
+		},
+		"second-other-entity": {
+			require:   true,
+			secret2:   "hvs.wrapped-other",
+			expectErr: true,
+		},
+		"second-unwrap-failure": {
+			require:   true,
+			secret2:   "hvs.wrapped-unknown",
+			expectErr: true,
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			upstream := newTestUpstream(t, map[string]http.HandlerFunc{
+				"/v1/sys/wrapping/unwrap": func(w http.ResponseWriter, r *http.Request) {
+					source, ok := wrapped[r.Header.Get("X-Vault-Token")]
+					if !ok {
+						jsonHandler(http.StatusBadRequest, map[string]interface{}{
+							"errors": []string{"wrapping token is not valid or does not exist"},
+						})(w, r)
+						return
+					}
+					unwrapHandler(source)(w, r)
+				},
+				"/v1/auth/token/lookup": func(w http.ResponseWriter, r *http.Request) {
+					var body map[string]string
+					_ = json.NewDecoder(r.Body).Decode(&body)
+					lookupHandler(map[string]interface{}{"entity_id": entities[body["token"]]})(w, r)
+				},
+			})
+			b, storage := getBackend(t)
+			writeConfig(t, b, storage, map[string]interface{}{"cluster": upstream.URL})
+			writeRole(t, b, storage, "sample", map[string]interface{}{
+				"entity_id":           testEntityID,
+				"require_dual_secret": tCase.require,
+			})
+
+			data := map[string]interface{}{"role": "sample", "secret": "hvs.wrapped-a"}
+			if tCase.secret2 != "" {
+				data["secret2"] = tCase.secret2
+			}
+			resp, err := doLogin(t, b, storage, data)
+			assert.Equal(t, err != nil || resp.IsError(), tCase.expectErr)
+		})
+	}
+}
+
+func TestLogin_TokenTTLJitter(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		role   map[string]interface{}
+		minTTL time.Duration
+		maxTTL time.Duration
+	}{
+		"role-ttl": {
+			role:   map[string]interface{}{"token_ttl": "1h", "token_max_ttl": "1h", "token_ttl_jitter": 20},
+			minTTL: time.Minute * 48,
+			maxTTL: time.Hour,
+		},
+		"mount-default-ttl": {
+			role:   map[string]interface{}{"token_ttl_jitter": 50},
+			minTTL: time.Hour * 12,
+			maxTTL: time.Hour * 24,
+		},
+		"no-jitter": {
+			role:   map[string]interface{}{"token_ttl": "1h"},
+			minTTL: time.Hour,
+			maxTTL: time.Hour,
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			upstream := newTestUpstream(t, upstreamHandlers(map[string]interface{}{"entity_id": testEntityID}))
+			b, storage := getBackend(t)
+			writeConfig(t, b, storage, map[string]interface{}{"cluster": upstream.URL})
+			tCase.role["entity_id"] = testEntityID
+			writeRole(t, b, storage, "sample", tCase.role)
+
+			for i := 0; i < 50; i++ {
+				resp, err := doLogin(t, b, storage, map[string]interface{}{"role": "sample", "secret": testWrappedToken})
+				if err != nil || resp.IsError() {
+					t.Fatalf("unexpected error: %v, %v", err, resp)
+				}
+				assert.Assert(t, resp.Auth.TTL >= tCase.minTTL && resp.Auth.TTL <= tCase.maxTTL,
+					"TTL %s is out of bounds", resp.Auth.TTL)
+			}
+		})
+	}
+}
+
+func TestLogin_EntitylessSource(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		role          map[string]interface{}
+		lookup        map[string]interface{}
+		expectErr     bool
+		expectMessage string
+	}{
+		"entityless-rejected": {
+			role:          map[string]interface{}{"entity_id": testEntityID},
+			lookup:        map[string]interface{}{"entity_id": ""},
+			expectErr:     true,
+			expectMessage: "source token has no associated entity",
+		},
+		"entity-mismatch": {
+			role:          map[string]interface{}{"entity_id": testEntityID, "allow_entityless_source": true},
+			lookup:        map[string]interface{}{"entity_id": "99998888-7777-6666-5555-444433332222"},
+			expectErr:     true,
+			expectMessage: roleValidationFailed.Error(),
+		},
+		"entityless-allowed-metadata-match": {
+			role: map[string]interface{}{
+				"entity_id":               testEntityID,
+				"allow_entityless_source": true,
+				"entity_meta":             "team=ops",
+			},
+			lookup: map[string]interface{}{"entity_id": "", "meta": map[string]interface{}{"team": "ops"}},
+		},
+		"entityless-allowed-metadata-mismatch": {
+			role: map[string]interface{}{
+				"entity_id":               testEntityID,
+				"allow_entityless_source": true,
+				"entity_meta":             "team=ops",
+			},
+			lookup:        map[string]interface{}{"entity_id": "", "meta": map[string]interface{}{"team": "dev"}},
+			expectErr:     true,
+			expectMessage: roleValidationFailed.Error(),
+		},
+		"entityless-allowed-no-metadata-constraints": {
+			role:          map[string]interface{}{"entity_id": testEntityID, "allow_entityless_source": true},
+			lookup:        map[string]interface{}{"entity_id": ""},
+			expectErr:     true,
+			expectMessage: "role has no metadata constraints",
+		},
+		"entityless-allowed-entity-still-matched": {
+			role:   map[string]interface{}{"entity_id": testEntityID, "allow_entityless_source": true},
+			lookup: map[string]interface{}{"entity_id": testEntityID},
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			upstream := newTestUpstream(t, upstreamHandlers(tCase.lookup))
+			b, storage := getBackend(t)
+			writeConfig(t, b, storage, map[string]interface{}{"cluster": upstream.URL})
+			writeRole(t, b, storage, "sample", tCase.role)
+
+			resp, err := doLogin(t, b, storage, map[string]interface{}{"role": "sample", "secret": testWrappedToken})
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, resp.IsError(), tCase.expectErr)
+			if tCase.expectErr {
+				assert.ErrorContains(t, resp.Error(), tCase.expectMessage)
+			}
+		})
+	}
+}
+
+func TestLogin_RequiredSourceDisplayName(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		pattern     string
+		displayName string
+		expectErr   bool
+	}{
+		"not-set": {
+			displayName: "token-anything",
+		},
+		"glob-match": {
+			pattern:     "token-ci-*",
+			displayName: "token-ci-deploy",
+		},
+		"glob-mismatch": {
+			pattern:     "token-ci-*",
+			displayName: "token-dev-deploy",
+			expectErr:   true,
+		},
+		"glob-partial-match": {
+			pattern:     "ci",
+			displayName: "token-ci-deploy",
+			expectErr:   true,
+		},
+		"glob-literal-dot": {
+			pattern:     "token.ci",
+			displayName: "token-ci",
+			expectErr:   true,
+		},
+		"regex-match": {
+			pattern:     "regex:token-(ci|cd)-[a-z]+",
+			displayName: "token-cd-release",
+		},
+		"regex-mismatch": {
+			pattern:     "regex:token-(ci|cd)-[a-z]+",
+			displayName: "token-cd-release-1",
+			expectErr:   true,
+		},
+		"missing-display-name": {
+			pattern:   "token-*",
+			expectErr: true,
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			upstream := newTestUpstream(t, upstreamHandlers(map[string]interface{}{
+				"entity_id":    testEntityID,
+				"display_name": tCase.displayName,
+			}))
+			b, storage := getBackend(t)
+			writeConfig(t, b, storage, map[string]interface{}{"cluster": upstream.URL})
+			writeRole(t, b, storage, "sample", map[string]interface{}{
+				"entity_id":                    testEntityID,
+				"required_source_display_name": tCase.pattern,
+			})
+			// pattern is compiled on role write, logins use the cached one
+			_, ok := b.(*crossVaultAuthBackend).displayNamePatterns.patterns[tCase.pattern]
+			assert.Assert(t, ok)
+
+			resp, err := doLogin(t, b, storage, map[string]interface{}{"role": "sample", "secret": testWrappedToken})
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, resp.IsError(), tCase.expectErr)
+			if tCase.expectErr {
+				assert.ErrorContains(t, resp.Error(), "display name")
+			}
+		})
+	}
+}
+
+func TestLogin_CorrelationID(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		correlationID string
+		expectErr     bool
+	}{
+		"absent": {},
+		"present": {
+			correlationID: "req-2024/01:abc_DEF.1",
+		},
+		"max-length": {
+			correlationID: strings.Repeat("a", maxCorrelationIDLength),
+		},
+		"oversized": {
+			correlationID: strings.Repeat("a", maxCorrelationIDLength+1),
+			expectErr:     true,
+		},
+		"invalid-characters": {
+			correlationID: "req id\n",
+			expectErr:     true,
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			upstream := newTestUpstream(t, upstreamHandlers(map[string]interface{}{"entity_id": testEntityID}))
+			b, storage := getBackend(t)
+			writeConfig(t, b, storage, map[string]interface{}{"cluster": upstream.URL})
+			writeRole(t, b, storage, "sample", map[string]interface{}{"entity_id": testEntityID})
+
+			data := map[string]interface{}{"role": "sample", "secret": testWrappedToken}
+			if tCase.correlationID != "" {
+				data["correlation_id"] = tCase.correlationID
+			}
+			resp, err := doLogin(t, b, storage, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, resp.IsError(), tCase.expectErr)
+			if tCase.expectErr {
+				assert.ErrorContains(t, resp.Error(), "correlation_id")
+				return
+			}
+			correlationID, ok := resp.Auth.Metadata["correlation_id"]
+			assert.Equal(t, ok, tCase.correlationID != "")
+			assert.Equal(t, correlationID, tCase.correlationID)
+			_, ok = resp.Auth.Alias.Metadata["correlation_id"]
+			assert.Assert(t, !ok)
+		})
+	}
+}
+
+func TestLogin_HeaderCredentials(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		allow             bool
+		credentialHeaders map[string]interface{}
+		body              map[string]interface{}
+		requestHeaders    map[string][]string
+		expectErr         bool
+	}{
+		"headers-only": {
+			allow: true,
+			requestHeaders: map[string][]string{
+				"X-Cross-Vault-Role":   {"sample"},
+				"X-Cross-Vault-Secret": {testWrappedToken},
+				"X-Cross-Vault-Method": {WrappedTokenFull},
+			},
+		},
+		"headers-any-case": {
+			allow: true,
+			requestHeaders: map[string][]string{
+				"x-cross-vault-role":   {"sample"},
+				"x-cross-vault-secret": {testWrappedToken},
+			},
+		},
+		"custom-header": {
+			allow:             true,
+			credentialHeaders: map[string]interface{}{"secret": "X-Proxy-Credential"},
+			body:              map[string]interface{}{"role": "sample"},
+			requestHeaders: map[string][]string{
+				"X-Proxy-Credential": {testWrappedToken},
+			},
+		},
+		"body-preferred": {
+			allow: true,
+			body:  map[string]interface{}{"role": "sample", "secret": testWrappedToken},
+			requestHeaders: map[string][]string{
+				"X-Cross-Vault-Role":   {"unknown"},
+				"X-Cross-Vault-Secret": {"hvs.other"},
+			},
+		},
+		"not-allowed": {
+			requestHeaders: map[string][]string{
+				"X-Cross-Vault-Role":   {"sample"},
+				"X-Cross-Vault-Secret": {testWrappedToken},
+			},
+			expectErr: true,
+		},
+		"multiple-values": {
+			allow: true,
+			body:  map[string]interface{}{"role": "sample"},
+			requestHeaders: map[string][]string{
+				"X-Cross-Vault-Secret": {testWrappedToken, "hvs.other"},
+			},
+			expectErr: true,
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			upstream := newTestUpstream(t, upstreamHandlers(map[string]interface{}{"entity_id": testEntityID}))
+			b, storage := getBackend(t)
+			config := map[string]interface{}{
+				"cluster":                  upstream.URL,
+				"allow_header_credentials": tCase.allow,
+			}
+			if tCase.credentialHeaders != nil {
+				config["credential_headers"] = tCase.credentialHeaders
+			}
+			writeConfig(t, b, storage, config)
+			writeRole(t, b, storage, "sample", map[string]interface{}{"entity_id": testEntityID})
+
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      loginPath,
+				Data:      tCase.body,
+				Headers:   tCase.requestHeaders,
+				Storage:   storage,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, resp.IsError(), tCase.expectErr)
+			if !tCase.expectErr {
+				assert.Equal(t, resp.Auth.Metadata["role"], "sample")
+			}
+		})
+	}
+}
+
+func TestLogin_TTLByMeta(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		rules       string
+		maxTTL      string
+		ttl         string
+		meta        map[string]interface{}
+		expectedTTL time.Duration
+	}{
+		"gold": {
+			rules:       "tier=gold:8h,tier=silver:1h",
+			meta:        map[string]interface{}{"tier": "gold"},
+			expectedTTL: time.Hour * 8,
+		},
+		"silver": {
+			rules:       "tier=gold:8h,tier=silver:1h",
+			meta:        map[string]interface{}{"tier": "silver"},
+			expectedTTL: time.Hour,
+		},
+		"no-match": {
+			rules:       "tier=gold:8h,tier=silver:1h",
+			meta:        map[string]interface{}{"tier": "bronze"},
+			expectedTTL: time.Minute * 30,
+		},
+		"first-match-wins": {
+			rules:       "region=eu:2h,tier=gold:8h",
+			meta:        map[string]interface{}{"tier": "gold", "region": "eu"},
+			expectedTTL: time.Hour * 2,
+		},
+		"clamped-by-role-max-ttl": {
+			rules:       "tier=gold:8h",
+			maxTTL:      "4h",
+			meta:        map[string]interface{}{"tier": "gold"},
+			expectedTTL: time.Hour * 4,
+		},
+		"requested-ttl-shorter": {
+			rules:       "tier=gold:8h",
+			ttl:         "10m",
+			meta:        map[string]interface{}{"tier": "gold"},
+			expectedTTL: time.Minute * 10,
+		},
+		"requested-ttl-bounded": {
+			rules:       "tier=gold:8h,tier=silver:1h",
+			ttl:         "8h",
+			meta:        map[string]interface{}{"tier": "silver"},
+			expectedTTL: time.Hour,
+		},
+		"requested-ttl-no-match": {
+			rules:       "tier=gold:8h,tier=silver:1h",
+			ttl:         "8h",
+			meta:        map[string]interface{}{"tier": "bronze"},
+			expectedTTL: time.Hour * 8,
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			upstream := newTestUpstream(t, upstreamHandlers(map[string]interface{}{
+				"entity_id": testEntityID,
+				"meta":      tCase.meta,
+			}))
+			b, storage := getBackend(t)
+			writeConfig(t, b, storage, map[string]interface{}{"cluster": upstream.URL})
+			roleData := map[string]interface{}{
+				"entity_id":   testEntityID,
+				"token_ttl":   "30m",
+				"ttl_by_meta": tCase.rules,
+			}
+			if tCase.maxTTL != "" {
+				roleData["token_max_ttl"] = tCase.maxTTL
+			}
+			writeRole(t, b, storage, "sample", roleData)
+
+			data := map[string]interface{}{"role": "sample", "secret": testWrappedToken}
+			if tCase.ttl != "" {
+				data["ttl"] = tCase.ttl
+			}
+			resp, err := doLogin(t, b, storage, data)
+			if err != nil || resp.IsError() {
+				t.Fatalf("unexpected error: %v, %v", err, resp)
+			}
+			assert.Equal(t, resp.Auth.TTL, tCase.expectedTTL)
+		})
+	}
+}
+
+func TestLogin_RequiredSourcePolicies(t *testing.T) {
+	t.Parallel()
+
+	lookup := map[string]interface{}{
+		"entity_id":         testEntityID,
+		"policies":          []string{"default", "deployer"},
+		"identity_policies": []string{"team-ops"},
+	}
+
+	tests := map[string]struct {
+		required     string
+		policySource string
+		expectErr    bool
+	}{
+		"not-required": {},
+		"all-token-policy": {
+			required: "deployer",
+		},
+		"all-identity-policy": {
+			required: "team-ops",
+		},
+		"all-both": {
+			required: "deployer,team-ops",
+		},
+		"token-token-policy": {
+			required:     "deployer",
+			policySource: policySourceToken,
+		},
+		"token-identity-policy": {
+			required:     "team-ops",
+			policySource: policySourceToken,
+			expectErr:    true,
+		},
+		"identity-identity-policy": {
+			required:     "team-ops",
+			policySource: policySourceIdentity,
+		},
+		"identity-token-policy": {
+			required:     "deployer",
+			policySource: policySourceIdentity,
+			expectErr:    true,
+		},
+		"missing": {
+			required:  "deployer,admin",
+			expectErr: true,
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			upstream := newTestUpstream(t, upstreamHandlers(lookup))
+			b, storage := getBackend(t)
+			writeConfig(t, b, storage, map[string]interface{}{"cluster": upstream.URL})
+			roleData := map[string]interface{}{
+				"entity_id":                testEntityID,
+				"required_source_policies": tCase.required,
+			}
+			if tCase.policySource != "" {
+				roleData["policy_source"] = tCase.policySource
+			}
+			writeRole(t, b, storage, "sample", roleData)
+
+			resp, err := doLogin(t, b, storage, map[string]interface{}{"role": "sample", "secret": testWrappedToken})
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, resp.IsError(), tCase.expectErr)
+			if tCase.expectErr {
+				assert.ErrorContains(t, resp.Error(), "lacks required policies")
+			}
+		})
+	}
+}
+
+func TestLogin_EntityIDs(t *testing.T) {
+	t.Parallel()
+
+	const (
+		firstEntityID  = "aaaabbbb-cccc-dddd-eeee-ffff00001111"
+		secondEntityID = "aaaabbbb-cccc-dddd-eeee-ffff00002222"
+		otherEntityID  = "aaaabbbb-cccc-dddd-eeee-ffff00003333"
+	)
+
+	tests := map[string]struct {
+		role           map[string]interface{}
+		sourceEntityID string
+		expectErr      bool
+	}{
+		"entity-id": {
+			role:           map[string]interface{}{"entity_id": testEntityID, "entity_ids": firstEntityID},
+			sourceEntityID: testEntityID,
+		},
+		"second-id": {
+			role:           map[string]interface{}{"entity_ids": []string{firstEntityID, secondEntityID}},
+			sourceEntityID: secondEntityID,
+		},
+		"second-id-other-case": {
+			role: map[string]interface{}{
+				"entity_id":  testEntityID,
+				"entity_ids": []string{firstEntityID, secondEntityID},
+			},
+			sourceEntityID: strings.ToUpper(secondEntityID),
+		},
+		"no-match": {
+			role: map[string]interface{}{
+				"entity_id":  testEntityID,
+				"entity_ids": []string{firstEntityID, secondEntityID},
+			},
+			sourceEntityID: otherEntityID,
+			expectErr:      true,
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			upstream := newTestUpstream(t, upstreamHandlers(map[string]interface{}{"entity_id": tCase.sourceEntityID}))
+			b, storage := getBackend(t)
+			writeConfig(t, b, storage, map[string]interface{}{"cluster": upstream.URL})
+			writeRole(t, b, storage, "sample", tCase.role)
+
+			resp, err := doLogin(t, b, storage, map[string]interface{}{"role": "sample", "secret": testWrappedToken})
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, resp.IsError(), tCase.expectErr)
+			if tCase.expectErr {
+				return
+			}
+			// the token is mapped to the matching entity ID as stored by the role
+			assert.Equal(t, resp.Auth.Metadata["mapped_entity_id"], strings.ToLower(tCase.sourceEntityID))
+			assert.Equal(t, resp.Auth.Alias.Metadata["mapped_entity_id"], strings.ToLower(tCase.sourceEntityID))
+		})
+	}
+}
+
+func TestLogin_AliasSource(t *testing.T) {
+	t.Parallel()
+
+	const testAccessor = "8609694a-cdbc-db9b-d345-e782dbb562ed"
+
+	tests := map[string]struct {
+		aliasSource        string
+		lookup             map[string]interface{}
+		expectedName       func(role *crossVaultAuthRoleEntry) string
+		expectLookaheadErr bool
+		expectLoginErr     bool
+	}{
+		"default": {
+			expectedName: func(role *crossVaultAuthRoleEntry) string { return role.RoleID },
+		},
+		"role-id": {
+			aliasSource:  aliasSourceRoleID,
+			expectedName: func(role *crossVaultAuthRoleEntry) string { return role.RoleID },
+		},
+		"entity-id": {
+			aliasSource:  aliasSourceEntityID,
+			expectedName: func(*crossVaultAuthRoleEntry) string { return testEntityID },
+		},
+		"accessor": {
+			aliasSource:        aliasSourceAccessor,
+			lookup:             map[string]interface{}{"entity_id": testEntityID, "accessor": testAccessor},
+			expectedName:       func(*crossVaultAuthRoleEntry) string { return testAccessor },
+			expectLookaheadErr: true,
+		},
+		"accessor-missing": {
+			aliasSource:        aliasSourceAccessor,
+			lookup:             map[string]interface{}{"entity_id": testEntityID, "type": sourceTokenTypeBatch},
+			expectLookaheadErr: true,
+			expectLoginErr:     true,
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			lookup := tCase.lookup
+			if lookup == nil {
+				lookup = map[string]interface{}{"entity_id": testEntityID}
+			}
+			upstream := newTestUpstream(t, upstreamHandlers(lookup))
+			b, storage := getBackend(t)
+			writeConfig(t, b, storage, map[string]interface{}{"cluster": upstream.URL})
+			roleData := map[string]interface{}{"entity_id": testEntityID}
+			if tCase.aliasSource != "" {
+				roleData["alias_source"] = tCase.aliasSource
+			}
+			writeRole(t, b, storage, "sample", roleData)
+			role, err := b.(*crossVaultAuthBackend).role(context.Background(), storage, "sample")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			lookahead, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.AliasLookaheadOperation,
+				Path:      loginPath,
+				Data:      map[string]interface{}{"role": "sample"},
+				Storage:   storage,
+			})
+			if tCase.expectLookaheadErr {
+				assert.ErrorIs(t, err, aliasNameUnknown)
+			} else {
+				if err != nil || lookahead.IsError() {
+					t.Fatalf("unexpected error: %v, %v", err, lookahead)
+				}
+				assert.Equal(t, lookahead.Auth.Alias.Name, tCase.expectedName(role))
+			}
+
+			resp, err := doLogin(t, b, storage, map[string]interface{}{"role": "sample", "secret": testWrappedToken})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tCase.expectLoginErr {
+				assert.ErrorContains(t, resp.Error(), sourceTokenAccessorMissing.Error())
+				return
+			}
+			if resp.IsError() {
+				t.Fatalf("unexpected error: %v", resp.Error())
+			}
+			assert.Equal(t, resp.Auth.Alias.Name, tCase.expectedName(role))
+		})
+	}
+}
+
+func TestLogin_TokenAuthMount(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		mount        string
+		method       string
+		expectedPath string
+	}{
+		"default": {
+			expectedPath: "/v1/auth/token/lookup",
+		},
+		"custom": {
+			mount:        "custom-token",
+			expectedPath: "/v1/auth/custom-token/lookup",
+		},
+		"custom-accessor": {
+			mount:        "custom-token",
+			method:       WrappedAccessorOnly,
+			expectedPath: "/v1/auth/custom-token/lookup-accessor",
+		},
+		"surrounding-slashes": {
+			mount:        "/nested/token/",
+			expectedPath: "/v1/auth/nested/token/lookup",
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			// lookup is served only at the expected path of the namespace, so lookups in root or
+			// at another mount fail
+			lookup := lookupHandler(map[string]interface{}{"entity_id": testEntityID})
+			var lookups atomic.Int32
+			upstream := newTestUpstream(t, map[string]http.HandlerFunc{
+				"/v1/sys/wrapping/unwrap": unwrapHandler(testSourceToken),
+				tCase.expectedPath: func(w http.ResponseWriter, r *http.Request) {
+					if r.Header.Get("X-Vault-Namespace") != "team-a" {
+						jsonHandler(http.StatusForbidden, map[string]interface{}{"errors": []string{"permission denied"}})(w, r)
+						return
+					}
+					lookups.Add(1)
+					lookup(w, r)
+				},
+			})
+			b, storage := getBackend(t)
+			config := map[string]interface{}{"cluster": upstream.URL, "namespace": "team-a"}
+			if tCase.mount != "" {
+				config["token_auth_mount"] = tCase.mount
+			}
+			writeConfig(t, b, storage, config)
+			writeRole(t, b, storage, "sample", map[string]interface{}{"entity_id": testEntityID})
+
+			data := map[string]interface{}{"role": "sample", "secret": testWrappedToken}
+			if tCase.method != "" {
+				data["method"] = tCase.method
+			}
+			resp, err := doLogin(t, b, storage, data)
+			if err != nil || resp.IsError() {
+				t.Fatalf("unexpected error: %v, %v", err, resp)
+			}
+			assert.Equal(t, lookups.Load(), int32(1))
+		})
+	}
+}
+
+func TestLogin_SkipMetaVerify(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		skip      bool
+		lookup    map[string]interface{}
+		expectErr bool
+	}{
+		"metadata-mismatch": {
+			lookup:    map[string]interface{}{"entity_id": testEntityID, "meta": map[string]interface{}{"env": "dev"}},
+			expectErr: true,
+		},
+		"metadata-mismatch-skipped": {
+			skip:   true,
+			lookup: map[string]interface{}{"entity_id": testEntityID, "meta": map[string]interface{}{"env": "dev"}},
+		},
+		"strict-extra-key-skipped": {
+			skip: true,
+			lookup: map[string]interface{}{
+				"entity_id": testEntityID,
+				"meta":      map[string]interface{}{"env": "prod", "team": "core"},
+			},
+		},
+		"entity-mismatch-skipped": {
+			skip:      true,
+			lookup:    map[string]interface{}{"entity_id": "99998888-7777-6666-5555-444433332222"},
+			expectErr: true,
+		},
+		"entityless-skipped": {
+			skip:      true,
+			lookup:    map[string]interface{}{"entity_id": "", "meta": map[string]interface{}{"env": "prod"}},
+			expectErr: true,
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			upstream := newTestUpstream(t, upstreamHandlers(tCase.lookup))
+			b, storage := getBackend(t)
+			writeConfig(t, b, storage, map[string]interface{}{"cluster": upstream.URL})
+			writeRole(t, b, storage, "sample", map[string]interface{}{
+				"entity_id":               testEntityID,
+				"entity_meta":             "env=prod",
+				"strict_meta_verify":      true,
+				"allow_entityless_source": true,
+				"skip_meta_verify":        tCase.skip,
+			})
+
+			resp, err := doLogin(t, b, storage, map[string]interface{}{"role": "sample", "secret": testWrappedToken})
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, resp.IsError(), tCase.expectErr)
+		})
+	}
+}
+
+func TestLogin_DebugValidationTrace(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		debug         bool
+		role          map[string]interface{}
+		lookup        map[string]interface{}
+		expectedTrace map[string]interface{}
+	}{
+		"entity-mismatch": {
+			debug:  true,
+			role:   map[string]interface{}{"entity_id": testEntityID},
+			lookup: map[string]interface{}{"entity_id": "99998888-7777-6666-5555-444433332222"},
+			expectedTrace: map[string]interface{}{
+				"unwrap":         "ok",
+				"lookup":         "ok",
+				"entity_present": true,
+				"entity_match":   false,
+			},
+		},
+		"entity-missing": {
+			debug:  true,
+			role:   map[string]interface{}{"entity_id": testEntityID},
+			lookup: map[string]interface{}{"entity_id": ""},
+			expectedTrace: map[string]interface{}{
+				"unwrap":         "ok",
+				"lookup":         "ok",
+				"entity_present": false,
+			},
+		},
+		"token-type": {
+			debug:  true,
+			role:   map[string]interface{}{"entity_id": testEntityID, "allowed_source_token_types": "batch"},
+			lookup: map[string]interface{}{"entity_id": testEntityID, "type": sourceTokenTypeService},
+			expectedTrace: map[string]interface{}{
+				"unwrap":            "ok",
+				"lookup":            "ok",
+				"entity_present":    true,
+				"entity_match":      true,
+				"source_token_type": false,
+			},
+		},
+		"source-namespace": {
+			debug:  true,
+			role:   map[string]interface{}{"entity_id": testEntityID, "source_namespace": "team-a"},
+			lookup: map[string]interface{}{"entity_id": testEntityID, "namespace_path": "team-b/"},
+			expectedTrace: map[string]interface{}{
+				"unwrap":           "ok",
+				"lookup":           "ok",
+				"entity_present":   true,
+				"entity_match":     true,
+				"source_namespace": false,
+			},
+		},
+		"source-policies": {
+			debug:  true,
+			role:   map[string]interface{}{"entity_id": testEntityID, "required_source_policies": "admin"},
+			lookup: map[string]interface{}{"entity_id": testEntityID, "policies": []string{"default"}},
+			expectedTrace: map[string]interface{}{
+				"unwrap":          "ok",
+				"lookup":          "ok",
+				"entity_present":  true,
+				"entity_match":    true,
+				"source_policies": false,
+			},
+		},
+		"display-name": {
+			debug:  true,
+			role:   map[string]interface{}{"entity_id": testEntityID, "required_source_display_name": "ci-*"},
+			lookup: map[string]interface{}{"entity_id": testEntityID, "display_name": "token-dev"},
+			expectedTrace: map[string]interface{}{
+				"unwrap":         "ok",
+				"lookup":         "ok",
+				"entity_present": true,
+				"entity_match":   true,
+				"display_name":   false,
+			},
+		},
+		"metadata": {
+			debug:  true,
+			role:   map[string]interface{}{"entity_id": testEntityID, "entity_meta": "env=prod"},
+			lookup: map[string]interface{}{"entity_id": testEntityID, "meta": map[string]interface{}{"env": "dev"}},
+			expectedTrace: map[string]interface{}{
+				"unwrap":         "ok",
+				"lookup":         "ok",
+				"entity_present": true,
+				"entity_match":   true,
+				"metadata_match": false,
+			},
+		},
+		"disabled": {
+			role:   map[string]interface{}{"entity_id": testEntityID},
+			lookup: map[string]interface{}{"entity_id": "99998888-7777-6666-5555-444433332222"},
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			upstream := newTestUpstream(t, upstreamHandlers(tCase.lookup))
+			b, storage := getBackend(t)
+			writeConfig(t, b, storage, map[string]interface{}{
+				"cluster":     upstream.URL,
+				"debug_login": tCase.debug,
+			})
+			writeRole(t, b, storage, "sample", tCase.role)
+
+			resp, err := doLogin(t, b, storage, map[string]interface{}{"role": "sample", "secret": testWrappedToken})
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Assert(t, resp.IsError())
+			data, ok := resp.Data["data"].(map[string]interface{})
+			assert.Equal(t, ok, tCase.debug)
+			if tCase.debug {
+				assert.DeepEqual(t, data["trace"], tCase.expectedTrace)
+			}
+		})
+	}
+}
+
+func TestLogin_GroupAliases(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		groupAliases []string
+	}{
+		"none": {},
+		"single": {
+			groupAliases: []string{"admins"},
+		},
+		"multiple": {
+			groupAliases: []string{"admins", "auditors"},
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			upstream := newTestUpstream(t, upstreamHandlers(map[string]interface{}{"entity_id": testEntityID}))
+			b, storage := getBackend(t)
+			writeConfig(t, b, storage, map[string]interface{}{"cluster": upstream.URL})
+			writeRole(t, b, storage, "sample", map[string]interface{}{
+				"entity_id":     testEntityID,
+				"group_aliases": tCase.groupAliases,
+			})
+
+			resp, err := doLogin(t, b, storage, map[string]interface{}{"role": "sample", "secret": testWrappedToken})
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Assert(t, !resp.IsError())
+			var names []string
+			for _, groupAlias := range resp.Auth.GroupAliases {
+				names = append(names, groupAlias.Name)
+			}
+			assert.DeepEqual(t, names, tCase.groupAliases)
+		})
+	}
+}
+
+func TestLogin_UpstreamWarnings(t *testing.T) {
+	t.Parallel()
+
+	const upstreamWarning = "endpoint is deprecated"
+
+	tests := map[string]struct {
+		forward          bool
+		expectedWarnings []string
+	}{
+		"not-forwarded": {},
+		"forwarded": {
+			forward:          true,
+			expectedWarnings: []string{"target Vault cluster: " + upstreamWarning},
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			handlers := upstreamHandlers(nil)
+			handlers["/v1/auth/token/lookup"] = jsonHandler(http.StatusOK, map[string]interface{}{
+				"data":     map[string]interface{}{"entity_id": testEntityID},
+				"warnings": []string{upstreamWarning},
+			})
+			upstream := newTestUpstream(t, handlers)
+			b, storage := getBackend(t)
+			writeConfig(t, b, storage, map[string]interface{}{
+				"cluster":                   upstream.URL,
+				"forward_upstream_warnings": tCase.forward,
+			})
+			writeRole(t, b, storage, "sample", map[string]interface{}{"entity_id": testEntityID})
+
+			resp, err := doLogin(t, b, storage, map[string]interface{}{"role": "sample", "secret": testWrappedToken})
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Assert(t, !resp.IsError())
+			assert.DeepEqual(t, resp.Warnings, tCase.expectedWarnings)
+		})
+	}
+}
+
+func TestLogin_TokenMetadata(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		tokenMetadata map[string]interface{}
+		correlationID string
+		expected      map[string]string
+	}{
+		"none": {
+			expected: map[string]string{"role": "sample", "mapped_entity_id": testEntityID},
+		},
+		"merged": {
+			tokenMetadata: map[string]interface{}{"team": "core", "env": "prod"},
+			expected: map[string]string{
+				"role":             "sample",
+				"mapped_entity_id": testEntityID,
+				"team":             "core",
+				"env":              "prod",
+			},
+		},
+		"merged-with-correlation-id": {
+			tokenMetadata: map[string]interface{}{"team": "core"},
+			correlationID: "req-1",
+			expected: map[string]string{
+				"role":             "sample",
+				"mapped_entity_id": testEntityID,
+				"team":             "core",
+				"correlation_id":   "req-1",
+			},
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			upstream := newTestUpstream(t, upstreamHandlers(map[string]interface{}{"entity_id": testEntityID}))
+			b, storage := getBackend(t)
+			writeConfig(t, b, storage, map[string]interface{}{"cluster": upstream.URL})
+			role := map[string]interface{}{"entity_id": testEntityID}
+			if tCase.tokenMetadata != nil {
+				role["token_metadata"] = tCase.tokenMetadata
+			}
+			writeRole(t, b, storage, "sample", role)
+
+			login := map[string]interface{}{"role": "sample", "secret": testWrappedToken}
+			if tCase.correlationID != "" {
+				login["correlation_id"] = tCase.correlationID
+			}
+			resp, err := doLogin(t, b, storage, login)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Assert(t, !resp.IsError())
+			for key, value := range tCase.expected {
+				assert.Equal(t, resp.Auth.Metadata[key], value)
+			}
+			assert.DeepEqual(t, resp.Auth.Alias.Metadata, map[string]string{"role": "sample", "mapped_entity_id": testEntityID})
+		})
+	}
+}
+
+func TestLogin_RoleRequestTimeout(t *testing.T) {
+	t.Parallel()
+
+	const lookupDelay = time.Millisecond * 1500
+
+	tests := map[string]struct {
+		timeout   string
+		expectErr bool
+	}{
+		"default": {},
+		"longer": {
+			timeout: "3s",
+		},
+		"shorter": {
+			timeout:   "1s",
+			expectErr: true,
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			handlers := upstreamHandlers(nil)
+			lookup := lookupHandler(map[string]interface{}{"entity_id": testEntityID})
+			handlers["/v1/auth/token/lookup"] = func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(lookupDelay)
+				lookup(w, r)
+			}
+			upstream := newTestUpstream(t, handlers)
+			b, storage := getBackend(t)
+			writeConfig(t, b, storage, map[string]interface{}{"cluster": upstream.URL})
+			role := map[string]interface{}{"entity_id": testEntityID}
+			if tCase.timeout != "" {
+				role["request_timeout"] = tCase.timeout
+			}
+			writeRole(t, b, storage, "sample", role)
+
+			resp, err := doLogin(t, b, storage, map[string]interface{}{"role": "sample", "secret": testWrappedToken})
+			if tCase.expectErr {
+				assert.ErrorIs(t, err, context.DeadlineExceeded)
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Assert(t, !resp.IsError())
+		})
+	}
+}
+
+func TestLogin_AuditMetadata(t *testing.T) {
+	t.Parallel()
+
+	sum := sha256.Sum256([]byte(testEntityID))
+	hashedEntityID := "sha256:" + hex.EncodeToString(sum[:])
+
+	tests := map[string]struct {
+		hash          bool
+		method        string
+		lookup        map[string]interface{}
+		role          map[string]interface{}
+		expectedMeta  map[string]string
+		expectedEntry string
+	}{
+		"default": {
+			method: WrappedTokenFull,
+			lookup: map[string]interface{}{"entity_id": testEntityID},
+			expectedMeta: map[string]string{
+				"role":             "sample",
+				"mapped_entity_id": testEntityID,
+				"source_entity_id": testEntityID,
+				"method":           WrappedTokenFull,
+			},
+			expectedEntry: testEntityID,
+		},
+		"accessor-method": {
+			method: WrappedAccessorOnly,
+			lookup: map[string]interface{}{"entity_id": testEntityID},
+			expectedMeta: map[string]string{
+				"role":             "sample",
+				"mapped_entity_id": testEntityID,
+				"source_entity_id": testEntityID,
+				"method":           WrappedAccessorOnly,
+			},
+			expectedEntry: testEntityID,
+		},
+		"hashed": {
+			hash:   true,
+			method: WrappedTokenFull,
+			lookup: map[string]interface{}{"entity_id": testEntityID},
+			expectedMeta: map[string]string{
+				"role":             "sample",
+				"mapped_entity_id": hashedEntityID,
+				"source_entity_id": hashedEntityID,
+				"method":           WrappedTokenFull,
+			},
+			expectedEntry: hashedEntityID,
+		},
+		"hashed-entityless": {
+			hash:   true,
+			method: WrappedTokenFull,
+			lookup: map[string]interface{}{"entity_id": "", "meta": map[string]interface{}{"env": "prod"}},
+			role:   map[string]interface{}{"allow_entityless_source": true, "entity_meta": "env=prod"},
+			expectedMeta: map[string]string{
+				"role":             "sample",
+				"mapped_entity_id": hashedEntityID,
+				"source_entity_id": "",
+				"method":           WrappedTokenFull,
+			},
+			expectedEntry: hashedEntityID,
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			upstream := newTestUpstream(t, upstreamHandlers(tCase.lookup))
+			b, storage := getBackend(t)
+			writeConfig(t, b, storage, map[string]interface{}{
+				"cluster":               upstream.URL,
+				"hash_audit_entity_ids": tCase.hash,
+			})
+			role := map[string]interface{}{"entity_id": testEntityID}
+			for key, value := range tCase.role {
+				role[key] = value
+			}
+			writeRole(t, b, storage, "sample", role)
+
+			resp, err := doLogin(t, b, storage, map[string]interface{}{
+				"role":   "sample",
+				"secret": testWrappedToken,
+				"method": tCase.method,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Assert(t, !resp.IsError())
+
+			expected := maps.Clone(tCase.expectedMeta)
+			expected["source_cluster"] = strings.TrimPrefix(upstream.URL, "http://")
+			assert.DeepEqual(t, resp.Auth.Metadata, expected)
+			assert.Equal(t, resp.Auth.DisplayName, "sample-"+tCase.expectedEntry)
+			// alias metadata is never hashed
+			assert.Equal(t, resp.Auth.Alias.Metadata["mapped_entity_id"], testEntityID)
+		})
+	}
+}
+
+func TestLogin_DeniedEntityIDs(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		denied    string
+		lookup    map[string]interface{}
+		role      map[string]interface{}
+		expectErr bool
+	}{
+		"not-denied": {
+			denied: "99998888-7777-6666-5555-444433332222",
+			lookup: map[string]interface{}{"entity_id": testEntityID},
+		},
+		"denied": {
+			denied:    "99998888-7777-6666-5555-444433332222," + testEntityID,
+			lookup:    map[string]interface{}{"entity_id": testEntityID},
+			expectErr: true,
+		},
+		"denied-other-case": {
+			denied:    strings.ToUpper(testEntityID),
+			lookup:    map[string]interface{}{"entity_id": testEntityID},
+			expectErr: true,
+		},
+		"denied-skip-meta-verify": {
+			denied:    testEntityID,
+			lookup:    map[string]interface{}{"entity_id": testEntityID},
+			role:      map[string]interface{}{"skip_meta_verify": true},
+			expectErr: true,
+		},
+		"entityless": {
+			denied: testEntityID,
+			lookup: map[string]interface{}{"entity_id": "", "meta": map[string]interface{}{"env": "prod"}},
+			role:   map[string]interface{}{"allow_entityless_source": true, "entity_meta": "env=prod"},
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			upstream := newTestUpstream(t, upstreamHandlers(tCase.lookup))
+			b, storage := getBackend(t)
+			writeConfig(t, b, storage, map[string]interface{}{
+				"cluster":           upstream.URL,
+				"denied_entity_ids": tCase.denied,
+			})
+			role := map[string]interface{}{"entity_id": testEntityID}
+			for key, value := range tCase.role {
+				role[key] = value
+			}
+			writeRole(t, b, storage, "sample", role)
+
+			resp, err := doLogin(t, b, storage, map[string]interface{}{"role": "sample", "secret": testWrappedToken})
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, resp.IsError(), tCase.expectErr)
+			if tCase.expectErr {
+				assert.ErrorContains(t, resp.Error(), "denied_entity_ids")
+			}
+		})
+	}
+}
+
+func TestLogin_ValidateOnly(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		validateOnly bool
+		lookup       map[string]interface{}
+		expectErr    bool
+	}{
+		"login": {
+			lookup: map[string]interface{}{"entity_id": testEntityID},
+		},
+		"validate-only": {
+			validateOnly: true,
+			lookup:       map[string]interface{}{"entity_id": testEntityID},
+		},
+		"validate-only-invalid": {
+			validateOnly: true,
+			lookup:       map[string]interface{}{"entity_id": "99998888-7777-6666-5555-444433332222"},
+			expectErr:    true,
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			upstream := newTestUpstream(t, upstreamHandlers(tCase.lookup))
+			b, storage := getBackend(t)
+			writeConfig(t, b, storage, map[string]interface{}{"cluster": upstream.URL})
+			writeRole(t, b, storage, "sample", map[string]interface{}{"entity_id": testEntityID})
+
+			resp, err := doLogin(t, b, storage, map[string]interface{}{
+				"role":          "sample",
+				"secret":        testWrappedToken,
+				"validate_only": tCase.validateOnly,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, resp.IsError(), tCase.expectErr)
+			if tCase.expectErr {
+				return
+			}
+			if !tCase.validateOnly {
+				assert.Assert(t, resp.Auth != nil)
+				assert.Equal(t, resp.Data["valid"], nil)
+				return
+			}
+			assert.Assert(t, resp.Auth == nil)
+			assert.Equal(t, resp.Data["valid"], true)
+			assert.Equal(t, resp.Data["role"], "sample")
+		})
+	}
+}
+
+func TestLogin_Proxy(t *testing.T) {
+	t.Parallel()
+
+	// forward proxy imitating target Vault cluster itself, since requests to plain-http cluster are
+	// sent to the proxy as is
+	var proxied atomic.Int32
+	mux := upstreamMux(upstreamHandlers(map[string]interface{}{"entity_id": testEntityID}))
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Host == "vault.invalid:8200" {
+			proxied.Add(1)
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(proxy.Close)
+
+	b, storage := getBackend(t)
+	// cluster can't be resolved, so it is reachable through the proxy only
+	config := map[string]interface{}{"cluster": "http://vault.invalid:8200", "proxy_url": proxy.URL}
+	writeConfig(t, b, storage, config)
+	writeRole(t, b, storage, "sample", map[string]interface{}{"entity_id": testEntityID})
+
+	login := func() {
+		resp, err := doLogin(t, b, storage, map[string]interface{}{"role": "sample", "secret": testWrappedToken})
+		if err != nil || resp.IsError() {
+			t.Fatalf("unexpected error: %v, %v", err, resp)
+		}
+	}
+	login()
+	assert.Assert(t, proxied.Load() > 0)
+
+	// proxy is kept when TLS config changes
+	config["ca_cert"] = selfSignedCertificatePEM(t)
+	writeConfig(t, b, storage, config)
+	b.(*crossVaultAuthBackend).refreshTLSConfig(context.Background(), storage)
+	proxied.Store(0)
+	login()
+	assert.Assert(t, proxied.Load() > 0)
+}
+
+func TestLogin_FailOpenCachedLookups(t *testing.T) {
+	t.Parallel()
+
+	entityPath := "/v1/identity/entity/id/" + testEntityID
+	tests := map[string]struct {
+		failOpen     bool
+		rejectEntity bool
+		// failing is the upstream path failing on the second login
+		failing      string
+		failStatus   int
+		secondSource string
+		expire       bool
+		rewrite      bool
+		expectErr    bool
+	}{
+		"lookup-unreachable": {
+			failOpen: true,
+			failing:  "/v1/auth/token/lookup",
+		},
+		"lookup-unreachable-fail-closed": {
+			failing:   "/v1/auth/token/lookup",
+			expectErr: true,
+		},
+		"lookup-rejected": {
+			failOpen:   true,
+			failing:    "/v1/auth/token/lookup",
+			failStatus: http.StatusForbidden,
+			expectErr:  true,
+		},
+		"lookup-unreachable-another-source": {
+			failOpen:     true,
+			failing:      "/v1/auth/token/lookup",
+			secondSource: "hvs.another",
+			expectErr:    true,
+		},
+		"lookup-unreachable-expired": {
+			failOpen:  true,
+			failing:   "/v1/auth/token/lookup",
+			expire:    true,
+			expectErr: true,
+		},
+		"lookup-unreachable-config-rewritten": {
+			failOpen:  true,
+			failing:   "/v1/auth/token/lookup",
+			rewrite:   true,
+			expectErr: true,
+		},
+		"unwrap-unreachable": {
+			failOpen:  true,
+			failing:   "/v1/sys/wrapping/unwrap",
+			expectErr: true,
+		},
+		"entity-unreachable": {
+			failOpen:     true,
+			rejectEntity: true,
+			failing:      entityPath,
+		},
+		"entity-unreachable-fail-closed": {
+			rejectEntity: true,
+			failing:      entityPath,
+			expectErr:    true,
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			var failing atomic.Bool
+			failable := func(path string, handler http.HandlerFunc) http.HandlerFunc {
+				return func(w http.ResponseWriter, r *http.Request) {
+					if path != tCase.failing || !failing.Load() {
+						handler(w, r)
+						return
+					}
+					status := tCase.failStatus
+					if status == 0 {
+						status = http.StatusServiceUnavailable
+					}
+					jsonHandler(status, map[string]interface{}{"errors": []string{http.StatusText(status)}})(w, r)
+				}
+			}
+			lookup := lookupHandler(map[string]interface{}{"entity_id": testEntityID})
+			upstream := newTestUpstream(t, map[string]http.HandlerFunc{
+				"/v1/sys/wrapping/unwrap": failable("/v1/sys/wrapping/unwrap", func(w http.ResponseWriter, r *http.Request) {
+					if tCase.secondSource != "" && failing.Load() {
+						unwrapHandler(tCase.secondSource)(w, r)
+						return
+					}
+					unwrapHandler(testSourceToken)(w, r)
+				}),
+				"/v1/auth/token/lookup": failable("/v1/auth/token/lookup", lookup),
+				entityPath: failable(entityPath,
+					lookupHandler(map[string]interface{}{"id": testEntityID, "disabled": false})),
+			})
+			b, storage := getBackend(t)
+			config := map[string]interface{}{"cluster": upstream.URL, "fail_open_cached_lookups": tCase.failOpen}
+			writeConfig(t, b, storage, config)
+			writeRole(t, b, storage, "sample", map[string]interface{}{
+				"entity_id":              testEntityID,
+				"reject_disabled_entity": tCase.rejectEntity,
+			})
+			login := map[string]interface{}{"role": "sample", "secret": testWrappedToken, "method": WrappedTokenFull}
+
+			resp, err := doLogin(t, b, storage, login)
+			if err != nil || resp.IsError() {
+				t.Fatalf("unexpected error: %v, %v", err, resp)
+			}
+			assert.Equal(t, len(resp.Warnings), 0)
+
+			if tCase.expire {
+				backend := b.(*crossVaultAuthBackend)
+				backend.lookupCacheMu.Lock()
+				for _, entry := range backend.lookupCache {
+					entry.expiresAt = time.Now().Add(-time.Second)
+				}
+				backend.lookupCacheMu.Unlock()
+			}
+			if tCase.rewrite {
+				writeConfig(t, b, storage, config)
+			}
+			failing.Store(true)
+
+			resp, err = doLogin(t, b, storage, login)
+			if tCase.expectErr {
+				assert.Assert(t, err != nil || resp.IsError())
+				return
+			}
+			if err != nil || resp.IsError() {
+				t.Fatalf("unexpected error: %v, %v", err, resp)
+			}
+			assert.DeepEqual(t, resp.Warnings,
+				[]string{"target Vault cluster is unreachable, login was validated with cached results"})
+		})
+	}
+}
+
+func TestIsTransientUpstreamError(t *testing.T) {
+	t.Parallel()
+
+	tlsUpstream := newTestTLSUpstream(t, upstreamHandlers(map[string]interface{}{"entity_id": testEntityID}))
+	unreachable := httptest.NewServer(http.NotFoundHandler())
+	unreachable.Close()
+	requestErr := func(client *http.Client, target string) error {
+		resp, err := client.Get(target)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	tests := map[string]struct {
+		err       error
+		transient bool
+	}{
+		"connection-refused": {
+			err:       requestErr(http.DefaultClient, unreachable.URL),
+			transient: true,
+		},
+		"service-unavailable": {
+			err:       &api.ResponseError{StatusCode: http.StatusServiceUnavailable},
+			transient: true,
+		},
+		"permission-denied": {
+			err: &api.ResponseError{StatusCode: http.StatusForbidden},
+		},
+		"canceled": {
+			err: &url.Error{Op: "Get", URL: unreachable.URL, Err: context.Canceled},
+		},
+		"untrusted-certificate": {
+			err: requestErr(http.DefaultClient, tlsUpstream.URL),
+		},
+		"hostname-mismatch": {
+			err: requestErr(tlsUpstream.Client(), strings.Replace(tlsUpstream.URL, "127.0.0.1", "localhost", 1)),
+		},
+		"pinned-certificate-mismatch": {
+			err: &url.Error{Op: "Get", URL: tlsUpstream.URL, Err: tlsPinnedCertificateMismatch},
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			assert.Assert(t, tCase.err != nil)
+			assert.Equal(t, isTransientUpstreamError(tCase.err), tCase.transient, tCase.err.Error())
+		})
+	}
+}
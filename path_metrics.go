@@ -0,0 +1,125 @@
+package cva
+
+import (
+	"context"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	metricsPath = "metrics"
+
+	// metricsPrefix is the first key element of metrics emitted via go-metrics
+	metricsPrefix = "cva"
+
+	// loginMethodUnknown labels logins requesting method the plugin doesn't know
+	loginMethodUnknown = "unknown"
+
+	metricsHelpSynopsis    = "Reports in-memory counters and state of the mount"
+	metricsHelpDescription = `
+Returns snapshot of the counters emitted via go-metrics: logins by role,
+method and outcome, role cache hits, misses and invalidations, and state
+of the TLS config updater. Counters are kept in memory, so they are reset
+on plugin reload and are specific to the node serving the request. Failed
+logins of roles which don't exist are counted under empty role name.`
+)
+
+// loginCounterKey identifies login counter.
+type loginCounterKey struct {
+	role    string
+	method  string
+	outcome string
+}
+
+func (b *crossVaultAuthBackend) pathMetrics() *framework.Path {
+	return &framework.Path{
+		Pattern: metricsPath + "$",
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathMetricsRead,
+				DisplayAttrs: &framework.DisplayAttributes{
+					OperationVerb: "read",
+				},
+				Description: "returns snapshot of in-memory counters",
+			},
+		},
+		HelpSynopsis:    metricsHelpSynopsis,
+		HelpDescription: metricsHelpDescription,
+	}
+}
+
+func (b *crossVaultAuthBackend) pathMetricsRead(
+	_ context.Context,
+	_ *logical.Request,
+	_ *framework.FieldData,
+) (*logical.Response, error) {
+	logins := make(map[string]map[string]map[string]int64)
+	totals := map[string]int64{
+		loginOutcomeSuccess: 0,
+		loginOutcomeFailure: 0,
+	}
+	b.metricsMu.Lock()
+	for key, count := range b.loginCounters {
+		if logins[key.role] == nil {
+			logins[key.role] = make(map[string]map[string]int64)
+		}
+		if logins[key.role][key.method] == nil {
+			logins[key.role][key.method] = make(map[string]int64)
+		}
+		logins[key.role][key.method][key.outcome] = count
+		totals[key.outcome] += count
+	}
+	b.metricsMu.Unlock()
+
+	b.roleCacheMu.Lock()
+	cacheStats := b.roleCacheStats
+	cacheEntries := len(b.roleCache)
+	b.roleCacheMu.Unlock()
+
+	b.tlsMu.RLock()
+	var lastRefreshTime string
+	if !b.tlsConfigLastUpdate.IsZero() {
+		lastRefreshTime = b.tlsConfigLastUpdate.Format(time.RFC3339)
+	}
+	tlsUpdater := map[string]interface{}{
+		"running":            b.tlsConfigUpdateRunning,
+		"last_refresh_time":  lastRefreshTime,
+		"last_refresh_error": b.tlsConfigLastUpdateErr != nil,
+	}
+	b.tlsMu.RUnlock()
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"logins":       logins,
+			"logins_total": totals,
+			"role_cache": map[string]interface{}{
+				"hits":          cacheStats.Hits,
+				"misses":        cacheStats.Misses,
+				"invalidations": cacheStats.Invalidations,
+				"entries":       cacheEntries,
+			},
+			"tls_updater": tlsUpdater,
+		},
+	}, nil
+}
+
+// countLogin increments in-memory login counter and emits it via go-metrics. Unknown methods are
+// counted together, so counters can't grow unbounded because of arbitrary request values.
+func (b *crossVaultAuthBackend) countLogin(roleName, method, outcome string) {
+	if !isKnownLoginMethod(method) {
+		method = loginMethodUnknown
+	}
+
+	b.metricsMu.Lock()
+	b.loginCounters[loginCounterKey{role: roleName, method: method, outcome: outcome}]++
+	b.metricsMu.Unlock()
+
+	metrics.IncrCounterWithLabels([]string{metricsPrefix, "login"}, 1, []metrics.Label{
+		{Name: "role", Value: roleName},
+		{Name: "method", Value: method},
+		{Name: "outcome", Value: outcome},
+	})
+}
@@ -0,0 +1,70 @@
+package cva
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"gotest.tools/v3/assert"
+)
+
+func TestMetrics_Read(t *testing.T) {
+	t.Parallel()
+
+	upstream := newTestUpstream(t, upstreamHandlers(map[string]interface{}{"entity_id": testEntityID}))
+	b, storage := getBackend(t)
+	writeConfig(t, b, storage, map[string]interface{}{
+		"cluster":        upstream.URL,
+		"role_cache_ttl": "1m",
+	})
+	writeRole(t, b, storage, "sample", map[string]interface{}{"entity_id": testEntityID})
+	writeRole(t, b, storage, "other", map[string]interface{}{"entity_id": "99998888-7777-6666-5555-444433332222"})
+
+	logins := []map[string]interface{}{
+		{"role": "sample", "secret": testWrappedToken},
+		{"role": "sample", "secret": testWrappedToken, "method": WrappedTokenOnly},
+		{"role": "other", "secret": testWrappedToken},
+		{"role": "missing", "secret": testWrappedToken},
+		{"role": "sample", "secret": testWrappedToken, "method": "not-a-method"},
+	}
+	for _, login := range logins {
+		if _, err := doLogin(t, b, storage, login); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      metricsPath,
+		Storage:   storage,
+	})
+	if err != nil || resp.IsError() {
+		t.Fatalf("unexpected error: %v, %v", err, resp)
+	}
+
+	assert.DeepEqual(t, resp.Data["logins"], map[string]map[string]map[string]int64{
+		"sample": {
+			WrappedTokenFull:   {loginOutcomeSuccess: 1},
+			WrappedTokenOnly:   {loginOutcomeSuccess: 1},
+			loginMethodUnknown: {loginOutcomeFailure: 1},
+		},
+		"other": {
+			WrappedTokenFull: {loginOutcomeFailure: 1},
+		},
+		"": {
+			WrappedTokenFull: {loginOutcomeFailure: 1},
+		},
+	})
+	assert.DeepEqual(t, resp.Data["logins_total"], map[string]int64{
+		loginOutcomeSuccess: 2,
+		loginOutcomeFailure: 3,
+	})
+
+	cache, _ := resp.Data["role_cache"].(map[string]interface{})
+	assert.Equal(t, cache["entries"], 2)
+	assert.Assert(t, cache["hits"].(int64) > 0)
+	assert.Assert(t, cache["misses"].(int64) > 0)
+
+	tlsUpdater, _ := resp.Data["tls_updater"].(map[string]interface{})
+	assert.Equal(t, tlsUpdater["running"], false)
+}
@@ -3,13 +3,19 @@ package cva
 import (
 	"context"
 	"fmt"
+	"regexp"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/hashicorp/go-secure-stdlib/parseutil"
+	"github.com/hashicorp/go-secure-stdlib/strutil"
 	"github.com/hashicorp/go-uuid"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/helper/tokenutil"
 	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/mitchellh/mapstructure"
 	"github.com/pkg/errors"
 )
 
@@ -17,7 +23,8 @@ type contextKey string
 
 const (
 	roleListHelpSynopsis    = "List registered roles."
-	roleListHelpDescription = "The list contains roles' names."
+	roleListHelpDescription = `The list contains roles' names. With detailed=true the response also 
+contains a summary of each role, which requires an extra storage read per role.`
 
 	roleHelpSynopsis    = "Register the role"
 	roleHelpDescription = `
@@ -26,11 +33,58 @@ The role's configuration provides data which is used to ensure that
 token provided for authentication and issued by the another Vault 
 cluster is valid for authentication.`
 
+	roleSchemaHelpSynopsis    = "Describe role and config fields."
+	roleSchemaHelpDescription = `
+Returns names, types, defaults, descriptions and validation rules of the 
+fields accepted by the role and config endpoints.`
+
+	roleBulkHelpSynopsis    = "Create or update multiple roles."
+	roleBulkHelpDescription = `
+Accepts a list of role definitions, each containing role's name and the 
+same fields as role endpoint. Roles are created or updated one by one, 
+result is reported per role.`
+
 	roleNameCtxKey contextKey = "roleName"
+
+	maxBulkRoles = 100
+
+	maxDetailedListRoles = 1000
+
+	maxCachedMetaPatterns = 1000
+
+	entityMetaAnySeparator = "|"
+
+	displayNameRegexPrefix = "regex:"
+
+	policySourceAll      = "all"
+	policySourceToken    = "token"
+	policySourceIdentity = "identity"
+
+	aliasSourceRoleID   = "role_id"
+	aliasSourceEntityID = "entity_id"
+	aliasSourceAccessor = "accessor"
+
+	metaMatchModeExact = "exact"
+	metaMatchModeRegex = "regex"
+
+	maxRoleRequestTimeout = time.Minute * 5
 )
 
 var (
 	roleStorageEntryCreateFailed = errors.New("failed to create storage entry for role")
+	roleStorageEntryCorrupted    = errors.New("role storage entry is corrupted")
+	aliasNameUnknown             = errors.New("alias is named after source token accessor, which is known on login only")
+	sourceTokenAccessorMissing   = errors.New("source token has no accessor, alias can't be named after it")
+
+	roleNameRegex = regexp.MustCompile("^" + framework.GenericNameRegex("name") + "$")
+
+	// reservedRoleNames collide with the paths under role/ prefix or list semantics
+	reservedRoleNames = []string{"list", "schema"}
+
+	// reservedTokenMetadataKeys are set by login and can't be overridden by role's token_metadata
+	reservedTokenMetadataKeys = []string{
+		"role", "mapped_entity_id", "source_entity_id", "method", "source_cluster", "correlation_id",
+	}
 )
 
 type crossVaultAuthRoleEntry struct {
@@ -42,17 +96,222 @@ type crossVaultAuthRoleEntry struct {
 	// EntityID stores uuid of the entity, token being validated was issued for
 	EntityID string `json:"entity_id" mapstructure:"entity_id" structs:"entity_id"`
 
+	// EntityIDs stores uuids of further entities, tokens issued for which are accepted along with EntityID's ones
+	EntityIDs []string `json:"entity_ids" mapstructure:"entity_ids" structs:"entity_ids"`
+
 	// EntityMeta stores metadata applied to the entity in the target Vault cluster
 	EntityMeta map[string]string `json:"entity_meta" mapstructure:"entity_meta" structs:"entity_meta"`
 
 	// StrictMetaVerify defines whether metadata provided for role must be exactly
 	// the same as metadata applied to the entity in the target Vault cluster
 	StrictMetaVerify bool `json:"strict_meta_verify" mapstructure:"strict_meta_verify" structs:"strict_meta_verify"`
+
+	// StrictIgnoreExtra relaxes strict verification to tolerate upstream metadata keys not defined by the role
+	StrictIgnoreExtra bool `json:"strict_ignore_extra" mapstructure:"strict_ignore_extra" structs:"strict_ignore_extra"`
+
+	// AllowedMethods restricts login methods which can be used with the role, any method is allowed if empty
+	AllowedMethods []string `json:"allowed_methods" mapstructure:"allowed_methods" structs:"allowed_methods"`
+
+	// AllowedSourceTokenTypes restricts types of the source token accepted for login, any type is accepted if empty
+	AllowedSourceTokenTypes []string `json:"allowed_source_token_types" mapstructure:"allowed_source_token_types" structs:"allowed_source_token_types"`
+
+	// MirrorSourceOrphan defines whether issued token's orphan flag mirrors the source token's one
+	MirrorSourceOrphan bool `json:"mirror_source_orphan" mapstructure:"mirror_source_orphan" structs:"mirror_source_orphan"`
+
+	// EntityMetaAny stores metadata keys with the lists of acceptable values, upstream value must match any of them
+	EntityMetaAny map[string][]string `json:"entity_meta_any" mapstructure:"entity_meta_any" structs:"entity_meta_any"`
+
+	// SourceNamespace restricts namespace the source token must live in, any namespace is accepted if empty
+	SourceNamespace string `json:"source_namespace" mapstructure:"source_namespace" structs:"source_namespace"`
+
+	// RejectDisabledEntity defines whether upstream entity status is checked and login is rejected if it is disabled
+	RejectDisabledEntity bool `json:"reject_disabled_entity" mapstructure:"reject_disabled_entity" structs:"reject_disabled_entity"`
+
+	// RequireRenewableSource defines whether only renewable source tokens are accepted
+	RequireRenewableSource bool `json:"require_renewable_source" mapstructure:"require_renewable_source" structs:"require_renewable_source"`
+
+	// RequireNonRenewableSource defines whether only non-renewable source tokens are accepted
+	RequireNonRenewableSource bool `json:"require_non_renewable_source" mapstructure:"require_non_renewable_source" structs:"require_non_renewable_source"`
+
+	// MetaTrimWhitespace defines whether surrounding whitespace of metadata values is ignored on comparison
+	MetaTrimWhitespace bool `json:"meta_trim_whitespace" mapstructure:"meta_trim_whitespace" structs:"meta_trim_whitespace"`
+
+	// MetaKeysCaseInsensitive defines whether metadata keys are compared case-insensitively
+	MetaKeysCaseInsensitive bool `json:"meta_keys_case_insensitive" mapstructure:"meta_keys_case_insensitive" structs:"meta_keys_case_insensitive"`
+
+	// MetaMatchMode defines whether entity_meta values are compared with upstream ones exactly or as regular expressions
+	MetaMatchMode string `json:"meta_match_mode" mapstructure:"meta_match_mode" structs:"meta_match_mode"`
+
+	// RequireDualSecret defines whether login requires two independent secrets of the role's entity
+	RequireDualSecret bool `json:"require_dual_secret" mapstructure:"require_dual_secret" structs:"require_dual_secret"`
+
+	// TokenTTLJitter is the max percentage issued token's TTL is randomly reduced by
+	TokenTTLJitter int `json:"token_ttl_jitter" mapstructure:"token_ttl_jitter" structs:"token_ttl_jitter"`
+
+	// AllowEntitylessSource defines whether source tokens without entity are matched by metadata only
+	AllowEntitylessSource bool `json:"allow_entityless_source" mapstructure:"allow_entityless_source" structs:"allow_entityless_source"`
+
+	// RequiredSourceDisplayName is the glob or regex pattern source token's display name must match, if not empty
+	RequiredSourceDisplayName string `json:"required_source_display_name" mapstructure:"required_source_display_name" structs:"required_source_display_name"`
+
+	// TTLByMeta maps upstream metadata values to issued token's TTL, the first matching rule wins
+	TTLByMeta []metaTTL `json:"ttl_by_meta" mapstructure:"ttl_by_meta" structs:"ttl_by_meta"`
+
+	// RequiredSourcePolicies lists policies the source token must have, none are required if empty
+	RequiredSourcePolicies []string `json:"required_source_policies" mapstructure:"required_source_policies" structs:"required_source_policies"`
+
+	// PolicySource defines which source token's policies are compared with required ones: token's explicit
+	// policies, identity-derived ones or both
+	PolicySource string `json:"policy_source" mapstructure:"policy_source" structs:"policy_source"`
+
+	// AliasSource defines whether identity alias of issued tokens is named after role ID or mapped entity ID
+	AliasSource string `json:"alias_source" mapstructure:"alias_source" structs:"alias_source"`
+
+	// SkipMetaVerify defines whether metadata verification is skipped, so only entity ID is compared
+	SkipMetaVerify bool `json:"skip_meta_verify" mapstructure:"skip_meta_verify" structs:"skip_meta_verify"`
+
+	// GroupAliases are names of external groups issued tokens are associated with in local identity store
+	GroupAliases []string `json:"group_aliases" mapstructure:"group_aliases" structs:"group_aliases"`
+
+	// TokenPoliciesTemplate stores policy name templates rendered with source entity's metadata on login,
+	// rendered policies are added to token policies
+	TokenPoliciesTemplate []string `json:"token_policies_template" mapstructure:"token_policies_template" structs:"token_policies_template"`
+
+	// TokenMetadata stores static metadata added to issued tokens
+	TokenMetadata map[string]string `json:"token_metadata" mapstructure:"token_metadata" structs:"token_metadata"`
+
+	// RequestTimeout overrides timeout of login requests to target Vault cluster, default one if zero
+	RequestTimeout time.Duration `json:"request_timeout" mapstructure:"request_timeout" structs:"request_timeout"`
+
+	// BaseRole is the name of the role fields not set by this role are inherited from
+	BaseRole string `json:"base_role" mapstructure:"base_role" structs:"base_role"`
+
+	// Namespace overrides configured namespace requests to target Vault cluster are sent to, if not empty
+	Namespace string `json:"namespace" mapstructure:"namespace" structs:"namespace"`
+
+	// Disabled defines whether logins with the role are rejected, the role is kept along with its settings
+	Disabled bool `json:"disabled" mapstructure:"disabled" structs:"disabled"`
+
+	// ExplicitFields lists fields set by role writes, so fields explicitly set to zero value override
+	// base role's ones. Fields having non-zero value are considered set regardless of the list
+	ExplicitFields []string `json:"explicit_fields" mapstructure:"explicit_fields" structs:"explicit_fields"`
+}
+
+// policySource returns role's policy source, roles without it consider all source token's policies.
+func (r *crossVaultAuthRoleEntry) policySource() string {
+	if r.PolicySource == "" {
+		return policySourceAll
+	}
+	return r.PolicySource
+}
+
+// aliasSource returns role's alias source, roles without it name aliases after role ID.
+func (r *crossVaultAuthRoleEntry) aliasSource() string {
+	if r.AliasSource == "" {
+		return aliasSourceRoleID
+	}
+	return r.AliasSource
+}
+
+// metaMatchMode returns role's metadata match mode, roles without it compare metadata values exactly.
+func (r *crossVaultAuthRoleEntry) metaMatchMode() string {
+	if r.MetaMatchMode == "" {
+		return metaMatchModeExact
+	}
+	return r.MetaMatchMode
+}
+
+// requestTimeout returns timeout of role's login requests to target Vault cluster, roles without
+// it use the default one.
+func (r *crossVaultAuthRoleEntry) requestTimeout() time.Duration {
+	if r.RequestTimeout == time.Duration(0) {
+		return requestTimeout
+	}
+	return r.RequestTimeout
+}
+
+// aliasName returns name of identity alias of tokens issued for the role. Login and alias lookahead
+// must both use it, otherwise identity would get different aliases for the same login. Source token
+// is unknown to alias lookahead, so it is nil there and aliases named after accessor can't be named.
+func (r *crossVaultAuthRoleEntry) aliasName(source *sourceToken) (string, error) {
+	switch r.aliasSource() {
+	case aliasSourceEntityID:
+		// alias must be known before login, so all entities of the role share the alias
+		if ids := r.entityIDs(); len(ids) > 0 {
+			return ids[0], nil
+		}
+		return "", nil
+	case aliasSourceAccessor:
+		if source == nil {
+			return "", aliasNameUnknown
+		}
+		if source.Accessor == "" {
+			return "", sourceTokenAccessorMissing
+		}
+		return source.Accessor, nil
+	default:
+		return r.RoleID, nil
+	}
+}
+
+// entityIDs returns IDs of entities tokens of which the role accepts, entity_id being the first one.
+func (r *crossVaultAuthRoleEntry) entityIDs() []string {
+	if r.EntityID == "" {
+		return r.EntityIDs
+	}
+	return append([]string{r.EntityID}, r.EntityIDs...)
+}
+
+// acceptsEntity reports whether the role accepts tokens of the entity. Roles written before entity ID
+// normalization may store it in other case.
+func (r *crossVaultAuthRoleEntry) acceptsEntity(entityID string) bool {
+	return r.mappedEntityID(entityID) != ""
+}
+
+// mappedEntityID returns role's entity ID matching the entity, or empty string if the role doesn't
+// accept tokens of the entity.
+func (r *crossVaultAuthRoleEntry) mappedEntityID(entityID string) string {
+	if entityID == "" {
+		return ""
+	}
+	for _, id := range r.entityIDs() {
+		if strings.EqualFold(id, entityID) {
+			return id
+		}
+	}
+	return ""
+}
+
+// loginEntityID returns role's entity ID the login of the source token is mapped to: the one matching
+// source token's entity, or the first one for entity-less source tokens.
+func (r *crossVaultAuthRoleEntry) loginEntityID(source *sourceToken) string {
+	if id := r.mappedEntityID(source.EntityID); id != "" {
+		return id
+	}
+	if ids := r.entityIDs(); len(ids) > 0 {
+		return ids[0]
+	}
+	return ""
+}
+
+// metaTTL is the TTL of tokens issued for the source token having metadata key with the value.
+type metaTTL struct {
+	Key   string        `json:"key" mapstructure:"key" structs:"key"`
+	Value string        `json:"value" mapstructure:"value" structs:"value"`
+	TTL   time.Duration `json:"ttl" mapstructure:"ttl" structs:"ttl"`
 }
 
 func (b *crossVaultAuthBackend) pathRoleList() *framework.Path {
 	return &framework.Path{
 		Pattern: "role/?",
+		Fields: map[string]*framework.FieldSchema{
+			"detailed": {
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: fmt.Sprintf(`Flag defines whether to return summary of each role along with names. 
+Summary is returned for up to %d roles`, maxDetailedListRoles),
+			},
+		},
 		Operations: map[logical.Operation]framework.OperationHandler{
 			logical.ListOperation: &framework.PathOperation{
 				Callback: b.roleList,
@@ -72,7 +331,7 @@ func (b *crossVaultAuthBackend) pathRoleList() *framework.Path {
 func (b *crossVaultAuthBackend) roleList(
 	ctx context.Context,
 	req *logical.Request,
-	_ *framework.FieldData,
+	data *framework.FieldData,
 ) (*logical.Response, error) {
 	b.mu.RLock()
 	defer b.mu.RUnlock()
@@ -81,7 +340,196 @@ func (b *crossVaultAuthBackend) roleList(
 	if err != nil {
 		return nil, err
 	}
-	return logical.ListResponse(roles), nil
+	if detailed, _ := data.Get("detailed").(bool); !detailed {
+		return logical.ListResponse(roles), nil
+	}
+
+	keyInfo := make(map[string]interface{}, len(roles))
+	for i, name := range roles {
+		if i >= maxDetailedListRoles {
+			break
+		}
+		role, err := b.role(ctx, req.Storage, name)
+		if err != nil {
+			return nil, err
+		}
+		if role == nil {
+			continue
+		}
+		keyInfo[name] = map[string]interface{}{
+			"entity_id":          role.EntityID,
+			"strict_meta_verify": role.StrictMetaVerify,
+			"policy_count":       len(role.TokenPolicies),
+			"base_role":          role.BaseRole,
+			"disabled":           role.Disabled,
+		}
+	}
+
+	resp := logical.ListResponseWithInfo(roles, keyInfo)
+	if len(roles) > maxDetailedListRoles {
+		resp.AddWarning(fmt.Sprintf("summary is returned for the first %d roles only", maxDetailedListRoles))
+	}
+	return resp, nil
+}
+
+func (b *crossVaultAuthBackend) pathRoleBulk() *framework.Path {
+	return &framework.Path{
+		Pattern: "roles/bulk$",
+		Fields: map[string]*framework.FieldSchema{
+			"roles": {
+				Type: framework.TypeSlice,
+				Description: fmt.Sprintf(`List of role definitions, each containing 'name' and role fields. 
+Up to %d roles per request`, maxBulkRoles),
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.roleBulkWrite,
+				DisplayAttrs: &framework.DisplayAttributes{
+					OperationVerb: "write",
+					ItemType:      "Role",
+				},
+				Description: "create or update multiple role entries",
+			},
+		},
+		HelpSynopsis:    roleBulkHelpSynopsis,
+		HelpDescription: roleBulkHelpDescription,
+	}
+}
+
+func (b *crossVaultAuthBackend) roleBulkWrite(
+	ctx context.Context,
+	req *logical.Request,
+	data *framework.FieldData,
+) (*logical.Response, error) {
+	roles, _ := data.Get("roles").([]interface{})
+	if len(roles) == 0 {
+		return logical.ErrorResponse("roles must be provided"), nil
+	}
+	if len(roles) > maxBulkRoles {
+		return logical.ErrorResponse(fmt.Sprintf("up to %d roles can be written at once", maxBulkRoles)), nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	results := make([]map[string]interface{}, 0, len(roles))
+	for _, raw := range roles {
+		roleData, _ := raw.(map[string]interface{})
+		roleName, _ := roleData["name"].(string)
+		result := map[string]interface{}{"name": roleName, "success": true}
+
+		resp, err := b.roleBulkEntryWrite(ctx, req, roleName, roleData)
+		switch {
+		case resp.IsError():
+			result["success"] = false
+			result["error"] = resp.Error().Error()
+		case err != nil:
+			result["success"] = false
+			result["error"] = err.Error()
+		case resp != nil && len(resp.Warnings) > 0:
+			result["warnings"] = resp.Warnings
+		}
+		results = append(results, result)
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"results": results,
+		},
+	}, nil
+}
+
+// roleBulkEntryWrite creates or updates single role of the bulk request. Caller must hold b.mu.
+func (b *crossVaultAuthBackend) roleBulkEntryWrite(
+	ctx context.Context,
+	req *logical.Request,
+	roleName string,
+	roleData map[string]interface{},
+) (*logical.Response, error) {
+	if roleData == nil {
+		return logical.ErrorResponse("role definition must be an object"), nil
+	}
+	if !roleNameRegex.MatchString(roleName) {
+		return logical.ErrorResponse("valid role name must be specified"), nil
+	}
+
+	data := &framework.FieldData{Raw: roleData, Schema: b.pathRole().Fields}
+	if err := data.Validate(); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	role, err := b.role(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+
+	entryReq := *req
+	entryReq.Operation = logical.UpdateOperation
+	if role == nil {
+		entryReq.Operation = logical.CreateOperation
+		role = &crossVaultAuthRoleEntry{}
+	}
+
+	roleUpdCtx := context.WithValue(ctx, roleNameCtxKey, roleName)
+	return b.roleEntryUpdate(roleUpdCtx, &entryReq, data, role)
+}
+
+func (b *crossVaultAuthBackend) pathRoleSchema() *framework.Path {
+	return &framework.Path{
+		Pattern: "role/schema$",
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.roleSchemaRead,
+				DisplayAttrs: &framework.DisplayAttributes{
+					OperationVerb: "read",
+				},
+				Description: "returns role and config fields schema",
+			},
+		},
+		HelpSynopsis:    roleSchemaHelpSynopsis,
+		HelpDescription: roleSchemaHelpDescription,
+	}
+}
+
+func (b *crossVaultAuthBackend) roleSchemaRead(
+	_ context.Context,
+	_ *logical.Request,
+	_ *framework.FieldData,
+) (*logical.Response, error) {
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"role":   fieldsSchema(b.pathRole().Fields, []string{"entity_id"}, "name", "force"),
+			"config": fieldsSchema(b.pathConfig().Fields, []string{"cluster"}),
+		},
+	}, nil
+}
+
+// fieldsSchema describes provided fields. Fields listed in exclude are omitted.
+func fieldsSchema(
+	fields map[string]*framework.FieldSchema,
+	required []string,
+	exclude ...string,
+) map[string]interface{} {
+	schema := make(map[string]interface{}, len(fields))
+	for name, field := range fields {
+		if strutil.StrListContains(exclude, name) {
+			continue
+		}
+		fieldSchema := map[string]interface{}{
+			"type":        field.Type.String(),
+			"description": strings.Join(strings.Fields(field.Description), " "),
+			"required":    strutil.StrListContains(required, name),
+		}
+		if field.Default != nil {
+			fieldSchema["default"] = field.Default
+		}
+		if len(field.AllowedValues) > 0 {
+			fieldSchema["allowed_values"] = field.AllowedValues
+		}
+		schema[name] = fieldSchema
+	}
+	return schema
 }
 
 func (b *crossVaultAuthBackend) pathRole() *framework.Path {
@@ -96,19 +544,197 @@ func (b *crossVaultAuthBackend) pathRole() *framework.Path {
 				Type:        framework.TypeString,
 				Description: "Entity ID binding",
 			},
+			"entity_ids": {
+				Type: framework.TypeCommaStringSlice,
+				Description: `Further entity IDs binding, tokens of any of them and of entity_id are accepted. 
+Either entity_id or entity_ids must be provided`,
+			},
 			"entity_meta": {
 				Type:        framework.TypeKVPairs,
 				Description: "Entity metadata binding",
 			},
+			"entity_meta_any": {
+				Type: framework.TypeKVPairs,
+				Description: `Entity metadata binding with multiple acceptable values separated by '|', 
+e.g. env=prod|staging. Keys must not overlap with entity_meta`,
+			},
 			"strict_meta_verify": {
 				Type:    framework.TypeBool,
 				Default: false,
 				Description: `Flag defines whether provided entity metadata must strictly match with 
 metadata stored for target entity in target Vault cluster`,
+			},
+			"strict_ignore_extra": {
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `Flag defines whether strict verification tolerates upstream metadata keys not defined 
+by the role. Role's keys still must be present upstream with exactly the same values. Has effect only with 
+strict_meta_verify`,
+			},
+			"allowed_methods": {
+				Type: framework.TypeCommaStringSlice,
+				Description: `Login methods allowed for the role. If only one method is set, it is used 
+by default when login request omits the method`,
+			},
+			"allowed_source_token_types": {
+				Type: framework.TypeCommaStringSlice,
+				Description: `Types of the source token (service, batch) accepted for login. 
+Any type is accepted if empty`,
+			},
+			"mirror_source_orphan": {
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `Flag defines whether issued token is marked orphan only if the source token is 
+orphan. Otherwise issued tokens are always orphan`,
+			},
+			"source_namespace": {
+				Type: framework.TypeString,
+				Description: `Namespace path the source token must live in, 'root' for the root namespace. 
+Tokens from other namespaces are rejected even if entity ID matches. Any namespace is accepted if empty`,
+			},
+			"meta_trim_whitespace": {
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `Flag defines whether surrounding whitespace of role's and upstream metadata values 
+is ignored on comparison`,
+			},
+			"meta_keys_case_insensitive": {
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `Flag defines whether role's and upstream metadata keys are compared case-insensitively. 
+Keys are matched exactly if not set`,
+			},
+			"meta_match_mode": {
+				Type:    framework.TypeString,
+				Default: metaMatchModeExact,
+				Description: `Defines how entity_meta values are compared with upstream ones: 'exact' for equality, 
+'regex' for regular expressions the whole upstream value must match. entity_meta_any values are always compared 
+exactly`,
+				AllowedValues: []interface{}{metaMatchModeExact, metaMatchModeRegex},
+			},
+			"require_dual_secret": {
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `Flag defines whether login requires second independent secret (secret2), both 
+secrets must be validated against the role and belong to the same entity`,
+			},
+			"token_ttl_jitter": {
+				Type:    framework.TypeInt,
+				Default: 0,
+				Description: `Max percentage (0-100) issued token's TTL is randomly reduced by to spread expiry 
+of tokens issued together. TTL is never increased`,
+			},
+			"ttl_by_meta": {
+				Type: framework.TypeCommaStringSlice,
+				Description: `Rules in form 'key=value:ttl' mapping upstream metadata values to issued token's TTL, 
+e.g. 'tier=gold:8h,tier=silver:1h'. The first rule matching upstream metadata wins. TTL is limited by 
+token_max_ttl and system max TTL, requested login ttl is used only if it is shorter`,
+			},
+			"required_source_policies": {
+				Type: framework.TypeCommaStringSlice,
+				Description: `Policies the source token must have, which of its policies are considered is 
+defined by policy_source. None are required if empty`,
+			},
+			"policy_source": {
+				Type:    framework.TypeString,
+				Default: policySourceAll,
+				Description: `Defines which source token's policies are compared with required_source_policies: 
+'token' for explicit token policies, 'identity' for entity and group derived ones, 'all' for both`,
+				AllowedValues: []interface{}{policySourceAll, policySourceToken, policySourceIdentity},
+			},
+			"alias_source": {
+				Type:    framework.TypeString,
+				Default: aliasSourceRoleID,
+				Description: `Defines name of identity alias of issued tokens: 'role_id' for role's generated ID, 
+'entity_id' for role's entity ID, so tokens of all roles bound to the entity map to the same identity, 
+'accessor' for source token's accessor, so every source token maps to its own alias. The latter creates 
+an alias per login in identity store and rejects source tokens without accessor (batch tokens)`,
+				AllowedValues: []interface{}{aliasSourceRoleID, aliasSourceEntityID, aliasSourceAccessor},
+			},
+			"skip_meta_verify": {
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `Flag defines whether metadata verification is skipped, so only entity ID is compared. 
+Intended for temporary debugging, stored entity_meta and entity_meta_any are kept`,
+			},
+			"group_aliases": {
+				Type: framework.TypeCommaStringSlice,
+				Description: `Names of external groups issued tokens are associated with. Local identity store 
+adds the token's entity to external groups having group alias with the name on this mount`,
+			},
+			"request_timeout": {
+				Type: framework.TypeDurationSecond,
+				Description: `Timeout of login requests (unwrap, lookup) to target Vault cluster, e.g. for 
+cross-region clusters. Must not exceed 5m, 30s if not set`,
+			},
+			"token_policies_template": {
+				Type: framework.TypeCommaStringSlice,
+				Description: `Templates of policy names rendered on login with metadata of the source token's entity, 
+e.g. app-{{identity.entity.metadata.env}}, and added to token_policies. Metadata keys are normalized the same 
+way as on verification. Login fails if the entity lacks a referenced key`,
+			},
+			"token_metadata": {
+				Type: framework.TypeKVPairs,
+				Description: `Static metadata added to issued tokens, e.g. team or environment. Keys role, 
+mapped_entity_id, source_entity_id, method, source_cluster and correlation_id are reserved`,
+			},
+			"allow_entityless_source": {
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `Flag defines whether source tokens without associated entity (e.g. root tokens) are 
+accepted; such tokens are matched by role's metadata constraints only, which must be set`,
+			},
+			"required_source_display_name": {
+				Type: framework.TypeString,
+				Description: fmt.Sprintf(`Pattern source token's display name must fully match. Glob with '*' 
+wildcards, or regular expression if prefixed with '%s'. Any display name is accepted if empty`, displayNameRegexPrefix),
+			},
+			"base_role": {
+				Type: framework.TypeString,
+				Description: `Name of the role to inherit fields from. Fields not set by this role, or set to 
+zero or empty values, are taken from the base role on login. Inheritance cycles are rejected`,
+			},
+			"reject_disabled_entity": {
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `Flag defines whether login is rejected if the entity is disabled in target Vault 
+cluster. Requires read access to identity/entity/id/*`,
+			},
+			"require_renewable_source": {
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `Flag defines whether only renewable source tokens are accepted. Can't be set 
+together with require_non_renewable_source`,
+			},
+			"require_non_renewable_source": {
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `Flag defines whether only non-renewable source tokens are accepted, e.g. short-lived 
+ones. Can't be set together with require_renewable_source`,
+			},
+			"namespace": {
+				Type: framework.TypeString,
+				Description: `Enterprise only. Overrides the namespace login requests to target Vault cluster are 
+sent to. Must be listed in mount's allowed_namespaces if those are set`,
+			},
+			"disabled": {
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `Flag defines whether logins with the role are rejected, e.g. to suspend the role during 
+an incident without losing its settings`,
+			},
+			"force": {
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `Delete only. Flag defines whether the role is deleted even if other roles reference it 
+as base_role. The flag is not stored`,
 			},
 			"token_ttl": {
 				Type: framework.TypeDurationSecond,
 			},
+			"token_max_ttl": {
+				Type: framework.TypeDurationSecond,
+			},
 			"token_policies": {
 				Type: framework.TypeCommaStringSlice,
 			},
@@ -232,17 +858,51 @@ func (b *crossVaultAuthBackend) roleRead(
 		return nil, nil
 	}
 
+	return &logical.Response{
+		Data: roleResponseData(role),
+	}, nil
+}
+
+// roleResponseData returns role fields in the format of role write parameters.
+func roleResponseData(role *crossVaultAuthRoleEntry) map[string]interface{} {
 	roleData := map[string]interface{}{
-		"entity_id":          role.EntityID,
-		"entity_meta":        role.EntityMeta,
-		"strict_meta_verify": role.StrictMetaVerify,
+		"entity_id":                    role.EntityID,
+		"entity_ids":                   role.EntityIDs,
+		"entity_meta":                  role.EntityMeta,
+		"entity_meta_any":              role.EntityMetaAny,
+		"strict_meta_verify":           role.StrictMetaVerify,
+		"allowed_methods":              role.AllowedMethods,
+		"allowed_source_token_types":   role.AllowedSourceTokenTypes,
+		"mirror_source_orphan":         role.MirrorSourceOrphan,
+		"source_namespace":             role.SourceNamespace,
+		"reject_disabled_entity":       role.RejectDisabledEntity,
+		"require_renewable_source":     role.RequireRenewableSource,
+		"require_non_renewable_source": role.RequireNonRenewableSource,
+		"base_role":                    role.BaseRole,
+		"meta_trim_whitespace":         role.MetaTrimWhitespace,
+		"meta_keys_case_insensitive":   role.MetaKeysCaseInsensitive,
+		"meta_match_mode":              role.metaMatchMode(),
+		"require_dual_secret":          role.RequireDualSecret,
+		"allow_entityless_source":      role.AllowEntitylessSource,
+		"required_source_display_name": role.RequiredSourceDisplayName,
+		"token_ttl_jitter":             role.TokenTTLJitter,
+		"ttl_by_meta":                  formatTTLByMeta(role.TTLByMeta),
+		"required_source_policies":     role.RequiredSourcePolicies,
+		"policy_source":                role.policySource(),
+		"alias_source":                 role.aliasSource(),
+		"skip_meta_verify":             role.SkipMetaVerify,
+		"strict_ignore_extra":          role.StrictIgnoreExtra,
+		"group_aliases":                role.GroupAliases,
+		"token_policies_template":      role.TokenPoliciesTemplate,
+		"token_metadata":               role.TokenMetadata,
+		"request_timeout":              int64(role.requestTimeout().Seconds()),
+		"namespace":                    role.Namespace,
+		"disabled":                     role.Disabled,
 	}
 
 	role.PopulateTokenData(roleData)
 
-	return &logical.Response{
-		Data: roleData,
-	}, nil
+	return roleData
 }
 
 func (b *crossVaultAuthBackend) roleDelete(
@@ -255,13 +915,38 @@ func (b *crossVaultAuthBackend) roleDelete(
 		return logical.ErrorResponse("role name must be specified"), nil
 	}
 
+	force, _ := data.Get("force").(bool)
+
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	if err := req.Storage.Delete(ctx, fmt.Sprintf("%s/%s", rolePath, strings.ToLower(roleName))); err != nil {
+	// derived roles can't be resolved on login without their base role
+	derived, err := b.derivedRoles(ctx, req.Storage, roleName)
+	if err != nil {
 		return nil, err
 	}
-	return nil, nil
+	if len(derived) > 0 && !force {
+		return logical.ErrorResponse("roles %s reference the role as base_role, update them or set force to delete it",
+			strings.Join(derived, ", ")), nil
+	}
+
+	if err = req.Storage.Delete(ctx, fmt.Sprintf("%s/%s", rolePath, strings.ToLower(roleName))); err != nil {
+		return nil, err
+	}
+	b.invalidateRole(roleName)
+
+	b.statusMu.Lock()
+	delete(b.roleStatuses, strings.ToLower(roleName))
+	b.statusMu.Unlock()
+
+	if len(derived) == 0 {
+		return nil, nil
+	}
+	b.Logger().Warn("role referenced as base_role deleted", "role", roleName, "derived", derived)
+	resp := &logical.Response{}
+	resp.AddWarning(fmt.Sprintf("roles %s reference the deleted role as base_role and must be updated",
+		strings.Join(derived, ", ")))
+	return resp, nil
 }
 
 func (b *crossVaultAuthBackend) roleEntryUpdate(
@@ -270,38 +955,366 @@ func (b *crossVaultAuthBackend) roleEntryUpdate(
 	data *framework.FieldData,
 	role *crossVaultAuthRoleEntry,
 ) (*logical.Response, error) {
-	var (
-		entry *logical.StorageEntry
-		resp  *logical.Response
-		err   error
-	)
 	roleName, _ := ctx.Value(roleNameCtxKey).(string)
 
-	if err = role.ParseTokenFields(req, data); err != nil {
-		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	if err := validateRoleName(roleName); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
 	}
 
-	if role.TokenMaxTTL > time.Duration(0) && role.TokenTTL > role.TokenMaxTTL {
-		return logical.ErrorResponse("token_max_ttl must be greater than token_ttl"), nil
+	if err := role.ParseTokenFields(req, data); err != nil {
+		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
 	}
 
-	if role.TokenMaxTTL > b.System().MaxLeaseTTL() {
-		resp = &logical.Response{}
-		resp.AddWarning("token_max_ttl is greater than system or backend mount's max TTL, issued tokens' TTL will be truncated")
+	config, err := b.config(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		// mount's limits are not set until the configuration is written
+		config = &crossVaultAuthBackendConfig{}
+	}
+	warnings, errResp := b.parseRoleFields(req.Operation, data, config, role)
+	if errResp != nil {
+		return errResp, nil
 	}
 
-	if req.Operation == logical.CreateOperation {
+	// imported roles keep their identifiers
+	if req.Operation == logical.CreateOperation && role.RoleID == "" {
 		role.RoleID, err = uuid.GenerateUUID()
 		if err != nil {
 			return nil, err
 		}
 	}
 
+	role.ExplicitFields = explicitRoleFields(role.ExplicitFields, data)
+
+	if role.BaseRole != "" {
+		if errResp, err = b.validateRoleInheritance(ctx, req.Storage, roleName, role); errResp != nil || err != nil {
+			return errResp, err
+		}
+	}
+
+	entry, err := logical.StorageEntryJSON(fmt.Sprintf("%s/%s", rolePath, strings.ToLower(roleName)), role)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, roleStorageEntryCreateFailed
+	}
+	if err = req.Storage.Put(ctx, entry); err != nil {
+		return nil, err
+	}
+	b.invalidateRole(roleName)
+
+	if len(warnings) == 0 {
+		return nil, nil
+	}
+	resp := &logical.Response{}
+	for _, warning := range warnings {
+		resp.AddWarning(warning)
+	}
+	return resp, nil
+}
+
+// parseRoleFields parses and validates the role fields provided on write, returning the warnings or
+// the field error response.
+func (b *crossVaultAuthBackend) parseRoleFields(
+	op logical.Operation,
+	data *framework.FieldData,
+	config *crossVaultAuthBackendConfig,
+	role *crossVaultAuthRoleEntry,
+) ([]string, *logical.Response) {
+	if errResp := validateRoleTokenLimits(config, role); errResp != nil {
+		return nil, errResp
+	}
+
+	var warnings []string
+	if role.TokenMaxTTL > b.System().MaxLeaseTTL() {
+		warnings = append(warnings, "token_max_ttl is greater than system or backend mount's max TTL, "+
+			"issued tokens' TTL will be truncated")
+	}
+
+	setRoleValues(op, data, role)
+	if errResp := validateRoleModes(role); errResp != nil {
+		return nil, errResp
+	}
+	if errResp := parseRoleTokenTTLs(data, role); errResp != nil {
+		return nil, errResp
+	}
+	warning, errResp := parseRoleRequestTimeout(data, config, role)
+	if errResp != nil {
+		return nil, errResp
+	}
+	if warning != "" {
+		warnings = append(warnings, warning)
+	}
+	if errResp = parseRoleTokenTemplates(data, config, role); errResp != nil {
+		return nil, errResp
+	}
+	if errResp = b.parseRoleDisplayName(data, role); errResp != nil {
+		return nil, errResp
+	}
+	if errResp = parseRoleEntityIDs(op, data, role); errResp != nil {
+		return nil, errResp
+	}
+	if errResp = parseRoleEntityMeta(data, config, role); errResp != nil {
+		return nil, errResp
+	}
+	if errResp = b.validateRoleEntityMeta(config, role); errResp != nil {
+		return nil, errResp
+	}
+	if errResp = parseRoleAllowedValues(data, role); errResp != nil {
+		return nil, errResp
+	}
+	if errResp = validateRoleSourceConstraints(config, role); errResp != nil {
+		return nil, errResp
+	}
+	return warnings, nil
+}
+
+// validateRoleTokenLimits checks token fields of the role against each other and mount's limits.
+func validateRoleTokenLimits(config *crossVaultAuthBackendConfig, role *crossVaultAuthRoleEntry) *logical.Response {
+	if role.TokenMaxTTL > time.Duration(0) && role.TokenTTL > role.TokenMaxTTL {
+		return fieldErrorResponse("token_max_ttl", fieldErrorTTLOrder, "token_max_ttl must be greater than token_ttl")
+	}
+	if config.MaxTokenTTL > time.Duration(0) &&
+		(role.TokenTTL > config.MaxTokenTTL || role.TokenMaxTTL > config.MaxTokenTTL) {
+		return fieldErrorResponse("token_max_ttl", fieldErrorTTLOrder,
+			fmt.Sprintf("token_ttl and token_max_ttl must not exceed mount's max_token_ttl (%s)", config.MaxTokenTTL))
+	}
+	if disallowed := config.disallowedPolicies(role.TokenPolicies); len(disallowed) > 0 {
+		return fieldErrorResponse("token_policies", fieldErrorNotAllowed,
+			fmt.Sprintf("token_policies contain policies not allowed by mount's allowed_policies: %s",
+				strings.Join(disallowed, ", ")))
+	}
+	if config.MaxTokenPolicies > 0 && len(role.TokenPolicies) > config.MaxTokenPolicies {
+		return fieldErrorResponse("token_policies", fieldErrorNotAllowed,
+			fmt.Sprintf("token_policies contain %d policies, mount's max_token_policies is %d",
+				len(role.TokenPolicies), config.MaxTokenPolicies))
+	}
+	return nil
+}
+
+// setRoleValues sets the role fields provided on write which need no parsing.
+func setRoleValues(op logical.Operation, data *framework.FieldData, role *crossVaultAuthRoleEntry) {
+	flags := map[string]*bool{
+		"meta_trim_whitespace":         &role.MetaTrimWhitespace,
+		"meta_keys_case_insensitive":   &role.MetaKeysCaseInsensitive,
+		"require_dual_secret":          &role.RequireDualSecret,
+		"skip_meta_verify":             &role.SkipMetaVerify,
+		"allow_entityless_source":      &role.AllowEntitylessSource,
+		"strict_meta_verify":           &role.StrictMetaVerify,
+		"strict_ignore_extra":          &role.StrictIgnoreExtra,
+		"mirror_source_orphan":         &role.MirrorSourceOrphan,
+		"reject_disabled_entity":       &role.RejectDisabledEntity,
+		"require_renewable_source":     &role.RequireRenewableSource,
+		"require_non_renewable_source": &role.RequireNonRenewableSource,
+		"disabled":                     &role.Disabled,
+	}
+	for name, flag := range flags {
+		if value, ok := data.GetOk(name); ok {
+			*flag, _ = value.(bool)
+		}
+	}
+	if _, ok := data.GetOk("strict_meta_verify"); !ok && op == logical.CreateOperation {
+		role.StrictMetaVerify, _ = data.GetDefaultOrZero("strict_meta_verify").(bool)
+	}
+
+	// match mode, policy and alias sources aren't defaulted on write, so they can be inherited from base role
+	values := map[string]*string{
+		"meta_match_mode":  &role.MetaMatchMode,
+		"policy_source":    &role.PolicySource,
+		"alias_source":     &role.AliasSource,
+		"base_role":        &role.BaseRole,
+		"source_namespace": &role.SourceNamespace,
+		"namespace":        &role.Namespace,
+	}
+	for name, field := range values {
+		if value, ok := data.GetOk(name); ok {
+			*field, _ = value.(string)
+		}
+	}
+
+	lists := map[string]*[]string{
+		"required_source_policies": &role.RequiredSourcePolicies,
+		"group_aliases":            &role.GroupAliases,
+	}
+	for name, field := range lists {
+		if value, ok := data.GetOk(name); ok {
+			*field, _ = value.([]string)
+		}
+	}
+}
+
+// validateRoleModes checks the fields selecting one of predefined behaviors.
+func validateRoleModes(role *crossVaultAuthRoleEntry) *logical.Response {
+	switch role.MetaMatchMode {
+	case "", metaMatchModeExact, metaMatchModeRegex:
+	default:
+		return fieldErrorResponse("meta_match_mode", fieldErrorInvalidValue,
+			"meta_match_mode must be one of: exact, regex")
+	}
+	switch role.PolicySource {
+	case "", policySourceAll, policySourceToken, policySourceIdentity:
+	default:
+		return fieldErrorResponse("policy_source", fieldErrorInvalidValue,
+			"policy_source must be one of: all, token, identity")
+	}
+	switch role.AliasSource {
+	case "", aliasSourceRoleID, aliasSourceEntityID, aliasSourceAccessor:
+	default:
+		return fieldErrorResponse("alias_source", fieldErrorInvalidValue,
+			"alias_source must be one of: role_id, entity_id, accessor")
+	}
+	return nil
+}
+
+// parseRoleTokenTTLs parses the fields adjusting TTL of issued tokens.
+func parseRoleTokenTTLs(data *framework.FieldData, role *crossVaultAuthRoleEntry) *logical.Response {
+	tokenTTLJitter, ok := data.GetOk("token_ttl_jitter")
+	if ok {
+		role.TokenTTLJitter, _ = tokenTTLJitter.(int)
+		if role.TokenTTLJitter < 0 || role.TokenTTLJitter > 100 {
+			return fieldErrorResponse("token_ttl_jitter", fieldErrorOutOfRange,
+				"token_ttl_jitter must be a percentage between 0 and 100")
+		}
+	}
+
+	ttlByMeta, ok := data.GetOk("ttl_by_meta")
+	if ok {
+		var err error
+		rules, _ := ttlByMeta.([]string)
+		if role.TTLByMeta, err = parseTTLByMeta(rules); err != nil {
+			return fieldErrorResponse("ttl_by_meta", fieldErrorInvalidValue, err.Error())
+		}
+	}
+	return nil
+}
+
+// parseRoleRequestTimeout parses role's timeout of requests to target Vault cluster, returning the
+// warning if the mount's HTTP client cuts requests earlier.
+func parseRoleRequestTimeout(
+	data *framework.FieldData,
+	config *crossVaultAuthBackendConfig,
+	role *crossVaultAuthRoleEntry,
+) (string, *logical.Response) {
+	roleRequestTimeout, ok := data.GetOk("request_timeout")
+	if !ok {
+		return "", nil
+	}
+	timeout, _ := roleRequestTimeout.(int)
+	role.RequestTimeout = time.Duration(timeout) * time.Second
+	if role.RequestTimeout < time.Duration(0) || role.RequestTimeout > maxRoleRequestTimeout {
+		return "", fieldErrorResponse("request_timeout", fieldErrorOutOfRange,
+			fmt.Sprintf("request_timeout must be positive and must not exceed %s", maxRoleRequestTimeout))
+	}
+	if config.HTTPClientTimeout > time.Duration(0) && role.RequestTimeout > config.HTTPClientTimeout {
+		return fmt.Sprintf("request_timeout exceeds mount's http_client_timeout (%s), requests will be "+
+			"cut by the latter", config.HTTPClientTimeout), nil
+	}
+	return "", nil
+}
+
+// parseRoleTokenTemplates parses the fields rendered into policies and metadata of issued tokens.
+func parseRoleTokenTemplates(
+	data *framework.FieldData,
+	config *crossVaultAuthBackendConfig,
+	role *crossVaultAuthRoleEntry,
+) *logical.Response {
+	tokenPoliciesTemplate, ok := data.GetOk("token_policies_template")
+	if ok {
+		role.TokenPoliciesTemplate, _ = tokenPoliciesTemplate.([]string)
+		for _, template := range role.TokenPoliciesTemplate {
+			if err := validatePolicyTemplate(template); err != nil {
+				return fieldErrorResponse("token_policies_template", fieldErrorInvalidValue,
+					"token_policies_template: "+err.Error())
+			}
+		}
+		if config.MaxTokenPolicies > 0 &&
+			len(role.TokenPolicies)+len(role.TokenPoliciesTemplate) > config.MaxTokenPolicies {
+			return fieldErrorResponse("token_policies_template", fieldErrorNotAllowed,
+				fmt.Sprintf("token_policies and token_policies_template contain %d "+
+					"policies, mount's max_token_policies is %d",
+					len(role.TokenPolicies)+len(role.TokenPoliciesTemplate), config.MaxTokenPolicies))
+		}
+	}
+
+	tokenMetadata, ok := data.GetOk("token_metadata")
+	if ok {
+		role.TokenMetadata, _ = tokenMetadata.(map[string]string)
+		for key := range role.TokenMetadata {
+			if strutil.StrListContains(reservedTokenMetadataKeys, key) {
+				return fieldErrorResponse("token_metadata", fieldErrorInvalidValue,
+					fmt.Sprintf("token_metadata key %q is reserved", key))
+			}
+		}
+	}
+	return nil
+}
+
+// parseRoleDisplayName parses the pattern source token's display name must match. Valid pattern is cached
+// for logins.
+func (b *crossVaultAuthBackend) parseRoleDisplayName(
+	data *framework.FieldData,
+	role *crossVaultAuthRoleEntry,
+) *logical.Response {
+	requiredSourceDisplayName, ok := data.GetOk("required_source_display_name")
+	if !ok {
+		return nil
+	}
+	role.RequiredSourceDisplayName, _ = requiredSourceDisplayName.(string)
+	if _, err := b.displayNamePatterns.compile(role.RequiredSourceDisplayName); err != nil {
+		return fieldErrorResponse("required_source_display_name", fieldErrorInvalidValue,
+			fmt.Sprintf("invalid required_source_display_name: %s", err))
+	}
+	return nil
+}
+
+// parseRoleEntityIDs parses the entities of target Vault cluster the role accepts.
+func parseRoleEntityIDs(op logical.Operation, data *framework.FieldData, role *crossVaultAuthRoleEntry) *logical.Response {
 	entityID, ok := data.GetOk("entity_id")
-	if req.Operation == logical.CreateOperation && !ok {
-		return logical.ErrorResponse("entity_id must be provided"), nil
-	} else if ok {
+	entityIDs, idsOk := data.GetOk("entity_ids")
+	if op == logical.CreateOperation && !ok && !idsOk && role.BaseRole == "" {
+		return fieldErrorResponse("entity_id", fieldErrorMissingRequired, "entity_id or entity_ids must be provided")
+	}
+	if ok {
 		role.EntityID, _ = entityID.(string)
+		// entity IDs are lowercase, while operators may provide them in any case
+		role.EntityID = strings.ToLower(role.EntityID)
+	}
+	if idsOk {
+		role.EntityIDs, _ = entityIDs.([]string)
+		for i, id := range role.EntityIDs {
+			role.EntityIDs[i] = strings.ToLower(id)
+		}
+		role.EntityIDs = strutil.RemoveDuplicatesStable(role.EntityIDs, false)
+	}
+	// entity_id is not repeated in entity_ids, so each of them can be changed independently
+	if strutil.StrListContains(role.EntityIDs, role.EntityID) {
+		var otherIDs []string
+		for _, id := range role.EntityIDs {
+			if id != role.EntityID {
+				otherIDs = append(otherIDs, id)
+			}
+		}
+		role.EntityIDs = otherIDs
+	}
+	return nil
+}
+
+// parseRoleEntityMeta parses metadata constraints of the role.
+func parseRoleEntityMeta(
+	data *framework.FieldData,
+	config *crossVaultAuthBackendConfig,
+	role *crossVaultAuthRoleEntry,
+) *logical.Response {
+	if !config.AllowDuplicateMetaKeys {
+		for _, field := range []string{"entity_meta", "entity_meta_any"} {
+			if key, found := duplicateKVPairsKey(data.Raw[field]); found {
+				return fieldErrorResponse(field, fieldErrorInvalidValue,
+					fmt.Sprintf("%s contains duplicate key %q", field, key))
+			}
+		}
 	}
 
 	entityMeta, ok := data.GetOk("entity_meta")
@@ -309,22 +1322,344 @@ func (b *crossVaultAuthBackend) roleEntryUpdate(
 		role.EntityMeta, _ = entityMeta.(map[string]string)
 	}
 
-	strictMetaVerify, ok := data.GetOk("strict_meta_verify")
-	if req.Operation == logical.CreateOperation && !ok {
-		role.StrictMetaVerify, _ = data.GetDefaultOrZero("strict_meta_verify").(bool)
-	} else if ok {
-		role.StrictMetaVerify, _ = strictMetaVerify.(bool)
+	entityMetaAny, ok := data.GetOk("entity_meta_any")
+	if ok {
+		var err error
+		raw, _ := entityMetaAny.(map[string]string)
+		if role.EntityMetaAny, err = parseEntityMetaAny(raw); err != nil {
+			return fieldErrorResponse("entity_meta_any", fieldErrorInvalidValue, err.Error())
+		}
+	}
+	return nil
+}
+
+// validateRoleEntityMeta checks metadata constraints of the role against each other and mount's limits.
+func (b *crossVaultAuthBackend) validateRoleEntityMeta(
+	config *crossVaultAuthBackendConfig,
+	role *crossVaultAuthRoleEntry,
+) *logical.Response {
+	if config.MaxEntityMetaLength > 0 {
+		if key, found := oversizedEntityMetaKey(role, config.MaxEntityMetaLength); found {
+			return fieldErrorResponse("entity_meta", fieldErrorNotAllowed,
+				fmt.Sprintf("metadata key %q or its value exceeds mount's max_entity_meta_length (%d)",
+					key, config.MaxEntityMetaLength))
+		}
+	}
+	if config.RequiredMetaKeyPrefix != "" {
+		if key, found := unprefixedEntityMetaKey(role, config.RequiredMetaKeyPrefix); found {
+			return fieldErrorResponse("entity_meta", fieldErrorNotAllowed,
+				fmt.Sprintf("metadata key %q doesn't start with mount's required_meta_key_prefix %q",
+					key, config.RequiredMetaKeyPrefix))
+		}
+	}
+	for key := range role.EntityMetaAny {
+		if _, ok := role.EntityMeta[key]; ok {
+			return fieldErrorResponse("entity_meta_any", fieldErrorConflict,
+				fmt.Sprintf("key %q is defined in both entity_meta and entity_meta_any", key))
+		}
+	}
+	if role.MetaKeysCaseInsensitive {
+		if first, second, found := caseCollidingMetaKeys(role); found {
+			return fieldErrorResponse("meta_keys_case_insensitive", fieldErrorConflict,
+				fmt.Sprintf("metadata keys %q and %q differ only in case, "+
+					"they can't be used with meta_keys_case_insensitive", first, second))
+		}
+	}
+	if role.metaMatchMode() == metaMatchModeRegex {
+		if key, err := invalidMetaPattern(b.metaPatterns, role); err != nil {
+			return fieldErrorResponse("entity_meta", fieldErrorInvalidValue,
+				fmt.Sprintf("entity_meta value of key %q is not a valid regular expression: %s", key, err))
+		}
+	}
+	return nil
+}
+
+// parseRoleAllowedValues parses the lists of login methods and source token types the role accepts.
+func parseRoleAllowedValues(data *framework.FieldData, role *crossVaultAuthRoleEntry) *logical.Response {
+	allowedMethods, ok := data.GetOk("allowed_methods")
+	if ok {
+		role.AllowedMethods, _ = allowedMethods.([]string)
+		for _, method := range role.AllowedMethods {
+			if !isKnownLoginMethod(method) {
+				return fieldErrorResponse("allowed_methods", fieldErrorInvalidValue,
+					fmt.Sprintf("unknown login method %q in allowed_methods", method))
+			}
+		}
+	}
+
+	allowedSourceTokenTypes, ok := data.GetOk("allowed_source_token_types")
+	if ok {
+		role.AllowedSourceTokenTypes, _ = allowedSourceTokenTypes.([]string)
+		for _, tokenType := range role.AllowedSourceTokenTypes {
+			if tokenType != sourceTokenTypeService && tokenType != sourceTokenTypeBatch {
+				return fieldErrorResponse("allowed_source_token_types", fieldErrorInvalidValue,
+					fmt.Sprintf("unknown token type %q in allowed_source_token_types", tokenType))
+			}
+		}
+	}
+	return nil
+}
+
+// validateRoleSourceConstraints checks the constraints of source token and the namespace of the role.
+func validateRoleSourceConstraints(config *crossVaultAuthBackendConfig, role *crossVaultAuthRoleEntry) *logical.Response {
+	if role.RequireRenewableSource && role.RequireNonRenewableSource {
+		return fieldErrorResponse("require_non_renewable_source", fieldErrorConflict,
+			"require_renewable_source and require_non_renewable_source can't be both set")
+	}
+	if role.Namespace != "" && len(config.AllowedNamespaces) > 0 &&
+		!strutil.StrListContains(config.AllowedNamespaces, role.Namespace) {
+		return fieldErrorResponse("namespace", fieldErrorNotAllowed,
+			fmt.Sprintf("namespace %q is not allowed by mount's allowed_namespaces", role.Namespace))
 	}
+	return nil
+}
 
-	entry, err = logical.StorageEntryJSON(fmt.Sprintf("%s/%s", rolePath, strings.ToLower(roleName)), role)
+// validateRoleInheritance checks that the role resolved with its base roles is complete and valid.
+func (b *crossVaultAuthBackend) validateRoleInheritance(
+	ctx context.Context,
+	storage logical.Storage,
+	roleName string,
+	role *crossVaultAuthRoleEntry,
+) (*logical.Response, error) {
+	resolved, err := b.resolveRole(ctx, storage, roleName, role)
+	if errors.Is(err, baseRoleNotFound) || errors.Is(err, roleInheritanceCycle) {
+		return logical.ErrorResponse(err.Error()), nil
+	}
 	if err != nil {
 		return nil, err
 	}
-	if entry == nil {
-		return nil, roleStorageEntryCreateFailed
+	if len(resolved.entityIDs()) == 0 {
+		return fieldErrorResponse("entity_id", fieldErrorMissingRequired,
+			"entity_id or entity_ids must be provided by the role or its base roles"), nil
 	}
-	if err = req.Storage.Put(ctx, entry); err != nil {
+	// match mode and metadata may come from different roles of the chain
+	if resolved.metaMatchMode() == metaMatchModeRegex {
+		if key, err := invalidMetaPattern(b.metaPatterns, resolved); err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("entity_meta value of key %q inherited with regex "+
+				"meta_match_mode is not a valid regular expression: %s", key, err)), nil
+		}
+	}
+	return nil, nil
+}
+
+// validateRoleName rejects names reserved for paths keywords or breaking the storage path.
+func validateRoleName(name string) error {
+	if strutil.StrListContains(reservedRoleNames, strings.ToLower(name)) {
+		return fmt.Errorf("role name %q is reserved", name)
+	}
+	if strings.Contains(name, "..") {
+		return fmt.Errorf("role name %q must not contain '..'", name)
+	}
+	return nil
+}
+
+// duplicateKVPairsKey returns the first repeated key of raw framework.TypeKVPairs input. The framework
+// silently keeps the last value of repeated "key=value" pairs, while a map input can't hold duplicates.
+func duplicateKVPairsKey(raw interface{}) (string, bool) {
+	if raw == nil {
+		return "", false
+	}
+	var mapResult map[string]string
+	if err := mapstructure.WeakDecode(raw, &mapResult); err == nil {
+		return "", false
+	}
+	var pairs []string
+	if err := mapstructure.WeakDecode(raw, &pairs); err != nil {
+		return "", false
+	}
+	seen := make(map[string]struct{}, len(pairs))
+	for _, pair := range pairs {
+		key, _, _ := strings.Cut(pair, "=")
+		if _, ok := seen[key]; ok {
+			return key, true
+		}
+		seen[key] = struct{}{}
+	}
+	return "", false
+}
+
+// parseTTLByMeta parses 'key=value:ttl' rules keeping their order.
+func parseTTLByMeta(rules []string) ([]metaTTL, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	result := make([]metaTTL, 0, len(rules))
+	for _, rule := range rules {
+		key, rest, found := strings.Cut(rule, "=")
+		separator := strings.LastIndex(rest, ":")
+		if !found || key == "" || separator < 0 {
+			return nil, fmt.Errorf("ttl_by_meta rule %q must be in form 'key=value:ttl'", rule)
+		}
+		ttl, err := parseutil.ParseDurationSecond(rest[separator+1:])
+		if err != nil || ttl <= time.Duration(0) {
+			return nil, fmt.Errorf("ttl_by_meta rule %q must have positive ttl", rule)
+		}
+		result = append(result, metaTTL{Key: key, Value: rest[:separator], TTL: ttl})
+	}
+	return result, nil
+}
+
+// formatTTLByMeta formats rules the same way they are provided on write.
+func formatTTLByMeta(rules []metaTTL) []string {
+	result := make([]string, 0, len(rules))
+	for _, rule := range rules {
+		result = append(result, fmt.Sprintf("%s=%s:%s", rule.Key, rule.Value, rule.TTL))
+	}
+	return result
+}
+
+// unprefixedEntityMetaKey returns the first (in sorted order) key of role's metadata which doesn't start with prefix.
+func unprefixedEntityMetaKey(role *crossVaultAuthRoleEntry, prefix string) (string, bool) {
+	var unprefixed []string
+	for key := range role.EntityMeta {
+		if !strings.HasPrefix(key, prefix) {
+			unprefixed = append(unprefixed, key)
+		}
+	}
+	for key := range role.EntityMetaAny {
+		if !strings.HasPrefix(key, prefix) {
+			unprefixed = append(unprefixed, key)
+		}
+	}
+	if len(unprefixed) == 0 {
+		return "", false
+	}
+	sort.Strings(unprefixed)
+	return unprefixed[0], true
+}
+
+// caseCollidingMetaKeys returns the first (in sorted order) pair of role's metadata keys which differ
+// only in case.
+func caseCollidingMetaKeys(role *crossVaultAuthRoleEntry) (string, string, bool) {
+	keys := matchedMetaKeys(role)
+	seen := make(map[string]string, len(keys))
+	for _, key := range keys {
+		if other, ok := seen[strings.ToLower(key)]; ok && other != key {
+			return other, key, true
+		}
+		seen[strings.ToLower(key)] = key
+	}
+	return "", "", false
+}
+
+// oversizedEntityMetaKey returns the first (in sorted order) role's metadata key, which or which value
+// is longer than maxLength. Each acceptable value of entity_meta_any is checked separately.
+func oversizedEntityMetaKey(role *crossVaultAuthRoleEntry, maxLength int) (string, bool) {
+	var oversized []string
+	for key, value := range role.EntityMeta {
+		if len(key) > maxLength || len(value) > maxLength {
+			oversized = append(oversized, key)
+		}
+	}
+	for key, options := range role.EntityMetaAny {
+		if len(key) > maxLength {
+			oversized = append(oversized, key)
+			continue
+		}
+		for _, option := range options {
+			if len(option) > maxLength {
+				oversized = append(oversized, key)
+				break
+			}
+		}
+	}
+	if len(oversized) == 0 {
+		return "", false
+	}
+	sort.Strings(oversized)
+	return oversized[0], true
+}
+
+// parseEntityMetaAny splits '|' separated acceptable values of the metadata keys.
+func parseEntityMetaAny(raw map[string]string) (map[string][]string, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	result := make(map[string][]string, len(raw))
+	for key, value := range raw {
+		options := strings.Split(value, entityMetaAnySeparator)
+		for _, option := range options {
+			if option == "" {
+				return nil, fmt.Errorf("entity_meta_any key %q contains empty value option", key)
+			}
+		}
+		result[key] = options
+	}
+	return result, nil
+}
+
+// compileDisplayNamePattern compiles required display name pattern into anchored regular expression.
+// Pattern is a regular expression if prefixed with displayNameRegexPrefix, glob with '*' wildcards otherwise.
+func compileDisplayNamePattern(pattern string) (*regexp.Regexp, error) {
+	if expr, ok := strings.CutPrefix(pattern, displayNameRegexPrefix); ok {
+		return regexp.Compile("^(?:" + expr + ")$")
+	}
+	parts := strings.Split(pattern, "*")
+	for i := range parts {
+		parts[i] = regexp.QuoteMeta(parts[i])
+	}
+	return regexp.Compile("^" + strings.Join(parts, ".*") + "$")
+}
+
+// compileMetaPattern compiles entity_meta value of role in regex match mode into anchored regular expression,
+// so the pattern must match the whole upstream value.
+func compileMetaPattern(pattern string) (*regexp.Regexp, error) {
+	return regexp.Compile("^(?:" + pattern + ")$")
+}
+
+// metaPatternCache stores compiled patterns by their source. Patterns are compiled when roles are written,
+// the ones written on other nodes or before restart are compiled on first use.
+type metaPatternCache struct {
+	mu       sync.RWMutex
+	patterns map[string]*regexp.Regexp
+
+	compilePattern func(string) (*regexp.Regexp, error)
+}
+
+// newMetaPatternCache returns the cache of patterns compiled by compilePattern, e.g. compileMetaPattern
+// for entity_meta values or compileDisplayNamePattern for required_source_display_name.
+func newMetaPatternCache(compilePattern func(string) (*regexp.Regexp, error)) *metaPatternCache {
+	return &metaPatternCache{
+		patterns:       make(map[string]*regexp.Regexp),
+		compilePattern: compilePattern,
+	}
+}
+
+// compile returns compiled pattern, invalid patterns are not cached.
+func (c *metaPatternCache) compile(pattern string) (*regexp.Regexp, error) {
+	c.mu.RLock()
+	compiled, ok := c.patterns[pattern]
+	c.mu.RUnlock()
+	if ok {
+		return compiled, nil
+	}
+
+	compiled, err := c.compilePattern(pattern)
+	if err != nil {
 		return nil, err
 	}
-	return resp, nil
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// patterns of updated roles are never removed, so the cache is started over once it is full
+	if len(c.patterns) >= maxCachedMetaPatterns {
+		c.patterns = make(map[string]*regexp.Regexp)
+	}
+	c.patterns[pattern] = compiled
+	return compiled, nil
+}
+
+// invalidMetaPattern returns the first entity_meta key in sorted order whose value doesn't compile
+// as a regular expression. Valid patterns are cached for logins.
+func invalidMetaPattern(patterns *metaPatternCache, role *crossVaultAuthRoleEntry) (string, error) {
+	keys := make([]string, 0, len(role.EntityMeta))
+	for key := range role.EntityMeta {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if _, err := patterns.compile(role.EntityMeta[key]); err != nil {
+			return key, err
+		}
+	}
+	return "", nil
 }
@@ -0,0 +1,144 @@
+package cva
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	roleRenameHelpSynopsis    = "Renames the role."
+	roleRenameHelpDescription = `
+Stores the role under the new name and deletes the entry stored under the
+current one. All role settings are kept as is. Roles referencing the
+renamed role as base_role are not updated, they are reported in warnings
+instead.`
+)
+
+func (b *crossVaultAuthBackend) pathRoleRename() *framework.Path {
+	return &framework.Path{
+		Pattern: "role/" + framework.GenericNameRegex("name") + "/rename$",
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "The name of the role",
+			},
+			"new_name": {
+				Type:        framework.TypeString,
+				Description: "The new name of the role. The field is mandatory.",
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.roleRename,
+				DisplayAttrs: &framework.DisplayAttributes{
+					OperationVerb: "rename",
+				},
+				Description: "renames the role",
+			},
+		},
+		HelpSynopsis:    roleRenameHelpSynopsis,
+		HelpDescription: roleRenameHelpDescription,
+	}
+}
+
+func (b *crossVaultAuthBackend) roleRename(
+	ctx context.Context,
+	req *logical.Request,
+	data *framework.FieldData,
+) (*logical.Response, error) {
+	roleName, _ := data.Get("name").(string)
+	if roleName == "" {
+		return logical.ErrorResponse("role name must be specified"), nil
+	}
+	newName, _ := data.Get("new_name").(string)
+	if newName == "" {
+		return logical.ErrorResponse("'new_name' field is mandatory"), nil
+	}
+	if err := validateRoleName(newName); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if strings.EqualFold(roleName, newName) {
+		return logical.ErrorResponse("new_name must differ from the current role name"), nil
+	}
+
+	// read, write and delete are made under the same lock, so concurrent writes can't interleave
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	role, err := b.role(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return logical.ErrorResponse("role with provided name not found"), nil
+	}
+	existing, err := b.role(ctx, req.Storage, newName)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return logical.ErrorResponse("role with name %q already exists", newName), nil
+	}
+
+	derived, err := b.derivedRoles(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+
+	entry, err := logical.StorageEntryJSON(fmt.Sprintf("%s/%s", rolePath, strings.ToLower(newName)), role)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, roleStorageEntryCreateFailed
+	}
+	if err = req.Storage.Put(ctx, entry); err != nil {
+		return nil, err
+	}
+	if err = req.Storage.Delete(ctx, fmt.Sprintf("%s/%s", rolePath, strings.ToLower(roleName))); err != nil {
+		return nil, err
+	}
+	b.invalidateRole(roleName)
+	b.invalidateRole(newName)
+
+	b.statusMu.Lock()
+	delete(b.roleStatuses, strings.ToLower(roleName))
+	b.statusMu.Unlock()
+
+	b.Logger().Info("role renamed", "role", roleName, "new_name", newName)
+
+	if len(derived) == 0 {
+		return nil, nil
+	}
+	resp := &logical.Response{}
+	resp.AddWarning(fmt.Sprintf("roles %s reference the previous name as base_role and must be updated",
+		strings.Join(derived, ", ")))
+	return resp, nil
+}
+
+// derivedRoles returns names of the roles having the role with provided name as their base_role.
+func (b *crossVaultAuthBackend) derivedRoles(
+	ctx context.Context,
+	storage logical.Storage,
+	name string,
+) ([]string, error) {
+	roles, err := storage.List(ctx, rolePath+"/")
+	if err != nil {
+		return nil, err
+	}
+	var derived []string
+	for _, roleName := range roles {
+		role, err := b.role(ctx, storage, roleName)
+		if err != nil {
+			return nil, err
+		}
+		if role != nil && strings.EqualFold(role.BaseRole, name) {
+			derived = append(derived, roleName)
+		}
+	}
+	return derived, nil
+}
@@ -0,0 +1,111 @@
+package cva
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"gotest.tools/v3/assert"
+)
+
+func TestRole_Rename(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	b, storage := getBackend(t)
+	writeRole(t, b, storage, "sample", map[string]interface{}{
+		"entity_id":      testEntityID,
+		"entity_meta":    "team=core",
+		"token_ttl":      "10m",
+		"token_max_ttl":  "1h",
+		"token_policies": "test,sample",
+	})
+	writeRole(t, b, storage, "derived", map[string]interface{}{"base_role": "sample"})
+	readRole := func(name string) *logical.Response {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      rolePath + "/" + name,
+			Storage:   storage,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("unexpected error: %v, %v", err, resp)
+		}
+		return resp
+	}
+	previous := readRole("sample")
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      rolePath + "/sample/rename",
+		Data:      map[string]interface{}{"new_name": "Renamed"},
+		Storage:   storage,
+	})
+	if err != nil || resp.IsError() {
+		t.Fatalf("unexpected error: %v, %v", err, resp)
+	}
+	assert.DeepEqual(t, resp.Warnings, []string{
+		"roles derived reference the previous name as base_role and must be updated",
+	})
+
+	assert.Assert(t, readRole("sample") == nil)
+	renamed := readRole("renamed")
+	assert.DeepEqual(t, renamed.Data, previous.Data)
+	assert.Equal(t, renamed.Data["token_ttl"], int64(600))
+	assert.DeepEqual(t, renamed.Data["token_policies"], []string{"test", "sample"})
+}
+
+func TestRole_RenameErrors(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		role    string
+		newName string
+	}{
+		"role-not-found": {
+			role:    "missing",
+			newName: "renamed",
+		},
+		"target-exists": {
+			role:    "sample",
+			newName: "Other",
+		},
+		"same-name": {
+			role:    "sample",
+			newName: "SAMPLE",
+		},
+		"new-name-missing": {
+			role: "sample",
+		},
+		"new-name-reserved": {
+			role:    "sample",
+			newName: "list",
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			b, storage := getBackend(t)
+			writeRole(t, b, storage, "sample", map[string]interface{}{"entity_id": testEntityID})
+			writeRole(t, b, storage, "other", map[string]interface{}{"entity_id": testEntityID})
+
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      rolePath + "/" + tCase.role + "/rename",
+				Data:      map[string]interface{}{"new_name": tCase.newName},
+				Storage:   storage,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Assert(t, resp.IsError())
+
+			roles, err := storage.List(context.Background(), rolePath+"/")
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.DeepEqual(t, roles, []string{"other", "sample"})
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package cva
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	roleRepairHelpSynopsis    = "Removes corrupted role storage entry."
+	roleRepairHelpDescription = `
+Deletes storage entry of the role if it can't be decoded, so the role can
+be written again. Raw content of the removed entry is returned to help
+recreating the role. Entries which are decoded successfully are left intact.`
+)
+
+func (b *crossVaultAuthBackend) pathRoleRepair() *framework.Path {
+	return &framework.Path{
+		Pattern: "role/" + framework.GenericNameRegex("name") + "/repair$",
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "The name of the role",
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.roleRepair,
+				DisplayAttrs: &framework.DisplayAttributes{
+					OperationVerb: "repair",
+				},
+				Description: "deletes corrupted role storage entry",
+			},
+		},
+		HelpSynopsis:    roleRepairHelpSynopsis,
+		HelpDescription: roleRepairHelpDescription,
+	}
+}
+
+func (b *crossVaultAuthBackend) roleRepair(
+	ctx context.Context,
+	req *logical.Request,
+	data *framework.FieldData,
+) (*logical.Response, error) {
+	roleName, _ := data.Get("name").(string)
+	if roleName == "" {
+		return logical.ErrorResponse("role name must be specified"), nil
+	}
+	key := fmt.Sprintf("%s/%s", rolePath, strings.ToLower(roleName))
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	raw, err := req.Storage.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if raw == nil {
+		return logical.ErrorResponse("role with provided name not found"), nil
+	}
+	if json.Unmarshal(raw.Value, &crossVaultAuthRoleEntry{}) == nil {
+		return logical.ErrorResponse("role storage entry is not corrupted"), nil
+	}
+
+	if err = req.Storage.Delete(ctx, key); err != nil {
+		return nil, err
+	}
+	b.invalidateRole(roleName)
+
+	b.statusMu.Lock()
+	delete(b.roleStatuses, strings.ToLower(roleName))
+	b.statusMu.Unlock()
+
+	b.Logger().Warn("corrupted role storage entry deleted", "role", roleName)
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"deleted_entry": string(raw.Value),
+		},
+	}, nil
+}
@@ -0,0 +1,94 @@
+package cva
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"gotest.tools/v3/assert"
+)
+
+func TestRole_CorruptedEntry(t *testing.T) {
+	t.Parallel()
+
+	b, storage := getBackend(t)
+	ctx := context.Background()
+	if err := storage.Put(ctx, &logical.StorageEntry{
+		Key:   rolePath + "/sample",
+		Value: []byte(`{"entity_id": `),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := b.(*crossVaultAuthBackend).role(ctx, storage, "sample")
+	assert.ErrorIs(t, err, roleStorageEntryCorrupted)
+	assert.ErrorContains(t, err, `role "sample"`)
+
+	_, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      rolePath + "/sample",
+		Storage:   storage,
+	})
+	assert.ErrorContains(t, err, roleStorageEntryCorrupted.Error())
+}
+
+func TestRole_Repair(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		entry         []byte
+		expectErr     bool
+		expectDeleted bool
+	}{
+		"corrupted": {
+			entry:         []byte(`{"entity_id": `),
+			expectDeleted: true,
+		},
+		"wrong-type": {
+			entry:         []byte(`{"entity_id": 42}`),
+			expectDeleted: true,
+		},
+		"valid": {
+			entry:     []byte(`{"entity_id": "11112222-3333-4444-5555-666677778888"}`),
+			expectErr: true,
+		},
+		"missing": {
+			expectErr: true,
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			b, storage := getBackend(t)
+			ctx := context.Background()
+			key := fmt.Sprintf("%s/%s", rolePath, name)
+			if tCase.entry != nil {
+				if err := storage.Put(ctx, &logical.StorageEntry{Key: key, Value: tCase.entry}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			resp, err := b.HandleRequest(ctx, &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      key + "/repair",
+				Storage:   storage,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, resp.IsError(), tCase.expectErr)
+			if tCase.expectDeleted {
+				assert.Equal(t, resp.Data["deleted_entry"], string(tCase.entry))
+			}
+
+			entry, err := storage.Get(ctx, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, entry == nil, tCase.expectDeleted || tCase.entry == nil)
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package cva
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	roleStatusHelpSynopsis    = "Reports runtime state of the role."
+	roleStatusHelpDescription = `
+Returns result of the last verification of role's entity and the last login
+failure of the role: its type, plugin-generated message and time. Secrets and
+upstream error details are never recorded. The state is kept in memory, so it
+is reset on plugin reload and is specific to the node serving the request.`
+)
+
+func (b *crossVaultAuthBackend) pathRoleStatus() *framework.Path {
+	return &framework.Path{
+		Pattern: "role/" + framework.GenericNameRegex("name") + "/status$",
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "The name of the role",
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.roleStatusRead,
+				DisplayAttrs: &framework.DisplayAttributes{
+					OperationVerb: "read",
+				},
+				Description: "returns runtime state of the role",
+			},
+		},
+		HelpSynopsis:    roleStatusHelpSynopsis,
+		HelpDescription: roleStatusHelpDescription,
+	}
+}
+
+func (b *crossVaultAuthBackend) roleStatusRead(
+	ctx context.Context,
+	req *logical.Request,
+	data *framework.FieldData,
+) (*logical.Response, error) {
+	roleName, _ := data.Get("name").(string)
+	if roleName == "" {
+		return logical.ErrorResponse("role name must be specified"), nil
+	}
+
+	b.mu.RLock()
+	role, err := b.role(ctx, req.Storage, roleName)
+	b.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return logical.ErrorResponse("role with provided name not found"), nil
+	}
+
+	b.statusMu.RLock()
+	defer b.statusMu.RUnlock()
+
+	status, ok := b.roleStatuses[strings.ToLower(roleName)]
+	if !ok {
+		status = &roleStatus{}
+	}
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"entity_verified_at":       formatStatusTime(status.EntityVerifiedAt),
+			"entity_missing":           status.EntityMissing,
+			"last_login_error":         status.LastLoginError,
+			"last_login_error_message": status.LastLoginErrorMessage,
+			"last_login_error_at":      formatStatusTime(status.LastLoginErrorAt),
+		},
+	}, nil
+}
+
+// formatStatusTime formats time of the status event, zero time is formatted as empty string.
+func formatStatusTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
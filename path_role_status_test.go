@@ -0,0 +1,97 @@
+package cva
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"gotest.tools/v3/assert"
+)
+
+func TestRole_Status(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		handlers        map[string]http.HandlerFunc
+		login           map[string]interface{}
+		expectedType    string
+		expectedMessage string
+	}{
+		"no-failures": {},
+		"success": {
+			handlers: upstreamHandlers(map[string]interface{}{"entity_id": testEntityID}),
+			login:    map[string]interface{}{"role": "sample", "secret": testWrappedToken},
+		},
+		"validation-failed": {
+			handlers:        upstreamHandlers(map[string]interface{}{"entity_id": "99998888-7777-6666-5555-444433332222"}),
+			login:           map[string]interface{}{"role": "sample", "secret": testWrappedToken},
+			expectedType:    loginErrorValidation,
+			expectedMessage: roleValidationFailed.Error(),
+		},
+		"request-rejected": {
+			login:           map[string]interface{}{"role": "sample", "secret": testWrappedToken, "method": "unknown"},
+			expectedType:    loginErrorRejected,
+			expectedMessage: unknownLoginMethod.Error(),
+		},
+		"upstream-error": {
+			handlers: map[string]http.HandlerFunc{
+				"/v1/sys/wrapping/unwrap": jsonHandler(http.StatusBadRequest, map[string]interface{}{
+					"errors": []string{"wrapping token is not valid or does not exist"},
+				}),
+			},
+			login:        map[string]interface{}{"role": "sample", "secret": testWrappedToken},
+			expectedType: loginErrorUpstream,
+		},
+		"unknown-role": {
+			login: map[string]interface{}{"role": "other", "secret": testWrappedToken},
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			upstream := newTestUpstream(t, tCase.handlers)
+			b, storage := getBackend(t)
+			writeConfig(t, b, storage, map[string]interface{}{"cluster": upstream.URL})
+			writeRole(t, b, storage, "sample", map[string]interface{}{"entity_id": testEntityID})
+
+			if tCase.login != nil {
+				if _, err := doLogin(t, b, storage, tCase.login); err != nil && tCase.expectedType != loginErrorUpstream {
+					t.Fatal(err)
+				}
+			}
+
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.ReadOperation,
+				Path:      rolePath + "/sample/status",
+				Storage:   storage,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Assert(t, !resp.IsError())
+			assert.Equal(t, resp.Data["last_login_error"], tCase.expectedType)
+			assert.Equal(t, resp.Data["last_login_error_message"], tCase.expectedMessage)
+			assert.Equal(t, resp.Data["last_login_error_at"] != "", tCase.expectedType != "")
+			assert.Equal(t, resp.Data["entity_verified_at"], "")
+			assert.Equal(t, resp.Data["entity_missing"], false)
+		})
+	}
+}
+
+func TestRole_StatusNotFound(t *testing.T) {
+	t.Parallel()
+
+	b, storage := getBackend(t)
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      rolePath + "/missing/status",
+		Storage:   storage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Assert(t, resp.IsError())
+}
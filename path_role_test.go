@@ -3,6 +3,7 @@ package cva
 import (
 	"context"
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 
@@ -45,6 +46,134 @@ func TestRole_Write(t *testing.T) {
 				EntityID: "11112222-3333-4444-5555-666677778888",
 			},
 		},
+		"unknown-source-token-type": {
+			data: map[string]interface{}{
+				"entity_id":                  "11112222-3333-4444-5555-666677778888",
+				"allowed_source_token_types": "service,default",
+			},
+			expectErr: true,
+		},
+		"both-renewability-requirements": {
+			data: map[string]interface{}{
+				"entity_id":                    "11112222-3333-4444-5555-666677778888",
+				"require_renewable_source":     true,
+				"require_non_renewable_source": true,
+			},
+			expectErr: true,
+		},
+		"with-entity-meta-any": {
+			data: map[string]interface{}{
+				"entity_id":       "11112222-3333-4444-5555-666677778888",
+				"entity_meta_any": "env=prod|staging",
+			},
+			expectedRole: &crossVaultAuthRoleEntry{
+				TokenParams: tokenutil.TokenParams{
+					TokenType: logical.TokenTypeDefault,
+				},
+				EntityID:      "11112222-3333-4444-5555-666677778888",
+				EntityMetaAny: map[string][]string{"env": {"prod", "staging"}},
+			},
+		},
+		"entity-meta-any-empty-option": {
+			data: map[string]interface{}{
+				"entity_id":       "11112222-3333-4444-5555-666677778888",
+				"entity_meta_any": "env=prod|",
+			},
+			expectErr: true,
+		},
+		"entity-meta-any-overlap": {
+			data: map[string]interface{}{
+				"entity_id":       "11112222-3333-4444-5555-666677778888",
+				"entity_meta":     "env=prod",
+				"entity_meta_any": "env=prod|staging",
+			},
+			expectErr: true,
+		},
+		"with-ttl-by-meta": {
+			data: map[string]interface{}{
+				"entity_id":   "11112222-3333-4444-5555-666677778888",
+				"ttl_by_meta": "tier=gold:8h,url=https://a:1h",
+			},
+			expectedRole: &crossVaultAuthRoleEntry{
+				TokenParams: tokenutil.TokenParams{
+					TokenType: logical.TokenTypeDefault,
+				},
+				EntityID: "11112222-3333-4444-5555-666677778888",
+				TTLByMeta: []metaTTL{
+					{Key: "tier", Value: "gold", TTL: time.Hour * 8},
+					{Key: "url", Value: "https://a", TTL: time.Hour},
+				},
+			},
+		},
+		"ttl-by-meta-invalid-rule": {
+			data: map[string]interface{}{
+				"entity_id":   "11112222-3333-4444-5555-666677778888",
+				"ttl_by_meta": "tier:8h",
+			},
+			expectErr: true,
+		},
+		"ttl-by-meta-invalid-ttl": {
+			data: map[string]interface{}{
+				"entity_id":   "11112222-3333-4444-5555-666677778888",
+				"ttl_by_meta": "tier=gold:0s",
+			},
+			expectErr: true,
+		},
+		"unknown-policy-source": {
+			data: map[string]interface{}{
+				"entity_id":     "11112222-3333-4444-5555-666677778888",
+				"policy_source": "group",
+			},
+			expectErr: true,
+		},
+		"unknown-alias-source": {
+			data: map[string]interface{}{
+				"entity_id":    "11112222-3333-4444-5555-666677778888",
+				"alias_source": "display_name",
+			},
+			expectErr: true,
+		},
+		"invalid-display-name-regex": {
+			data: map[string]interface{}{
+				"entity_id":                    "11112222-3333-4444-5555-666677778888",
+				"required_source_display_name": "regex:token-(ci",
+			},
+			expectErr: true,
+		},
+		"with-token-metadata": {
+			data: map[string]interface{}{
+				"entity_id":      "11112222-3333-4444-5555-666677778888",
+				"token_metadata": map[string]interface{}{"team": "core", "env": "prod"},
+			},
+			expectedRole: &crossVaultAuthRoleEntry{
+				TokenParams: tokenutil.TokenParams{
+					TokenType: logical.TokenTypeDefault,
+				},
+				EntityID:      "11112222-3333-4444-5555-666677778888",
+				TokenMetadata: map[string]string{"team": "core", "env": "prod"},
+			},
+		},
+		"reserved-token-metadata-key": {
+			data: map[string]interface{}{
+				"entity_id":      "11112222-3333-4444-5555-666677778888",
+				"token_metadata": "role=other",
+			},
+			expectErr: true,
+		},
+		"negative-request-timeout": {
+			data: map[string]interface{}{
+				"entity_id":       "11112222-3333-4444-5555-666677778888",
+				"request_timeout": -1,
+			},
+			expectErr: true,
+		},
+		"request-timeout-over-limit": {
+			data: map[string]interface{}{
+				"entity_id":       "11112222-3333-4444-5555-666677778888",
+				"request_timeout": "6m",
+			},
+			expectErr: true,
+		},
 		"with-error": {
 			data: map[string]interface{}{
 				"token_ttl":      "10m",
@@ -80,6 +209,8 @@ func TestRole_Write(t *testing.T) {
 				}
 				// zeroing role id since it has generated value and assertion is not possible
 				role.RoleID = ""
+				// explicit fields are bookkeeping of role inheritance, they are covered by its tests
+				role.ExplicitFields = nil
 				assert.DeepEqual(t, role, tCase.expectedRole)
 			}
 		})
@@ -89,7 +220,11 @@ func TestRole_Write(t *testing.T) {
 func TestRole_Read(t *testing.T) {
 	t.Parallel()
 
-	var emptyMeta map[string]string
+	var (
+		emptyMeta    map[string]string
+		emptyMetaAny map[string][]string
+		emptyList    []string
+	)
 
 	tests := map[string]struct {
 		request  map[string]interface{}
@@ -100,18 +235,47 @@ func TestRole_Read(t *testing.T) {
 				"entity_id": "11112222-3333-4444-5555-666677778888",
 			},
 			response: map[string]interface{}{
-				"entity_id":               "11112222-3333-4444-5555-666677778888",
-				"entity_meta":             emptyMeta,
-				"strict_meta_verify":      false,
-				"token_bound_cidrs":       []string{},
-				"token_explicit_max_ttl":  int64(0),
-				"token_max_ttl":           int64(0),
-				"token_no_default_policy": false,
-				"token_num_uses":          0,
-				"token_period":            int64(0),
-				"token_policies":          []string{},
-				"token_ttl":               int64(0),
-				"token_type":              "default",
+				"entity_id":                    "11112222-3333-4444-5555-666677778888",
+				"entity_ids":                   emptyList,
+				"entity_meta":                  emptyMeta,
+				"entity_meta_any":              emptyMetaAny,
+				"strict_meta_verify":           false,
+				"allowed_methods":              emptyList,
+				"allowed_source_token_types":   emptyList,
+				"mirror_source_orphan":         false,
+				"source_namespace":             "",
+				"reject_disabled_entity":       false,
+				"require_renewable_source":     false,
+				"require_non_renewable_source": false,
+				"base_role":                    "",
+				"meta_trim_whitespace":         false,
+				"meta_keys_case_insensitive":   false,
+				"meta_match_mode":              "exact",
+				"require_dual_secret":          false,
+				"allow_entityless_source":      false,
+				"required_source_display_name": "",
+				"token_ttl_jitter":             0,
+				"ttl_by_meta":                  []string{},
+				"required_source_policies":     emptyList,
+				"policy_source":                "all",
+				"alias_source":                 "role_id",
+				"skip_meta_verify":             false,
+				"strict_ignore_extra":          false,
+				"group_aliases":                emptyList,
+				"token_policies_template":      emptyList,
+				"token_metadata":               emptyMeta,
+				"request_timeout":              int64(requestTimeout.Seconds()),
+				"namespace":                    "",
+				"disabled":                     false,
+				"token_bound_cidrs":            []string{},
+				"token_explicit_max_ttl":       int64(0),
+				"token_max_ttl":                int64(0),
+				"token_no_default_policy":      false,
+				"token_num_uses":               0,
+				"token_period":                 int64(0),
+				"token_policies":               []string{},
+				"token_ttl":                    int64(0),
+				"token_type":                   "default",
 			},
 		},
 		"with-token-params": {
@@ -121,18 +285,47 @@ func TestRole_Read(t *testing.T) {
 				"token_policies": "test,sample",
 			},
 			response: map[string]interface{}{
-				"entity_id":               "11112222-3333-4444-5555-666677778888",
-				"entity_meta":             emptyMeta,
-				"strict_meta_verify":      false,
-				"token_bound_cidrs":       []string{},
-				"token_explicit_max_ttl":  int64(0),
-				"token_max_ttl":           int64(0),
-				"token_no_default_policy": false,
-				"token_num_uses":          0,
-				"token_period":            int64(0),
-				"token_policies":          []string{"test", "sample"},
-				"token_ttl":               int64(600),
-				"token_type":              "default",
+				"entity_id":                    "11112222-3333-4444-5555-666677778888",
+				"entity_ids":                   emptyList,
+				"entity_meta":                  emptyMeta,
+				"entity_meta_any":              emptyMetaAny,
+				"strict_meta_verify":           false,
+				"allowed_methods":              emptyList,
+				"allowed_source_token_types":   emptyList,
+				"mirror_source_orphan":         false,
+				"source_namespace":             "",
+				"reject_disabled_entity":       false,
+				"require_renewable_source":     false,
+				"require_non_renewable_source": false,
+				"base_role":                    "",
+				"meta_trim_whitespace":         false,
+				"meta_keys_case_insensitive":   false,
+				"meta_match_mode":              "exact",
+				"require_dual_secret":          false,
+				"allow_entityless_source":      false,
+				"required_source_display_name": "",
+				"token_ttl_jitter":             0,
+				"ttl_by_meta":                  []string{},
+				"required_source_policies":     emptyList,
+				"policy_source":                "all",
+				"alias_source":                 "role_id",
+				"skip_meta_verify":             false,
+				"strict_ignore_extra":          false,
+				"group_aliases":                emptyList,
+				"token_policies_template":      emptyList,
+				"token_metadata":               emptyMeta,
+				"request_timeout":              int64(requestTimeout.Seconds()),
+				"namespace":                    "",
+				"disabled":                     false,
+				"token_bound_cidrs":            []string{},
+				"token_explicit_max_ttl":       int64(0),
+				"token_max_ttl":                int64(0),
+				"token_no_default_policy":      false,
+				"token_num_uses":               0,
+				"token_period":                 int64(0),
+				"token_policies":               []string{"test", "sample"},
+				"token_ttl":                    int64(600),
+				"token_type":                   "default",
 			},
 		},
 		"with-metadata": {
@@ -142,18 +335,47 @@ func TestRole_Read(t *testing.T) {
 				"strict_meta_verify": true,
 			},
 			response: map[string]interface{}{
-				"entity_id":               "11112222-3333-4444-5555-666677778888",
-				"entity_meta":             map[string]string{"env": "prod"},
-				"strict_meta_verify":      true,
-				"token_bound_cidrs":       []string{},
-				"token_explicit_max_ttl":  int64(0),
-				"token_max_ttl":           int64(0),
-				"token_no_default_policy": false,
-				"token_num_uses":          0,
-				"token_period":            int64(0),
-				"token_policies":          []string{},
-				"token_ttl":               int64(0),
-				"token_type":              "default",
+				"entity_id":                    "11112222-3333-4444-5555-666677778888",
+				"entity_ids":                   emptyList,
+				"entity_meta":                  map[string]string{"env": "prod"},
+				"entity_meta_any":              emptyMetaAny,
+				"strict_meta_verify":           true,
+				"allowed_methods":              emptyList,
+				"allowed_source_token_types":   emptyList,
+				"mirror_source_orphan":         false,
+				"source_namespace":             "",
+				"reject_disabled_entity":       false,
+				"require_renewable_source":     false,
+				"require_non_renewable_source": false,
+				"base_role":                    "",
+				"meta_trim_whitespace":         false,
+				"meta_keys_case_insensitive":   false,
+				"meta_match_mode":              "exact",
+				"require_dual_secret":          false,
+				"allow_entityless_source":      false,
+				"required_source_display_name": "",
+				"token_ttl_jitter":             0,
+				"ttl_by_meta":                  []string{},
+				"required_source_policies":     emptyList,
+				"policy_source":                "all",
+				"alias_source":                 "role_id",
+				"skip_meta_verify":             false,
+				"strict_ignore_extra":          false,
+				"group_aliases":                emptyList,
+				"token_policies_template":      emptyList,
+				"token_metadata":               emptyMeta,
+				"request_timeout":              int64(requestTimeout.Seconds()),
+				"namespace":                    "",
+				"disabled":                     false,
+				"token_bound_cidrs":            []string{},
+				"token_explicit_max_ttl":       int64(0),
+				"token_max_ttl":                int64(0),
+				"token_no_default_policy":      false,
+				"token_num_uses":               0,
+				"token_period":                 int64(0),
+				"token_policies":               []string{},
+				"token_ttl":                    int64(0),
+				"token_type":                   "default",
 			},
 		},
 	}
@@ -228,3 +450,1004 @@ func TestRole_Delete(t *testing.T) {
 		t.Fatal()
 	}
 }
+
+func TestRole_Schema(t *testing.T) {
+	t.Parallel()
+
+	b, storage := getBackend(t)
+	req := &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      fmt.Sprintf("%s/%s", rolePath, "schema"),
+		Storage:   storage,
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || resp.IsError() {
+		t.Fatal()
+	}
+
+	roleSchema, _ := resp.Data["role"].(map[string]interface{})
+	_, ok := roleSchema["name"]
+	assert.Assert(t, !ok)
+	assert.DeepEqual(t, roleSchema["entity_id"], map[string]interface{}{
+		"type":        "string",
+		"description": "Entity ID binding",
+		"required":    true,
+	})
+	assert.DeepEqual(t, roleSchema["strict_meta_verify"].(map[string]interface{})["default"], false)
+
+	configSchema, _ := resp.Data["config"].(map[string]interface{})
+	assert.DeepEqual(t, configSchema["cluster"].(map[string]interface{})["required"], true)
+	assert.DeepEqual(t, configSchema["method_precedence"].(map[string]interface{})["allowed_values"],
+		[]interface{}{methodPrecedenceRequest, methodPrecedenceRole})
+}
+
+func TestRole_BulkWrite(t *testing.T) {
+	t.Parallel()
+
+	b, storage := getBackend(t)
+	writeRole(t, b, storage, "existing", map[string]interface{}{
+		"entity_id": "11112222-3333-4444-5555-666677778888",
+		"token_ttl": "5m",
+	})
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/bulk",
+		Data: map[string]interface{}{
+			"roles": []interface{}{
+				map[string]interface{}{
+					"name":           "created",
+					"entity_id":      "11112222-3333-4444-5555-666677778888",
+					"token_policies": "test",
+				},
+				map[string]interface{}{
+					"name":      "existing",
+					"token_ttl": "10m",
+				},
+				map[string]interface{}{
+					"name":      "missing-entity-id",
+					"token_ttl": "10m",
+				},
+				map[string]interface{}{
+					"name":      "invalid/name",
+					"entity_id": "11112222-3333-4444-5555-666677778888",
+				},
+			},
+		},
+		Storage: storage,
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || resp.IsError() {
+		t.Fatalf("unexpected error: %v, %v", err, resp)
+	}
+
+	results, _ := resp.Data["results"].([]map[string]interface{})
+	assert.Equal(t, len(results), 4)
+	for i, success := range []bool{true, true, false, false} {
+		assert.Equal(t, results[i]["success"], success, "result %d", i)
+	}
+
+	created, err := b.(*crossVaultAuthBackend).role(context.Background(), storage, "created")
+	if err != nil || created == nil {
+		t.Fatal("role expected to be created")
+	}
+	assert.DeepEqual(t, created.TokenPolicies, []string{"test"})
+
+	existing, err := b.(*crossVaultAuthBackend).role(context.Background(), storage, "existing")
+	if err != nil || existing == nil {
+		t.Fatal("role expected to exist")
+	}
+	assert.Equal(t, existing.TokenTTL, time.Minute*10)
+	assert.Equal(t, existing.EntityID, "11112222-3333-4444-5555-666677778888")
+
+	missing, err := b.(*crossVaultAuthBackend).role(context.Background(), storage, "missing-entity-id")
+	if err != nil || missing != nil {
+		t.Fatal("role expected to be rejected")
+	}
+}
+
+func TestRole_BulkWriteLimit(t *testing.T) {
+	t.Parallel()
+
+	roles := make([]interface{}, maxBulkRoles+1)
+	for i := range roles {
+		roles[i] = map[string]interface{}{
+			"name":      fmt.Sprintf("role-%d", i),
+			"entity_id": "11112222-3333-4444-5555-666677778888",
+		}
+	}
+
+	b, storage := getBackend(t)
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/bulk",
+		Data:      map[string]interface{}{"roles": roles},
+		Storage:   storage,
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Assert(t, resp.IsError())
+}
+
+func TestRole_ReservedNames(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]bool{
+		"list":            true,
+		"LIST":            true,
+		"schema":          true,
+		"double..dot":     true,
+		"listing":         false,
+		"my-schema":       false,
+		"team.app-region": false,
+	}
+
+	for n, tc := range tests {
+		name, expectErr := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			b, storage := getBackend(t)
+			// bulk endpoint is used since role/schema is routed to the schema endpoint
+			req := &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      "roles/bulk",
+				Data: map[string]interface{}{
+					"roles": []interface{}{
+						map[string]interface{}{
+							"name":      name,
+							"entity_id": "11112222-3333-4444-5555-666677778888",
+						},
+					},
+				},
+				Storage: storage,
+			}
+			resp, err := b.HandleRequest(context.Background(), req)
+			if err != nil || resp.IsError() {
+				t.Fatalf("unexpected error: %v, %v", err, resp)
+			}
+			results, _ := resp.Data["results"].([]map[string]interface{})
+			assert.Equal(t, results[0]["success"], !expectErr)
+
+			req = &logical.Request{
+				Operation: logical.CreateOperation,
+				Path:      fmt.Sprintf("%s/%s", rolePath, name),
+				Data:      map[string]interface{}{"entity_id": "11112222-3333-4444-5555-666677778888"},
+				Storage:   storage,
+			}
+			resp, err = b.HandleRequest(context.Background(), req)
+			if name != "schema" {
+				assert.Equal(t, err != nil || resp.IsError(), expectErr)
+			}
+		})
+	}
+}
+
+func TestRole_MaxTokenTTL(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		data          map[string]interface{}
+		expectErr     bool
+		expectWarning bool
+	}{
+		"within-ceiling": {
+			data: map[string]interface{}{"token_ttl": "1h", "token_max_ttl": "2h"},
+		},
+		"token-ttl-exceeds-ceiling": {
+			data:      map[string]interface{}{"token_ttl": "72h"},
+			expectErr: true,
+		},
+		"token-max-ttl-exceeds-ceiling": {
+			data:      map[string]interface{}{"token_ttl": "1h", "token_max_ttl": "72h"},
+			expectErr: true,
+		},
+		"exceeds-system-max-within-ceiling": {
+			data:          map[string]interface{}{"token_ttl": "1h", "token_max_ttl": "30h"},
+			expectWarning: true,
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			b, storage := getBackend(t)
+			writeConfig(t, b, storage, map[string]interface{}{
+				"cluster":       "http://127.0.0.1:8200",
+				"max_token_ttl": "48h",
+			})
+
+			tCase.data["entity_id"] = "11112222-3333-4444-5555-666677778888"
+			req := &logical.Request{
+				Operation: logical.CreateOperation,
+				Path:      fmt.Sprintf("%s/%s", rolePath, name),
+				Data:      tCase.data,
+				Storage:   storage,
+			}
+			resp, err := b.HandleRequest(context.Background(), req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, resp.IsError(), tCase.expectErr)
+			assert.Equal(t, resp != nil && len(resp.Warnings) > 0, tCase.expectWarning)
+		})
+	}
+}
+
+func TestRole_AllowedPolicies(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		policies  string
+		expectErr bool
+	}{
+		"no-policies":         {},
+		"allowed-policies":    {policies: "reader,writer"},
+		"disallowed-policies": {policies: "reader,admin", expectErr: true},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			b, storage := getBackend(t)
+			writeConfig(t, b, storage, map[string]interface{}{
+				"cluster":          "http://127.0.0.1:8200",
+				"allowed_policies": "reader,writer",
+			})
+
+			req := &logical.Request{
+				Operation: logical.CreateOperation,
+				Path:      fmt.Sprintf("%s/%s", rolePath, name),
+				Data: map[string]interface{}{
+					"entity_id":      "11112222-3333-4444-5555-666677778888",
+					"token_policies": tCase.policies,
+				},
+				Storage: storage,
+			}
+			resp, err := b.HandleRequest(context.Background(), req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, resp.IsError(), tCase.expectErr)
+		})
+	}
+}
+
+func TestRole_DuplicateMetaKeys(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		data         map[string]interface{}
+		allow        bool
+		expectErr    bool
+		expectedMeta map[string]string
+	}{
+		"unique-keys": {
+			data:         map[string]interface{}{"entity_meta": []interface{}{"env=prod", "team=core"}},
+			expectedMeta: map[string]string{"env": "prod", "team": "core"},
+		},
+		"map-input": {
+			data:         map[string]interface{}{"entity_meta": map[string]interface{}{"env": "prod"}},
+			expectedMeta: map[string]string{"env": "prod"},
+		},
+		"duplicate-keys": {
+			data:      map[string]interface{}{"entity_meta": []interface{}{"env=prod", "env=dev"}},
+			expectErr: true,
+		},
+		"duplicate-keys-any": {
+			data:      map[string]interface{}{"entity_meta_any": []interface{}{"env=prod|staging", "env=dev"}},
+			expectErr: true,
+		},
+		"duplicate-keys-allowed": {
+			data:         map[string]interface{}{"entity_meta": []interface{}{"env=prod", "env=dev"}},
+			allow:        true,
+			expectedMeta: map[string]string{"env": "dev"},
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			b, storage := getBackend(t)
+			writeConfig(t, b, storage, map[string]interface{}{
+				"cluster":                   "http://127.0.0.1:8200",
+				"allow_duplicate_meta_keys": tCase.allow,
+			})
+
+			tCase.data["entity_id"] = "11112222-3333-4444-5555-666677778888"
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.CreateOperation,
+				Path:      fmt.Sprintf("%s/%s", rolePath, name),
+				Data:      tCase.data,
+				Storage:   storage,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, resp.IsError(), tCase.expectErr)
+			if !tCase.expectErr {
+				role, err := b.(*crossVaultAuthBackend).role(context.Background(), storage, name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				assert.DeepEqual(t, role.EntityMeta, tCase.expectedMeta)
+			}
+		})
+	}
+}
+
+func TestRole_MaxEntityMetaLength(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		maxLength   interface{}
+		data        map[string]interface{}
+		expectedKey string
+	}{
+		"value-at-limit": {
+			maxLength: 8,
+			data:      map[string]interface{}{"entity_meta": "env=" + strings.Repeat("v", 8)},
+		},
+		"value-over-limit": {
+			maxLength:   8,
+			data:        map[string]interface{}{"entity_meta": "env=" + strings.Repeat("v", 9)},
+			expectedKey: "env",
+		},
+		"key-at-limit": {
+			maxLength: 8,
+			data:      map[string]interface{}{"entity_meta": strings.Repeat("k", 8) + "=prod"},
+		},
+		"key-over-limit": {
+			maxLength:   8,
+			data:        map[string]interface{}{"entity_meta": strings.Repeat("k", 9) + "=prod"},
+			expectedKey: strings.Repeat("k", 9),
+		},
+		"any-option-over-limit": {
+			maxLength:   8,
+			data:        map[string]interface{}{"entity_meta_any": "env=prod|" + strings.Repeat("v", 9)},
+			expectedKey: "env",
+		},
+		"default-limit": {
+			data:        map[string]interface{}{"entity_meta": "env=" + strings.Repeat("v", defaultMaxEntityMetaLength+1)},
+			expectedKey: "env",
+		},
+		"no-limit": {
+			maxLength: 0,
+			data:      map[string]interface{}{"entity_meta": "env=" + strings.Repeat("v", defaultMaxEntityMetaLength+1)},
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			b, storage := getBackend(t)
+			config := map[string]interface{}{"cluster": "http://127.0.0.1:8200"}
+			if tCase.maxLength != nil {
+				config["max_entity_meta_length"] = tCase.maxLength
+			}
+			writeConfig(t, b, storage, config)
+
+			tCase.data["entity_id"] = "11112222-3333-4444-5555-666677778888"
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.CreateOperation,
+				Path:      fmt.Sprintf("%s/%s", rolePath, name),
+				Data:      tCase.data,
+				Storage:   storage,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, resp.IsError(), tCase.expectedKey != "")
+			if tCase.expectedKey != "" {
+				assert.ErrorContains(t, resp.Error(), fmt.Sprintf("%q", tCase.expectedKey))
+			}
+		})
+	}
+}
+
+func TestRole_RequiredMetaKeyPrefix(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		prefix      string
+		data        map[string]interface{}
+		expectedKey string
+	}{
+		"compliant": {
+			prefix: "teamA/",
+			data: map[string]interface{}{
+				"entity_meta":     map[string]interface{}{"teamA/env": "prod", "teamA/tier": "backend"},
+				"entity_meta_any": "teamA/region=eu|us",
+			},
+		},
+		"non-compliant": {
+			prefix:      "teamA/",
+			data:        map[string]interface{}{"entity_meta": map[string]interface{}{"teamA/env": "prod", "tier": "backend"}},
+			expectedKey: "tier",
+		},
+		"other-team": {
+			prefix:      "teamA/",
+			data:        map[string]interface{}{"entity_meta": "teamB/env=prod"},
+			expectedKey: "teamB/env",
+		},
+		"any-non-compliant": {
+			prefix:      "teamA/",
+			data:        map[string]interface{}{"entity_meta_any": "region=eu|us"},
+			expectedKey: "region",
+		},
+		"not-enforced": {
+			data: map[string]interface{}{"entity_meta": "env=prod"},
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			b, storage := getBackend(t)
+			writeConfig(t, b, storage, map[string]interface{}{
+				"cluster":                  "http://127.0.0.1:8200",
+				"required_meta_key_prefix": tCase.prefix,
+			})
+
+			tCase.data["entity_id"] = "11112222-3333-4444-5555-666677778888"
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.CreateOperation,
+				Path:      fmt.Sprintf("%s/%s", rolePath, name),
+				Data:      tCase.data,
+				Storage:   storage,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, resp.IsError(), tCase.expectedKey != "")
+			if tCase.expectedKey != "" {
+				assert.ErrorContains(t, resp.Error(), fmt.Sprintf("%q", tCase.expectedKey))
+			}
+		})
+	}
+}
+
+func TestRole_MetaKeysCaseInsensitive(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		insensitive bool
+		data        map[string]interface{}
+		expectErr   bool
+	}{
+		"distinct-keys": {
+			insensitive: true,
+			data:        map[string]interface{}{"entity_meta": map[string]interface{}{"team": "core", "env": "prod"}},
+		},
+		"keys-differ-in-case": {
+			insensitive: true,
+			data:        map[string]interface{}{"entity_meta": map[string]interface{}{"env": "prod", "Env": "prod"}},
+			expectErr:   true,
+		},
+		"any-keys-differ-in-case": {
+			insensitive: true,
+			data: map[string]interface{}{
+				"entity_meta":     "env=prod",
+				"entity_meta_any": "ENV=prod|staging",
+			},
+			expectErr: true,
+		},
+		"keys-differ-in-case-sensitive": {
+			data: map[string]interface{}{"entity_meta": map[string]interface{}{"env": "prod", "Env": "prod"}},
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			b, storage := getBackend(t)
+			writeConfig(t, b, storage, map[string]interface{}{"cluster": "http://127.0.0.1:8200"})
+
+			tCase.data["entity_id"] = "11112222-3333-4444-5555-666677778888"
+			tCase.data["meta_keys_case_insensitive"] = tCase.insensitive
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.CreateOperation,
+				Path:      fmt.Sprintf("%s/%s", rolePath, name),
+				Data:      tCase.data,
+				Storage:   storage,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, resp.IsError(), tCase.expectErr)
+		})
+	}
+}
+
+func TestRole_MaxTokenPolicies(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		maxPolicies interface{}
+		policies    int
+		expectErr   bool
+	}{
+		"at-limit": {
+			maxPolicies: 3,
+			policies:    3,
+		},
+		"over-limit": {
+			maxPolicies: 3,
+			policies:    4,
+			expectErr:   true,
+		},
+		"default-at-limit": {
+			policies: defaultMaxTokenPolicies,
+		},
+		"default-over-limit": {
+			policies:  defaultMaxTokenPolicies + 1,
+			expectErr: true,
+		},
+		"no-limit": {
+			maxPolicies: 0,
+			policies:    defaultMaxTokenPolicies + 1,
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			b, storage := getBackend(t)
+			config := map[string]interface{}{"cluster": "http://127.0.0.1:8200"}
+			if tCase.maxPolicies != nil {
+				config["max_token_policies"] = tCase.maxPolicies
+			}
+			writeConfig(t, b, storage, config)
+
+			policies := make([]string, 0, tCase.policies)
+			for i := 0; i < tCase.policies; i++ {
+				policies = append(policies, fmt.Sprintf("policy-%d", i))
+			}
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.CreateOperation,
+				Path:      fmt.Sprintf("%s/%s", rolePath, name),
+				Data: map[string]interface{}{
+					"entity_id":      "11112222-3333-4444-5555-666677778888",
+					"token_policies": policies,
+				},
+				Storage: storage,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, resp.IsError(), tCase.expectErr)
+			if tCase.expectErr {
+				assert.ErrorContains(t, resp.Error(), fmt.Sprintf("contain %d policies", tCase.policies))
+			}
+		})
+	}
+}
+
+func TestRole_PoliciesTemplate(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		templates interface{}
+		expectErr string
+	}{
+		"valid": {
+			templates: []string{"app-{{identity.entity.metadata.env}}", "{{ identity.entity.metadata.team }}-ro"},
+		},
+		"comma-separated": {
+			templates: "app-{{identity.entity.metadata.env}},team-{{identity.entity.metadata.team}}",
+		},
+		"no-placeholder": {
+			templates: []string{"app-static"},
+			expectErr: "has no {{identity.entity.metadata.<key>}} placeholder",
+		},
+		"unsupported-placeholder": {
+			templates: []string{"{{identity.entity.metadata.env}}-{{identity.entity.id}}"},
+			expectErr: "unsupported placeholder",
+		},
+		"unbalanced-braces": {
+			templates: []string{"app-{{identity.entity.metadata.env}}}}"},
+			expectErr: "unsupported placeholder",
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			b, storage := getBackend(t)
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.CreateOperation,
+				Path:      fmt.Sprintf("%s/%s", rolePath, name),
+				Data: map[string]interface{}{
+					"entity_id":               "11112222-3333-4444-5555-666677778888",
+					"token_policies_template": tCase.templates,
+				},
+				Storage: storage,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, resp.IsError(), tCase.expectErr != "")
+			if tCase.expectErr != "" {
+				assert.ErrorContains(t, resp.Error(), tCase.expectErr)
+			}
+		})
+	}
+}
+
+func TestRole_EntityIDs(t *testing.T) {
+	t.Parallel()
+
+	const (
+		firstEntityID  = "aaaabbbb-cccc-dddd-eeee-ffff00001111"
+		secondEntityID = "aaaabbbb-cccc-dddd-eeee-ffff00002222"
+	)
+
+	tests := map[string]struct {
+		data        map[string]interface{}
+		expectedID  string
+		expectedIDs []string
+		expectErr   string
+	}{
+		"entity-ids-only": {
+			data:        map[string]interface{}{"entity_ids": []string{firstEntityID, secondEntityID}},
+			expectedIDs: []string{firstEntityID, secondEntityID},
+		},
+		"normalized": {
+			data: map[string]interface{}{
+				"entity_id":  testEntityID,
+				"entity_ids": []string{strings.ToUpper(firstEntityID), firstEntityID, strings.ToUpper(testEntityID)},
+			},
+			expectedID:  testEntityID,
+			expectedIDs: []string{firstEntityID},
+		},
+		"comma-separated": {
+			data:        map[string]interface{}{"entity_ids": firstEntityID + "," + secondEntityID},
+			expectedIDs: []string{firstEntityID, secondEntityID},
+		},
+		"non-uuid-entity-id": {
+			data:       map[string]interface{}{"entity_id": "Sample-Entity"},
+			expectedID: "sample-entity",
+		},
+		"non-uuid-entity-ids": {
+			data:        map[string]interface{}{"entity_ids": []string{firstEntityID, "Sample-Entity"}},
+			expectedIDs: []string{firstEntityID, "sample-entity"},
+		},
+		"missing": {
+			data:      map[string]interface{}{"token_policies": "default"},
+			expectErr: "entity_id or entity_ids must be provided",
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			b, storage := getBackend(t)
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.CreateOperation,
+				Path:      fmt.Sprintf("%s/%s", rolePath, name),
+				Data:      tCase.data,
+				Storage:   storage,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, resp.IsError(), tCase.expectErr != "")
+			if tCase.expectErr != "" {
+				assert.ErrorContains(t, resp.Error(), tCase.expectErr)
+				return
+			}
+
+			role, err := b.(*crossVaultAuthBackend).role(context.Background(), storage, name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, role.EntityID, tCase.expectedID)
+			assert.DeepEqual(t, role.EntityIDs, tCase.expectedIDs)
+		})
+	}
+}
+
+func TestRole_MetaMatchMode(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		mode      string
+		meta      map[string]interface{}
+		expectErr string
+	}{
+		"regex": {
+			mode: metaMatchModeRegex,
+			meta: map[string]interface{}{"app": `payments-v1\.\d+\.\d+`},
+		},
+		"invalid-pattern-exact": {
+			mode: metaMatchModeExact,
+			meta: map[string]interface{}{"app": "payments-(v1"},
+		},
+		"invalid-pattern-regex": {
+			mode:      metaMatchModeRegex,
+			meta:      map[string]interface{}{"app": "payments-(v1"},
+			expectErr: `entity_meta value of key "app" is not a valid regular expression`,
+		},
+		"unknown-mode": {
+			mode:      "glob",
+			meta:      map[string]interface{}{"app": "payments-*"},
+			expectErr: "meta_match_mode must be one of: exact, regex",
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			b, storage := getBackend(t)
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.CreateOperation,
+				Path:      fmt.Sprintf("%s/%s", rolePath, name),
+				Data: map[string]interface{}{
+					"entity_id":       "11112222-3333-4444-5555-666677778888",
+					"entity_meta":     tCase.meta,
+					"meta_match_mode": tCase.mode,
+				},
+				Storage: storage,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, resp.IsError(), tCase.expectErr != "")
+			if tCase.expectErr != "" {
+				assert.ErrorContains(t, resp.Error(), tCase.expectErr)
+			}
+		})
+	}
+}
+
+func TestRole_MetaMatchModeInherited(t *testing.T) {
+	t.Parallel()
+
+	b, storage := getBackend(t)
+	writeRole(t, b, storage, "base", map[string]interface{}{
+		"entity_id":       "11112222-3333-4444-5555-666677778888",
+		"meta_match_mode": metaMatchModeRegex,
+	})
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      fmt.Sprintf("%s/%s", rolePath, "derived"),
+		Data: map[string]interface{}{
+			"base_role":   "base",
+			"entity_meta": map[string]interface{}{"app": "payments-(v1"},
+		},
+		Storage: storage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Assert(t, resp.IsError())
+	assert.ErrorContains(t, resp.Error(), "inherited with regex meta_match_mode")
+}
+
+func TestRole_FieldErrors(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		config        map[string]interface{}
+		data          map[string]interface{}
+		expectedField string
+		expectedCode  string
+	}{
+		"missing-entity-id": {
+			data:          map[string]interface{}{"token_ttl": "10m"},
+			expectedField: "entity_id",
+			expectedCode:  fieldErrorMissingRequired,
+		},
+		"unknown-meta-match-mode": {
+			data:          map[string]interface{}{"entity_id": "sample-entity", "meta_match_mode": "glob"},
+			expectedField: "meta_match_mode",
+			expectedCode:  fieldErrorInvalidValue,
+		},
+		"jitter-over-percentage": {
+			data:          map[string]interface{}{"entity_id": "sample-entity", "token_ttl_jitter": 101},
+			expectedField: "token_ttl_jitter",
+			expectedCode:  fieldErrorOutOfRange,
+		},
+		"renewable-and-non-renewable": {
+			data: map[string]interface{}{
+				"entity_id":                    "sample-entity",
+				"require_renewable_source":     true,
+				"require_non_renewable_source": true,
+			},
+			expectedField: "require_non_renewable_source",
+			expectedCode:  fieldErrorConflict,
+		},
+		"namespace-not-allowed": {
+			config:        map[string]interface{}{"allowed_namespaces": "team-a"},
+			data:          map[string]interface{}{"entity_id": "sample-entity", "namespace": "team-b"},
+			expectedField: "namespace",
+			expectedCode:  fieldErrorNotAllowed,
+		},
+		"ttl-over-mount-max": {
+			config: map[string]interface{}{"max_token_ttl": "1h"},
+			data: map[string]interface{}{
+				"entity_id":     "11112222-3333-4444-5555-666677778888",
+				"token_max_ttl": "2h",
+			},
+			expectedField: "token_max_ttl",
+			expectedCode:  fieldErrorTTLOrder,
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			b, storage := getBackend(t)
+			if tCase.config != nil {
+				tCase.config["cluster"] = "http://127.0.0.1:8200"
+				writeConfig(t, b, storage, tCase.config)
+			}
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.CreateOperation,
+				Path:      fmt.Sprintf("%s/%s", rolePath, name),
+				Data:      tCase.data,
+				Storage:   storage,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Assert(t, resp.IsError())
+			details, ok := resp.Data["data"].(map[string]interface{})
+			assert.Assert(t, ok)
+			assert.Equal(t, details["field"], tCase.expectedField)
+			assert.Equal(t, details["code"], tCase.expectedCode)
+			assert.Equal(t, details["message"], resp.Error().Error())
+		})
+	}
+}
+
+func TestRole_AllowedNamespaces(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		allowed   string
+		namespace string
+		expectErr bool
+	}{
+		"not-overridden": {
+			allowed: "team-a,team-b",
+		},
+		"allowed": {
+			allowed:   "team-a,team-b",
+			namespace: "team-b",
+		},
+		"disallowed": {
+			allowed:   "team-a,team-b",
+			namespace: "team-c",
+			expectErr: true,
+		},
+		"not-limited": {
+			namespace: "team-c",
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			b, storage := getBackend(t)
+			writeConfig(t, b, storage, map[string]interface{}{
+				"cluster":            "http://127.0.0.1:8200",
+				"allowed_namespaces": tCase.allowed,
+			})
+
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.CreateOperation,
+				Path:      fmt.Sprintf("%s/%s", rolePath, name),
+				Data: map[string]interface{}{
+					"entity_id": "11112222-3333-4444-5555-666677778888",
+					"namespace": tCase.namespace,
+				},
+				Storage: storage,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, resp.IsError(), tCase.expectErr)
+		})
+	}
+}
+
+func TestRole_TokenTTLJitter(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		jitter    int
+		expectErr bool
+	}{
+		"zero":     {jitter: 0},
+		"valid":    {jitter: 25},
+		"full":     {jitter: 100},
+		"negative": {jitter: -1, expectErr: true},
+		"too-big":  {jitter: 101, expectErr: true},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			b, storage := getBackend(t)
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.CreateOperation,
+				Path:      fmt.Sprintf("%s/%s", rolePath, name),
+				Data: map[string]interface{}{
+					"entity_id":        "11112222-3333-4444-5555-666677778888",
+					"token_ttl_jitter": tCase.jitter,
+				},
+				Storage: storage,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, resp.IsError(), tCase.expectErr)
+		})
+	}
+}
+
+func TestRole_ListDetailed(t *testing.T) {
+	t.Parallel()
+
+	b, storage := getBackend(t)
+	writeRole(t, b, storage, "first", map[string]interface{}{
+		"entity_id":          "11112222-3333-4444-5555-666677778888",
+		"strict_meta_verify": true,
+		"token_policies":     "reader,writer",
+	})
+	writeRole(t, b, storage, "second", map[string]interface{}{
+		"base_role": "first",
+		"disabled":  true,
+	})
+
+	list := func(data map[string]interface{}) *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ListOperation,
+			Path:      rolePath + "/",
+			Data:      data,
+			Storage:   storage,
+		})
+		if err != nil || resp.IsError() {
+			t.Fatalf("unexpected error: %v, %v", err, resp)
+		}
+		return resp
+	}
+
+	plain := list(nil)
+	assert.DeepEqual(t, plain.Data, map[string]interface{}{"keys": []string{"first", "second"}})
+
+	detailed := list(map[string]interface{}{"detailed": true})
+	assert.DeepEqual(t, detailed.Data["keys"], plain.Data["keys"])
+	assert.DeepEqual(t, detailed.Data["key_info"], map[string]interface{}{
+		"first": map[string]interface{}{
+			"entity_id":          "11112222-3333-4444-5555-666677778888",
+			"strict_meta_verify": true,
+			"policy_count":       2,
+			"base_role":          "",
+			"disabled":           false,
+		},
+		"second": map[string]interface{}{
+			"entity_id":          "",
+			"strict_meta_verify": false,
+			"policy_count":       0,
+			"base_role":          "first",
+			"disabled":           true,
+		},
+	})
+}
@@ -0,0 +1,112 @@
+package cva
+
+import (
+	"context"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/pkg/errors"
+)
+
+const (
+	roleTestMatchHelpSynopsis    = "Tests the role against simulated token lookup response."
+	roleTestMatchHelpDescription = `
+Runs the role's matching rules against provided token lookup response data
+(entity_id, meta and other fields returned by the token lookup endpoint of
+target Vault cluster) and returns whether login would pass them and the
+outcomes of validation stages. No requests are sent to target Vault cluster,
+so the checks which require them are not performed.`
+)
+
+func (b *crossVaultAuthBackend) pathRoleTestMatch() *framework.Path {
+	return &framework.Path{
+		Pattern: "role/" + framework.GenericNameRegex("name") + "/test-match$",
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "The name of the role",
+			},
+			"lookup": {
+				Type: framework.TypeMap,
+				Description: `Simulated data of token lookup response of target Vault cluster, e.g. entity_id and meta.
+Fields not provided are treated as empty`,
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.roleTestMatch,
+				DisplayAttrs: &framework.DisplayAttributes{
+					OperationVerb: "test-match",
+				},
+				Description: "tests the role against simulated token lookup response",
+			},
+		},
+		HelpSynopsis:    roleTestMatchHelpSynopsis,
+		HelpDescription: roleTestMatchHelpDescription,
+	}
+}
+
+func (b *crossVaultAuthBackend) roleTestMatch(
+	ctx context.Context,
+	req *logical.Request,
+	data *framework.FieldData,
+) (*logical.Response, error) {
+	roleName, _ := data.Get("name").(string)
+	if roleName == "" {
+		return logical.ErrorResponse("role name must be specified"), nil
+	}
+	lookup, _ := data.Get("lookup").(map[string]interface{})
+	source, err := parseSourceToken(lookup)
+	if err != nil {
+		return logical.ErrorResponse("invalid lookup: %s", err.Error()), nil
+	}
+
+	b.mu.RLock()
+	role, err := b.resolvedRole(ctx, req.Storage, roleName)
+	b.mu.RUnlock()
+	if errors.Is(err, baseRoleNotFound) || errors.Is(err, roleInheritanceCycle) {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return logical.ErrorResponse("role with provided name not found"), nil
+	}
+
+	config, err := b.config(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		// matching rules depend on configuration only through optional settings
+		config = &crossVaultAuthBackendConfig{}
+	}
+
+	trace := newValidationTrace(true)
+	var reason string
+	if err = matchSource(config, role, b.metaPatterns, b.displayNamePatterns, source, trace, nil); err != nil {
+		if !errors.Is(err, roleValidationFailed) {
+			return nil, err
+		}
+		reason = err.Error()
+	}
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"match":  reason == "",
+			"reason": reason,
+			"trace":  map[string]interface{}(trace),
+		},
+	}
+	if role.Disabled {
+		resp.AddWarning("role is disabled, its logins are rejected regardless of the match")
+	}
+	if role.RejectDisabledEntity {
+		resp.AddWarning("reject_disabled_entity is not verified, as it requires request to target Vault cluster")
+	}
+	if role.RequireDualSecret {
+		resp.AddWarning("require_dual_secret is not verified, as it requires second secret")
+	}
+	return resp, nil
+}
@@ -0,0 +1,200 @@
+package cva
+
+import (
+	"context"
+	"maps"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"gotest.tools/v3/assert"
+)
+
+func TestRole_TestMatch(t *testing.T) {
+	t.Parallel()
+
+	const otherEntityID = "99998888-7777-6666-5555-444433332222"
+
+	tests := map[string]struct {
+		config           map[string]interface{}
+		role             map[string]interface{}
+		lookup           map[string]interface{}
+		expectedMatch    bool
+		expectedReason   string
+		expectedTrace    map[string]interface{}
+		expectedWarnings []string
+	}{
+		"match": {
+			role: map[string]interface{}{"entity_meta": "team=core", "entity_meta_any": "env=prod|staging"},
+			lookup: map[string]interface{}{
+				"entity_id": testEntityID,
+				"meta":      map[string]interface{}{"team": "core", "env": "staging"},
+			},
+			expectedMatch: true,
+			expectedTrace: map[string]interface{}{
+				"entity_present": true,
+				"entity_match":   true,
+				"metadata_match": true,
+			},
+		},
+		"entity-mismatch": {
+			lookup:         map[string]interface{}{"entity_id": otherEntityID},
+			expectedReason: roleValidationFailed.Error(),
+			expectedTrace: map[string]interface{}{
+				"entity_present": true,
+				"entity_match":   false,
+			},
+		},
+		"entity-missing": {
+			lookup:         map[string]interface{}{"meta": map[string]interface{}{"team": "core"}},
+			expectedReason: "role validation failed: source token has no associated entity",
+			expectedTrace: map[string]interface{}{
+				"entity_present": false,
+			},
+		},
+		"metadata-mismatch": {
+			role: map[string]interface{}{"entity_meta": "team=core"},
+			lookup: map[string]interface{}{
+				"entity_id": testEntityID,
+				"meta":      map[string]interface{}{"team": "platform"},
+			},
+			expectedReason: roleValidationFailed.Error(),
+			expectedTrace: map[string]interface{}{
+				"entity_present": true,
+				"entity_match":   true,
+				"metadata_match": false,
+			},
+		},
+		"strict-extra-key": {
+			role: map[string]interface{}{"entity_meta": "team=core", "strict_meta_verify": true},
+			lookup: map[string]interface{}{
+				"entity_id": testEntityID,
+				"meta":      map[string]interface{}{"team": "core", "env": "prod"},
+			},
+			expectedReason: roleValidationFailed.Error(),
+			expectedTrace: map[string]interface{}{
+				"entity_present": true,
+				"entity_match":   true,
+				"metadata_match": false,
+			},
+		},
+		"config-strip-prefix": {
+			config: map[string]interface{}{"meta_key_strip_prefix": "tags/"},
+			role:   map[string]interface{}{"entity_meta": "team=core"},
+			lookup: map[string]interface{}{
+				"entity_id": testEntityID,
+				"meta":      map[string]interface{}{"tags/team": "core"},
+			},
+			expectedMatch: true,
+			expectedTrace: map[string]interface{}{
+				"entity_present": true,
+				"entity_match":   true,
+				"metadata_match": true,
+			},
+		},
+		"token-type-not-allowed": {
+			role:           map[string]interface{}{"allowed_source_token_types": sourceTokenTypeBatch},
+			lookup:         map[string]interface{}{"entity_id": testEntityID, "type": sourceTokenTypeService},
+			expectedReason: `role validation failed: source token type "service" is not allowed by the role`,
+			expectedTrace: map[string]interface{}{
+				"entity_present":    true,
+				"entity_match":      true,
+				"source_token_type": false,
+			},
+		},
+		"source-namespace": {
+			role:          map[string]interface{}{"source_namespace": "team-a"},
+			lookup:        map[string]interface{}{"entity_id": testEntityID, "namespace_path": "team-a/"},
+			expectedMatch: true,
+			expectedTrace: map[string]interface{}{
+				"entity_present":   true,
+				"entity_match":     true,
+				"source_namespace": true,
+				"metadata_match":   true,
+			},
+		},
+		"upstream-checks-not-performed": {
+			role: map[string]interface{}{
+				"reject_disabled_entity": true,
+				"require_dual_secret":    true,
+			},
+			lookup:        map[string]interface{}{"entity_id": testEntityID},
+			expectedMatch: true,
+			expectedTrace: map[string]interface{}{
+				"entity_present": true,
+				"entity_match":   true,
+				"metadata_match": true,
+			},
+			expectedWarnings: []string{
+				"reject_disabled_entity is not verified, as it requires request to target Vault cluster",
+				"require_dual_secret is not verified, as it requires second secret",
+			},
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			b, storage := getBackend(t)
+			// no target Vault cluster is listening, matching must not send requests to it
+			config := map[string]interface{}{"cluster": "http://127.0.0.1:1"}
+			maps.Copy(config, tCase.config)
+			writeConfig(t, b, storage, config)
+			role := map[string]interface{}{"entity_id": testEntityID}
+			maps.Copy(role, tCase.role)
+			writeRole(t, b, storage, "sample", role)
+
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      rolePath + "/sample/test-match",
+				Data:      map[string]interface{}{"lookup": tCase.lookup},
+				Storage:   storage,
+			})
+			if err != nil || resp.IsError() {
+				t.Fatalf("unexpected error: %v, %v", err, resp)
+			}
+			assert.Equal(t, resp.Data["match"], tCase.expectedMatch)
+			assert.Equal(t, resp.Data["reason"], tCase.expectedReason)
+			assert.DeepEqual(t, resp.Data["trace"], tCase.expectedTrace)
+			assert.DeepEqual(t, resp.Warnings, tCase.expectedWarnings)
+		})
+	}
+}
+
+func TestRole_TestMatchErrors(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		role   string
+		lookup interface{}
+	}{
+		"role-not-found": {
+			role:   "missing",
+			lookup: map[string]interface{}{"entity_id": testEntityID},
+		},
+		"invalid-lookup": {
+			role:   "sample",
+			lookup: map[string]interface{}{"meta": "team=core"},
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			b, storage := getBackend(t)
+			writeRole(t, b, storage, "sample", map[string]interface{}{"entity_id": testEntityID})
+
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      rolePath + "/" + tCase.role + "/test-match",
+				Data:      map[string]interface{}{"lookup": tCase.lookup},
+				Storage:   storage,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Assert(t, resp.IsError())
+		})
+	}
+}
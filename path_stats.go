@@ -0,0 +1,153 @@
+package cva
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	methodStatsPath = "stats/methods"
+
+	methodStatsHelpSynopsis    = "Reports login attempts broken down by login method"
+	methodStatsHelpDescription = `
+Counts login attempts of the whole mount per login method, which helps 
+to find out which wrapping methods are actually in use. Attempts are 
+counted in memory and persisted periodically, counters can be reset with 
+delete operation.`
+)
+
+// methodStats holds mount-wide login attempt counters.
+type methodStats struct {
+	// Attempts stores number of login attempts per login method
+	Attempts map[string]int64 `json:"attempts"`
+}
+
+func (b *crossVaultAuthBackend) pathMethodStats() *framework.Path {
+	return &framework.Path{
+		Pattern: methodStatsPath + "$",
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathMethodStatsRead,
+				DisplayAttrs: &framework.DisplayAttributes{
+					OperationVerb: "read",
+				},
+				Description: "returns login attempts per method",
+			},
+			logical.DeleteOperation: &framework.PathOperation{
+				Callback: b.pathMethodStatsDelete,
+				DisplayAttrs: &framework.DisplayAttributes{
+					OperationVerb: "reset",
+				},
+				Description: "resets login attempts counters",
+			},
+		},
+		HelpSynopsis:    methodStatsHelpSynopsis,
+		HelpDescription: methodStatsHelpDescription,
+	}
+}
+
+func (b *crossVaultAuthBackend) pathMethodStatsRead(
+	ctx context.Context,
+	req *logical.Request,
+	_ *framework.FieldData,
+) (*logical.Response, error) {
+	b.statsMu.Lock()
+	defer b.statsMu.Unlock()
+
+	stats, err := b.methodStats(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	attempts := map[string]int64{
+		WrappedTokenFull:    0,
+		WrappedTokenOnly:    0,
+		WrappedAccessorOnly: 0,
+	}
+	for method, count := range stats.Attempts {
+		attempts[method] = count
+	}
+	for method, count := range b.methodAttempts {
+		attempts[method] += count
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"attempts": attempts,
+		},
+	}, nil
+}
+
+func (b *crossVaultAuthBackend) pathMethodStatsDelete(
+	ctx context.Context,
+	req *logical.Request,
+	_ *framework.FieldData,
+) (*logical.Response, error) {
+	b.statsMu.Lock()
+	defer b.statsMu.Unlock()
+
+	if err := req.Storage.Delete(ctx, methodStatsPath); err != nil {
+		return nil, err
+	}
+	b.methodAttempts = make(map[string]int64)
+	return nil, nil
+}
+
+func (b *crossVaultAuthBackend) methodStats(ctx context.Context, storage logical.Storage) (*methodStats, error) {
+	stats := &methodStats{Attempts: make(map[string]int64)}
+
+	raw, err := storage.Get(ctx, methodStatsPath)
+	if err != nil {
+		return nil, err
+	}
+	if raw == nil {
+		return stats, nil
+	}
+
+	if err = json.Unmarshal(raw.Value, stats); err != nil {
+		return nil, err
+	}
+	if stats.Attempts == nil {
+		stats.Attempts = make(map[string]int64)
+	}
+	return stats, nil
+}
+
+// countMethodAttempt increments in-memory login attempts counter of the method. Counters are
+// persisted by persistMethodStats, so login attempts never write to storage.
+func (b *crossVaultAuthBackend) countMethodAttempt(method string) {
+	b.statsMu.Lock()
+	defer b.statsMu.Unlock()
+	b.methodAttempts[method]++
+}
+
+// persistMethodStats adds login attempts counted since the previous call to the persisted counters.
+// Attempts are kept in memory if storage can't be written, e.g. on performance standby.
+func (b *crossVaultAuthBackend) persistMethodStats(ctx context.Context, storage logical.Storage) error {
+	b.statsMu.Lock()
+	defer b.statsMu.Unlock()
+
+	if len(b.methodAttempts) == 0 {
+		return nil
+	}
+	stats, err := b.methodStats(ctx, storage)
+	if err != nil {
+		return err
+	}
+	for method, count := range b.methodAttempts {
+		stats.Attempts[method] += count
+	}
+
+	entry, err := logical.StorageEntryJSON(methodStatsPath, stats)
+	if err != nil {
+		return err
+	}
+	if err = storage.Put(ctx, entry); err != nil {
+		return err
+	}
+	b.methodAttempts = make(map[string]int64)
+	return nil
+}
@@ -0,0 +1,126 @@
+package cva
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"gotest.tools/v3/assert"
+)
+
+func TestMethodStats(t *testing.T) {
+	t.Parallel()
+
+	upstream := newTestUpstream(t, upstreamHandlers(map[string]interface{}{"entity_id": testEntityID}))
+	b, storage := getBackend(t)
+	writeConfig(t, b, storage, map[string]interface{}{"cluster": upstream.URL})
+	writeRole(t, b, storage, "sample", map[string]interface{}{"entity_id": testEntityID})
+
+	for _, method := range []string{WrappedTokenFull, WrappedTokenFull, WrappedAccessorOnly, "unknown"} {
+		if _, err := doLogin(t, b, storage, map[string]interface{}{
+			"role":   "sample",
+			"secret": testWrappedToken,
+			"method": method,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assert.DeepEqual(t, readMethodStats(t, b, storage), map[string]int64{
+		WrappedTokenFull:    2,
+		WrappedTokenOnly:    0,
+		WrappedAccessorOnly: 1,
+	})
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      methodStatsPath,
+		Storage:   storage,
+	})
+	if err != nil || resp.IsError() {
+		t.Fatalf("unexpected error: %v, %v", err, resp)
+	}
+	assert.DeepEqual(t, readMethodStats(t, b, storage), map[string]int64{
+		WrappedTokenFull:    0,
+		WrappedTokenOnly:    0,
+		WrappedAccessorOnly: 0,
+	})
+}
+
+func readMethodStats(t *testing.T, b logical.Backend, storage logical.Storage) map[string]int64 {
+	t.Helper()
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      methodStatsPath,
+		Storage:   storage,
+	})
+	if err != nil || resp.IsError() {
+		t.Fatalf("unexpected error: %v, %v", err, resp)
+	}
+	attempts, _ := resp.Data["attempts"].(map[string]int64)
+	return attempts
+}
+
+func TestMethodStats_Persist(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		readOnly  bool
+		persisted map[string]int64
+	}{
+		"persisted": {
+			persisted: map[string]int64{WrappedTokenFull: 2},
+		},
+		"read-only-storage": {
+			readOnly:  true,
+			persisted: map[string]int64{},
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			upstream := newTestUpstream(t, upstreamHandlers(map[string]interface{}{"entity_id": testEntityID}))
+			b, storage := getBackend(t)
+			writeConfig(t, b, storage, map[string]interface{}{"cluster": upstream.URL})
+			writeRole(t, b, storage, "sample", map[string]interface{}{"entity_id": testEntityID})
+
+			for i := 0; i < 2; i++ {
+				if _, err := doLogin(t, b, storage, map[string]interface{}{"role": "sample", "secret": testWrappedToken}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// login attempts don't write storage
+			backend := b.(*crossVaultAuthBackend)
+			stats, err := backend.methodStats(context.Background(), storage)
+			assert.NilError(t, err)
+			assert.DeepEqual(t, stats.Attempts, map[string]int64{})
+
+			var target logical.Storage = storage
+			if tCase.readOnly {
+				target = readOnlyStorage{storage}
+			}
+			err = backend.persistMethodStats(context.Background(), target)
+			assert.Equal(t, err != nil, tCase.readOnly)
+
+			stats, err = backend.methodStats(context.Background(), storage)
+			assert.NilError(t, err)
+			assert.DeepEqual(t, stats.Attempts, tCase.persisted)
+			// attempts are counted once whether they are persisted or not
+			assert.DeepEqual(t, readMethodStats(t, b, storage), map[string]int64{
+				WrappedTokenFull:    2,
+				WrappedTokenOnly:    0,
+				WrappedAccessorOnly: 0,
+			})
+		})
+	}
+}
+
+// readOnlyStorage imitates storage of performance standby.
+type readOnlyStorage struct {
+	logical.Storage
+}
+
+func (s readOnlyStorage) Put(context.Context, *logical.StorageEntry) error {
+	return logical.ErrReadOnly
+}
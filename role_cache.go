@@ -0,0 +1,100 @@
+package cva
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/armon/go-metrics"
+)
+
+// roleCacheEntry is the stored role entry cached in memory until expiration.
+type roleCacheEntry struct {
+	// value is the raw storage entry value, so every read gets its own copy of the role
+	value []byte
+
+	expiresAt time.Time
+}
+
+// roleCacheStats holds role cache counters.
+type roleCacheStats struct {
+	Hits          int64
+	Misses        int64
+	Invalidations int64
+}
+
+// cachedRole returns raw storage entry value of the role if it is cached and not expired.
+func (b *crossVaultAuthBackend) cachedRole(name string) ([]byte, bool) {
+	b.roleCacheMu.Lock()
+	defer b.roleCacheMu.Unlock()
+
+	if b.roleCacheTTL <= 0 {
+		return nil, false
+	}
+	entry, ok := b.roleCache[strings.ToLower(name)]
+	if ok && time.Now().After(entry.expiresAt) {
+		delete(b.roleCache, strings.ToLower(name))
+		ok = false
+	}
+	if !ok {
+		b.roleCacheStats.Misses++
+		metrics.IncrCounter([]string{metricsPrefix, "role_cache", "miss"}, 1)
+		return nil, false
+	}
+	b.roleCacheStats.Hits++
+	metrics.IncrCounter([]string{metricsPrefix, "role_cache", "hit"}, 1)
+	return entry.value, true
+}
+
+// cacheRole caches raw storage entry value of the role read at the provided generation. The value
+// is not cached if the cache is disabled or invalidated since the read, so the role written
+// concurrently with the read is not shadowed by its previous value.
+func (b *crossVaultAuthBackend) cacheRole(name string, value []byte, generation uint64) {
+	b.roleCacheMu.Lock()
+	defer b.roleCacheMu.Unlock()
+
+	if b.roleCacheTTL <= 0 || generation != b.roleCacheGeneration {
+		return
+	}
+	b.roleCache[strings.ToLower(name)] = &roleCacheEntry{
+		value:     value,
+		expiresAt: time.Now().Add(b.roleCacheTTL),
+	}
+}
+
+// roleCacheState returns whether the role cache is enabled and its current generation.
+func (b *crossVaultAuthBackend) roleCacheState() (bool, uint64) {
+	b.roleCacheMu.Lock()
+	defer b.roleCacheMu.Unlock()
+	return b.roleCacheTTL > 0, b.roleCacheGeneration
+}
+
+// invalidateRole removes the role from the cache. Must be called after every change of role's storage entry.
+func (b *crossVaultAuthBackend) invalidateRole(name string) {
+	b.roleCacheMu.Lock()
+	defer b.roleCacheMu.Unlock()
+
+	b.roleCacheGeneration++
+	delete(b.roleCache, strings.ToLower(name))
+	b.roleCacheStats.Invalidations++
+}
+
+// setRoleCacheTTL applies configured role cache TTL, the cache is flushed on change.
+func (b *crossVaultAuthBackend) setRoleCacheTTL(ttl time.Duration) {
+	b.roleCacheMu.Lock()
+	defer b.roleCacheMu.Unlock()
+
+	if b.roleCacheTTL == ttl {
+		return
+	}
+	b.roleCacheTTL = ttl
+	b.roleCacheGeneration++
+	b.roleCache = make(map[string]*roleCacheEntry)
+}
+
+// invalidate removes changed roles from the cache when storage is modified by other nodes.
+func (b *crossVaultAuthBackend) invalidate(_ context.Context, key string) {
+	if name, ok := strings.CutPrefix(key, rolePath+"/"); ok {
+		b.invalidateRole(name)
+	}
+}
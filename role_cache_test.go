@@ -0,0 +1,170 @@
+package cva
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"gotest.tools/v3/assert"
+)
+
+// countingStorage counts reads of role storage entries.
+type countingStorage struct {
+	logical.Storage
+	roleGets atomic.Int64
+}
+
+func (s *countingStorage) Get(ctx context.Context, key string) (*logical.StorageEntry, error) {
+	if strings.HasPrefix(key, rolePath+"/") {
+		s.roleGets.Add(1)
+	}
+	return s.Storage.Get(ctx, key)
+}
+
+func TestRole_Cache(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		ttl          string
+		between      func(t *testing.T, b *crossVaultAuthBackend, storage logical.Storage)
+		expectedGets int64
+		expectedTTL  time.Duration
+		expectNil    bool
+	}{
+		"disabled": {
+			ttl:          "0",
+			expectedGets: 1,
+			expectedTTL:  time.Hour,
+		},
+		"hit": {
+			ttl:          "1m",
+			expectedGets: 0,
+			expectedTTL:  time.Hour,
+		},
+		"expired": {
+			ttl: "1m",
+			between: func(_ *testing.T, b *crossVaultAuthBackend, _ logical.Storage) {
+				b.roleCacheMu.Lock()
+				defer b.roleCacheMu.Unlock()
+				b.roleCache["sample"].expiresAt = time.Now().Add(-time.Second)
+			},
+			expectedGets: 1,
+			expectedTTL:  time.Hour,
+		},
+		"invalidated-on-write": {
+			ttl: "1m",
+			between: func(t *testing.T, b *crossVaultAuthBackend, storage logical.Storage) {
+				resp, err := b.HandleRequest(context.Background(), &logical.Request{
+					Operation: logical.UpdateOperation,
+					Path:      rolePath + "/sample",
+					Data:      map[string]interface{}{"token_ttl": "2h"},
+					Storage:   storage,
+				})
+				if err != nil || resp.IsError() {
+					t.Fatalf("unexpected error: %v, %v", err, resp)
+				}
+			},
+			expectedGets: 1,
+			expectedTTL:  time.Hour * 2,
+		},
+		"invalidated-on-delete": {
+			ttl: "1m",
+			between: func(t *testing.T, b *crossVaultAuthBackend, storage logical.Storage) {
+				if _, err := b.HandleRequest(context.Background(), &logical.Request{
+					Operation: logical.DeleteOperation,
+					Path:      rolePath + "/sample",
+					Storage:   storage,
+				}); err != nil {
+					t.Fatal(err)
+				}
+			},
+			expectedGets: 1,
+			expectNil:    true,
+		},
+		"invalidated-by-other-node": {
+			ttl: "1m",
+			between: func(_ *testing.T, b *crossVaultAuthBackend, _ logical.Storage) {
+				b.InvalidateKey(context.Background(), rolePath+"/sample")
+			},
+			expectedGets: 1,
+			expectedTTL:  time.Hour,
+		},
+		"flushed-on-ttl-change": {
+			ttl: "1m",
+			between: func(t *testing.T, b *crossVaultAuthBackend, storage logical.Storage) {
+				writeConfig(t, b, storage, map[string]interface{}{
+					"cluster":        "http://127.0.0.1:8200",
+					"role_cache_ttl": "2m",
+				})
+			},
+			expectedGets: 1,
+			expectedTTL:  time.Hour,
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			backend, inmem := getBackend(t)
+			b := backend.(*crossVaultAuthBackend)
+			storage := &countingStorage{Storage: inmem}
+			writeConfig(t, b, storage, map[string]interface{}{
+				"cluster":        "http://127.0.0.1:8200",
+				"role_cache_ttl": tCase.ttl,
+			})
+			writeRole(t, b, storage, "sample", map[string]interface{}{
+				"entity_id": testEntityID,
+				"token_ttl": "1h",
+			})
+
+			if _, err := b.role(ctx, storage, "sample"); err != nil {
+				t.Fatal(err)
+			}
+			if tCase.between != nil {
+				tCase.between(t, b, storage)
+			}
+
+			storage.roleGets.Store(0)
+			role, err := b.role(ctx, storage, "Sample")
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, storage.roleGets.Load(), tCase.expectedGets)
+			if tCase.expectNil {
+				assert.Assert(t, role == nil)
+				return
+			}
+			assert.Equal(t, role.TokenTTL, tCase.expectedTTL)
+		})
+	}
+}
+
+func TestRole_CacheReturnsCopies(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	backend, storage := getBackend(t)
+	b := backend.(*crossVaultAuthBackend)
+	writeConfig(t, b, storage, map[string]interface{}{
+		"cluster":        "http://127.0.0.1:8200",
+		"role_cache_ttl": "1m",
+	})
+	writeRole(t, b, storage, "sample", map[string]interface{}{"entity_id": testEntityID})
+
+	role, err := b.role(ctx, storage, "sample")
+	if err != nil {
+		t.Fatal(err)
+	}
+	role.EntityID = "modified"
+
+	role, err = b.role(ctx, storage, "sample")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, role.EntityID, testEntityID)
+}
@@ -0,0 +1,175 @@
+package cva
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/pkg/errors"
+)
+
+var (
+	baseRoleNotFound     = errors.New("base role not found")
+	roleInheritanceCycle = errors.New("role inheritance cycle detected")
+
+	// nonInheritedRoleFields are the fields of the role itself, e.g. disabling a role must not
+	// suspend roles derived from it
+	nonInheritedRoleFields = map[string]struct{}{
+		"disabled":        {},
+		"explicit_fields": {},
+	}
+)
+
+// resolvedRole returns the role with fields inherited from its base roles. Returns nil if role not found.
+func (b *crossVaultAuthBackend) resolvedRole(
+	ctx context.Context,
+	storage logical.Storage,
+	name string,
+) (*crossVaultAuthRoleEntry, error) {
+	role, err := b.role(ctx, storage, name)
+	if err != nil || role == nil {
+		return role, err
+	}
+	return b.resolveRole(ctx, storage, name, role)
+}
+
+// resolveRole layers role's explicitly set fields over the fields of its base roles chain.
+// Role itself is not modified. Fields having zero value and not listed in role's explicit fields
+// are considered not set, so they are inherited from the closest base role setting them.
+func (b *crossVaultAuthBackend) resolveRole(
+	ctx context.Context,
+	storage logical.Storage,
+	name string,
+	role *crossVaultAuthRoleEntry,
+) (*crossVaultAuthRoleEntry, error) {
+	resolved := *role
+	explicit := make(map[string]struct{}, len(role.ExplicitFields))
+	for _, field := range role.ExplicitFields {
+		explicit[field] = struct{}{}
+	}
+	visited := map[string]struct{}{strings.ToLower(name): {}}
+	for baseName := role.BaseRole; baseName != ""; {
+		key := strings.ToLower(baseName)
+		if _, ok := visited[key]; ok {
+			return nil, fmt.Errorf("%w: role %q is reached twice", roleInheritanceCycle, baseName)
+		}
+		visited[key] = struct{}{}
+
+		base, err := b.role(ctx, storage, baseName)
+		if err != nil {
+			return nil, err
+		}
+		if base == nil {
+			return nil, fmt.Errorf("%w: %q", baseRoleNotFound, baseName)
+		}
+		inheritFields(reflect.ValueOf(&resolved).Elem(), reflect.ValueOf(base).Elem(), explicit)
+		// fields explicitly set by the base role override the ones of roles further up the chain
+		for _, field := range base.ExplicitFields {
+			explicit[field] = struct{}{}
+		}
+		baseName = base.BaseRole
+	}
+	return &resolved, nil
+}
+
+// inheritFields sets fields of dst having zero value or being empty to the values of src fields,
+// unless they are explicitly set. Fields are identified by their JSON names. Embedded structs are
+// processed field by field.
+func inheritFields(dst, src reflect.Value, explicit map[string]struct{}) {
+	for i := 0; i < dst.NumField(); i++ {
+		field := dst.Field(i)
+		if !field.CanSet() {
+			continue
+		}
+		if dst.Type().Field(i).Anonymous && field.Kind() == reflect.Struct {
+			inheritFields(field, src.Field(i), explicit)
+			continue
+		}
+		name := roleFieldName(dst.Type().Field(i))
+		if _, ok := explicit[name]; ok {
+			continue
+		}
+		if _, ok := nonInheritedRoleFields[name]; ok {
+			continue
+		}
+		switch field.Kind() {
+		case reflect.Map, reflect.Slice:
+			if field.Len() == 0 {
+				field.Set(src.Field(i))
+			}
+		default:
+			if field.IsZero() {
+				field.Set(src.Field(i))
+			}
+		}
+	}
+}
+
+// roleFieldName returns JSON name of the role field, which is the name of the role write parameter
+// setting it.
+func roleFieldName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	return name
+}
+
+// explicitRoleFields returns sorted names of role fields set by the previous writes of the role and
+// by the write request. Request data not accepted by the path schema is ignored.
+func explicitRoleFields(previous []string, data *framework.FieldData) []string {
+	fields := slices.Clone(previous)
+	for _, name := range roleFieldNames(reflect.TypeOf(crossVaultAuthRoleEntry{})) {
+		if _, ok := data.Schema[name]; !ok {
+			continue
+		}
+		if _, ok := data.Raw[name]; ok && !slices.Contains(fields, name) {
+			fields = append(fields, name)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// roleFieldNames returns JSON names of the fields of role struct type, including embedded ones.
+func roleFieldNames(t reflect.Type) []string {
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			names = append(names, roleFieldNames(field.Type)...)
+			continue
+		}
+		if name := roleFieldName(field); name != "" && name != "-" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// setRoleFields returns JSON names of role fields explicitly set by role writes or having non-zero value.
+func setRoleFields(role *crossVaultAuthRoleEntry) map[string]struct{} {
+	set := make(map[string]struct{}, len(role.ExplicitFields))
+	for _, name := range role.ExplicitFields {
+		set[name] = struct{}{}
+	}
+	nonZeroFields(reflect.ValueOf(role).Elem(), set)
+	return set
+}
+
+// nonZeroFields adds JSON names of struct fields having non-zero value to the set, embedded structs
+// are processed field by field.
+func nonZeroFields(v reflect.Value, set map[string]struct{}) {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		if v.Type().Field(i).Anonymous && field.Kind() == reflect.Struct {
+			nonZeroFields(field, set)
+			continue
+		}
+		if !field.IsZero() {
+			set[roleFieldName(v.Type().Field(i))] = struct{}{}
+		}
+	}
+}
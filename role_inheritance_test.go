@@ -0,0 +1,339 @@
+package cva
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"gotest.tools/v3/assert"
+)
+
+func TestRole_BaseRoleResolve(t *testing.T) {
+	t.Parallel()
+
+	b, storage := getBackend(t)
+	writeRole(t, b, storage, "base", map[string]interface{}{
+		"entity_id":          testEntityID,
+		"entity_meta":        "env=prod",
+		"strict_meta_verify": true,
+		"token_ttl":          "10m",
+		"token_policies":     "reader",
+	})
+	writeRole(t, b, storage, "middle", map[string]interface{}{
+		"base_role":      "base",
+		"token_policies": "writer",
+	})
+	writeRole(t, b, storage, "child", map[string]interface{}{
+		"base_role": "middle",
+		"token_ttl": "5m",
+	})
+
+	cvab := b.(*crossVaultAuthBackend)
+	child, err := cvab.resolvedRole(context.Background(), storage, "child")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, child.EntityID, testEntityID)
+	assert.DeepEqual(t, child.EntityMeta, map[string]string{"env": "prod"})
+	assert.Equal(t, child.StrictMetaVerify, true)
+	assert.Equal(t, child.TokenTTL, time.Minute*5)
+	assert.DeepEqual(t, child.TokenPolicies, []string{"writer"})
+	assert.Equal(t, child.BaseRole, "middle")
+
+	// stored role keeps only explicitly set fields
+	stored, err := cvab.role(context.Background(), storage, "child")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, stored.EntityID, "")
+}
+
+func TestRole_BaseRoleWrite(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		name      string
+		data      map[string]interface{}
+		expectErr bool
+	}{
+		"inherited-entity-id": {
+			name: "child",
+			data: map[string]interface{}{"base_role": "base"},
+		},
+		"missing-base-role": {
+			name:      "child",
+			data:      map[string]interface{}{"base_role": "unknown"},
+			expectErr: true,
+		},
+		"self-reference": {
+			name:      "child",
+			data:      map[string]interface{}{"entity_id": testEntityID, "base_role": "child"},
+			expectErr: true,
+		},
+		"cycle": {
+			name:      "base",
+			data:      map[string]interface{}{"base_role": "derived"},
+			expectErr: true,
+		},
+		"no-entity-id-without-base": {
+			name:      "child",
+			data:      map[string]interface{}{"token_ttl": "5m"},
+			expectErr: true,
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			b, storage := getBackend(t)
+			writeRole(t, b, storage, "base", map[string]interface{}{"entity_id": testEntityID})
+			writeRole(t, b, storage, "derived", map[string]interface{}{"base_role": "base"})
+
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.CreateOperation,
+				Path:      rolePath + "/" + tCase.name,
+				Data:      tCase.data,
+				Storage:   storage,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, resp.IsError(), tCase.expectErr)
+		})
+	}
+}
+
+func TestRole_BaseRoleDelete(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		name           string
+		data           map[string]interface{}
+		expectErr      string
+		expectWarnings []string
+		expectDeleted  bool
+	}{
+		"referenced": {
+			name:      "base",
+			expectErr: "roles derived reference the role as base_role",
+		},
+		"referenced-force": {
+			name:           "base",
+			data:           map[string]interface{}{"force": true},
+			expectWarnings: []string{"roles derived reference the deleted role as base_role and must be updated"},
+			expectDeleted:  true,
+		},
+		"not-referenced": {
+			name:          "derived",
+			expectDeleted: true,
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			b, storage := getBackend(t)
+			writeRole(t, b, storage, "base", map[string]interface{}{"entity_id": testEntityID})
+			writeRole(t, b, storage, "derived", map[string]interface{}{"base_role": "Base"})
+
+			resp, err := b.HandleRequest(ctx, &logical.Request{
+				Operation: logical.DeleteOperation,
+				Path:      rolePath + "/" + tCase.name,
+				Data:      tCase.data,
+				Storage:   storage,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tCase.expectErr != "" {
+				assert.Assert(t, resp.IsError())
+				assert.ErrorContains(t, resp.Error(), tCase.expectErr)
+			} else if len(tCase.expectWarnings) > 0 {
+				assert.Assert(t, !resp.IsError())
+				assert.DeepEqual(t, resp.Warnings, tCase.expectWarnings)
+			} else {
+				assert.Assert(t, resp == nil)
+			}
+
+			role, err := b.(*crossVaultAuthBackend).role(ctx, storage, tCase.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, role == nil, tCase.expectDeleted)
+		})
+	}
+}
+
+func TestRole_BaseRoleOverrideToFalse(t *testing.T) {
+	t.Parallel()
+
+	base := map[string]interface{}{
+		"entity_id":                testEntityID,
+		"strict_meta_verify":       true,
+		"strict_ignore_extra":      true,
+		"mirror_source_orphan":     true,
+		"reject_disabled_entity":   true,
+		"require_renewable_source": true,
+		"disabled":                 true,
+	}
+	fields := func(role *crossVaultAuthRoleEntry) map[string]bool {
+		return map[string]bool{
+			"strict_meta_verify":       role.StrictMetaVerify,
+			"strict_ignore_extra":      role.StrictIgnoreExtra,
+			"mirror_source_orphan":     role.MirrorSourceOrphan,
+			"reject_disabled_entity":   role.RejectDisabledEntity,
+			"require_renewable_source": role.RequireRenewableSource,
+			"disabled":                 role.Disabled,
+		}
+	}
+
+	tests := map[string]struct {
+		writes   []map[string]interface{}
+		expected map[string]bool
+	}{
+		"inherited": {
+			writes: []map[string]interface{}{{"base_role": "base"}},
+			expected: map[string]bool{
+				"strict_meta_verify":       true,
+				"strict_ignore_extra":      true,
+				"mirror_source_orphan":     true,
+				"reject_disabled_entity":   true,
+				"require_renewable_source": true,
+				"disabled":                 false,
+			},
+		},
+		"overridden-to-false": {
+			writes: []map[string]interface{}{{
+				"base_role":                "base",
+				"strict_meta_verify":       false,
+				"strict_ignore_extra":      false,
+				"mirror_source_orphan":     false,
+				"reject_disabled_entity":   false,
+				"require_renewable_source": false,
+			}},
+			expected: map[string]bool{
+				"strict_meta_verify":       false,
+				"strict_ignore_extra":      false,
+				"mirror_source_orphan":     false,
+				"reject_disabled_entity":   false,
+				"require_renewable_source": false,
+				"disabled":                 false,
+			},
+		},
+		"override-kept-by-later-writes": {
+			writes: []map[string]interface{}{
+				{"base_role": "base", "strict_meta_verify": false},
+				{"token_ttl": "5m"},
+			},
+			expected: map[string]bool{
+				"strict_meta_verify":       false,
+				"strict_ignore_extra":      true,
+				"mirror_source_orphan":     true,
+				"reject_disabled_entity":   true,
+				"require_renewable_source": true,
+				"disabled":                 false,
+			},
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			b, storage := getBackend(t)
+			writeRole(t, b, storage, "base", base)
+			for i, data := range tCase.writes {
+				operation := logical.Operation(logical.UpdateOperation)
+				if i == 0 {
+					operation = logical.CreateOperation
+				}
+				resp, err := b.HandleRequest(context.Background(), &logical.Request{
+					Operation: operation,
+					Path:      rolePath + "/child",
+					Data:      data,
+					Storage:   storage,
+				})
+				if err != nil || resp.IsError() {
+					t.Fatalf("unexpected error: %v %v", err, resp)
+				}
+			}
+
+			child, err := b.(*crossVaultAuthBackend).resolvedRole(context.Background(), storage, "child")
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.DeepEqual(t, fields(child), tCase.expected)
+		})
+	}
+}
+
+func TestLogin_BaseRoleDisabledNotInherited(t *testing.T) {
+	t.Parallel()
+
+	upstream := newTestUpstream(t, upstreamHandlers(map[string]interface{}{"entity_id": testEntityID}))
+	b, storage := getBackend(t)
+	writeConfig(t, b, storage, map[string]interface{}{"cluster": upstream.URL})
+	writeRole(t, b, storage, "base", map[string]interface{}{"entity_id": testEntityID, "disabled": true})
+	writeRole(t, b, storage, "child", map[string]interface{}{"base_role": "base"})
+
+	resp, err := doLogin(t, b, storage, map[string]interface{}{"role": "base", "secret": testWrappedToken})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, resp.IsError(), true)
+
+	resp, err = doLogin(t, b, storage, map[string]interface{}{"role": "child", "secret": testWrappedToken})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, resp.IsError(), false)
+}
+
+func TestLogin_BaseRole(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		meta      map[string]interface{}
+		expectErr bool
+	}{
+		"inherited-meta-matches": {
+			meta: map[string]interface{}{"env": "prod"},
+		},
+		"inherited-meta-mismatch": {
+			meta:      map[string]interface{}{"env": "dev"},
+			expectErr: true,
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			upstream := newTestUpstream(t, upstreamHandlers(map[string]interface{}{
+				"entity_id": testEntityID,
+				"meta":      tCase.meta,
+			}))
+			b, storage := getBackend(t)
+			writeConfig(t, b, storage, map[string]interface{}{"cluster": upstream.URL})
+			writeRole(t, b, storage, "base", map[string]interface{}{
+				"entity_id":      testEntityID,
+				"entity_meta":    "env=prod",
+				"token_policies": "reader",
+			})
+			writeRole(t, b, storage, "child", map[string]interface{}{"base_role": "base"})
+
+			resp, err := doLogin(t, b, storage, map[string]interface{}{"role": "child", "secret": testWrappedToken})
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, resp.IsError(), tCase.expectErr)
+			if !tCase.expectErr {
+				assert.DeepEqual(t, resp.Auth.Policies, []string{"reader"})
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package cva
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// policyTemplatePlaceholder matches placeholder of token_policies_template referencing metadata key of
+// source token's entity.
+var policyTemplatePlaceholder = regexp.MustCompile(`\{\{\s*identity\.entity\.metadata\.([^{}\s]+)\s*\}\}`)
+
+// validatePolicyTemplate rejects templates with unknown placeholders or without any placeholder, so
+// policy names are not silently malformed on login.
+func validatePolicyTemplate(template string) error {
+	if !policyTemplatePlaceholder.MatchString(template) {
+		return fmt.Errorf("template %q has no {{identity.entity.metadata.<key>}} placeholder, "+
+			"static policies belong to token_policies", template)
+	}
+	rest := policyTemplatePlaceholder.ReplaceAllString(template, "")
+	if strings.Contains(rest, "{{") || strings.Contains(rest, "}}") {
+		return fmt.Errorf("template %q has unsupported placeholder, only "+
+			"{{identity.entity.metadata.<key>}} is supported", template)
+	}
+	return nil
+}
+
+// renderPolicyTemplates returns policies of role's token_policies_template with placeholders replaced
+// by values of source entity's metadata, normalized the same way they are on verification. Missing
+// or empty values fail the login.
+func renderPolicyTemplates(
+	config *crossVaultAuthBackendConfig,
+	role *crossVaultAuthRoleEntry,
+	metadata map[string]string,
+) ([]string, error) {
+	if len(role.TokenPoliciesTemplate) == 0 {
+		return nil, nil
+	}
+	if config.MetaKeyStripPrefix != "" {
+		metadata = stripMetaKeyPrefix(metadata, config.MetaKeyStripPrefix)
+	}
+	if role.MetaKeysCaseInsensitive {
+		lowercased, _, err := lowercaseMetaKeys(metadata, role)
+		if err != nil {
+			return nil, err
+		}
+		metadata = lowercased
+	}
+
+	policies := make([]string, 0, len(role.TokenPoliciesTemplate))
+	for _, template := range role.TokenPoliciesTemplate {
+		var renderErr error
+		policy := policyTemplatePlaceholder.ReplaceAllStringFunc(template, func(placeholder string) string {
+			key := policyTemplatePlaceholder.FindStringSubmatch(placeholder)[1]
+			if role.MetaKeysCaseInsensitive {
+				key = strings.ToLower(key)
+			}
+			value := metadata[key]
+			if role.MetaTrimWhitespace {
+				value = strings.TrimSpace(value)
+			}
+			if value == "" && renderErr == nil {
+				renderErr = fmt.Errorf("%w: token_policies_template %q references metadata key %q "+
+					"the source entity doesn't have", roleValidationFailed, template, key)
+			}
+			return value
+		})
+		if renderErr != nil {
+			return nil, renderErr
+		}
+		policies = append(policies, policy)
+	}
+	return policies, nil
+}
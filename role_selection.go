@@ -0,0 +1,219 @@
+package cva
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hashicorp/go-secure-stdlib/strutil"
+	"github.com/hashicorp/go-sockaddr"
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/pkg/errors"
+)
+
+// loginSelectingRole performs login without role provided, the role is selected among the roles the
+// source token matches. The secret has to be unwrapped before the role is known, so roles overriding
+// namespace or requiring dual secret are never selected and default request timeout is used.
+func (b *crossVaultAuthBackend) loginSelectingRole(
+	ctx context.Context,
+	req *logical.Request,
+	data *framework.FieldData,
+) (*logical.Response, error) {
+	config, err := b.config(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil || !config.AllowRoleSelection {
+		return logical.ErrorResponse("'role' field is mandatory"), nil
+	}
+	secret, _ := data.Get("secret").(string)
+	if secret == "" {
+		return logical.ErrorResponse("'secret' field is mandatory"), nil
+	}
+
+	method, err := resolveLoginMethod(config, &crossVaultAuthRoleEntry{}, data)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	validateOnly, _ := data.Get("validate_only").(bool)
+	correlationID, _ := data.Get("correlation_id").(string)
+	if err = validateCorrelationID(correlationID); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	b.countMethodAttempt(method)
+
+	client, err := b.sharedUpstreamClient(config)
+	if err != nil {
+		return nil, err
+	}
+	reqCtx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	trace := newValidationTrace(config.DebugLogin)
+	timings := newLoginTimings(config.DebugLogin)
+	unwrapStart := time.Now()
+	secret, err = b.unwrapSecret(reqCtx, client, config, method, secret)
+	if err != nil {
+		return nil, err
+	}
+	timings.since("unwrap", unwrapStart)
+	trace.set("unwrap", "ok")
+	lookupStart := time.Now()
+	source, err := b.lookupSource(reqCtx, client, config, method, secret)
+	if err != nil {
+		return nil, err
+	}
+	timings.since("lookup", lookupStart)
+	trace.set("lookup", lookupOutcome(source))
+
+	// the metadata of the source token is compared with each candidate role during selection
+	selectionStart := time.Now()
+	roleName, role, err := b.selectRole(reqCtx, client, req.Storage, config, method, source)
+	timings.since("metadata_comparison", selectionStart)
+	if trace.check("role_selection", err == nil); err != nil {
+		if errors.Is(err, roleValidationFailed) {
+			return trace.errorResponse(err.Error()), nil
+		}
+		return nil, err
+	}
+	b.Logger().Debug("role selected for login without role", "role", roleName)
+
+	requestedTTL, err := b.requestedTTL(role, data)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	var callerCIDR *sockaddr.SockAddrMarshaler
+	if bindCallerIP, _ := data.Get("bind_caller_ip").(bool); bindCallerIP {
+		if callerCIDR, err = callerBoundCIDR(req, role); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+	}
+
+	return b.issueAuth(&loginState{
+		config:        config,
+		role:          role,
+		roleName:      roleName,
+		method:        method,
+		source:        source,
+		trace:         trace,
+		timings:       timings,
+		requestedTTL:  requestedTTL,
+		callerCIDR:    callerCIDR,
+		correlationID: correlationID,
+		validateOnly:  validateOnly,
+	})
+}
+
+// selectRole returns the most specific role the source token matches. Roles are ranked by the number
+// of metadata keys they constrain, then by the number of keys constrained to exact value, then strict
+// roles rank above non-strict ones. Several matching roles of the same rank are reported as ambiguity
+// rather than resolved by name, as the choice would depend on role naming.
+func (b *crossVaultAuthBackend) selectRole(
+	ctx context.Context,
+	client *api.Client,
+	storage logical.Storage,
+	config *crossVaultAuthBackendConfig,
+	method string,
+	source *sourceToken,
+) (string, *crossVaultAuthRoleEntry, error) {
+	names, err := storage.List(ctx, rolePath+"/")
+	if err != nil {
+		return "", nil, err
+	}
+	sort.Strings(names)
+
+	var (
+		selected     []string
+		selectedRole *crossVaultAuthRoleEntry
+	)
+	for _, name := range names {
+		role, err := b.resolvedRole(ctx, storage, name)
+		if errors.Is(err, roleStorageEntryCorrupted) || errors.Is(err, baseRoleNotFound) ||
+			errors.Is(err, roleInheritanceCycle) {
+			b.Logger().Warn("role skipped on role selection", "role", name, "error", err)
+			continue
+		}
+		if err != nil {
+			return "", nil, err
+		}
+		if role == nil || !roleSelectable(role, method) {
+			continue
+		}
+		if err = b.validateSource(ctx, client, config, role, source, nil, nil); err != nil {
+			if errors.Is(err, roleValidationFailed) {
+				continue
+			}
+			return "", nil, err
+		}
+
+		switch rank := compareRoleSpecificity(role, selectedRole); {
+		case selectedRole == nil || rank > 0:
+			selected, selectedRole = []string{name}, role
+		case rank == 0:
+			selected = append(selected, name)
+		}
+	}
+
+	switch len(selected) {
+	case 0:
+		return "", nil, fmt.Errorf("%w: source token matches no role", roleValidationFailed)
+	case 1:
+		return selected[0], selectedRole, nil
+	default:
+		// role names are logged only, as the caller hasn't proved access to any particular role yet
+		b.Logger().Warn("source token matches several roles of the same specificity", "roles", selected)
+		return "", nil, fmt.Errorf("%w: source token matches %d roles of the same specificity, the role must be "+
+			"provided", roleValidationFailed, len(selected))
+	}
+}
+
+// roleSelectable reports whether the role can be selected for login without role provided.
+func roleSelectable(role *crossVaultAuthRoleEntry, method string) bool {
+	if role.Disabled || role.RequireDualSecret || role.Namespace != "" {
+		return false
+	}
+	return len(role.AllowedMethods) == 0 || strutil.StrListContains(role.AllowedMethods, method)
+}
+
+// compareRoleSpecificity returns positive number if the role is more specific than the other one,
+// negative if it is less specific and zero if they are of the same specificity.
+func compareRoleSpecificity(role, other *crossVaultAuthRoleEntry) int {
+	if other == nil {
+		return 1
+	}
+	if c := cmp.Compare(constrainedMetaKeys(role), constrainedMetaKeys(other)); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(exactMetaKeys(role), exactMetaKeys(other)); c != 0 {
+		return c
+	}
+	return cmp.Compare(strictRank(role), strictRank(other))
+}
+
+// constrainedMetaKeys returns the number of metadata keys the role verifies.
+func constrainedMetaKeys(role *crossVaultAuthRoleEntry) int {
+	if role.SkipMetaVerify {
+		return 0
+	}
+	return len(role.EntityMeta) + len(role.EntityMetaAny)
+}
+
+// exactMetaKeys returns the number of metadata keys the role constrains to exact value.
+func exactMetaKeys(role *crossVaultAuthRoleEntry) int {
+	if role.SkipMetaVerify || role.metaMatchMode() == metaMatchModeRegex {
+		return 0
+	}
+	return len(role.EntityMeta)
+}
+
+func strictRank(role *crossVaultAuthRoleEntry) int {
+	if role.StrictMetaVerify && !role.SkipMetaVerify {
+		return 1
+	}
+	return 0
+}
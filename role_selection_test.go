@@ -0,0 +1,127 @@
+package cva
+
+import (
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestLogin_RoleSelection(t *testing.T) {
+	t.Parallel()
+
+	const otherEntityID = "99998888-7777-6666-5555-444433332222"
+
+	tests := map[string]struct {
+		disabled      bool
+		roles         map[string]map[string]interface{}
+		expectedRole  string
+		expectedError string
+	}{
+		"unique-match": {
+			roles: map[string]map[string]interface{}{
+				"sample": {"entity_id": testEntityID},
+				"other":  {"entity_id": otherEntityID},
+			},
+			expectedRole: "sample",
+		},
+		"most-constrained-keys": {
+			roles: map[string]map[string]interface{}{
+				"broad":  {"entity_id": testEntityID, "entity_meta": "team=core"},
+				"narrow": {"entity_id": testEntityID, "entity_meta": map[string]interface{}{"team": "core", "env": "prod"}},
+			},
+			expectedRole: "narrow",
+		},
+		"exact-over-any": {
+			roles: map[string]map[string]interface{}{
+				"any":   {"entity_id": testEntityID, "entity_meta_any": "env=prod|staging"},
+				"exact": {"entity_id": testEntityID, "entity_meta": "env=prod"},
+			},
+			expectedRole: "exact",
+		},
+		"strict-over-non-strict": {
+			roles: map[string]map[string]interface{}{
+				"loose": {"entity_id": testEntityID, "entity_meta": map[string]interface{}{"team": "core", "env": "prod"}},
+				"strict": {
+					"entity_id":          testEntityID,
+					"entity_meta":        map[string]interface{}{"team": "core", "env": "prod"},
+					"strict_meta_verify": true,
+				},
+			},
+			expectedRole: "strict",
+		},
+		"not-matching-metadata-ignored": {
+			roles: map[string]map[string]interface{}{
+				"broad": {"entity_id": testEntityID},
+				"other": {"entity_id": testEntityID, "entity_meta": "env=staging"},
+			},
+			expectedRole: "broad",
+		},
+		"ambiguous": {
+			roles: map[string]map[string]interface{}{
+				"first":  {"entity_id": testEntityID, "entity_meta": "team=core"},
+				"second": {"entity_id": testEntityID, "entity_meta": "env=prod"},
+			},
+			expectedError: "source token matches 2 roles of the same specificity",
+		},
+		"no-match": {
+			roles: map[string]map[string]interface{}{
+				"other": {"entity_id": otherEntityID},
+			},
+			expectedError: "source token matches no role",
+		},
+		"dual-secret-not-selected": {
+			roles: map[string]map[string]interface{}{
+				"dual": {"entity_id": testEntityID, "require_dual_secret": true},
+			},
+			expectedError: "source token matches no role",
+		},
+		"disabled-role-not-selected": {
+			roles: map[string]map[string]interface{}{
+				"first":  {"entity_id": testEntityID, "entity_meta": "team=core", "disabled": true},
+				"second": {"entity_id": testEntityID},
+			},
+			expectedRole: "second",
+		},
+		"disabled": {
+			disabled: true,
+			roles: map[string]map[string]interface{}{
+				"sample": {"entity_id": testEntityID},
+			},
+			expectedError: "'role' field is mandatory",
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			upstream := newTestUpstream(t, upstreamHandlers(map[string]interface{}{
+				"entity_id": testEntityID,
+				"meta":      map[string]interface{}{"team": "core", "env": "prod"},
+			}))
+			b, storage := getBackend(t)
+			writeConfig(t, b, storage, map[string]interface{}{
+				"cluster":              upstream.URL,
+				"allow_role_selection": !tCase.disabled,
+			})
+			for roleName, role := range tCase.roles {
+				writeRole(t, b, storage, roleName, role)
+			}
+
+			resp, err := doLogin(t, b, storage, map[string]interface{}{"secret": testWrappedToken})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tCase.expectedError != "" {
+				assert.Assert(t, resp.IsError())
+				assert.ErrorContains(t, resp.Error(), tCase.expectedError)
+				return
+			}
+			if resp.IsError() {
+				t.Fatalf("unexpected error: %v", resp.Error())
+			}
+			assert.Equal(t, resp.Auth.Metadata["role"], tCase.expectedRole)
+			assert.Equal(t, resp.Auth.InternalData["role"], tCase.expectedRole)
+		})
+	}
+}
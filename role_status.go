@@ -0,0 +1,192 @@
+package cva
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/pkg/errors"
+)
+
+const (
+	entityReadPath = "identity/entity/id/%s"
+
+	// types of login errors recorded in role status
+	loginErrorValidation = "validation_failed"
+	loginErrorRejected   = "request_rejected"
+	loginErrorUpstream   = "upstream_error"
+	loginErrorInternal   = "internal_error"
+)
+
+// roleStatus holds runtime state of the role.
+type roleStatus struct {
+	// EntityVerifiedAt is the time role's entity was last verified in target Vault cluster
+	EntityVerifiedAt time.Time
+
+	// EntityMissing reflects whether any of role's entities was not found during the last verification
+	EntityMissing bool
+
+	// LastLoginError is the type of the last failed login error
+	LastLoginError string
+
+	// LastLoginErrorMessage is the message of the last failed login error response, empty for
+	// upstream and internal errors which may carry details not intended for operators
+	LastLoginErrorMessage string
+
+	// LastLoginErrorAt is the time of the last failed login
+	LastLoginErrorAt time.Time
+}
+
+// verifyRoleEntities checks that entities bound to the roles still exist in target Vault cluster
+// and logs warning for every role whose entity was deleted. Check is skipped unless enabled
+// by configuration or if the previous one was performed less than roleEntityCheckInterval ago.
+func (b *crossVaultAuthBackend) verifyRoleEntities(ctx context.Context, storage logical.Storage) error {
+	b.statusMu.RLock()
+	due := time.Since(b.roleEntitiesLastCheck) >= roleEntityCheckInterval
+	b.statusMu.RUnlock()
+	if !due {
+		return nil
+	}
+
+	config, err := b.config(ctx, storage)
+	if err != nil {
+		return err
+	}
+	if config == nil || !config.VerifyRoleEntities {
+		return nil
+	}
+
+	client, err := b.upstreamClient(config)
+	if err != nil {
+		return err
+	}
+
+	// roles are read under the lock, while upstream requests are sent without it, so slow target
+	// cluster doesn't stall config and role writes
+	bindings, err := b.roleEntityBindings(ctx, storage)
+	if err != nil {
+		return err
+	}
+	for _, binding := range bindings {
+		missing := false
+		for _, entityID := range binding.entityIDs {
+			reqCtx, cancel := context.WithTimeout(ctx, requestTimeout)
+			entity, err := client.Logical().ReadWithContext(reqCtx, fmt.Sprintf(entityReadPath, entityID))
+			cancel()
+			if err != nil {
+				return err
+			}
+			if entity == nil {
+				missing = true
+				b.Logger().Warn("role's entity no longer exists in target Vault cluster, its logins will fail",
+					"role", binding.name, "entity_id", entityID)
+			}
+		}
+
+		b.statusMu.Lock()
+		status := b.roleStatusLocked(binding.name)
+		status.EntityVerifiedAt = time.Now()
+		status.EntityMissing = missing
+		b.statusMu.Unlock()
+	}
+
+	b.statusMu.Lock()
+	b.roleEntitiesLastCheck = time.Now()
+	b.statusMu.Unlock()
+	return nil
+}
+
+// roleEntityBinding holds entity IDs of the role verified by verifyRoleEntities.
+type roleEntityBinding struct {
+	name      string
+	entityIDs []string
+}
+
+// roleEntityBindings returns entity IDs of every resolvable role. Roles which can't be resolved
+// are logged and skipped.
+func (b *crossVaultAuthBackend) roleEntityBindings(
+	ctx context.Context,
+	storage logical.Storage,
+) ([]roleEntityBinding, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	names, err := storage.List(ctx, rolePath+"/")
+	if err != nil {
+		return nil, err
+	}
+	bindings := make([]roleEntityBinding, 0, len(names))
+	for _, name := range names {
+		role, err := b.resolvedRole(ctx, storage, name)
+		if errors.Is(err, baseRoleNotFound) || errors.Is(err, roleInheritanceCycle) {
+			b.Logger().Warn("role can't be resolved, entity verification skipped", "role", name, "error", err)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if role == nil {
+			continue
+		}
+		bindings = append(bindings, roleEntityBinding{name: name, entityIDs: role.entityIDs()})
+	}
+	return bindings, nil
+}
+
+// roleStatusLocked returns status of the role, creating it if needed. Caller must hold statusMu.
+func (b *crossVaultAuthBackend) roleStatusLocked(name string) *roleStatus {
+	status, ok := b.roleStatuses[name]
+	if !ok {
+		status = &roleStatus{}
+		b.roleStatuses[name] = status
+	}
+	return status
+}
+
+// roleExists reports whether the role is stored. Runtime state is recorded for existing roles only,
+// so it can't grow unbounded because of login requests naming arbitrary roles.
+func (b *crossVaultAuthBackend) roleExists(ctx context.Context, storage logical.Storage, roleName string) bool {
+	if roleName == "" {
+		return false
+	}
+	b.mu.RLock()
+	role, err := b.role(ctx, storage, roleName)
+	b.mu.RUnlock()
+	return err == nil && role != nil
+}
+
+// recordLoginError stores the error of the failed login in role's status. Caller must ensure the
+// role exists, empty role name is ignored.
+func (b *crossVaultAuthBackend) recordLoginError(roleName string, resp *logical.Response, loginErr error) {
+	if roleName == "" {
+		return
+	}
+
+	errType, message := loginErrorType(resp, loginErr)
+	b.statusMu.Lock()
+	defer b.statusMu.Unlock()
+	status := b.roleStatusLocked(strings.ToLower(roleName))
+	status.LastLoginError = errType
+	status.LastLoginErrorMessage = message
+	status.LastLoginErrorAt = time.Now()
+}
+
+// loginErrorType classifies the error of the failed login. The message is returned for error
+// responses only, as they are generated by the plugin and never contain secrets.
+func loginErrorType(resp *logical.Response, err error) (string, string) {
+	var respErr *api.ResponseError
+	switch {
+	case errors.As(err, &respErr):
+		return loginErrorUpstream, ""
+	case err != nil:
+		return loginErrorInternal, ""
+	}
+	message := resp.Error().Error()
+	if strings.HasPrefix(message, roleValidationFailed.Error()) {
+		return loginErrorValidation, message
+	}
+	return loginErrorRejected, message
+}
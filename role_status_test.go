@@ -0,0 +1,115 @@
+package cva
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"gotest.tools/v3/assert"
+)
+
+func TestRoleStatus_VerifyRoleEntities(t *testing.T) {
+	t.Parallel()
+
+	const deletedEntityID = "99998888-7777-6666-5555-444433332222"
+
+	tests := map[string]struct {
+		enabled  bool
+		expected map[string]bool
+	}{
+		"enabled": {
+			enabled:  true,
+			expected: map[string]bool{"existing": false, "deleted": true},
+		},
+		"disabled": {
+			expected: map[string]bool{},
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			upstream := newTestUpstream(t, map[string]http.HandlerFunc{
+				"/v1/identity/entity/id/" + testEntityID: lookupHandler(map[string]interface{}{"id": testEntityID}),
+				"/v1/identity/entity/id/" + deletedEntityID: jsonHandler(http.StatusNotFound, map[string]interface{}{
+					"errors": []string{},
+				}),
+			})
+			b, storage := getBackend(t)
+			writeConfig(t, b, storage, map[string]interface{}{
+				"cluster":              upstream.URL,
+				"verify_role_entities": tCase.enabled,
+			})
+			writeRole(t, b, storage, "existing", map[string]interface{}{"entity_id": testEntityID})
+			writeRole(t, b, storage, "deleted", map[string]interface{}{"entity_id": deletedEntityID})
+
+			cvab := b.(*crossVaultAuthBackend)
+			if err := cvab.verifyRoleEntities(context.Background(), storage); err != nil {
+				t.Fatal(err)
+			}
+
+			cvab.statusMu.RLock()
+			defer cvab.statusMu.RUnlock()
+			assert.Equal(t, len(cvab.roleStatuses), len(tCase.expected))
+			for roleName, missing := range tCase.expected {
+				status, ok := cvab.roleStatuses[roleName]
+				assert.Assert(t, ok)
+				assert.Equal(t, status.EntityMissing, missing)
+				assert.Assert(t, !status.EntityVerifiedAt.IsZero())
+			}
+		})
+	}
+}
+
+func TestRoleStatus_VerifyRoleEntitiesDoesNotBlockWrites(t *testing.T) {
+	t.Parallel()
+
+	requested := make(chan struct{})
+	release := make(chan struct{})
+	upstream := newTestUpstream(t, map[string]http.HandlerFunc{
+		"/v1/identity/entity/id/" + testEntityID: func(w http.ResponseWriter, r *http.Request) {
+			close(requested)
+			<-release
+			lookupHandler(map[string]interface{}{"id": testEntityID})(w, r)
+		},
+	})
+	b, storage := getBackend(t)
+	writeConfig(t, b, storage, map[string]interface{}{
+		"cluster":              upstream.URL,
+		"verify_role_entities": true,
+	})
+	writeRole(t, b, storage, "existing", map[string]interface{}{"entity_id": testEntityID})
+
+	verified := make(chan error, 1)
+	go func() {
+		verified <- b.(*crossVaultAuthBackend).verifyRoleEntities(context.Background(), storage)
+	}()
+	<-requested
+
+	// upstream request is in flight, role write must not wait for it
+	written := make(chan error, 1)
+	go func() {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      rolePath + "/other",
+			Data:      map[string]interface{}{"entity_id": testEntityID},
+			Storage:   storage,
+		})
+		if err == nil && resp.IsError() {
+			err = resp.Error()
+		}
+		written <- err
+	}()
+	select {
+	case err := <-written:
+		assert.NilError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Error("role write is blocked by entity verification")
+	}
+
+	close(release)
+	assert.NilError(t, <-verified)
+}
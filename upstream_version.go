@@ -0,0 +1,126 @@
+package cva
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/go-version"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// upstreamFeature describes target Vault cluster capability the plugin depends on.
+type upstreamFeature struct {
+	name       string
+	minVersion string
+	enterprise bool
+	// enabled reports whether the configuration makes use of the feature
+	enabled func(config *crossVaultAuthBackendConfig) bool
+}
+
+// upstreamFeatures lists capabilities of target Vault cluster with the minimal versions providing them.
+var upstreamFeatures = []upstreamFeature{
+	{
+		name:       "identity entities",
+		minVersion: "0.9.0",
+		enabled:    func(_ *crossVaultAuthBackendConfig) bool { return true },
+	},
+	{
+		name:       "namespaces",
+		minVersion: "0.11.0",
+		enterprise: true,
+		enabled: func(config *crossVaultAuthBackendConfig) bool {
+			return normalizeNamespace(config.Namespace) != ""
+		},
+	},
+}
+
+// refreshUpstreamVersion checks version of target Vault cluster and records the error reported by
+// config/status endpoint. Failures are logged only.
+func (b *crossVaultAuthBackend) refreshUpstreamVersion(ctx context.Context, storage logical.Storage) {
+	checkErr := b.checkUpstreamVersion(ctx, storage)
+	if checkErr != nil {
+		b.Logger().Warn("target Vault cluster version check failed", "error", checkErr)
+	}
+	b.statusMu.Lock()
+	b.upstreamVersionErr = checkErr
+	b.statusMu.Unlock()
+}
+
+// checkUpstreamVersion detects version of target Vault cluster reported by its health endpoint and
+// logs warning for every enabled feature the cluster doesn't provide. Check is skipped unless enabled
+// by configuration or if the previous one was performed less than upstreamVersionCheckInterval ago.
+func (b *crossVaultAuthBackend) checkUpstreamVersion(ctx context.Context, storage logical.Storage) error {
+	b.statusMu.RLock()
+	due := time.Since(b.upstreamVersionLastCheck) >= upstreamVersionCheckInterval
+	b.statusMu.RUnlock()
+	if !due {
+		return nil
+	}
+
+	config, err := b.config(ctx, storage)
+	if err != nil {
+		return err
+	}
+	if config == nil || !config.CheckUpstreamVersion {
+		// detected version of previously checked cluster must not be reported
+		b.statusMu.Lock()
+		b.upstreamVersion, b.upstreamVersionWarnings = "", nil
+		b.statusMu.Unlock()
+		return nil
+	}
+
+	client, err := b.upstreamClient(config)
+	if err != nil {
+		return err
+	}
+	reqCtx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	health, err := client.Sys().HealthWithContext(reqCtx)
+	if err != nil {
+		return err
+	}
+
+	warnings, err := upstreamVersionWarnings(config, health.Version, health.Enterprise)
+	if err != nil {
+		return err
+	}
+	for _, warning := range warnings {
+		b.Logger().Warn("target Vault cluster version is not compatible with configuration",
+			"version", health.Version, "warning", warning)
+	}
+
+	b.statusMu.Lock()
+	b.upstreamVersion = health.Version
+	b.upstreamVersionWarnings = warnings
+	b.upstreamVersionLastCheck = time.Now()
+	b.statusMu.Unlock()
+	return nil
+}
+
+// upstreamVersionWarnings returns warnings about features enabled by configuration which target Vault
+// cluster of the reported version and edition doesn't provide.
+func upstreamVersionWarnings(config *crossVaultAuthBackendConfig, reported string, enterprise bool) ([]string, error) {
+	detected, err := version.NewVersion(reported)
+	if err != nil {
+		return nil, fmt.Errorf("target Vault cluster reported invalid version %q: %w", reported, err)
+	}
+
+	warnings := make([]string, 0)
+	for _, feature := range upstreamFeatures {
+		if !feature.enabled(config) {
+			continue
+		}
+		minVersion := version.Must(version.NewVersion(feature.minVersion))
+		if detected.LessThan(minVersion) {
+			warnings = append(warnings, fmt.Sprintf("%s require Vault %s or later, target cluster runs %s",
+				feature.name, feature.minVersion, reported))
+			continue
+		}
+		if feature.enterprise && !enterprise {
+			warnings = append(warnings, fmt.Sprintf("%s require Vault Enterprise, target cluster runs %s",
+				feature.name, reported))
+		}
+	}
+	return warnings, nil
+}
@@ -0,0 +1,144 @@
+package cva
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"gotest.tools/v3/assert"
+)
+
+func TestUpstreamVersion_Check(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		disabled         bool
+		namespace        string
+		version          string
+		enterprise       bool
+		expectedVersion  string
+		expectedWarnings []string
+		expectErr        bool
+	}{
+		"compatible": {
+			version:          "1.15.2",
+			expectedVersion:  "1.15.2",
+			expectedWarnings: []string{},
+		},
+		"outdated": {
+			version:         "0.8.3",
+			expectedVersion: "0.8.3",
+			expectedWarnings: []string{
+				"identity entities require Vault 0.9.0 or later, target cluster runs 0.8.3",
+			},
+		},
+		"namespace-enterprise": {
+			namespace:        "team-a",
+			version:          "1.15.2+ent",
+			enterprise:       true,
+			expectedVersion:  "1.15.2+ent",
+			expectedWarnings: []string{},
+		},
+		"namespace-community": {
+			namespace:       "team-a",
+			version:         "1.15.2",
+			expectedVersion: "1.15.2",
+			expectedWarnings: []string{
+				"namespaces require Vault Enterprise, target cluster runs 1.15.2",
+			},
+		},
+		"namespace-outdated": {
+			namespace:       "team-a",
+			version:         "0.10.4+ent",
+			enterprise:      true,
+			expectedVersion: "0.10.4+ent",
+			expectedWarnings: []string{
+				"namespaces require Vault 0.11.0 or later, target cluster runs 0.10.4+ent",
+			},
+		},
+		"invalid-version": {
+			version:          "unknown",
+			expectedWarnings: []string{},
+			expectErr:        true,
+		},
+		"disabled": {
+			disabled:         true,
+			version:          "0.8.3",
+			expectedWarnings: []string{},
+		},
+	}
+
+	for n, tc := range tests {
+		name, tCase := n, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			upstream := newTestUpstream(t, map[string]http.HandlerFunc{
+				"/v1/sys/health": jsonHandler(http.StatusOK, map[string]interface{}{
+					"initialized": true,
+					"sealed":      false,
+					"version":     tCase.version,
+					"enterprise":  tCase.enterprise,
+				}),
+			})
+			b, storage := getBackend(t)
+			writeConfig(t, b, storage, map[string]interface{}{
+				"cluster":                upstream.URL,
+				"namespace":              tCase.namespace,
+				"check_upstream_version": !tCase.disabled,
+			})
+
+			b.(*crossVaultAuthBackend).refreshUpstreamVersion(context.Background(), storage)
+
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.ReadOperation,
+				Path:      configPath + "/status",
+				Storage:   storage,
+			})
+			if err != nil || resp.IsError() {
+				t.Fatalf("unexpected error: %v, %v", err, resp)
+			}
+			assert.Equal(t, resp.Data["upstream_version"], tCase.expectedVersion)
+			assert.DeepEqual(t, resp.Data["upstream_version_warnings"], tCase.expectedWarnings)
+			assert.Equal(t, resp.Data["upstream_version_error"] != "", tCase.expectErr)
+		})
+	}
+}
+
+func TestUpstreamVersion_CheckInterval(t *testing.T) {
+	t.Parallel()
+
+	var version atomic.Value
+	version.Store("1.15.2")
+	upstream := newTestUpstream(t, map[string]http.HandlerFunc{
+		"/v1/sys/health": func(w http.ResponseWriter, r *http.Request) {
+			jsonHandler(http.StatusOK, map[string]interface{}{"initialized": true, "version": version.Load()})(w, r)
+		},
+	})
+	b, storage := getBackend(t)
+	writeConfig(t, b, storage, map[string]interface{}{"cluster": upstream.URL, "check_upstream_version": true})
+
+	cvab := b.(*crossVaultAuthBackend)
+	if err := cvab.checkUpstreamVersion(context.Background(), storage); err != nil {
+		t.Fatal(err)
+	}
+	version.Store("1.16.0")
+
+	// the next check is due after the interval only
+	if err := cvab.checkUpstreamVersion(context.Background(), storage); err != nil {
+		t.Fatal(err)
+	}
+	cvab.statusMu.RLock()
+	assert.Equal(t, cvab.upstreamVersion, "1.15.2")
+	cvab.statusMu.RUnlock()
+
+	// config write makes the check due right away
+	writeConfig(t, b, storage, map[string]interface{}{"cluster": upstream.URL, "check_upstream_version": true})
+	if err := cvab.checkUpstreamVersion(context.Background(), storage); err != nil {
+		t.Fatal(err)
+	}
+	cvab.statusMu.RLock()
+	defer cvab.statusMu.RUnlock()
+	assert.Equal(t, cvab.upstreamVersion, "1.16.0")
+}